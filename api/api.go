@@ -6,10 +6,12 @@ package api
 // dealing with the Binance Exchange is contained in binance.go
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -18,6 +20,10 @@ import (
 // api logger
 var apiLog = logrus.New()
 
+// statusIPBanned is the status code Binance returns once an IP has been banned
+// for ignoring rate limit errors
+const statusIPBanned = 418
+
 // Client is a helper struct for API calls
 type Client struct {
 	// API endpoint address
@@ -25,6 +31,14 @@ type Client struct {
 
 	// Timeout is the timeout set for the API
 	Timeout time.Duration
+
+	// Env is the environment profile of the exchange the client talks to. It
+	// provides the credentials for secure API calls
+	Env Environment
+
+	// Breaker is the circuit breaker guarding the client's requests, requests
+	// are not guarded if it is nil
+	Breaker *CircuitBreaker
 }
 
 // InitLogger initializes the logger for the api
@@ -55,70 +69,96 @@ func NewClient(address string, timeout time.Duration) *Client {
 
 // GetAPI submits a get request to the intended url endpoint
 func (c *Client) GetAPI(url string) ([]byte, error) {
-	apiClient := http.Client{
-		Timeout: c.Timeout,
-	}
-
-	res, err := apiClient.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
+		apiLog.Warn("WARN: error creating new request:", err)
 		return []byte{}, err
 	}
-
-	return ioutil.ReadAll(res.Body)
+	return c.do(req)
 }
 
 // PostAPI submits a post request to the intended url endpoint
 func (c *Client) PostAPI(url string, data url.Values) ([]byte, error) {
-	apiClient := http.Client{
-		Timeout: c.Timeout,
-	}
-
-	res, err := apiClient.PostForm(url, data)
+	req, err := http.NewRequest("POST", url, strings.NewReader(data.Encode()))
 	if err != nil {
+		apiLog.Warn("WARN: error creating new request:", err)
 		return []byte{}, err
 	}
-
-	return ioutil.ReadAll(res.Body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
 }
 
 // GetSecureAPI submits a new get request to the intended url endpoint with the
 // public api key in the header
 func (c *Client) GetSecureAPI(url string) ([]byte, error) {
-	apiClient := http.Client{
-		Timeout: c.Timeout,
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		apiLog.Warn("WARN: error creating new request:", err)
+		return []byte{}, err
 	}
+	req.Header.Add("X-MBX-APIKEY", c.Env.APIPubKey)
+	return c.do(req)
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// PostSecureAPI submits a new post request to the intended url endpoint with
+// the public api key in the header
+func (c *Client) PostSecureAPI(url string) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, nil)
 	if err != nil {
 		apiLog.Warn("WARN: error creating new request:", err)
 		return []byte{}, err
 	}
-	req.Header.Add("X-MBX-APIKEY", BNBAPIPubKey)
-	res, err := apiClient.Do(req)
+	req.Header.Add("X-MBX-APIKEY", c.Env.APIPubKey)
+	return c.do(req)
+}
+
+// DeleteSecureAPI submits a new delete request to the intended url endpoint
+// with the public api key in the header
+func (c *Client) DeleteSecureAPI(url string) ([]byte, error) {
+	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
+		apiLog.Warn("WARN: error creating new request:", err)
 		return []byte{}, err
 	}
+	req.Header.Add("X-MBX-APIKEY", c.Env.APIPubKey)
+	return c.do(req)
+}
 
-	return ioutil.ReadAll(res.Body)
+// do submits a request through the client's circuit breaker. Requests are
+// rejected while the breaker is open, and connection errors and server side
+// errors are recorded as failures
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	if c.Breaker != nil {
+		if err := c.Breaker.Allow(); err != nil {
+			return []byte{}, err
+		}
+	}
+	body, err := c.send(req)
+	if c.Breaker != nil {
+		c.Breaker.Record(err)
+	}
+	return body, err
 }
 
-// PostSecureAPI submits a new post request to the intended url endpoint with
-// the public api key in the header
-func (c *Client) PostSecureAPI(url string) ([]byte, error) {
+// send submits a request without going through the circuit breaker. An error
+// is returned if the exchange is overloaded or has a server side error
+func (c *Client) send(req *http.Request) ([]byte, error) {
 	apiClient := http.Client{
 		Timeout: c.Timeout,
 	}
 
-	req, err := http.NewRequest("POST", url, nil)
+	res, err := apiClient.Do(req)
 	if err != nil {
-		apiLog.Warn("WARN: error creating new request:", err)
 		return []byte{}, err
 	}
-	req.Header.Add("X-MBX-APIKEY", BNBAPIPubKey)
-	res, err := apiClient.Do(req)
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return []byte{}, err
 	}
-
-	return ioutil.ReadAll(res.Body)
+	if res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests || res.StatusCode == statusIPBanned {
+		return []byte{}, fmt.Errorf("exchange returned %v: %s", res.Status, body)
+	}
+	return body, nil
 }
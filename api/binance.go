@@ -10,9 +10,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
+	"net/http"
 	"net/url"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,15 +22,12 @@ const (
 	// recvWindow is the allowable difference between the submitted timestamp
 	// and the servertime that an API call will be accepted
 	recvWindow = 5000
+
+	// BNBFeeDiscount is the discount on the commission when it is paid in BNB
+	BNBFeeDiscount = 0.25
 )
 
 var (
-	// BNBAPIPubKey is the public api key
-	BNBAPIPubKey = os.Getenv("bnbAPIPubKey")
-
-	// BNBAPISecretKey is the secret api key
-	BNBAPISecretKey = os.Getenv("bnbAPISecretKey")
-
 	// BNBBTC is the binance symbol for the BNB/BTC market to get ticker price
 	// Price of 1 BNB in BTC
 	BNBBTC = "BNBBTC"
@@ -41,16 +40,19 @@ var (
 	// Price of 1 BTC in USD
 	BTCUSDT = "BTCUSDT"
 
-	// BinanceAPI is the base API endpoint for the binance exchange
-	BinanceAPI = "https://api.binance.com/api/"
-
 	// BNBExchangeInfo is the exchange info endpoint for the binance API
 	BNBExchangeInfo = "v1/exchangeInfo"
 
 	// BNBPrice is the endpoint for the binance API to get the price of a coin,
-	// it takes an input parameter of `symbol`
+	// it takes an input parameter of `symbol`. Without a symbol the prices of
+	// all coins are returned
 	BNBPrice = "v3/ticker/price"
 
+	// BNBBookTicker is the endpoint for the best bid and ask of a coin, it
+	// takes an input parameter of `symbol`. Without a symbol the books of all
+	// coins are returned
+	BNBBookTicker = "v3/ticker/bookTicker"
+
 	// BNB24hrStats 24 hour price change statistics. Careful when accessing this
 	// with no symbol.
 	BNB24hrStats = "v1/ticker/24hr"
@@ -69,6 +71,13 @@ var (
 	// BNBNewOrder Send in a new order.
 	BNBNewOrder = "v3/order"
 
+	// BNBOrder Query or cancel an order. Use Order struct
+	BNBOrder = "v3/order"
+
+	// BNBMyTrades Get the trades of an account on a symbol, it takes an
+	// optional `orderId` to only get the trades of an order. Use Trade struct
+	BNBMyTrades = "v3/myTrades"
+
 	// BNBTestOrder sends a test order, does not post to market
 	BNBTestOrder = BNBNewOrder + "/test"
 
@@ -77,14 +86,150 @@ var (
 
 	// BNBTime server time
 	BNBTime = "v1/time"
+
+	// BNBKlines is the endpoint for the candlestick bars of a symbol
+	BNBKlines = "v3/klines"
+
+	// maxKlines is the most klines returned by one request
+	maxKlines = 1000
 )
 
+// quoteAssets are the assets Binance markets are quoted in
+var quoteAssets = []string{"USDT", "BUSD", "USDC", "BTC", "ETH", "BNB"}
+
+// SplitSymbol splits a symbol into its base and quote assets, ie BTCUSDT into
+// BTC and USDT. It returns false if the quote asset is not recognized
+func SplitSymbol(symbol string) (base, quote string, ok bool) {
+	for _, q := range quoteAssets {
+		if strings.HasSuffix(symbol, q) && len(symbol) > len(q) {
+			return strings.TrimSuffix(symbol, q), q, true
+		}
+	}
+	return "", "", false
+}
+
 // ExchangeInfo is the information returned about the exchange from the Binance
 // exchange api endpoint
 type ExchangeInfo struct {
 	Timezone string `json:"timezone"`
 	ServerTime
 	BNBLimits
+	Symbols []SymbolInfo `json:"symbols"`
+}
+
+// SymbolInfo is the information about a symbol from the exchange info,
+// including the filters orders on it must pass
+type SymbolInfo struct {
+	Symbol     string         `json:"symbol"`
+	Status     string         `json:"status"`
+	BaseAsset  string         `json:"baseAsset"`
+	QuoteAsset string         `json:"quoteAsset"`
+	Filters    []SymbolFilter `json:"filters"`
+}
+
+// SymbolFilter is a filter of a symbol, only the fields of its type are set
+type SymbolFilter struct {
+	FilterType  string `json:"filterType"`
+	MinPrice    string `json:"minPrice,omitempty"`
+	MaxPrice    string `json:"maxPrice,omitempty"`
+	TickSize    string `json:"tickSize,omitempty"`
+	MinQty      string `json:"minQty,omitempty"`
+	MaxQty      string `json:"maxQty,omitempty"`
+	StepSize    string `json:"stepSize,omitempty"`
+	MinNotional string `json:"minNotional,omitempty"`
+}
+
+// SymbolRules are the tick size, lot size and minimum notional of a symbol,
+// rules that are 0 are not enforced
+type SymbolRules struct {
+	TickSize    float64
+	StepSize    float64
+	MinQty      float64
+	MinNotional float64
+}
+
+// Rules parses the price, lot size and notional filters of the symbol
+func (s SymbolInfo) Rules() (SymbolRules, error) {
+	var r SymbolRules
+	for _, f := range s.Filters {
+		var err error
+		switch f.FilterType {
+		case "PRICE_FILTER":
+			err = parseFilter(&r.TickSize, f.TickSize)
+		case "LOT_SIZE":
+			if err = parseFilter(&r.StepSize, f.StepSize); err == nil {
+				err = parseFilter(&r.MinQty, f.MinQty)
+			}
+		case "MIN_NOTIONAL", "NOTIONAL":
+			err = parseFilter(&r.MinNotional, f.MinNotional)
+		}
+		if err != nil {
+			return SymbolRules{}, fmt.Errorf("%v %v: %v", s.Symbol, f.FilterType, err)
+		}
+	}
+	return r, nil
+}
+
+// parseFilter parses the value of a filter field, empty values are skipped
+func parseFilter(dest *float64, value string) error {
+	if value == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	*dest = v
+	return nil
+}
+
+// Rules returns the rules of every symbol of the exchange info keyed by symbol
+func (info ExchangeInfo) Rules() (map[string]SymbolRules, error) {
+	rules := make(map[string]SymbolRules, len(info.Symbols))
+	for _, s := range info.Symbols {
+		r, err := s.Rules()
+		if err != nil {
+			return nil, err
+		}
+		rules[s.Symbol] = r
+	}
+	return rules, nil
+}
+
+// RoundPrice rounds a price down to the tick size
+func (r SymbolRules) RoundPrice(price float64) float64 {
+	return roundDown(price, r.TickSize)
+}
+
+// RoundQuantity rounds a quantity down to the step size
+func (r SymbolRules) RoundQuantity(qty float64) float64 {
+	return roundDown(qty, r.StepSize)
+}
+
+// Valid returns true if an order of the quantity at the price passes the
+// minimum quantity and notional
+func (r SymbolRules) Valid(qty, price float64) bool {
+	return qty > 0 && qty >= r.MinQty && qty*price >= r.MinNotional
+}
+
+// roundDown rounds a value down to a multiple of the step, a step of 0 leaves
+// the value as is. The result is rounded to the decimals of the step so it
+// formats without float noise
+func roundDown(value, step float64) float64 {
+	if step <= 0 {
+		return value
+	}
+	// Allow for float error in values that are already a multiple
+	n := math.Floor(value/step + 1e-9)
+	decimals := 0
+	if s := strconv.FormatFloat(step, 'f', -1, 64); strings.Contains(s, ".") {
+		decimals = len(s) - strings.Index(s, ".") - 1
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(n*step, 'f', decimals, 64), 64)
+	if err != nil {
+		return n * step
+	}
+	return rounded
 }
 
 // BNBLimits are the limits for the Binance exchange API
@@ -94,9 +239,9 @@ type BNBLimits struct {
 
 // Limits are the different types of rate limits for the binance exchange
 type Limits struct {
-	RateLimitType string `json:"rateLimitType"`
-	Interval      string `json:"interval"`
-	Limit         int    `json:"limit"`
+	RateLimitType RateLimitType `json:"rateLimitType"`
+	Interval      string        `json:"interval"`
+	Limit         int           `json:"limit"`
 }
 
 // TickerPrice is the symbol and price of a coin on the Binance exchange
@@ -105,6 +250,16 @@ type TickerPrice struct {
 	Price  string `json:"price"`
 }
 
+// BookTicker is the best bid and ask price and quantity of a coin on the
+// Binance exchange
+type BookTicker struct {
+	Symbol   string `json:"symbol"`
+	BidPrice string `json:"bidPrice"`
+	BidQty   string `json:"bidQty"`
+	AskPrice string `json:"askPrice"`
+	AskQty   string `json:"askQty"`
+}
+
 // Stats24hr are the stats from the last 24 hours of a coin on the binance
 // exchange
 type Stats24hr struct {
@@ -114,6 +269,53 @@ type Stats24hr struct {
 	WeightedAvgPrice   string `json:"weightedAvgPrice"`
 	PrevClosePrice     string `json:"prevClosePrice"`
 	LastPrice          string `json:"lastPrice"`
+	LastQty            string `json:"lastQty"`
+	BidPrice           string `json:"bidPrice"`
+	AskPrice           string `json:"askPrice"`
+	OpenPrice          string `json:"openPrice"`
+	HighPrice          string `json:"highPrice"`
+	LowPrice           string `json:"lowPrice"`
+	Volume             string `json:"volume"`
+	QuoteVolume        string `json:"quoteVolume"`
+	OpenTime           int64  `json:"openTime"`
+	CloseTime          int64  `json:"closeTime"`
+	FirstID            int    `json:"firstId"`
+	LastID             int    `json:"lastId"`
+	Count              int    `json:"count"`
+}
+
+// DailyStats are the parsed open, high, low and volumes of a Stats24hr
+type DailyStats struct {
+	Open        float64
+	High        float64
+	Low         float64
+	Volume      float64
+	QuoteVolume float64
+}
+
+// Daily parses the open, high, low and volumes of the stats
+func (s Stats24hr) Daily() (DailyStats, error) {
+	var d DailyStats
+	for _, field := range []struct {
+		value string
+		dest  *float64
+	}{
+		{s.OpenPrice, &d.Open},
+		{s.HighPrice, &d.High},
+		{s.LowPrice, &d.Low},
+		{s.Volume, &d.Volume},
+		{s.QuoteVolume, &d.QuoteVolume},
+	} {
+		if field.value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(field.value, 64)
+		if err != nil {
+			return DailyStats{}, err
+		}
+		*field.dest = f
+	}
+	return d, nil
 }
 
 // Orders is a list of orders from the Binance exchange
@@ -123,22 +325,53 @@ type Orders struct {
 
 // Order contains the information about an order on the binance exchange
 type Order struct {
-	Symbol              string `json:"symbol"`
-	OrderID             int    `json:"orderId"`
-	ClientOrderID       string `json:"clientOrderId"`
-	Price               string `json:"price"`
-	OrigQty             string `json:"origQty"`
-	ExecutedQty         string `json:"executedQty"`
-	CummulativeQuoteQty string `json:"cummulativeQuoteQty"`
-	Status              string `json:"status"`
-	TimeInForce         string `json:"timeInForce"`
-	Type                string `json:"type"`
-	Side                string `json:"side"`
-	StopPrice           string `json:"stopPrice"`
-	IcebergQty          string `json:"icebergQty"`
-	Time                int64  `json:"time"`
-	UpdateTime          int64  `json:"updateTime"`
-	IsWorking           bool   `json:"isWorking"`
+	Symbol              string      `json:"symbol"`
+	OrderID             int         `json:"orderId"`
+	ClientOrderID       string      `json:"clientOrderId"`
+	Price               string      `json:"price"`
+	OrigQty             string      `json:"origQty"`
+	ExecutedQty         string      `json:"executedQty"`
+	CummulativeQuoteQty string      `json:"cummulativeQuoteQty"`
+	Status              OrderStatus `json:"status"`
+	TimeInForce         TimeInForce `json:"timeInForce"`
+	Type                OrderType   `json:"type"`
+	Side                Side        `json:"side"`
+	StopPrice           string      `json:"stopPrice"`
+	IcebergQty          string      `json:"icebergQty"`
+	Time                int64       `json:"time"`
+	UpdateTime          int64       `json:"updateTime"`
+	IsWorking           bool        `json:"isWorking"`
+}
+
+// Trade is a fill of an order of the account on the binance exchange with the
+// commission paid for it
+type Trade struct {
+	Symbol          string `json:"symbol"`
+	ID              int    `json:"id"`
+	OrderID         int    `json:"orderId"`
+	Price           string `json:"price"`
+	Qty             string `json:"qty"`
+	QuoteQty        string `json:"quoteQty"`
+	Commission      string `json:"commission"`
+	CommissionAsset string `json:"commissionAsset"`
+	Time            int64  `json:"time"`
+	IsBuyer         bool   `json:"isBuyer"`
+	IsMaker         bool   `json:"isMaker"`
+}
+
+// Commissions returns the commission paid on the trades keyed by asset
+func Commissions(trades []Trade) (map[string]float64, error) {
+	commissions := make(map[string]float64)
+	for _, trade := range trades {
+		commission, err := strconv.ParseFloat(trade.Commission, 64)
+		if err != nil {
+			return nil, err
+		}
+		if commission > 0 {
+			commissions[trade.CommissionAsset] += commission
+		}
+	}
+	return commissions, nil
 }
 
 // AccountInfo is the information about a Binance exchange account
@@ -161,6 +394,51 @@ type Asset struct {
 	Locked string `json:"locked"`
 }
 
+// Kline is a candlestick bar of a coin on the Binance exchange
+type Kline struct {
+	OpenTime    int64
+	Open        string
+	High        string
+	Low         string
+	Close       string
+	Volume      string
+	CloseTime   int64
+	QuoteVolume string
+	Trades      int64
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. Binance returns
+// klines as arrays rather than objects
+func (k *Kline) UnmarshalJSON(b []byte) error {
+	var fields []interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields) < 9 {
+		return fmt.Errorf("kline has %v fields, expected at least 9", len(fields))
+	}
+	var ok [9]bool
+	var openTime, closeTime, trades float64
+	openTime, ok[0] = fields[0].(float64)
+	k.Open, ok[1] = fields[1].(string)
+	k.High, ok[2] = fields[2].(string)
+	k.Low, ok[3] = fields[3].(string)
+	k.Close, ok[4] = fields[4].(string)
+	k.Volume, ok[5] = fields[5].(string)
+	closeTime, ok[6] = fields[6].(float64)
+	k.QuoteVolume, ok[7] = fields[7].(string)
+	trades, ok[8] = fields[8].(float64)
+	for i := range ok {
+		if !ok[i] {
+			return fmt.Errorf("unexpected type for kline field %v: %T", i, fields[i])
+		}
+	}
+	k.OpenTime = int64(openTime)
+	k.CloseTime = int64(closeTime)
+	k.Trades = int64(trades)
+	return nil
+}
+
 // ServerTime is the current time of the Binance exchange server
 type ServerTime struct {
 	ServerTime int64 `json:"serverTime"`
@@ -184,14 +462,96 @@ type Ack struct {
 // about the result of the order submission
 type Result struct {
 	OrderRespHeader
-	Price               string `json:"price"`
-	OrigQty             string `json:"origQty"`
-	ExecutedQty         string `json:"executedQty"`
-	CummulativeQuoteQty string `json:"cummulativeQuoteQty"`
-	Status              string `json:"status"`
-	TimeInForce         string `json:"timeInForce"`
-	Type                string `json:"type"`
-	Side                string `json:"side"`
+	Price               string      `json:"price"`
+	OrigQty             string      `json:"origQty"`
+	ExecutedQty         string      `json:"executedQty"`
+	CummulativeQuoteQty string      `json:"cummulativeQuoteQty"`
+	Status              OrderStatus `json:"status"`
+	TimeInForce         TimeInForce `json:"timeInForce"`
+	Type                OrderType   `json:"type"`
+	Side                Side        `json:"side"`
+}
+
+// Error is the error Binance returns for a request it refused
+type Error struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// Error implements the error interface
+func (e Error) Error() string {
+	return fmt.Sprintf("binance error %v: %v", e.Code, e.Msg)
+}
+
+// transientCodes are the error codes of requests that failed without being
+// looked at by the exchange, ie a timeout or a rate limit, and can be retried
+var transientCodes = map[int]bool{
+	-1000: true, // UNKNOWN
+	-1001: true, // DISCONNECTED
+	-1003: true, // TOO_MANY_REQUESTS
+	-1015: true, // TOO_MANY_ORDERS
+	-1021: true, // INVALID_TIMESTAMP
+}
+
+// unknownCodes are the error codes of requests the exchange may have executed
+// without saying so, ie an order that may be on the book
+var unknownCodes = map[int]bool{
+	-1006: true, // UNEXPECTED_RESP
+	-1007: true, // TIMEOUT
+}
+
+// IsRejection returns true if the error is the exchange refusing a request,
+// ie an order that breaks a filter or would trigger immediately. Connection
+// errors, transient exchange errors and unknown statuses are not rejections
+func IsRejection(err error) bool {
+	e, ok := err.(Error)
+	return ok && !transientCodes[e.Code] && !unknownCodes[e.Code]
+}
+
+// IsUnknownStatus returns true if the exchange couldn't tell whether the
+// request was executed, an order has to be looked up before it is retried
+func IsUnknownStatus(err error) bool {
+	e, ok := err.(Error)
+	return ok && unknownCodes[e.Code]
+}
+
+// refused returns the error of a response body that has an error code in
+// place of the response. Binance returns the refused requests as a 4xx
+func refused(body []byte) error {
+	var e Error
+	if err := json.Unmarshal(body, &e); err == nil && e.Code != 0 {
+		return e
+	}
+	return nil
+}
+
+// Executed returns the executed quantity of the order and the average price it
+// was executed at
+func (r Result) Executed() (quantity, price float64, err error) {
+	return executed(r.ExecutedQty, r.CummulativeQuoteQty)
+}
+
+// Executed returns the executed quantity of the order and the average price it
+// was executed at
+func (o Order) Executed() (quantity, price float64, err error) {
+	return executed(o.ExecutedQty, o.CummulativeQuoteQty)
+}
+
+// executed parses the executed quantity and cumulative quote quantity of an
+// order into the quantity and average price
+func executed(executedQty, cummulativeQuoteQty string) (float64, float64, error) {
+	if executedQty == "" {
+		return 0, 0, nil
+	}
+	qty, err := strconv.ParseFloat(executedQty, 64)
+	if err != nil || qty == 0 {
+		return 0, 0, err
+	}
+	quote, err := strconv.ParseFloat(cummulativeQuoteQty, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return qty, quote / qty, nil
 }
 
 // Full is a type of response from an order submission. It is the all the
@@ -209,15 +569,25 @@ type Fill struct {
 	CommissionAsset string `json:"commissionAsset"`
 }
 
-// NewBinanceClient creates a new api client for the Binance API
+// NewBinanceClient creates a new api client for the Binance API using the
+// active environment
 func NewBinanceClient() *Client {
-	return NewClient(BinanceAPI, 2)
+	return NewEnvironmentClient(ActiveEnvironment())
+}
+
+// NewEnvironmentClient creates a new api client for the Binance API in the
+// provided environment
+func NewEnvironmentClient(env Environment) *Client {
+	c := NewClient(env.RESTAddress, 2)
+	c.Env = env
+	c.Breaker = NewCircuitBreaker(DefaultBreakerConfig, c.Ping)
+	return c
 }
 
 // signature creates the signature for signig api calls
-func signature(params string) string {
+func (c *Client) signature(params string) string {
 	// Create a new HMAC by defining the hash type and the key (as byte array)
-	h := hmac.New(sha256.New, []byte(BNBAPISecretKey))
+	h := hmac.New(sha256.New, []byte(c.Env.APISecretKey))
 
 	// Write Data to it
 	h.Write([]byte(params))
@@ -226,8 +596,22 @@ func signature(params string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// Ping calls the API endpoint that tests connectivity to the exchange. The
+// request does not go through the circuit breaker so that it can be used to
+// probe the exchange while the breaker is open
+func (c *Client) Ping() error {
+	req, err := http.NewRequest("GET", c.Address+BNBPing, nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.send(req)
+	return err
+}
+
 // Get24hrStats calls the API endpoint that returns the 24hr statistics on a
 // coin
+//
+// Weight = 1
 func (c *Client) Get24hrStats(symbol string) (Stats24hr, error) {
 	body, err := c.GetAPI(c.Address + BNB24hrStats + "?symbol=" + symbol)
 	if err != nil {
@@ -251,7 +635,7 @@ func (c *Client) Get24hrStats(symbol string) (Stats24hr, error) {
 func (c *Client) GetAccountInfo() (AccountInfo, error) {
 	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
 	params := fmt.Sprintf("timestamp=%v&recvWindow=%v", timestamp, recvWindow)
-	sig := signature(params)
+	sig := c.signature(params)
 	query := fmt.Sprintf("?%v&signature=%v", params, sig)
 	body, err := c.GetSecureAPI(c.Address + BNBAccount + query)
 	if err != nil {
@@ -259,6 +643,10 @@ func (c *Client) GetAccountInfo() (AccountInfo, error) {
 		return AccountInfo{}, err
 	}
 
+	if err := refused(body); err != nil {
+		return AccountInfo{}, err
+	}
+
 	// Get Account Info
 	account := AccountInfo{}
 	err = json.Unmarshal(body, &account)
@@ -275,7 +663,7 @@ func (c *Client) GetAccountInfo() (AccountInfo, error) {
 func (c *Client) GetAllOrders(symbol string) ([]Order, error) {
 	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
 	params := fmt.Sprintf("symbol=%v&timestamp=%v&recvWindow=%v", symbol, timestamp, recvWindow)
-	sig := signature(params)
+	sig := c.signature(params)
 	query := fmt.Sprintf("?%v&signature=%v", params, sig)
 	body, err := c.GetSecureAPI(c.Address + BNBAllOrders + query)
 	if err != nil {
@@ -331,13 +719,87 @@ func (c *Client) GetCoinPrice(symbol string) (TickerPrice, error) {
 	return price, nil
 }
 
+// GetAllTickerPrices calls the API endpoint that returns the current price for
+// every coin
+//
+// Weight 2
+func (c *Client) GetAllTickerPrices() ([]TickerPrice, error) {
+	body, err := c.GetAPI(c.Address + BNBPrice)
+	if err != nil {
+		apiLog.Warn("WARN: error submitting get request:", err)
+		return []TickerPrice{}, err
+	}
+
+	prices := []TickerPrice{}
+	err = json.Unmarshal(body, &prices)
+	if err != nil {
+		apiLog.Warn("WARN: error unmarshaling ticker prices:", err)
+		return []TickerPrice{}, err
+	}
+
+	return prices, nil
+}
+
+// GetAllBookTickers calls the API endpoint that returns the best bid and ask
+// for every coin
+//
+// Weight 2
+func (c *Client) GetAllBookTickers() ([]BookTicker, error) {
+	body, err := c.GetAPI(c.Address + BNBBookTicker)
+	if err != nil {
+		apiLog.Warn("WARN: error submitting get request:", err)
+		return []BookTicker{}, err
+	}
+
+	books := []BookTicker{}
+	err = json.Unmarshal(body, &books)
+	if err != nil {
+		apiLog.Warn("WARN: error unmarshaling book tickers:", err)
+		return []BookTicker{}, err
+	}
+
+	return books, nil
+}
+
+// GetKlines calls the API endpoint that returns the candlestick bars of a coin
+// for an interval, ie 1m, 1h or 1d, between the start and end times. Binance
+// returns at most 1000 bars per request so the bars are requested in pages
+//
+// Weight 1 per request
+func (c *Client) GetKlines(symbol, interval string, start, end time.Time) ([]Kline, error) {
+	var klines []Kline
+	startMs := start.UnixNano() / int64(time.Millisecond)
+	endMs := end.UnixNano() / int64(time.Millisecond)
+	for startMs < endMs {
+		query := fmt.Sprintf("?symbol=%v&interval=%v&startTime=%v&endTime=%v&limit=%v", symbol, interval, startMs, endMs, maxKlines)
+		body, err := c.GetAPI(c.Address + BNBKlines + query)
+		if err != nil {
+			apiLog.Warn("WARN: error submitting get request:", err)
+			return []Kline{}, err
+		}
+
+		page := []Kline{}
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			apiLog.Warn("WARN: error unmarshaling klines:", err)
+			return []Kline{}, err
+		}
+		klines = append(klines, page...)
+		if len(page) < maxKlines {
+			break
+		}
+		startMs = page[len(page)-1].CloseTime + 1
+	}
+	return klines, nil
+}
+
 // GetOpenOrders calls the endpoint that returns all open orders
 //
 // Weight 1 with symbol, 40 w/o symbol
 func (c *Client) GetOpenOrders() ([]Order, error) {
 	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
 	params := fmt.Sprintf("timestamp=%v&recvWindow=%v", timestamp, recvWindow)
-	sig := signature(params)
+	sig := c.signature(params)
 	query := fmt.Sprintf("?%v&signature=%v", params, sig)
 	body, err := c.GetSecureAPI(c.Address + BNBOpenOrders + query)
 	if err != nil {
@@ -356,6 +818,82 @@ func (c *Client) GetOpenOrders() ([]Order, error) {
 	return orders, nil
 }
 
+// GetOrder calls the endpoint that returns the status of an order
+//
+// Weight 1
+func (c *Client) GetOrder(symbol string, orderID int) (Order, error) {
+	body, err := c.GetSecureAPI(c.Address + BNBOrder + c.orderQuery(symbol, orderID))
+	if err != nil {
+		apiLog.Warn("WARN: error submitting get request:", err)
+		return Order{}, err
+	}
+	if err := refused(body); err != nil {
+		return Order{}, err
+	}
+
+	order := Order{}
+	err = json.Unmarshal(body, &order)
+	if err != nil {
+		apiLog.Warn("WARN: error unmarshaling order:", err)
+		return Order{}, err
+	}
+	return order, nil
+}
+
+// CancelOrder calls the endpoint that cancels an open order. The canceled
+// order is returned with the quantity that was executed before the cancel
+//
+// Weight 1
+func (c *Client) CancelOrder(symbol string, orderID int) (Order, error) {
+	body, err := c.DeleteSecureAPI(c.Address + BNBOrder + c.orderQuery(symbol, orderID))
+	if err != nil {
+		apiLog.Warn("WARN: error submitting delete request:", err)
+		return Order{}, err
+	}
+	if err := refused(body); err != nil {
+		return Order{}, err
+	}
+
+	order := Order{}
+	err = json.Unmarshal(body, &order)
+	if err != nil {
+		apiLog.Warn("WARN: error unmarshaling order:", err)
+		return Order{}, err
+	}
+	return order, nil
+}
+
+// GetOrderTrades calls the endpoint that returns the trades of an order with
+// the commission paid on each
+//
+// Weight 10
+func (c *Client) GetOrderTrades(symbol string, orderID int) ([]Trade, error) {
+	body, err := c.GetSecureAPI(c.Address + BNBMyTrades + c.orderQuery(symbol, orderID))
+	if err != nil {
+		apiLog.Warn("WARN: error submitting get request:", err)
+		return nil, err
+	}
+	if err := refused(body); err != nil {
+		return nil, err
+	}
+
+	var trades []Trade
+	err = json.Unmarshal(body, &trades)
+	if err != nil {
+		apiLog.Warn("WARN: error unmarshaling trades:", err)
+		return nil, err
+	}
+	return trades, nil
+}
+
+// orderQuery returns the signed query for an order
+func (c *Client) orderQuery(symbol string, orderID int) string {
+	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	params := fmt.Sprintf("symbol=%v&orderId=%v&timestamp=%v&recvWindow=%v", symbol, orderID, timestamp, recvWindow)
+	sig := c.signature(params)
+	return fmt.Sprintf("?%v&signature=%v", params, sig)
+}
+
 // Order Parameters
 // Name				Type		Mandatory	Description
 // symbol			STRING		YES
@@ -380,29 +918,75 @@ func (c *Client) GetOpenOrders() ([]Order, error) {
 // TAKE_PROFIT_LIMIT	timeInForce, quantity, price, stopPrice
 // LIMIT_MAKER			quantity, price
 
-// PostNewLimitOrder calls the API endpoint to submit a limit order to Binance
-func (c *Client) PostNewLimitOrder(symbol, side string, quantity float64) (Result, error) {
+// PostNewLimitOrder calls the API endpoint to submit a limit order to
+// Binance. The order is only posted if the client's environment allows live
+// trading
+func (c *Client) PostNewLimitOrder(symbol string, side Side, quantity, price float64) (Result, error) {
+	if err := c.checkLiveTrading(); err != nil {
+		apiLog.Warnf("WARN: refusing to post order in %v environment: %v", c.Env.Name, err)
+		return Result{}, err
+	}
+	return c.postOrder(BNBNewOrder, symbol, side, OrderTypeLimit, quantity, price, 0)
+}
 
+// PostNewStopLimitOrder calls the API endpoint to submit a STOP_LOSS_LIMIT or
+// TAKE_PROFIT_LIMIT order to Binance. The limit order at price is placed once
+// the price reaches the stop price. The order is only posted if the client's
+// environment allows live trading
+func (c *Client) PostNewStopLimitOrder(symbol string, side Side, orderType OrderType, quantity, price, stopPrice float64) (Result, error) {
+	if orderType != OrderTypeStopLossLimit && orderType != OrderTypeTakeProfitLimit {
+		return Result{}, fmt.Errorf("order type %v is not a stop limit order type", orderType)
+	}
+	if err := c.checkLiveTrading(); err != nil {
+		apiLog.Warnf("WARN: refusing to post order in %v environment: %v", c.Env.Name, err)
+		return Result{}, err
+	}
+	return c.postOrder(BNBNewOrder, symbol, side, orderType, quantity, price, stopPrice)
+}
+
+// PostTestLimitOrder calls the API endpoint to test a limit order. The order
+// is validated by Binance but is not sent to the matching engine
+func (c *Client) PostTestLimitOrder(symbol string, side Side, quantity, price float64) (Result, error) {
+	return c.postOrder(BNBTestOrder, symbol, side, OrderTypeLimit, quantity, price, 0)
+}
+
+// postOrder submits a limit, stop loss limit or take profit limit order to the
+// provided order endpoint. The stop price is only sent if it is set
+func (c *Client) postOrder(endpoint, symbol string, side Side, orderType OrderType, quantity, price, stopPrice float64) (Result, error) {
+	if err := side.Validate(); err != nil {
+		return Result{}, err
+	}
+	if err := orderType.Validate(); err != nil {
+		return Result{}, err
+	}
 	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
 	values := url.Values{}
 	values.Set("symbol", symbol)                                       // Mandatory
-	values.Set("side", side)                                           // Mandatory
-	values.Set("type", "LIMIT")                                        // Mandatory
-	values.Set("timeInForce", "GTC")                                   // Mandatory
+	values.Set("side", string(side))                                   // Mandatory
+	values.Set("type", string(orderType))                              // Mandatory
+	values.Set("timeInForce", string(TimeInForceGTC))                  // Mandatory
 	values.Set("quantity", strconv.FormatFloat(quantity, 'f', -1, 64)) // Mandatory
-	values.Set("newOrderRespType", "RESULT")                           //
-	values.Set("recvWindow", strconv.FormatInt(recvWindow, 10))        //
-	values.Set("timestamp", timestamp)                                 // Mandatory
+	values.Set("price", strconv.FormatFloat(price, 'f', -1, 64))       // Mandatory
+	if stopPrice > 0 {
+		values.Set("stopPrice", strconv.FormatFloat(stopPrice, 'f', -1, 64)) // Mandatory for stop orders
+	}
+	values.Set("newOrderRespType", string(OrderResponseResult)) //
+	values.Set("recvWindow", strconv.FormatInt(recvWindow, 10)) //
+	values.Set("timestamp", timestamp)                          // Mandatory
 	params := values.Encode()
-	sig := signature(params)
+	sig := c.signature(params)
 	query := fmt.Sprintf("?%v&signature=%v", params, sig)
 
-	body, err := c.PostSecureAPI(c.Address + BNBTestOrder + query)
+	body, err := c.PostSecureAPI(c.Address + endpoint + query)
 	if err != nil {
 		apiLog.Warn("WARN: error submitting post request:", err)
 		return Result{}, err
 	}
 
+	// Refused orders are returned with an error code instead of the result
+	if err := refused(body); err != nil {
+		return Result{}, err
+	}
 	result := Result{}
 	err = json.Unmarshal(body, &result)
 	if err != nil {
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestKlineJSON tests that klines are unmarshaled from arrays
+func TestKlineJSON(t *testing.T) {
+	var klines []Kline
+	data := `[[1499040000000,"0.01634790","0.80000000","0.01575800","0.01577100","148976.11427815",1499644799999,"2434.19055334",308,"1756.87402397","28.46694368","0"]]`
+	if err := json.Unmarshal([]byte(data), &klines); err != nil {
+		t.Fatal(err)
+	}
+	k := klines[0]
+	if k.OpenTime != 1499040000000 || k.Close != "0.01577100" || k.CloseTime != 1499644799999 || k.Trades != 308 {
+		t.Fatal("unexpected kline", k)
+	}
+	if err := json.Unmarshal([]byte(`[[1,2]]`), &klines); err == nil {
+		t.Fatal("expected error for short kline")
+	}
+}
+
+// TestSplitSymbol tests splitting symbols into base and quote assets
+func TestSplitSymbol(t *testing.T) {
+	tests := []struct {
+		symbol, base, quote string
+		ok                  bool
+	}{
+		{BTCUSDT, "BTC", "USDT", true},
+		{BNBBTC, "BNB", "BTC", true},
+		{BNBUSDT, "BNB", "USDT", true},
+		{"ETHBNB", "ETH", "BNB", true},
+		{"USDT", "", "", false},
+		{"BTCXYZ", "", "", false},
+	}
+	for _, test := range tests {
+		base, quote, ok := SplitSymbol(test.symbol)
+		if base != test.base || quote != test.quote || ok != test.ok {
+			t.Errorf("%v: expected %v %v %v, got %v %v %v", test.symbol, test.base, test.quote, test.ok, base, quote, ok)
+		}
+	}
+}
+
+// TestStats24hrJSON tests that the full 24hr stats are decoded and parsed
+func TestStats24hrJSON(t *testing.T) {
+	data := `{"symbol":"BNBBTC","priceChange":"-94.99999800","priceChangePercent":"-95.960","weightedAvgPrice":"0.29628482","prevClosePrice":"0.10002000","lastPrice":"4.00000200","lastQty":"200.00000000","bidPrice":"4.00000000","askPrice":"4.00000200","openPrice":"99.00000000","highPrice":"100.00000000","lowPrice":"0.10000000","volume":"8913.30000000","quoteVolume":"15.30000000","openTime":1499783499040,"closeTime":1499869899040,"firstId":28385,"lastId":28460,"count":76}`
+	var stats Stats24hr
+	if err := json.Unmarshal([]byte(data), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Count != 76 || stats.OpenTime != 1499783499040 {
+		t.Fatal("unexpected stats", stats)
+	}
+	daily, err := stats.Daily()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if daily != (DailyStats{Open: 99, High: 100, Low: 0.1, Volume: 8913.3, QuoteVolume: 15.3}) {
+		t.Fatal("unexpected daily stats", daily)
+	}
+	stats.HighPrice = "high"
+	if _, err := stats.Daily(); err == nil {
+		t.Fatal("expected error for invalid price")
+	}
+}
+
+// TestCommissions tests that the commissions of trades are summed by asset
+func TestCommissions(t *testing.T) {
+	data := `[{"symbol":"BTCUSDT","id":28457,"orderId":100234,"price":"4.00000100","qty":"12.00000000","quoteQty":"48.000012","commission":"10.10000000","commissionAsset":"BNB","time":1499865549590,"isBuyer":true,"isMaker":false},{"symbol":"BTCUSDT","id":28458,"orderId":100234,"price":"4.00000100","qty":"1","quoteQty":"4","commission":"0.5","commissionAsset":"BNB","time":1499865549590,"isBuyer":true,"isMaker":true}]`
+	var trades []Trade
+	if err := json.Unmarshal([]byte(data), &trades); err != nil {
+		t.Fatal(err)
+	}
+	commissions, err := Commissions(trades)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commissions) != 1 || commissions["BNB"] != 10.6 {
+		t.Fatal("unexpected commissions", commissions)
+	}
+}
+
+// TestSymbolRules tests that the symbol filters are parsed from the exchange
+// info and that prices and quantities are rounded to them
+func TestSymbolRules(t *testing.T) {
+	var info ExchangeInfo
+	data := `{"timezone":"UTC","serverTime":1508631584636,"rateLimits":[],"symbols":[{"symbol":"BTCUSDT","status":"TRADING","baseAsset":"BTC","quoteAsset":"USDT","filters":[{"filterType":"PRICE_FILTER","minPrice":"0.01000000","maxPrice":"1000000.00000000","tickSize":"0.01000000"},{"filterType":"LOT_SIZE","minQty":"0.00001000","maxQty":"9000.00000000","stepSize":"0.00001000"},{"filterType":"NOTIONAL","minNotional":"5.00000000"}]}]}`
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := info.Rules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rules[BTCUSDT]
+	if r.TickSize != 0.01 || r.StepSize != 0.00001 || r.MinQty != 0.00001 || r.MinNotional != 5 {
+		t.Fatal("unexpected rules", r)
+	}
+	if p := r.RoundPrice(30000.129); p != 30000.12 {
+		t.Fatal("expected price rounded down to the tick, got", p)
+	}
+	if p := r.RoundPrice(0.3); p != 0.3 {
+		t.Fatal("expected price on the tick to be kept, got", p)
+	}
+	if q := r.RoundQuantity(0.000166666); q != 0.00016 {
+		t.Fatal("expected quantity rounded down to the step, got", q)
+	}
+	if r.Valid(0.0001, 30000) || !r.Valid(0.0002, 30000) {
+		t.Fatal("expected the min notional to be enforced")
+	}
+}
+
+// TestIsRejection tests that only the errors of requests refused by the
+// exchange are rejections
+func TestIsRejection(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{Error{Code: -2010, Msg: "Account has insufficient balance for requested action."}, true},
+		{Error{Code: -1013, Msg: "Filter failure: LOT_SIZE"}, true},
+		{Error{Code: -1007, Msg: "Timeout waiting for response from backend server."}, false},
+		{Error{Code: -1003, Msg: "Too many requests."}, false},
+		{errors.New("connection reset by peer"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if rejection := IsRejection(test.err); rejection != test.expected {
+			t.Fatalf("%v: expected %v, got %v", test.err, test.expected, rejection)
+		}
+	}
+	if !IsUnknownStatus(Error{Code: -1007}) || IsUnknownStatus(Error{Code: -1003}) {
+		t.Fatal("expected only the timeout to have an unknown status")
+	}
+}
+
+// TestRefused tests that the error code of a refused request is returned in
+// place of the response
+func TestRefused(t *testing.T) {
+	if err := refused([]byte(`{"code":-2011,"msg":"Unknown order sent."}`)); err != (Error{Code: -2011, Msg: "Unknown order sent."}) {
+		t.Fatal("expected the refusal, got", err)
+	}
+	for _, body := range []string{`{"symbol":"BTCUSDT","orderId":1,"status":"FILLED"}`, `[]`} {
+		if err := refused([]byte(body)); err != nil {
+			t.Fatal("unexpected refusal of", body, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package api
+
+// this file contains the circuit breaker that guards the connection to the
+// exchange. When the exchange is degraded the breaker opens and requests fail
+// fast instead of hammering the exchange. While open the breaker periodically
+// probes the exchange with a ping and closes again once the ping succeeds.
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed is the normal state, requests are allowed
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen is the failed state, requests are rejected
+	BreakerOpen
+
+	// BreakerHalfOpen is the state while the breaker is probing the exchange,
+	// requests are rejected until the probe succeeds
+	BreakerHalfOpen
+)
+
+var (
+	// ErrCircuitOpen is returned for requests made while the circuit breaker
+	// is not closed
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// DefaultBreakerConfig is the default configuration of the circuit
+	// breaker of the Binance clients
+	DefaultBreakerConfig = BreakerConfig{
+		MaxConsecutiveFailures: 5,
+		ErrorRateThreshold:     0.5,
+		MinRequests:            10,
+		Window:                 time.Minute,
+		OpenTimeout:            30 * time.Second,
+	}
+)
+
+// BreakerConfig is the configuration of a circuit breaker
+type BreakerConfig struct {
+	// MaxConsecutiveFailures is the number of failures in a row that will
+	// open the breaker
+	MaxConsecutiveFailures int
+
+	// ErrorRateThreshold is the fraction of failed requests within Window
+	// that will open the breaker. It only applies once there have been
+	// MinRequests requests within the window
+	ErrorRateThreshold float64
+	MinRequests        int
+	Window             time.Duration
+
+	// OpenTimeout is how long the breaker stays open before probing the
+	// exchange
+	OpenTimeout time.Duration
+}
+
+// BreakerEvent is emitted when the state of a circuit breaker changes
+type BreakerEvent struct {
+	From   BreakerState
+	To     BreakerState
+	Time   time.Time
+	Reason string
+}
+
+// CircuitBreaker tracks the results of requests to the exchange and rejects
+// requests while the exchange is failing
+type CircuitBreaker struct {
+	config BreakerConfig
+	probe  func() error
+
+	state       BreakerState
+	consecutive int
+	results     []requestResult
+
+	subscribers []func(BreakerEvent)
+
+	mu sync.Mutex
+}
+
+// requestResult is the outcome of a request within the error rate window
+type requestResult struct {
+	time   time.Time
+	failed bool
+}
+
+// String implements the fmt.Stringer interface
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// NewCircuitBreaker creates a new circuit breaker. The probe is called while
+// the breaker is half-open to check if the exchange has recovered
+func NewCircuitBreaker(config BreakerConfig, probe func() error) *CircuitBreaker {
+	return &CircuitBreaker{
+		config: config,
+		probe:  probe,
+	}
+}
+
+// Subscribe registers a function that is called on every state change. The
+// function is called in its own goroutine
+func (cb *CircuitBreaker) Subscribe(f func(BreakerEvent)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.subscribers = append(cb.subscribers, f)
+}
+
+// State returns the current state of the breaker
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Allow returns ErrCircuitOpen if requests are not allowed
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state != BreakerClosed {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Record records the result of a request, opening the breaker if the failure
+// limits are reached
+func (cb *CircuitBreaker) Record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state != BreakerClosed {
+		return
+	}
+
+	// Update the window
+	now := time.Now()
+	cb.results = append(cb.results, requestResult{time: now, failed: err != nil})
+	cutoff := now.Add(-cb.config.Window)
+	i := 0
+	for i < len(cb.results) && cb.results[i].time.Before(cutoff) {
+		i++
+	}
+	cb.results = cb.results[i:]
+
+	if err == nil {
+		cb.consecutive = 0
+		return
+	}
+	cb.consecutive++
+	if cb.config.MaxConsecutiveFailures > 0 && cb.consecutive >= cb.config.MaxConsecutiveFailures {
+		cb.open(fmt.Sprintf("%v consecutive failures, last error: %v", cb.consecutive, err))
+		return
+	}
+
+	if len(cb.results) < cb.config.MinRequests || cb.config.ErrorRateThreshold <= 0 {
+		return
+	}
+	var failed int
+	for _, r := range cb.results {
+		if r.failed {
+			failed++
+		}
+	}
+	rate := float64(failed) / float64(len(cb.results))
+	if rate >= cb.config.ErrorRateThreshold {
+		cb.open(fmt.Sprintf("error rate %.2f over %v requests, last error: %v", rate, len(cb.results), err))
+	}
+}
+
+// open opens the breaker and schedules a probe
+//
+// NOTE: the caller must hold the lock
+func (cb *CircuitBreaker) open(reason string) {
+	cb.setState(BreakerOpen, reason)
+	cb.consecutive = 0
+	cb.results = nil
+	time.AfterFunc(cb.config.OpenTimeout, cb.tryProbe)
+}
+
+// tryProbe moves the breaker to half-open and probes the exchange. The breaker
+// is closed if the probe succeeds and opened again if it fails
+func (cb *CircuitBreaker) tryProbe() {
+	cb.mu.Lock()
+	if cb.state != BreakerOpen {
+		cb.mu.Unlock()
+		return
+	}
+	cb.setState(BreakerHalfOpen, "probing exchange")
+	cb.mu.Unlock()
+
+	err := cb.probe()
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if err != nil {
+		cb.open(fmt.Sprintf("probe failed: %v", err))
+		return
+	}
+	cb.setState(BreakerClosed, "probe succeeded")
+}
+
+// setState changes the state of the breaker and notifies the subscribers
+//
+// NOTE: the caller must hold the lock
+func (cb *CircuitBreaker) setState(state BreakerState, reason string) {
+	event := BreakerEvent{
+		From:   cb.state,
+		To:     state,
+		Time:   time.Now(),
+		Reason: reason,
+	}
+	cb.state = state
+	apiLog.Warnf("Circuit breaker %v -> %v: %v", event.From, event.To, reason)
+	for _, f := range cb.subscribers {
+		go f(event)
+	}
+}
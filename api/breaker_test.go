@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCircuitBreaker tests that the breaker opens after consecutive failures
+// and closes once the probe succeeds
+func TestCircuitBreaker(t *testing.T) {
+	config := BreakerConfig{
+		MaxConsecutiveFailures: 3,
+		OpenTimeout:            10 * time.Millisecond,
+		Window:                 time.Minute,
+	}
+	probeErr := make(chan error, 2)
+	cb := NewCircuitBreaker(config, func() error { return <-probeErr })
+	events := make(chan BreakerEvent, 10)
+	cb.Subscribe(func(e BreakerEvent) { events <- e })
+
+	errExchange := errors.New("exchange down")
+	for i := 0; i < 2; i++ {
+		cb.Record(errExchange)
+	}
+	cb.Record(nil)
+	for i := 0; i < 2; i++ {
+		cb.Record(errExchange)
+	}
+	if cb.State() != BreakerClosed {
+		t.Fatal("breaker should be closed after a success resets the failures")
+	}
+	cb.Record(errExchange)
+	if cb.State() != BreakerOpen {
+		t.Fatal("breaker should be open after consecutive failures")
+	}
+	if err := cb.Allow(); err != ErrCircuitOpen {
+		t.Fatal("expected ErrCircuitOpen, got", err)
+	}
+
+	// Fail the first probe and pass the second
+	probeErr <- errExchange
+	probeErr <- nil
+	deadline := time.After(time.Second)
+	for cb.State() != BreakerClosed {
+		select {
+		case <-deadline:
+			t.Fatal("breaker did not close")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if err := cb.Allow(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCircuitBreakerErrorRate tests that the breaker opens on a high error
+// rate
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	config := BreakerConfig{
+		ErrorRateThreshold: 0.5,
+		MinRequests:        4,
+		Window:             time.Minute,
+		OpenTimeout:        time.Hour,
+	}
+	cb := NewCircuitBreaker(config, func() error { return nil })
+	errExchange := errors.New("exchange down")
+	cb.Record(errExchange)
+	cb.Record(nil)
+	cb.Record(nil)
+	if cb.State() != BreakerClosed {
+		t.Fatal("breaker should be closed below the minimum requests")
+	}
+	cb.Record(errExchange)
+	if cb.State() != BreakerOpen {
+		t.Fatal("breaker should be open at the error rate threshold")
+	}
+}
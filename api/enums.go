@@ -0,0 +1,148 @@
+package api
+
+// this file contains the enum types used by the Binance exchange API. Using
+// typed enums instead of raw strings means a typo is caught before the request
+// is sent to the exchange rather than being rejected by it. Responses are
+// decoded as is, so values the exchange adds later don't break the responses
+// they are in.
+
+import "fmt"
+
+// Side is the side of an order
+type Side string
+
+// OrderType is the type of an order
+type OrderType string
+
+// TimeInForce is how long an order will be active before it expires
+type TimeInForce string
+
+// OrderStatus is the status of an order
+type OrderStatus string
+
+// OrderResponseType is the type of the response to an order submission
+type OrderResponseType string
+
+// RateLimitType is the type of a rate limit of the exchange API
+type RateLimitType string
+
+// Order sides
+const (
+	SideBuy  Side = "BUY"
+	SideSell Side = "SELL"
+)
+
+// Order types
+const (
+	OrderTypeLimit           OrderType = "LIMIT"
+	OrderTypeMarket          OrderType = "MARKET"
+	OrderTypeStopLoss        OrderType = "STOP_LOSS"
+	OrderTypeStopLossLimit   OrderType = "STOP_LOSS_LIMIT"
+	OrderTypeTakeProfit      OrderType = "TAKE_PROFIT"
+	OrderTypeTakeProfitLimit OrderType = "TAKE_PROFIT_LIMIT"
+	OrderTypeLimitMaker      OrderType = "LIMIT_MAKER"
+)
+
+// Time in force
+const (
+	// TimeInForceGTC is good till canceled
+	TimeInForceGTC TimeInForce = "GTC"
+	// TimeInForceIOC is immediate or cancel
+	TimeInForceIOC TimeInForce = "IOC"
+	// TimeInForceFOK is fill or kill
+	TimeInForceFOK TimeInForce = "FOK"
+)
+
+// Order statuses
+const (
+	OrderStatusNew             OrderStatus = "NEW"
+	OrderStatusPendingNew      OrderStatus = "PENDING_NEW"
+	OrderStatusPartiallyFilled OrderStatus = "PARTIALLY_FILLED"
+	OrderStatusFilled          OrderStatus = "FILLED"
+	OrderStatusCanceled        OrderStatus = "CANCELED"
+	OrderStatusPendingCancel   OrderStatus = "PENDING_CANCEL"
+	OrderStatusRejected        OrderStatus = "REJECTED"
+	OrderStatusExpired         OrderStatus = "EXPIRED"
+	OrderStatusExpiredInMatch  OrderStatus = "EXPIRED_IN_MATCH"
+)
+
+// Order response types
+const (
+	OrderResponseAck    OrderResponseType = "ACK"
+	OrderResponseResult OrderResponseType = "RESULT"
+	OrderResponseFull   OrderResponseType = "FULL"
+)
+
+// Rate limit types
+const (
+	RateLimitRequestWeight RateLimitType = "REQUEST_WEIGHT"
+	RateLimitOrders        RateLimitType = "ORDERS"
+	RateLimitRawRequests   RateLimitType = "RAW_REQUESTS"
+	// RateLimitRequests is the name the v1 endpoints use for the request
+	// weight limit
+	RateLimitRequests RateLimitType = "REQUESTS"
+)
+
+var (
+	sides         = []string{string(SideBuy), string(SideSell)}
+	orderTypes    = []string{string(OrderTypeLimit), string(OrderTypeMarket), string(OrderTypeStopLoss), string(OrderTypeStopLossLimit), string(OrderTypeTakeProfit), string(OrderTypeTakeProfitLimit), string(OrderTypeLimitMaker)}
+	timeInForces  = []string{string(TimeInForceGTC), string(TimeInForceIOC), string(TimeInForceFOK)}
+	orderStatuses = []string{string(OrderStatusNew), string(OrderStatusPendingNew), string(OrderStatusPartiallyFilled), string(OrderStatusFilled), string(OrderStatusCanceled), string(OrderStatusPendingCancel), string(OrderStatusRejected), string(OrderStatusExpired), string(OrderStatusExpiredInMatch)}
+	responseTypes = []string{string(OrderResponseAck), string(OrderResponseResult), string(OrderResponseFull)}
+	rateLimits    = []string{string(RateLimitRequestWeight), string(RateLimitOrders), string(RateLimitRawRequests), string(RateLimitRequests)}
+)
+
+// validateEnum returns an error if the value is not one of the valid values of
+// the enum
+func validateEnum(enum, value string, valid []string) error {
+	for _, v := range valid {
+		if value == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %v %q, expected one of %v", enum, value, valid)
+}
+
+// Validate returns an error if the side is not a valid side
+func (s Side) Validate() error { return validateEnum("side", string(s), sides) }
+
+// Validate returns an error if the order type is not a valid order type
+func (ot OrderType) Validate() error { return validateEnum("order type", string(ot), orderTypes) }
+
+// Validate returns an error if the time in force is not a valid time in force
+func (tif TimeInForce) Validate() error {
+	return validateEnum("time in force", string(tif), timeInForces)
+}
+
+// Validate returns an error if the status is not a valid order status
+func (st OrderStatus) Validate() error {
+	return validateEnum("order status", string(st), orderStatuses)
+}
+
+// Validate returns an error if the response type is not a valid response type
+func (rt OrderResponseType) Validate() error {
+	return validateEnum("order response type", string(rt), responseTypes)
+}
+
+// Validate returns an error if the rate limit type is not a valid rate limit
+// type
+func (rl RateLimitType) Validate() error {
+	return validateEnum("rate limit type", string(rl), rateLimits)
+}
+
+// Opposite returns the other side
+func (s Side) Opposite() Side {
+	if s == SideBuy {
+		return SideSell
+	}
+	return SideBuy
+}
+
+// Final returns true if the order will not change status anymore
+func (st OrderStatus) Final() bool {
+	switch st {
+	case OrderStatusFilled, OrderStatusCanceled, OrderStatusRejected, OrderStatusExpired, OrderStatusExpiredInMatch:
+		return true
+	}
+	return false
+}
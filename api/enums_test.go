@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestEnumJSON tests that enums are decoded as is, including values that are
+// not known, and validated before they are sent
+func TestEnumJSON(t *testing.T) {
+	var order Order
+	err := json.Unmarshal([]byte(`{"symbol":"BTCUSDT","status":"FILLED","timeInForce":"GTC","type":"LIMIT","side":"SELL"}`), &order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Side != SideSell || order.Status != OrderStatusFilled || order.Type != OrderTypeLimit || order.TimeInForce != TimeInForceGTC {
+		t.Fatal("unexpected order", order)
+	}
+
+	err = json.Unmarshal([]byte(`{"symbol":"BTCUSDT","status":"EXPIRED_IN_MATCH","type":"OCO_LIMIT"}`), &order)
+	if err != nil {
+		t.Fatal("unknown values should be decoded, got", err)
+	}
+	if !order.Status.Final() || order.Type != "OCO_LIMIT" || order.Type.Validate() == nil {
+		t.Fatal("unexpected order", order)
+	}
+	var limits BNBLimits
+	err = json.Unmarshal([]byte(`{"rateLimits":[{"rateLimitType":"CONNECTIONS"}]}`), &limits)
+	if err != nil {
+		t.Fatal("unknown rate limit types should be decoded, got", err)
+	}
+
+	b, err := json.Marshal(order.Side)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `"SELL"` {
+		t.Fatal("unexpected json", string(b))
+	}
+
+	if err := Side("buy").Validate(); err == nil {
+		t.Fatal("expected error for lower case side")
+	}
+	if err := OrderType("").Validate(); err == nil {
+		t.Fatal("expected error for empty order type")
+	}
+}
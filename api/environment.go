@@ -0,0 +1,179 @@
+package api
+
+// this file contains the environment profiles for the Binance exchange API. A
+// profile bundles everything needed to talk to one deployment of the exchange
+// so that the trader can be pointed at production, the Spot Testnet or a local
+// fake without changing code.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// ProductionEnv is the name of the Binance production profile
+	ProductionEnv = "production"
+
+	// TestnetEnv is the name of the Binance Spot Testnet profile
+	TestnetEnv = "testnet"
+
+	// LocalEnv is the name of the profile for a fake exchange running on the
+	// local machine
+	LocalEnv = "local"
+
+	// EnvironmentVar is the environment variable used to select the profile
+	// on start up
+	EnvironmentVar = "traderbotEnv"
+)
+
+var (
+	// ErrLiveTradingDisabled is returned when a live order endpoint is called
+	// with a profile that does not allow live trading
+	ErrLiveTradingDisabled = errors.New("live trading is disabled for this environment")
+
+	// errUnknownEnvironment is returned when a profile name is not recognized
+	errUnknownEnvironment = errors.New("unknown environment")
+
+	// activeEnv is the environment used by NewBinanceClient
+	activeEnv   = mustLoadEnvironment(ProductionEnv)
+	activeEnvMu sync.Mutex
+)
+
+// Environment is a profile for a deployment of the Binance exchange
+type Environment struct {
+	// Name is the name of the profile
+	Name string
+
+	// RESTAddress is the base API endpoint for REST calls
+	RESTAddress string
+
+	// WebSocketAddress is the base endpoint for websocket streams
+	WebSocketAddress string
+
+	// WalletAddress is the base endpoint for the wallet API, it is empty if
+	// the environment does not provide the wallet endpoints
+	WalletAddress string
+
+	// APIPubKey and APISecretKey are the credentials used to sign requests
+	APIPubKey    string
+	APISecretKey string
+
+	// LiveTrading is the safety flag that must be set for orders to be posted
+	// to the live order endpoints
+	LiveTrading bool
+}
+
+// profile describes where to find the addresses and credentials of an
+// environment
+type profile struct {
+	restAddress      string
+	webSocketAddress string
+	walletAddress    string
+	pubKeyVar        string
+	secretKeyVar     string
+
+	// liveTradingVar is the environment variable that enables live trading,
+	// if empty liveTrading is used
+	liveTradingVar string
+	liveTrading    bool
+}
+
+// profiles are the known environment profiles
+var profiles = map[string]profile{
+	ProductionEnv: {
+		restAddress:      "https://api.binance.com/api/",
+		webSocketAddress: "wss://stream.binance.com:9443/ws/",
+		walletAddress:    "https://api.binance.com/sapi/",
+		pubKeyVar:        "bnbAPIPubKey",
+		secretKeyVar:     "bnbAPISecretKey",
+		liveTradingVar:   "bnbLiveTrading",
+	},
+	TestnetEnv: {
+		restAddress:      "https://testnet.binance.vision/api/",
+		webSocketAddress: "wss://testnet.binance.vision/ws/",
+		pubKeyVar:        "bnbTestnetAPIPubKey",
+		secretKeyVar:     "bnbTestnetAPISecretKey",
+		liveTrading:      true,
+	},
+	LocalEnv: {
+		restAddress:      "http://localhost:8080/api/",
+		webSocketAddress: "ws://localhost:8080/ws/",
+		walletAddress:    "http://localhost:8080/sapi/",
+		pubKeyVar:        "bnbLocalAPIPubKey",
+		secretKeyVar:     "bnbLocalAPISecretKey",
+		liveTrading:      true,
+	},
+}
+
+// EnvironmentNames returns the names of the known environment profiles
+func EnvironmentNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadEnvironment returns the environment profile with the given name. The
+// credentials are read from the profile's environment variables.
+//
+// NOTE: the production profile only allows live trading when the
+// `bnbLiveTrading` environment variable is set to true
+func LoadEnvironment(name string) (Environment, error) {
+	p, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return Environment{}, fmt.Errorf("%v: %q, expected one of %v", errUnknownEnvironment, name, EnvironmentNames())
+	}
+	env := Environment{
+		Name:             strings.ToLower(name),
+		RESTAddress:      p.restAddress,
+		WebSocketAddress: p.webSocketAddress,
+		WalletAddress:    p.walletAddress,
+		APIPubKey:        os.Getenv(p.pubKeyVar),
+		APISecretKey:     os.Getenv(p.secretKeyVar),
+		LiveTrading:      p.liveTrading,
+	}
+	if p.liveTradingVar != "" {
+		env.LiveTrading = strings.EqualFold(os.Getenv(p.liveTradingVar), "true")
+	}
+	return env, nil
+}
+
+// mustLoadEnvironment loads an environment and panics if it is unknown. It
+// should only be used for the built in profiles
+func mustLoadEnvironment(name string) Environment {
+	env, err := LoadEnvironment(name)
+	if err != nil {
+		panic(err)
+	}
+	return env
+}
+
+// SetEnvironment sets the environment used by new Binance clients
+func SetEnvironment(env Environment) {
+	activeEnvMu.Lock()
+	defer activeEnvMu.Unlock()
+	activeEnv = env
+	apiLog.Infof("Using %v environment, live trading: %v", env.Name, env.LiveTrading)
+}
+
+// ActiveEnvironment returns the environment used by new Binance clients
+func ActiveEnvironment() Environment {
+	activeEnvMu.Lock()
+	defer activeEnvMu.Unlock()
+	return activeEnv
+}
+
+// checkLiveTrading returns an error if the client's environment does not
+// allow live trading
+func (c *Client) checkLiveTrading() error {
+	if !c.Env.LiveTrading {
+		return ErrLiveTradingDisabled
+	}
+	return nil
+}
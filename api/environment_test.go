@@ -0,0 +1,37 @@
+package api
+
+import (
+	"os"
+	"testing"
+)
+
+// TestLiveTradingGate tests that live orders are refused unless the
+// environment explicitly allows live trading
+func TestLiveTradingGate(t *testing.T) {
+	os.Setenv("bnbLiveTrading", "")
+	env, err := LoadEnvironment(ProductionEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.LiveTrading {
+		t.Fatal("production should not allow live trading by default")
+	}
+	c := NewEnvironmentClient(env)
+	if _, err := c.PostNewLimitOrder(BTCUSDT, SideBuy, 1, 20000); err != ErrLiveTradingDisabled {
+		t.Fatal("expected ErrLiveTradingDisabled, got", err)
+	}
+
+	os.Setenv("bnbLiveTrading", "true")
+	defer os.Setenv("bnbLiveTrading", "")
+	env, err = LoadEnvironment(ProductionEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !env.LiveTrading {
+		t.Fatal("production should allow live trading when enabled")
+	}
+
+	if _, err := LoadEnvironment("mainnet"); err == nil {
+		t.Fatal("expected error for unknown environment")
+	}
+}
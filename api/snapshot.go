@@ -0,0 +1,134 @@
+package api
+
+// this file contains the code for taking a snapshot of every ticker on the
+// Binance exchange. A snapshot lets portfolio valuation and cross rate lookups
+// use one consistent set of prices rather than one request per symbol.
+
+import (
+	"strconv"
+	"time"
+)
+
+// bridgeAssets are the assets used to convert between two assets that do not
+// have a market with each other, in order of preference
+var bridgeAssets = []string{"BTC", "BNB", "ETH", "USDT"}
+
+// Book is the best bid and ask of a symbol
+type Book struct {
+	BidPrice float64
+	BidQty   float64
+	AskPrice float64
+	AskQty   float64
+}
+
+// TickerSnapshot is the price and book of every symbol on the exchange at a
+// point in time
+type TickerSnapshot struct {
+	Time   time.Time
+	Prices map[string]float64
+	Books  map[string]Book
+}
+
+// GetTickerSnapshot returns a snapshot of the prices and books of all the
+// symbols on the exchange
+//
+// Weight 4
+func (c *Client) GetTickerSnapshot() (TickerSnapshot, error) {
+	prices, err := c.GetAllTickerPrices()
+	if err != nil {
+		return TickerSnapshot{}, err
+	}
+	books, err := c.GetAllBookTickers()
+	if err != nil {
+		return TickerSnapshot{}, err
+	}
+	return NewTickerSnapshot(time.Now(), prices, books)
+}
+
+// NewTickerSnapshot builds a snapshot from the ticker prices and book tickers
+// returned by the exchange
+func NewTickerSnapshot(t time.Time, prices []TickerPrice, books []BookTicker) (TickerSnapshot, error) {
+	s := TickerSnapshot{
+		Time:   t,
+		Prices: make(map[string]float64, len(prices)),
+		Books:  make(map[string]Book, len(books)),
+	}
+	for _, tp := range prices {
+		price, err := strconv.ParseFloat(tp.Price, 64)
+		if err != nil {
+			return TickerSnapshot{}, err
+		}
+		s.Prices[tp.Symbol] = price
+	}
+	for _, bt := range books {
+		var b Book
+		var err error
+		for _, f := range []struct {
+			str string
+			val *float64
+		}{
+			{bt.BidPrice, &b.BidPrice},
+			{bt.BidQty, &b.BidQty},
+			{bt.AskPrice, &b.AskPrice},
+			{bt.AskQty, &b.AskQty},
+		} {
+			*f.val, err = strconv.ParseFloat(f.str, 64)
+			if err != nil {
+				return TickerSnapshot{}, err
+			}
+		}
+		s.Books[bt.Symbol] = b
+	}
+	return s, nil
+}
+
+// Price returns the last price of a symbol
+func (s TickerSnapshot) Price(symbol string) (float64, bool) {
+	price, ok := s.Prices[symbol]
+	return price, ok && price > 0
+}
+
+// Book returns the best bid and ask of a symbol
+func (s TickerSnapshot) Book(symbol string) (Book, bool) {
+	book, ok := s.Books[symbol]
+	return book, ok
+}
+
+// Rate returns the price of one unit of asset in units of quote. The rate is
+// taken from the asset/quote market, the inverse of the quote/asset market, or
+// through a bridge asset if there is no direct market
+func (s TickerSnapshot) Rate(asset, quote string) (float64, bool) {
+	if rate, ok := s.directRate(asset, quote); ok {
+		return rate, true
+	}
+	for _, bridge := range bridgeAssets {
+		if bridge == asset || bridge == quote {
+			continue
+		}
+		toBridge, ok := s.directRate(asset, bridge)
+		if !ok {
+			continue
+		}
+		fromBridge, ok := s.directRate(bridge, quote)
+		if !ok {
+			continue
+		}
+		return toBridge * fromBridge, true
+	}
+	return 0, false
+}
+
+// directRate returns the price of asset in quote from the asset/quote or
+// quote/asset markets
+func (s TickerSnapshot) directRate(asset, quote string) (float64, bool) {
+	if asset == quote {
+		return 1, true
+	}
+	if price, ok := s.Price(asset + quote); ok {
+		return price, true
+	}
+	if price, ok := s.Price(quote + asset); ok {
+		return 1 / price, true
+	}
+	return 0, false
+}
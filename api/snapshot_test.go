@@ -0,0 +1,48 @@
+package api
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestTickerSnapshotRate tests the direct, inverse and bridged rates of a
+// ticker snapshot
+func TestTickerSnapshotRate(t *testing.T) {
+	prices := []TickerPrice{
+		{Symbol: BTCUSDT, Price: "20000"},
+		{Symbol: BNBBTC, Price: "0.015"},
+		{Symbol: "XYZBNB", Price: "2"},
+	}
+	books := []BookTicker{
+		{Symbol: BTCUSDT, BidPrice: "19999", BidQty: "1", AskPrice: "20001", AskQty: "2"},
+	}
+	s, err := NewTickerSnapshot(time.Now(), prices, books)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		asset, quote string
+		rate         float64
+		ok           bool
+	}{
+		{"BTC", "USDT", 20000, true},
+		{"USDT", "BTC", 1.0 / 20000, true},
+		{"BNB", "USDT", 300, true},
+		{"XYZ", "BTC", 0.03, true},
+		{"USDT", "USDT", 1, true},
+		{"ABC", "USDT", 0, false},
+	}
+	for _, test := range tests {
+		rate, ok := s.Rate(test.asset, test.quote)
+		if ok != test.ok || math.Abs(rate-test.rate) > 1e-9 {
+			t.Errorf("%v/%v: expected %v %v, got %v %v", test.asset, test.quote, test.rate, test.ok, rate, ok)
+		}
+	}
+
+	book, ok := s.Book(BTCUSDT)
+	if !ok || book.BidPrice != 19999 || book.AskQty != 2 {
+		t.Fatal("unexpected book", book, ok)
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+// this file contains the code for interacting with the Binance wallet API. The
+// wallet endpoints live under the sapi path of the exchange rather than the api
+// path used by the trading endpoints, see Environment.WalletAddress
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// DepositSuccess is the status of a deposit that has been credited
+	DepositSuccess = 1
+
+	// WithdrawCompleted is the status of a withdrawal that has been completed
+	WithdrawCompleted = 6
+
+	// TransferSpotToFunding is the universal transfer type for moving assets
+	// out of the spot wallet into the funding wallet
+	TransferSpotToFunding = "MAIN_FUNDING"
+
+	// TransferFundingToSpot is the universal transfer type for moving assets
+	// into the spot wallet from the funding wallet
+	TransferFundingToSpot = "FUNDING_MAIN"
+
+	// HistoryWindow is the longest time range of a history request
+	HistoryWindow = 90 * 24 * time.Hour
+)
+
+var (
+	// BNBDepositHistory is the endpoint for the deposit history of the account
+	//
+	// Weight 1
+	BNBDepositHistory = "v1/capital/deposit/hisrec"
+
+	// BNBWithdrawHistory is the endpoint for the withdrawal history of the
+	// account
+	//
+	// Weight 1
+	BNBWithdrawHistory = "v1/capital/withdraw/history"
+
+	// BNBTransferHistory is the endpoint for the universal transfer history of
+	// the account, it takes a mandatory `type` parameter
+	//
+	// Weight 1
+	BNBTransferHistory = "v1/asset/transfer"
+
+	// ErrNoWalletAPI is returned when the environment does not provide the
+	// wallet endpoints
+	ErrNoWalletAPI = errors.New("environment does not support the wallet api")
+)
+
+// Deposit is a deposit into the Binance account
+type Deposit struct {
+	ID         string `json:"id"`
+	Amount     string `json:"amount"`
+	Coin       string `json:"coin"`
+	Network    string `json:"network"`
+	Status     int    `json:"status"`
+	Address    string `json:"address"`
+	TxID       string `json:"txId"`
+	InsertTime int64  `json:"insertTime"`
+}
+
+// Withdrawal is a withdrawal from the Binance account
+type Withdrawal struct {
+	ID             string `json:"id"`
+	Amount         string `json:"amount"`
+	TransactionFee string `json:"transactionFee"`
+	Coin           string `json:"coin"`
+	Network        string `json:"network"`
+	Status         int    `json:"status"`
+	Address        string `json:"address"`
+	TxID           string `json:"txId"`
+	ApplyTime      string `json:"applyTime"`
+	CompleteTime   string `json:"completeTime"`
+}
+
+// Transfers is a page of universal transfers between the Binance wallets
+type Transfers struct {
+	Total int        `json:"total"`
+	Rows  []Transfer `json:"rows"`
+}
+
+// Transfer is a universal transfer between the Binance wallets
+type Transfer struct {
+	Asset     string `json:"asset"`
+	Amount    string `json:"amount"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	TranID    int64  `json:"tranId"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// binanceTimeLayout is the layout of the timestamps returned as strings by the
+// wallet endpoints
+const binanceTimeLayout = "2006-01-02 15:04:05"
+
+// Time returns the time the withdrawal was completed, falling back to the
+// time it was applied for
+func (w Withdrawal) Time() (time.Time, error) {
+	if w.CompleteTime != "" {
+		return time.Parse(binanceTimeLayout, w.CompleteTime)
+	}
+	return time.Parse(binanceTimeLayout, w.ApplyTime)
+}
+
+// getWallet submits a signed get request to a wallet endpoint
+func (c *Client) getWallet(endpoint string, values url.Values) ([]byte, error) {
+	if c.Env.WalletAddress == "" {
+		return []byte{}, ErrNoWalletAPI
+	}
+	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	values.Set("recvWindow", strconv.FormatInt(recvWindow, 10))
+	values.Set("timestamp", timestamp)
+	params := values.Encode()
+	sig := c.signature(params)
+	query := fmt.Sprintf("?%v&signature=%v", params, sig)
+	return c.GetSecureAPI(c.Env.WalletAddress + endpoint + query)
+}
+
+// timeValues returns the url values for the time range of a history request,
+// a zero start or end time is left for Binance to default
+func timeValues(start, end time.Time) url.Values {
+	values := url.Values{}
+	if !start.IsZero() {
+		values.Set("startTime", strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10))
+	}
+	if !end.IsZero() {
+		values.Set("endTime", strconv.FormatInt(end.UnixNano()/int64(time.Millisecond), 10))
+	}
+	return values
+}
+
+// GetDepositHistory calls the endpoint that returns the deposit history of the
+// account between the start and end times
+//
+// NOTE: Binance limits the range to the HistoryWindow
+func (c *Client) GetDepositHistory(start, end time.Time) ([]Deposit, error) {
+	body, err := c.getWallet(BNBDepositHistory, timeValues(start, end))
+	if err != nil {
+		apiLog.Warn("WARN: error submitting get request:", err)
+		return []Deposit{}, err
+	}
+
+	deposits := []Deposit{}
+	err = json.Unmarshal(body, &deposits)
+	if err != nil {
+		apiLog.Warn("WARN: error unmarshaling deposit history:", err)
+		return []Deposit{}, err
+	}
+	return deposits, nil
+}
+
+// GetWithdrawHistory calls the endpoint that returns the withdrawal history of
+// the account between the start and end times
+//
+// NOTE: Binance limits the range to the HistoryWindow
+func (c *Client) GetWithdrawHistory(start, end time.Time) ([]Withdrawal, error) {
+	body, err := c.getWallet(BNBWithdrawHistory, timeValues(start, end))
+	if err != nil {
+		apiLog.Warn("WARN: error submitting get request:", err)
+		return []Withdrawal{}, err
+	}
+
+	withdrawals := []Withdrawal{}
+	err = json.Unmarshal(body, &withdrawals)
+	if err != nil {
+		apiLog.Warn("WARN: error unmarshaling withdraw history:", err)
+		return []Withdrawal{}, err
+	}
+	return withdrawals, nil
+}
+
+// GetTransferHistory calls the endpoint that returns the universal transfer
+// history of the given transfer type between the start and end times
+//
+// NOTE: Binance limits the range to the HistoryWindow
+func (c *Client) GetTransferHistory(transferType string, start, end time.Time) ([]Transfer, error) {
+	values := timeValues(start, end)
+	values.Set("type", transferType)
+	body, err := c.getWallet(BNBTransferHistory, values)
+	if err != nil {
+		apiLog.Warn("WARN: error submitting get request:", err)
+		return []Transfer{}, err
+	}
+
+	transfers := Transfers{}
+	err = json.Unmarshal(body, &transfers)
+	if err != nil {
+		apiLog.Warn("WARN: error unmarshaling transfer history:", err)
+		return []Transfer{}, err
+	}
+	return transfers.Rows, nil
+}
@@ -0,0 +1,408 @@
+package backtest
+
+// the backtest package replays historical market data through the trader's
+// decision code to see how a strategy would have performed. The exchange is
+// simulated with a simulated clock, simulated balances and simulated fills
+// that are charged a fee and slipped against the trader.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/trader"
+)
+
+var (
+	// errNoTicks is returned when a backtest is run without any data
+	errNoTicks = errors.New("no ticks to replay")
+
+	// errInsufficientBalance is the reason a fill is rejected when the
+	// simulated balance can't cover it
+	errInsufficientBalance = errors.New("insufficient balance")
+)
+
+// Config is the configuration of a backtest
+type Config struct {
+	// Strategy is the strategy being tested
+	Strategy trader.Strategy
+
+	// InitialBalances are the starting balances keyed by asset
+	InitialBalances map[string]float64
+
+	// Fee is the fee charged on each fill as a fraction of the fill, it is
+	// taken from the asset received
+	Fee float64
+
+	// Slippage is the fraction of the price that fills are moved against the
+	// trader, buys fill higher and sells fill lower
+	Slippage float64
+
+	// QuoteAsset is the asset the equity is valued in, defaults to USDT
+	QuoteAsset string
+}
+
+// Clock is the simulated clock of a backtest, it is moved forward by the ticks
+// being replayed
+type Clock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+// Now returns the simulated time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// set moves the clock to the provided time
+func (c *Clock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Trade is a simulated fill
+type Trade struct {
+	Time     time.Time
+	Symbol   string
+	Side     api.Side
+	Price    float64
+	Quantity float64
+	Fee      float64
+	FeeAsset string
+	LotID    uint64
+	Reason   string
+}
+
+// EquityPoint is the value of the simulated portfolio at a point in time
+type EquityPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// SymbolStats are the trade statistics of a symbol
+type SymbolStats struct {
+	Buys      int
+	Sells     int
+	HighBuy   float64
+	LowBuy    float64
+	HighSell  float64
+	LowSell   float64
+	BuyVolume float64 // in the quote asset of the symbol
+	SellValue float64 // in the quote asset of the symbol
+}
+
+// Summary are the summary statistics of a backtest
+type Summary struct {
+	Start time.Time
+	End   time.Time
+
+	Symbols map[string]*SymbolStats
+
+	StartValue         float64
+	EndValue           float64
+	ValueChange        float64
+	ValueChangePercent float64
+	MaxDrawdownPercent float64
+
+	// BalanceChanges are the change in quantity of each asset
+	BalanceChanges map[string]float64
+
+	// Fees is the value of the fees paid in the quote asset
+	Fees float64
+
+	// Rejected is the number of orders that could not be filled
+	Rejected int
+
+	// Ledger is the summary of the lots sold, its win rate and payoff size
+	// the Kelly sizer
+	Ledger trader.LedgerSummary
+}
+
+// Result is the result of a backtest
+type Result struct {
+	Trades   []Trade
+	Equity   []EquityPoint
+	Balances map[string]float64
+	Summary  Summary
+}
+
+// Backtester replays ticks through a trader and simulates the exchange
+type Backtester struct {
+	config Config
+	clock  *Clock
+	trader *trader.Trader
+
+	balances map[string]float64
+	prices   map[string]float64
+	result   Result
+}
+
+// New creates a new backtester
+func New(config Config) *Backtester {
+	if config.QuoteAsset == "" {
+		config.QuoteAsset = "USDT"
+	}
+	b := &Backtester{
+		config:   config,
+		clock:    &Clock{},
+		trader:   trader.NewTrader(config.Strategy),
+		balances: make(map[string]float64),
+		prices:   make(map[string]float64),
+	}
+	for asset, qty := range config.InitialBalances {
+		b.balances[asset] = qty
+	}
+	b.result.Summary.Symbols = make(map[string]*SymbolStats)
+	return b
+}
+
+// Clock returns the simulated clock of the backtest
+func (b *Backtester) Clock() *Clock { return b.clock }
+
+// Trader returns the trader being tested
+func (b *Backtester) Trader() *trader.Trader { return b.trader }
+
+// Run replays the ticks, which must be ordered by time, through the trader and
+// returns the result
+func (b *Backtester) Run(ticks []Tick) (Result, error) {
+	if len(ticks) == 0 {
+		return Result{}, errNoTicks
+	}
+	if err := b.updateBalances(); err != nil {
+		return Result{}, err
+	}
+
+	for i, tick := range ticks {
+		b.clock.set(tick.Time)
+		b.prices[tick.Symbol] = tick.Price
+
+		// Only decide once all the ticks for this time have been applied
+		if i+1 < len(ticks) && ticks[i+1].Time.Equal(tick.Time) {
+			continue
+		}
+		prices := make(map[string]float64, len(b.prices))
+		for symbol, price := range b.prices {
+			prices[symbol] = price
+		}
+		for _, intent := range b.trader.Decide(b.clock.Now(), prices) {
+			// Resting orders away from the price are not filled, the
+			// strategy decides on them again on the next tick
+			if intent.Resting && !marketable(intent, prices[intent.Symbol]) {
+				continue
+			}
+			if err := b.fill(intent); err != nil {
+				b.result.Summary.Rejected++
+				continue
+			}
+		}
+		if err := b.updateBalances(); err != nil {
+			return Result{}, err
+		}
+		b.result.Equity = append(b.result.Equity, EquityPoint{Time: b.clock.Now(), Value: b.value()})
+	}
+
+	b.summarize(ticks[0].Time, ticks[len(ticks)-1].Time)
+	b.result.Balances = b.balances
+	return b.result, nil
+}
+
+// fill simulates the fill of an order at the current price, moved by the
+// slippage and charged the fee
+func (b *Backtester) fill(intent trader.OrderIntent) error {
+	base, quote, ok := api.SplitSymbol(intent.Symbol)
+	if !ok {
+		return fmt.Errorf("unknown symbol %v", intent.Symbol)
+	}
+	price, ok := b.prices[intent.Symbol]
+	if !ok {
+		return fmt.Errorf("no price for %v", intent.Symbol)
+	}
+
+	trade := Trade{
+		Time:     b.clock.Now(),
+		Symbol:   intent.Symbol,
+		Side:     intent.Side,
+		Quantity: intent.Quantity,
+		LotID:    intent.LotID,
+		Reason:   intent.Reason,
+	}
+	received := intent.Quantity
+	switch intent.Side {
+	case api.SideBuy:
+		trade.Price = price * (1 + b.config.Slippage)
+		cost := trade.Price * intent.Quantity
+		if b.balances[quote] < cost {
+			return errInsufficientBalance
+		}
+		trade.Fee = intent.Quantity * b.config.Fee
+		trade.FeeAsset = base
+		received = intent.Quantity - trade.Fee
+		b.balances[quote] -= cost
+		b.balances[base] += received
+	case api.SideSell:
+		trade.Price = price * (1 - b.config.Slippage)
+		if b.balances[base] < intent.Quantity {
+			return errInsufficientBalance
+		}
+		proceeds := trade.Price * intent.Quantity
+		trade.Fee = proceeds * b.config.Fee
+		trade.FeeAsset = quote
+		b.balances[base] -= intent.Quantity
+		b.balances[quote] += proceeds - trade.Fee
+	default:
+		return fmt.Errorf("unknown side %v", intent.Side)
+	}
+
+	b.trader.RecordFill(intent, trade.Price, received)
+	b.result.Trades = append(b.result.Trades, trade)
+	return nil
+}
+
+// marketable returns true if the limit price of an intent has been reached
+func marketable(intent trader.OrderIntent, price float64) bool {
+	if intent.Side == api.SideBuy {
+		return price <= intent.Price
+	}
+	return price >= intent.Price
+}
+
+// updateBalances passes the simulated balances to the trader
+func (b *Backtester) updateBalances() error {
+	var account api.AccountInfo
+	account.CanTrade = true
+	account.MakerCommission = int(math.Round(b.config.Fee * 10000))
+	account.TakerCommission = account.MakerCommission
+	for asset, qty := range b.balances {
+		account.Balances = append(account.Balances, api.Asset{
+			Asset:  asset,
+			Free:   strconv.FormatFloat(qty, 'f', -1, 64),
+			Locked: "0",
+		})
+	}
+	return b.trader.UpdateBalances(account)
+}
+
+// value returns the value of the simulated balances in the quote asset
+func (b *Backtester) value() float64 {
+	snapshot := api.TickerSnapshot{Time: b.clock.Now(), Prices: b.prices}
+	var value float64
+	for asset, qty := range b.balances {
+		rate, ok := snapshot.Rate(asset, b.config.QuoteAsset)
+		if !ok {
+			continue
+		}
+		value += qty * rate
+	}
+	return value
+}
+
+// summarize calculates the summary statistics of the backtest
+func (b *Backtester) summarize(start, end time.Time) {
+	s := &b.result.Summary
+	s.Start = start
+	s.End = end
+
+	snapshot := api.TickerSnapshot{Time: end, Prices: b.prices}
+	for _, trade := range b.result.Trades {
+		stats, ok := s.Symbols[trade.Symbol]
+		if !ok {
+			stats = &SymbolStats{LowBuy: math.MaxFloat64, LowSell: math.MaxFloat64}
+			s.Symbols[trade.Symbol] = stats
+		}
+		notional := trade.Price * trade.Quantity
+		switch trade.Side {
+		case api.SideBuy:
+			stats.Buys++
+			stats.HighBuy = math.Max(stats.HighBuy, trade.Price)
+			stats.LowBuy = math.Min(stats.LowBuy, trade.Price)
+			stats.BuyVolume += notional
+		case api.SideSell:
+			stats.Sells++
+			stats.HighSell = math.Max(stats.HighSell, trade.Price)
+			stats.LowSell = math.Min(stats.LowSell, trade.Price)
+			stats.SellValue += notional
+		}
+		rate, _ := snapshot.Rate(trade.FeeAsset, b.config.QuoteAsset)
+		s.Fees += trade.Fee * rate
+	}
+	for _, stats := range s.Symbols {
+		if stats.Buys == 0 {
+			stats.LowBuy = 0
+		}
+		if stats.Sells == 0 {
+			stats.LowSell = 0
+		}
+	}
+
+	if len(b.result.Equity) > 0 {
+		s.StartValue = b.result.Equity[0].Value
+		s.EndValue = b.result.Equity[len(b.result.Equity)-1].Value
+	}
+	s.ValueChange = s.EndValue - s.StartValue
+	if s.StartValue != 0 {
+		s.ValueChangePercent = s.ValueChange / s.StartValue * 100
+	}
+	var peak float64
+	for _, point := range b.result.Equity {
+		peak = math.Max(peak, point.Value)
+		if peak > 0 {
+			s.MaxDrawdownPercent = math.Max(s.MaxDrawdownPercent, (peak-point.Value)/peak*100)
+		}
+	}
+
+	s.Ledger = trader.SummarizeLedger(b.trader.Ledger())
+
+	s.BalanceChanges = make(map[string]float64)
+	for asset, qty := range b.balances {
+		s.BalanceChanges[asset] = qty - b.config.InitialBalances[asset]
+	}
+	for asset, qty := range b.config.InitialBalances {
+		if _, ok := b.balances[asset]; !ok {
+			s.BalanceChanges[asset] = -qty
+		}
+	}
+}
+
+// String implements the fmt.Stringer interface
+func (s Summary) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Backtest %v to %v\n", s.Start.Format(time.RFC3339), s.End.Format(time.RFC3339))
+	var symbols []string
+	for symbol := range s.Symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		stats := s.Symbols[symbol]
+		fmt.Fprintf(&sb, "%v\n", symbol)
+		fmt.Fprintf(&sb, "  Number of buys: %v (high %v, low %v)\n", stats.Buys, stats.HighBuy, stats.LowBuy)
+		fmt.Fprintf(&sb, "  Number of sells: %v (high %v, low %v)\n", stats.Sells, stats.HighSell, stats.LowSell)
+	}
+	fmt.Fprintf(&sb, "Start value: %.2f\n", s.StartValue)
+	fmt.Fprintf(&sb, "End value: %.2f\n", s.EndValue)
+	fmt.Fprintf(&sb, "Value change: %.2f (%.2f%%)\n", s.ValueChange, s.ValueChangePercent)
+	fmt.Fprintf(&sb, "Max drawdown: %.2f%%\n", s.MaxDrawdownPercent)
+	fmt.Fprintf(&sb, "Fees: %.2f\n", s.Fees)
+	fmt.Fprintf(&sb, "Rejected orders: %v\n", s.Rejected)
+	fmt.Fprintf(&sb, "Closed lots: %v (%v wins, %v losses, pnl %.2f)\n", s.Ledger.Lots, s.Ledger.Wins, s.Ledger.Losses, s.Ledger.PnL)
+	kelly := trader.NewKellySizer(s.Ledger, 1)
+	fmt.Fprintf(&sb, "Win rate: %.4f, payoff: %.4f, kelly: %.4f\n", kelly.WinRate, kelly.Payoff, kelly.Criterion())
+	var assets []string
+	for asset := range s.BalanceChanges {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	for _, asset := range assets {
+		fmt.Fprintf(&sb, "%v change: %v\n", asset, s.BalanceChanges[asset])
+	}
+	return sb.String()
+}
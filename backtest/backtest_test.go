@@ -0,0 +1,98 @@
+package backtest
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/trader"
+)
+
+// TestLoadBarsCSV tests loading bars from a Binance kline csv
+func TestLoadBarsCSV(t *testing.T) {
+	data := `open_time,open,high,low,close,volume,close_time
+1530000000000,100,110,90,105,12.5,1530000059999
+1530000060000,105,106,95,96,3,1530000119999
+`
+	bars, err := LoadBarsCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bars) != 2 {
+		t.Fatal("expected 2 bars, got", len(bars))
+	}
+	if bars[0].Open != 100 || bars[0].High != 110 || bars[0].Low != 90 || bars[0].Last != 105 || bars[0].Volume != 12.5 {
+		t.Fatal("unexpected bar", bars[0])
+	}
+	if b := IndicatorBars(bars)[1]; b.Close != 96 || b.High != 106 || b.Volume != 3 || !b.Time.Equal(bars[1].Time) {
+		t.Fatal("unexpected indicator bar", b)
+	}
+
+	// A rising bar visits the low before the high, a falling bar the high
+	// before the low
+	ticks := BarTicks(api.BTCUSDT, bars, true)
+	expected := []float64{100, 90, 110, 105, 105, 106, 95, 96}
+	for i, tick := range ticks {
+		if tick.Price != expected[i] {
+			t.Fatal("unexpected tick path", ticks)
+		}
+	}
+}
+
+// TestBacktest tests that a dip and recovery produce a buy and a sell with the
+// fees and slippage applied
+func TestBacktest(t *testing.T) {
+	config := trader.DefaultDipConfig
+	config.BuyQuoteAmount = 100
+	config.DiffLimit = 0.01
+	bt := New(Config{
+		Strategy:        trader.NewDipStrategy(config),
+		InitialBalances: map[string]float64{"USDT": 1000},
+		Fee:             0.001,
+		Slippage:        0.0005,
+	})
+
+	start := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	var ticks []Tick
+	for i, price := range []float64{100, 97, 95, 96, 99, 101, 100.5} {
+		ticks = append(ticks, Tick{Time: start.Add(time.Duration(i) * time.Minute), Symbol: api.BTCUSDT, Price: price})
+	}
+	result, err := bt.Run(ticks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Trades) != 2 {
+		t.Fatal("expected a buy and a sell, got", result.Trades)
+	}
+	buy, sell := result.Trades[0], result.Trades[1]
+	if buy.Side != api.SideBuy || math.Abs(buy.Price-96*1.0005) > 1e-9 {
+		t.Fatal("unexpected buy", buy)
+	}
+	if sell.Side != api.SideSell || math.Abs(sell.Price-100.5*0.9995) > 1e-9 || sell.LotID != 1 {
+		t.Fatal("unexpected sell", sell)
+	}
+	if math.Abs(buy.Fee-buy.Quantity*0.001) > 1e-12 || buy.FeeAsset != "BTC" {
+		t.Fatal("unexpected buy fee", buy)
+	}
+
+	s := result.Summary
+	stats := s.Symbols[api.BTCUSDT]
+	if stats.Buys != 1 || stats.Sells != 1 || stats.HighBuy != buy.Price || stats.LowSell != sell.Price {
+		t.Fatal("unexpected stats", stats)
+	}
+	if len(result.Equity) != len(ticks) {
+		t.Fatal("expected an equity point per tick")
+	}
+	if s.EndValue <= s.StartValue {
+		t.Fatal("expected the round trip to be profitable", s)
+	}
+	if math.Abs(s.BalanceChanges["USDT"]-s.ValueChange) > 1e-9 {
+		t.Fatal("value change should match the USDT change once flat", s)
+	}
+	if !bt.Clock().Now().Equal(ticks[len(ticks)-1].Time) {
+		t.Fatal("clock not moved to the last tick")
+	}
+}
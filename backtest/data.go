@@ -0,0 +1,169 @@
+package backtest
+
+// this file contains the historical market data that is replayed by the
+// backtester. Data can be loaded from the kline csv files Binance publishes or
+// fetched from the klines endpoint of the api.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/indicators"
+)
+
+// Bar is a candlestick bar of a symbol
+type Bar struct {
+	Time   time.Time // open time
+	Close  time.Time // close time
+	Open   float64
+	High   float64
+	Low    float64
+	Last   float64 // close price
+	Volume float64
+}
+
+// Tick is a price of a symbol at a point in time
+type Tick struct {
+	Time   time.Time
+	Symbol string
+	Price  float64
+}
+
+// LoadBarsFile loads bars from a Binance kline csv file
+func LoadBarsFile(filename string) ([]Bar, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadBarsCSV(f)
+}
+
+// LoadBarsCSV loads bars from a Binance kline csv. The columns are open time,
+// open, high, low, close, volume and close time with the times in
+// milliseconds. A header row is skipped
+func LoadBarsCSV(r io.Reader) ([]Bar, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	var bars []Bar
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 7 {
+			return nil, fmt.Errorf("line %v: expected at least 7 columns, got %v", line, len(record))
+		}
+		// Skip the header
+		if _, err := strconv.ParseInt(record[0], 10, 64); err != nil && line == 1 {
+			continue
+		}
+		bar, err := parseBar(record[0], record[1], record[2], record[3], record[4], record[5], record[6])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
+
+// FetchBars fetches the bars of a symbol from the exchange
+func FetchBars(c *api.Client, symbol, interval string, start, end time.Time) ([]Bar, error) {
+	klines, err := c.GetKlines(symbol, interval, start, end)
+	if err != nil {
+		return nil, err
+	}
+	bars := make([]Bar, 0, len(klines))
+	for _, k := range klines {
+		bar, err := parseBar(strconv.FormatInt(k.OpenTime, 10), k.Open, k.High, k.Low, k.Close, k.Volume, strconv.FormatInt(k.CloseTime, 10))
+		if err != nil {
+			return nil, err
+		}
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
+
+// parseBar parses the string fields of a kline into a bar
+func parseBar(openTime, open, high, low, last, volume, closeTime string) (Bar, error) {
+	var bar Bar
+	ot, err := strconv.ParseInt(openTime, 10, 64)
+	if err != nil {
+		return Bar{}, err
+	}
+	ct, err := strconv.ParseInt(closeTime, 10, 64)
+	if err != nil {
+		return Bar{}, err
+	}
+	bar.Time = time.Unix(0, ot*int64(time.Millisecond))
+	bar.Close = time.Unix(0, ct*int64(time.Millisecond))
+	for _, f := range []struct {
+		str string
+		val *float64
+	}{
+		{open, &bar.Open},
+		{high, &bar.High},
+		{low, &bar.Low},
+		{last, &bar.Last},
+		{volume, &bar.Volume},
+	} {
+		*f.val, err = strconv.ParseFloat(f.str, 64)
+		if err != nil {
+			return Bar{}, err
+		}
+	}
+	return bar, nil
+}
+
+// IndicatorBars converts bars into the bars of the indicators package, the
+// bars are timed by their open time
+func IndicatorBars(bars []Bar) []indicators.Bar {
+	out := make([]indicators.Bar, len(bars))
+	for i, bar := range bars {
+		out[i] = indicators.Bar{Time: bar.Time, Open: bar.Open, High: bar.High, Low: bar.Low, Close: bar.Last, Volume: bar.Volume}
+	}
+	return out
+}
+
+// BarTicks converts bars of a symbol into ticks. With intrabar set each bar is
+// replayed as four ticks, open, low, high and close, with the low before the
+// high for a rising bar and the high before the low for a falling bar.
+// Otherwise each bar is one tick at its close
+func BarTicks(symbol string, bars []Bar, intrabar bool) []Tick {
+	var ticks []Tick
+	for _, bar := range bars {
+		if !intrabar {
+			ticks = append(ticks, Tick{Time: bar.Close, Symbol: symbol, Price: bar.Last})
+			continue
+		}
+		path := []float64{bar.Open, bar.High, bar.Low, bar.Last}
+		if bar.Last >= bar.Open {
+			path = []float64{bar.Open, bar.Low, bar.High, bar.Last}
+		}
+		step := bar.Close.Sub(bar.Time) / 3
+		for i, price := range path {
+			ticks = append(ticks, Tick{Time: bar.Time.Add(time.Duration(i) * step), Symbol: symbol, Price: price})
+		}
+	}
+	return ticks
+}
+
+// MergeTicks merges the ticks of several symbols into one series ordered by
+// time
+func MergeTicks(series ...[]Tick) []Tick {
+	var ticks []Tick
+	for _, s := range series {
+		ticks = append(ticks, s...)
+	}
+	sort.SliceStable(ticks, func(i, j int) bool { return ticks[i].Time.Before(ticks[j].Time) })
+	return ticks
+}
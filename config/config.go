@@ -0,0 +1,674 @@
+package config
+
+// the config package loads the trader configuration from a YAML file. The
+// trading criteria, the symbols that are traded and the loop intervals all
+// live in one file that is validated on start up. Parameters that are safe to
+// change while trading can be reloaded without restarting the trader.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/trader"
+	"gopkg.in/yaml.v2"
+)
+
+// FileVar is the environment variable used to set the config file
+const FileVar = "traderConfig"
+
+// Default is the default configuration, it matches the values the trader was
+// built with before the config file existed
+var Default = Config{
+	Symbols:          trader.DefaultDipConfig.Symbols,
+	BNBSymbol:        trader.DefaultDipConfig.BNBSymbol,
+	BuyBalanceLimit:  trader.DefaultDipConfig.BuyQuoteAmount,
+	DiffLimit:        trader.DefaultDipConfig.DiffLimit,
+	BNBBalanceTarget: trader.DefaultDipConfig.BNBTarget,
+	LotPolicy:        trader.DefaultLotPolicy,
+	Sizing:           defaultSizing,
+	BinanceLoopTime:  2 * time.Second,
+	MetricsLoopTime:  12 * time.Hour,
+	EmailInterval:    24 * time.Hour,
+	Adaptive: Adaptive{
+		Enabled:          trader.DefaultAdaptiveConfig.Enabled,
+		MinDiffLimit:     trader.DefaultAdaptiveConfig.MinDiff,
+		MaxDiffLimit:     trader.DefaultAdaptiveConfig.MaxDiff,
+		Window:           trader.DefaultAdaptiveConfig.Window,
+		Interval:         trader.DefaultAdaptiveConfig.Interval,
+		TargetRoundTrips: trader.DefaultAdaptiveConfig.TargetRoundTrips,
+		Step:             trader.DefaultAdaptiveConfig.Step,
+		MinProfit:        trader.DefaultAdaptiveConfig.MinProfit,
+		VolatilityFactor: trader.DefaultAdaptiveConfig.VolatilityFactor,
+	},
+	Exits: Exits{
+		Mode:        trader.DefaultExitConfig.Mode,
+		LimitOffset: trader.DefaultExitConfig.LimitOffset,
+	},
+	Fees: Fees{
+		BNBDiscount:  trader.DefaultFeeConfig.BNBDiscount,
+		MinNetProfit: trader.DefaultFeeConfig.MinNetProfit,
+	},
+	DCA: DCA{
+		Enabled:           trader.DefaultDCAConfig.Enabled,
+		Symbol:            trader.DefaultDCAConfig.Symbol,
+		Schedule:          trader.DefaultDCAConfig.Schedule,
+		QuoteAmount:       trader.DefaultDCAConfig.QuoteAmount,
+		MAInterval:        trader.DefaultDCAConfig.MAInterval,
+		MAPeriods:         trader.DefaultDCAConfig.MAPeriods,
+		BelowMAMultiplier: trader.DefaultDCAConfig.BelowMAMultiplier,
+		Budget:            trader.DefaultDCAConfig.Budget,
+		Sizing:            defaultSizing,
+	},
+	Grid: Grid{
+		Symbol:      trader.DefaultGridConfig.Symbol,
+		Levels:      trader.DefaultGridConfig.Levels,
+		Spacing:     trader.DefaultGridConfig.Spacing,
+		QuoteAmount: trader.DefaultGridConfig.QuoteAmount,
+		Sizing:      defaultSizing,
+	},
+	Rebalance: Rebalance{
+		Symbols:    trader.DefaultRebalanceConfig.Symbols,
+		ValueAsset: trader.DefaultRebalanceConfig.ValueAsset,
+		Threshold:  trader.DefaultRebalanceConfig.Threshold,
+		Schedule:   trader.DefaultRebalanceConfig.Schedule,
+		MinTrade:   trader.DefaultRebalanceConfig.MinTrade,
+		Sizing:     defaultSizing,
+	},
+	Arbitrage: Arbitrage{
+		Enabled:    trader.DefaultArbitrageConfig.Enabled,
+		Amount:     trader.DefaultArbitrageConfig.Amount,
+		MinEdge:    trader.DefaultArbitrageConfig.MinEdge,
+		Execute:    trader.DefaultArbitrageConfig.Execute,
+		LegTimeout: trader.DefaultArbitrageConfig.LegTimeout,
+	},
+}
+
+// defaultSizing is the default position sizer of the strategies
+var defaultSizing = Sizing{
+	Method:        trader.DefaultSizingConfig.Method,
+	ATRPeriods:    trader.DefaultSizingConfig.ATRPeriods,
+	ATRInterval:   trader.DefaultSizingConfig.ATRInterval,
+	KellyFraction: trader.DefaultSizingConfig.KellyFraction,
+}
+
+var (
+	// errSymbolsChanged is returned when a reload changes the traded symbols,
+	// the lots and price levels of the trader are tracked per symbol so the
+	// symbols can only change on a restart
+	errSymbolsChanged = errors.New("symbols can't be changed without restarting the trader")
+)
+
+// Config is the configuration of the trader
+type Config struct {
+	// Symbols are the markets that are traded and BNBSymbol is the market
+	// used to buy BNB to pay fees with
+	Symbols   []string `yaml:"symbols"`
+	BNBSymbol string   `yaml:"bnbSymbol"`
+
+	// Budgets are the most of the quote asset that can be spent on the lots
+	// of each symbol keyed by symbol, symbols without a budget have no limit
+	Budgets map[string]float64 `yaml:"budgets"`
+
+	// BuyBalanceLimit is how much of the quote asset to buy at a time
+	BuyBalanceLimit float64 `yaml:"buyBalanceLimit"`
+
+	// DiffLimit is the fraction the price has to move for a buy or sell
+	DiffLimit float64 `yaml:"diffLimit"`
+
+	// BNBBalanceTarget is the BNB balance to keep for fees
+	BNBBalanceTarget float64 `yaml:"bnbBalanceTarget"`
+
+	// MaxBuyRange and MinSellRange limit buys and sells to part of the 24 hour
+	// range, 0 is the low and 1 is the high. They are not used when 0
+	MaxBuyRange  float64 `yaml:"maxBuyRange"`
+	MinSellRange float64 `yaml:"minSellRange"`
+
+	// LotPolicy is the order the lots are matched against sells in
+	LotPolicy trader.LotPolicy `yaml:"lotPolicy"`
+
+	// Sizing is how the dip strategy sizes its buys
+	Sizing Sizing `yaml:"sizing"`
+
+	// BinanceLoopTime is the interval of the trading loop, MetricsLoopTime is
+	// the interval the metrics are updated and EmailInterval is the interval
+	// of the performance summary emails
+	BinanceLoopTime time.Duration `yaml:"binanceLoopTime"`
+	MetricsLoopTime time.Duration `yaml:"metricsLoopTime"`
+	EmailInterval   time.Duration `yaml:"emailInterval"`
+
+	// Adaptive is the configuration of the adaptive threshold controller
+	Adaptive Adaptive `yaml:"adaptive"`
+
+	// Risk are the limits of the risk manager
+	Risk Risk `yaml:"risk"`
+
+	// Exits are the exits of the lots
+	Exits Exits `yaml:"exits"`
+
+	// Fees is the configuration of the fee model
+	Fees Fees `yaml:"fees"`
+
+	// DCA are the scheduled buys of the dip strategy
+	DCA DCA `yaml:"dca"`
+
+	// Grid is the configuration of the grid strategy
+	Grid Grid `yaml:"grid"`
+
+	// Rebalance is the configuration of the rebalancing strategy
+	Rebalance Rebalance `yaml:"rebalance"`
+
+	// Arbitrage is the configuration of the triangular arbitrage detector
+	Arbitrage Arbitrage `yaml:"arbitrage"`
+}
+
+// DCA are the scheduled buys of the dip strategy. QuoteAmount, or the amount of
+// the Sizing, is bought on the cron-like Schedule and multiplied by BelowMAMultiplier while the price is
+// below the moving average of MAPeriods prices taken every MAInterval
+type DCA struct {
+	Enabled           bool          `yaml:"enabled"`
+	Symbol            string        `yaml:"symbol"`
+	Schedule          string        `yaml:"schedule"`
+	QuoteAmount       float64       `yaml:"quoteAmount"`
+	MAInterval        time.Duration `yaml:"maInterval"`
+	MAPeriods         int           `yaml:"maPeriods"`
+	BelowMAMultiplier float64       `yaml:"belowMAMultiplier"`
+	Budget            float64       `yaml:"budget"`
+	Sizing            Sizing        `yaml:"sizing"`
+
+	// Pauses are the periods no DCA buys are made in
+	Pauses []Pause `yaml:"pauses"`
+}
+
+// Pause is a period from Start until End, as RFC 3339 times or dates
+type Pause struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// Grid is the configuration of the grid strategy. The grid has Levels prices
+// from Lower to Upper and buys QuoteAmount of the quote asset at each, it is
+// not used until the bounds are set
+type Grid struct {
+	Symbol      string             `yaml:"symbol"`
+	Lower       float64            `yaml:"lower"`
+	Upper       float64            `yaml:"upper"`
+	Levels      int                `yaml:"levels"`
+	Spacing     trader.GridSpacing `yaml:"spacing"`
+	QuoteAmount float64            `yaml:"quoteAmount"`
+	Sizing      Sizing             `yaml:"sizing"`
+}
+
+// Sizing is how a strategy sizes its buys, Method is one of fixed, equity,
+// volatility, kelly or max-loss and only its parameters are used. Fixed buys
+// the quote amount of the strategy. Amounts from the equity and MaxLoss are in
+// USDT, the StopDistance defaults to the stop-loss of the exits
+type Sizing struct {
+	Method           trader.SizingMethod `yaml:"method"`
+	EquityFraction   float64             `yaml:"equityFraction"`
+	TargetVolatility float64             `yaml:"targetVolatility"`
+	ATRPeriods       int                 `yaml:"atrPeriods"`
+	ATRInterval      time.Duration       `yaml:"atrInterval"`
+	WinRate          float64             `yaml:"winRate"`
+	Payoff           float64             `yaml:"payoff"`
+	KellyFraction    float64             `yaml:"kellyFraction"`
+	MaxLoss          float64             `yaml:"maxLoss"`
+	StopDistance     float64             `yaml:"stopDistance"`
+}
+
+// Rebalance is the configuration of the rebalancing strategy. The Targets are
+// the weights of the assets keyed by asset, valued in ValueAsset and traded
+// through the Symbols. A rebalance starts when an asset drifts Threshold from
+// its target or on the cron-like Schedule, trades smaller than MinTrade are
+// not made. Sizing caps each order, the fixed method doesn't. It is not used
+// until the targets are set
+type Rebalance struct {
+	Targets    map[string]float64 `yaml:"targets"`
+	Symbols    []string           `yaml:"symbols"`
+	ValueAsset string             `yaml:"valueAsset"`
+	Threshold  float64            `yaml:"threshold"`
+	Schedule   string             `yaml:"schedule"`
+	MinTrade   float64            `yaml:"minTrade"`
+	Sizing     Sizing             `yaml:"sizing"`
+}
+
+// Arbitrage is the configuration of the triangular arbitrage detector. The
+// BNBBTC, BNBUSDT and BTCUSDT triangle is watched next to the Triangles, and
+// loops that return MinEdge over Amount after fees are logged. With Execute
+// set the best loop is traded, each leg waiting up to LegTimeout to fill
+type Arbitrage struct {
+	Enabled    bool          `yaml:"enabled"`
+	Triangles  []Triangle    `yaml:"triangles"`
+	Amount     float64       `yaml:"amount"`
+	MinEdge    float64       `yaml:"minEdge"`
+	Execute    bool          `yaml:"execute"`
+	LegTimeout time.Duration `yaml:"legTimeout"`
+}
+
+// Triangle is three symbols that form a loop starting and ending in Start
+type Triangle struct {
+	Start   string   `yaml:"start"`
+	Symbols []string `yaml:"symbols"`
+}
+
+// Fees is the configuration of the fee model. BNBDiscount is the discount on
+// fees paid in BNB and MinNetProfit is the least profit a round trip must make
+// after fees as a fraction of the cost of the buy
+type Fees struct {
+	BNBDiscount  float64 `yaml:"bnbDiscount"`
+	MinNetProfit float64 `yaml:"minNetProfit"`
+}
+
+// Exits are the stop-loss, take-profit and trailing stop of each lot as
+// fractions of the lot price, exits that are 0 are not used. Mode is local to
+// enforce them in the trader or exchange to place the stop-loss or
+// take-profit on the exchange
+type Exits struct {
+	Mode         trader.ExitMode `yaml:"mode"`
+	StopLoss     float64         `yaml:"stopLoss"`
+	TakeProfit   float64         `yaml:"takeProfit"`
+	TrailingStop float64         `yaml:"trailingStop"`
+	LimitOffset  float64         `yaml:"limitOffset"`
+}
+
+// Risk are the limits of the risk manager. Breaching the daily loss or drawdown
+// halts the buys, orders over the other limits are dropped. Limits that are 0
+// are not enforced
+type Risk struct {
+	// MaxDailyLoss is the most that can be lost on the lots sold in a UTC
+	// day in USDT and MaxDrawdown is the largest fraction the equity can fall
+	// from its peak
+	MaxDailyLoss float64 `yaml:"maxDailyLoss"`
+	MaxDrawdown  float64 `yaml:"maxDrawdown"`
+
+	// MaxPositions is the most of each asset that can be held keyed by asset
+	MaxPositions map[string]float64 `yaml:"maxPositions"`
+
+	// MaxOpenLots is the most lots that can be open across all symbols and
+	// MaxOrdersPerHour is the most orders that can be submitted in an hour
+	MaxOpenLots      int `yaml:"maxOpenLots"`
+	MaxOrdersPerHour int `yaml:"maxOrdersPerHour"`
+}
+
+// Adaptive is the configuration of the adaptive threshold controller. The
+// thresholds start at the diff limit and are kept between MinDiffLimit and
+// MaxDiffLimit
+type Adaptive struct {
+	Enabled          bool          `yaml:"enabled"`
+	MinDiffLimit     float64       `yaml:"minDiffLimit"`
+	MaxDiffLimit     float64       `yaml:"maxDiffLimit"`
+	Window           time.Duration `yaml:"window"`
+	Interval         time.Duration `yaml:"interval"`
+	TargetRoundTrips int           `yaml:"targetRoundTrips"`
+	Step             float64       `yaml:"step"`
+	MinProfit        float64       `yaml:"minProfit"`
+	VolatilityFactor float64       `yaml:"volatilityFactor"`
+}
+
+// Load loads the config file. Parameters missing from the file keep their
+// default values
+func Load(filename string) (Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Config{}, err
+	}
+	return Parse(data)
+}
+
+// Parse parses and validates a YAML config
+func Parse(data []byte) (Config, error) {
+	c := Default
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Validate returns an error describing every invalid parameter
+func (c Config) Validate() error {
+	var errs []string
+	if len(c.Symbols) == 0 {
+		errs = append(errs, "symbols can't be empty")
+	}
+	symbols := make(map[string]bool)
+	for _, symbol := range c.Symbols {
+		if _, _, ok := api.SplitSymbol(symbol); !ok {
+			errs = append(errs, fmt.Sprintf("symbols %q is not a known symbol", symbol))
+		}
+		if symbols[symbol] {
+			errs = append(errs, fmt.Sprintf("symbols %q is listed more than once", symbol))
+		}
+		symbols[symbol] = true
+	}
+	if _, _, ok := api.SplitSymbol(c.BNBSymbol); !ok {
+		errs = append(errs, fmt.Sprintf("bnbSymbol %q is not a known symbol", c.BNBSymbol))
+	}
+	for symbol, budget := range c.Budgets {
+		if !symbols[symbol] {
+			errs = append(errs, fmt.Sprintf("budgets %q is not one of the symbols", symbol))
+		}
+		if budget < 0 {
+			errs = append(errs, fmt.Sprintf("budgets %q can't be negative, got %v", symbol, budget))
+		}
+	}
+	if c.BuyBalanceLimit <= 0 {
+		errs = append(errs, fmt.Sprintf("buyBalanceLimit must be positive, got %v", c.BuyBalanceLimit))
+	}
+	if c.DiffLimit <= 0 || c.DiffLimit >= 1 {
+		errs = append(errs, fmt.Sprintf("diffLimit must be between 0 and 1, got %v", c.DiffLimit))
+	}
+	if c.BNBBalanceTarget < 0 {
+		errs = append(errs, fmt.Sprintf("bnbBalanceTarget can't be negative, got %v", c.BNBBalanceTarget))
+	}
+	if c.MaxBuyRange < 0 || c.MaxBuyRange > 1 {
+		errs = append(errs, fmt.Sprintf("maxBuyRange must be between 0 and 1, got %v", c.MaxBuyRange))
+	}
+	if c.MinSellRange < 0 || c.MinSellRange > 1 {
+		errs = append(errs, fmt.Sprintf("minSellRange must be between 0 and 1, got %v", c.MinSellRange))
+	}
+	for _, interval := range []struct {
+		name  string
+		value time.Duration
+		min   time.Duration
+	}{
+		{"binanceLoopTime", c.BinanceLoopTime, time.Second},
+		{"metricsLoopTime", c.MetricsLoopTime, time.Minute},
+		{"emailInterval", c.EmailInterval, time.Hour},
+	} {
+		if interval.value < interval.min {
+			errs = append(errs, fmt.Sprintf("%v must be at least %v, got %v", interval.name, interval.min, interval.value))
+		}
+	}
+	if a := c.Adaptive; a.Enabled {
+		if a.MinDiffLimit <= 0 || a.MaxDiffLimit >= 1 || a.MinDiffLimit > a.MaxDiffLimit {
+			errs = append(errs, fmt.Sprintf("adaptive minDiffLimit and maxDiffLimit must be between 0 and 1 with the min below the max, got %v and %v", a.MinDiffLimit, a.MaxDiffLimit))
+		}
+		if a.Interval < time.Minute || a.Window < a.Interval {
+			errs = append(errs, fmt.Sprintf("adaptive interval must be at least 1m and window at least the interval, got %v and %v", a.Interval, a.Window))
+		}
+		if a.TargetRoundTrips <= 0 {
+			errs = append(errs, fmt.Sprintf("adaptive targetRoundTrips must be positive, got %v", a.TargetRoundTrips))
+		}
+		if a.Step <= 0 || a.Step >= 1 {
+			errs = append(errs, fmt.Sprintf("adaptive step must be between 0 and 1, got %v", a.Step))
+		}
+		if a.MinProfit < 0 || a.VolatilityFactor < 0 {
+			errs = append(errs, fmt.Sprintf("adaptive minProfit and volatilityFactor can't be negative, got %v and %v", a.MinProfit, a.VolatilityFactor))
+		}
+	}
+	if err := c.LotPolicy.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("lotPolicy: %v", err))
+	}
+	if err := c.Exits.Mode.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("exits mode: %v", err))
+	}
+	for _, exit := range []struct {
+		name  string
+		value float64
+	}{
+		{"stopLoss", c.Exits.StopLoss},
+		{"trailingStop", c.Exits.TrailingStop},
+		{"limitOffset", c.Exits.LimitOffset},
+	} {
+		if exit.value < 0 || exit.value >= 1 {
+			errs = append(errs, fmt.Sprintf("exits %v must be between 0 and 1, got %v", exit.name, exit.value))
+		}
+	}
+	if c.Exits.TakeProfit < 0 {
+		errs = append(errs, fmt.Sprintf("exits takeProfit can't be negative, got %v", c.Exits.TakeProfit))
+	}
+	if c.Risk.MaxDailyLoss < 0 {
+		errs = append(errs, fmt.Sprintf("risk maxDailyLoss can't be negative, got %v", c.Risk.MaxDailyLoss))
+	}
+	if c.Risk.MaxDrawdown < 0 || c.Risk.MaxDrawdown >= 1 {
+		errs = append(errs, fmt.Sprintf("risk maxDrawdown must be between 0 and 1, got %v", c.Risk.MaxDrawdown))
+	}
+	for asset, max := range c.Risk.MaxPositions {
+		if max < 0 {
+			errs = append(errs, fmt.Sprintf("risk maxPositions %q can't be negative, got %v", asset, max))
+		}
+	}
+	if c.Risk.MaxOpenLots < 0 || c.Risk.MaxOrdersPerHour < 0 {
+		errs = append(errs, fmt.Sprintf("risk maxOpenLots and maxOrdersPerHour can't be negative, got %v and %v", c.Risk.MaxOpenLots, c.Risk.MaxOrdersPerHour))
+	}
+	if c.Fees.BNBDiscount < 0 || c.Fees.BNBDiscount >= 1 {
+		errs = append(errs, fmt.Sprintf("fees bnbDiscount must be between 0 and 1, got %v", c.Fees.BNBDiscount))
+	}
+	if c.Fees.MinNetProfit < 0 {
+		errs = append(errs, fmt.Sprintf("fees minNetProfit can't be negative, got %v", c.Fees.MinNetProfit))
+	}
+	if err := c.sizingConfig(c.Sizing).Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := c.sizingConfig(c.Grid.Sizing).Validate(); err != nil {
+		errs = append(errs, "grid "+err.Error())
+	}
+	if err := c.sizingConfig(c.DCA.Sizing).Validate(); err != nil {
+		errs = append(errs, "dca "+err.Error())
+	}
+	if err := c.sizingConfig(c.Rebalance.Sizing).Validate(); err != nil {
+		errs = append(errs, "rebalance "+err.Error())
+	}
+	if c.DCA.Budget < 0 {
+		errs = append(errs, fmt.Sprintf("dca budget can't be negative, got %v", c.DCA.Budget))
+	}
+	if dca, err := c.DCAConfig(); err != nil {
+		errs = append(errs, err.Error())
+	} else if err := dca.Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if c.Grid.Lower != 0 || c.Grid.Upper != 0 {
+		if err := c.GridConfig().Validate(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(c.Rebalance.Targets) > 0 {
+		if err := c.RebalanceConfig().Validate(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := c.ArbitrageConfig().Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// CheckReload returns an error if the config changes parameters of the
+// current config that can't be reloaded while trading
+func (c Config) CheckReload(current Config) error {
+	if len(c.Symbols) != len(current.Symbols) || c.BNBSymbol != current.BNBSymbol || c.Grid.Symbol != current.Grid.Symbol {
+		return errSymbolsChanged
+	}
+	for i := range c.Symbols {
+		if c.Symbols[i] != current.Symbols[i] {
+			return errSymbolsChanged
+		}
+	}
+	if len(c.Rebalance.Symbols) != len(current.Rebalance.Symbols) {
+		return errSymbolsChanged
+	}
+	for i := range c.Rebalance.Symbols {
+		if c.Rebalance.Symbols[i] != current.Rebalance.Symbols[i] {
+			return errSymbolsChanged
+		}
+	}
+	return nil
+}
+
+// DipConfig returns the configuration of the dip strategy
+func (c Config) DipConfig() trader.DipConfig {
+	// The pauses are checked by Validate
+	dca, _ := c.DCAConfig()
+	return trader.DipConfig{
+		Symbols:        c.Symbols,
+		BNBSymbol:      c.BNBSymbol,
+		BuyQuoteAmount: c.BuyBalanceLimit,
+		Sizer:          c.sizingConfig(c.Sizing).Sizer(c.BuyBalanceLimit),
+		DiffLimit:      c.DiffLimit,
+		BNBTarget:      c.BNBBalanceTarget,
+		MaxBuyRange:    c.MaxBuyRange,
+		MinSellRange:   c.MinSellRange,
+		DCA:            dca,
+	}
+}
+
+// DCAConfig returns the configuration of the DCA buys, it returns an error if
+// a pause can't be parsed
+func (c Config) DCAConfig() (trader.DCAConfig, error) {
+	dca := trader.DCAConfig{
+		Enabled:           c.DCA.Enabled,
+		Symbol:            c.DCA.Symbol,
+		Schedule:          c.DCA.Schedule,
+		QuoteAmount:       c.DCA.QuoteAmount,
+		MAInterval:        c.DCA.MAInterval,
+		MAPeriods:         c.DCA.MAPeriods,
+		BelowMAMultiplier: c.DCA.BelowMAMultiplier,
+		Budget:            c.DCA.Budget,
+		Sizer:             c.sizingConfig(c.DCA.Sizing).Sizer(c.DCA.QuoteAmount),
+	}
+	for _, p := range c.DCA.Pauses {
+		start, err := parseTime(p.Start)
+		if err != nil {
+			return trader.DCAConfig{}, fmt.Errorf("dca pause start: %v", err)
+		}
+		end, err := parseTime(p.End)
+		if err != nil {
+			return trader.DCAConfig{}, fmt.Errorf("dca pause end: %v", err)
+		}
+		dca.Pauses = append(dca.Pauses, trader.Pause{Start: start, End: end})
+	}
+	return dca, nil
+}
+
+// parseTime parses an RFC 3339 time or a date, dates are midnight UTC
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// AdaptiveConfig returns the configuration of the adaptive threshold
+// controller
+func (c Config) AdaptiveConfig() trader.AdaptiveConfig {
+	return trader.AdaptiveConfig{
+		Enabled:          c.Adaptive.Enabled,
+		Initial:          c.DiffLimit,
+		MinDiff:          c.Adaptive.MinDiffLimit,
+		MaxDiff:          c.Adaptive.MaxDiffLimit,
+		Window:           c.Adaptive.Window,
+		Interval:         c.Adaptive.Interval,
+		TargetRoundTrips: c.Adaptive.TargetRoundTrips,
+		Step:             c.Adaptive.Step,
+		MinProfit:        c.Adaptive.MinProfit,
+		VolatilityFactor: c.Adaptive.VolatilityFactor,
+	}
+}
+
+// RiskConfig returns the configuration of the risk manager
+func (c Config) RiskConfig() trader.RiskConfig {
+	return trader.RiskConfig{
+		MaxDailyLoss:     c.Risk.MaxDailyLoss,
+		MaxDrawdown:      c.Risk.MaxDrawdown,
+		MaxPositions:     c.Risk.MaxPositions,
+		MaxOpenLots:      c.Risk.MaxOpenLots,
+		MaxOrdersPerHour: c.Risk.MaxOrdersPerHour,
+	}
+}
+
+// ExitConfig returns the configuration of the lot exits
+func (c Config) ExitConfig() trader.ExitConfig {
+	return trader.ExitConfig{
+		Mode: c.Exits.Mode,
+		Exits: trader.Exits{
+			StopLoss:     c.Exits.StopLoss,
+			TakeProfit:   c.Exits.TakeProfit,
+			TrailingStop: c.Exits.TrailingStop,
+		},
+		LimitOffset: c.Exits.LimitOffset,
+	}
+}
+
+// FeeConfig returns the configuration of the fee model
+func (c Config) FeeConfig() trader.FeeConfig {
+	return trader.FeeConfig{
+		BNBDiscount:  c.Fees.BNBDiscount,
+		MinNetProfit: c.Fees.MinNetProfit,
+	}
+}
+
+// GridConfig returns the configuration of the grid strategy
+func (c Config) GridConfig() trader.GridConfig {
+	return trader.GridConfig{
+		Symbol:      c.Grid.Symbol,
+		Lower:       c.Grid.Lower,
+		Upper:       c.Grid.Upper,
+		Levels:      c.Grid.Levels,
+		Spacing:     c.Grid.Spacing,
+		QuoteAmount: c.Grid.QuoteAmount,
+		Sizer:       c.sizingConfig(c.Grid.Sizing).Sizer(c.Grid.QuoteAmount),
+	}
+}
+
+// sizingConfig returns the configuration of a position sizer, the stop
+// distance defaults to the stop-loss of the exits
+func (c Config) sizingConfig(s Sizing) trader.SizingConfig {
+	stop := s.StopDistance
+	if stop == 0 {
+		stop = c.Exits.StopLoss
+	}
+	return trader.SizingConfig{
+		Method:           s.Method,
+		EquityFraction:   s.EquityFraction,
+		TargetVolatility: s.TargetVolatility,
+		ATRPeriods:       s.ATRPeriods,
+		ATRInterval:      s.ATRInterval,
+		WinRate:          s.WinRate,
+		Payoff:           s.Payoff,
+		KellyFraction:    s.KellyFraction,
+		MaxLoss:          s.MaxLoss,
+		StopDistance:     stop,
+	}
+}
+
+// RebalanceConfig returns the configuration of the rebalancing strategy, the
+// orders are only capped by a sizer other than fixed
+func (c Config) RebalanceConfig() trader.RebalanceConfig {
+	var sizer trader.PositionSizer
+	if c.Rebalance.Sizing.Method != trader.SizeFixed {
+		sizer = c.sizingConfig(c.Rebalance.Sizing).Sizer(0)
+	}
+	return trader.RebalanceConfig{
+		Targets:    c.Rebalance.Targets,
+		Symbols:    c.Rebalance.Symbols,
+		ValueAsset: c.Rebalance.ValueAsset,
+		Threshold:  c.Rebalance.Threshold,
+		Schedule:   c.Rebalance.Schedule,
+		MinTrade:   c.Rebalance.MinTrade,
+		Sizer:      sizer,
+	}
+}
+
+// ArbitrageConfig returns the configuration of the arbitrage detector
+func (c Config) ArbitrageConfig() trader.ArbitrageConfig {
+	arbitrage := trader.ArbitrageConfig{
+		Enabled:    c.Arbitrage.Enabled,
+		Amount:     c.Arbitrage.Amount,
+		MinEdge:    c.Arbitrage.MinEdge,
+		Execute:    c.Arbitrage.Execute,
+		LegTimeout: c.Arbitrage.LegTimeout,
+	}
+	for _, tr := range c.Arbitrage.Triangles {
+		arbitrage.Triangles = append(arbitrage.Triangles, trader.Triangle{Start: tr.Start, Symbols: tr.Symbols})
+	}
+	return arbitrage
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/trader"
+)
+
+// TestParse tests that missing parameters keep their defaults and that invalid
+// parameters are reported
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte("diffLimit: 0.002\nbinanceLoopTime: 10s\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DiffLimit != 0.002 || c.BinanceLoopTime != 10*time.Second {
+		t.Fatal("parameters not parsed", c)
+	}
+	if len(c.Symbols) != 1 || c.Symbols[0] != api.BTCUSDT || c.BuyBalanceLimit != Default.BuyBalanceLimit || c.EmailInterval != 24*time.Hour {
+		t.Fatal("missing parameters should keep their defaults", c)
+	}
+
+	// Every invalid parameter is reported
+	_, err = Parse([]byte("symbols: [BTCEUR]\nbudgets: {BNBUSDT: 10}\ndiffLimit: 2\nbinanceLoopTime: 10ms\n"))
+	if err == nil {
+		t.Fatal("expected invalid config")
+	}
+	for _, name := range []string{"BTCEUR", "budgets", "diffLimit", "binanceLoopTime"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("expected error to mention %v, got %v", name, err)
+		}
+	}
+
+	// The DCA pauses are parsed as times or dates
+	c, err = Parse([]byte("dca:\n  enabled: true\n  schedule: 0 9 * * 1\n  pauses:\n    - start: 2024-12-20\n      end: 2025-01-02T12:00:00Z\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dca := c.DipConfig().DCA; len(dca.Pauses) != 1 || dca.Pauses[0].Start != time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC) || dca.Schedule != "0 9 * * 1" {
+		t.Fatal("unexpected dca config", dca)
+	}
+	if _, err := Parse([]byte("dca:\n  enabled: true\n  schedule: 0 25 * * *\n")); err == nil || !strings.Contains(err.Error(), "hour") {
+		t.Fatal("expected invalid dca schedule, got", err)
+	}
+
+	// The rebalance targets must add up to 1
+	c, err = Parse([]byte("rebalance:\n  targets:\n    BTC: 0.5\n    BNB: 0.1\n    USDT: 0.4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := c.RebalanceConfig(); r.Targets["BNB"] != 0.1 || len(r.Symbols) != 3 || r.ValueAsset != "USDT" {
+		t.Fatal("unexpected rebalance config", r)
+	}
+	if _, err := Parse([]byte("rebalance:\n  targets:\n    BTC: 0.5\n    USDT: 0.4\n")); err == nil || !strings.Contains(err.Error(), "add up to 1") {
+		t.Fatal("expected invalid rebalance targets, got", err)
+	}
+
+	// The max-loss stop distance defaults to the stop-loss of the exits
+	c, err = Parse([]byte("exits:\n  stopLoss: 0.05\nsizing:\n  method: max-loss\n  maxLoss: 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c.sizingConfig(c.Sizing); s.StopDistance != 0.05 || s.MaxLoss != 2 {
+		t.Fatal("unexpected sizing config", s)
+	}
+	if _, err := Parse([]byte("grid:\n  sizing:\n    method: kelly\n    winRate: 0.6\n")); err == nil || !strings.Contains(err.Error(), "grid sizing") {
+		t.Fatal("expected invalid grid sizing, got", err)
+	}
+	if r := c.RebalanceConfig(); r.Sizer != nil {
+		t.Fatal("expected the fixed method not to cap the rebalance orders, got", r.Sizer)
+	}
+	c, err = Parse([]byte("dca:\n  sizing:\n    method: equity\n    equityFraction: 0.01\nrebalance:\n  sizing:\n    method: equity\n    equityFraction: 0.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dca := c.DipConfig().DCA; dca.Sizer != (trader.EquitySizer{Fraction: 0.01}) || c.RebalanceConfig().Sizer != (trader.EquitySizer{Fraction: 0.1}) {
+		t.Fatal("unexpected dca and rebalance sizers", dca.Sizer, c.RebalanceConfig().Sizer)
+	}
+	if _, err := Parse([]byte("dca:\n  sizing:\n    method: equity\n")); err == nil || !strings.Contains(err.Error(), "dca sizing") {
+		t.Fatal("expected invalid dca sizing, got", err)
+	}
+
+	// Unknown parameters are rejected so typos are not silently ignored
+	if _, err := Parse([]byte("difLimit: 0.01\n")); err == nil {
+		t.Fatal("expected unknown parameter to be rejected")
+	}
+}
+
+// TestCheckReload tests that the symbols can't be changed by a reload
+func TestCheckReload(t *testing.T) {
+	c := Default
+	c.DiffLimit = 0.01
+	c.BinanceLoopTime = time.Minute
+	c.Budgets = map[string]float64{api.BTCUSDT: 100}
+	if err := c.CheckReload(Default); err != nil {
+		t.Fatal(err)
+	}
+	c.Symbols = []string{api.BTCUSDT, api.BNBUSDT}
+	if err := c.CheckReload(Default); err != errSymbolsChanged {
+		t.Fatal("expected errSymbolsChanged, got", err)
+	}
+}
+
+// TestExampleConfig tests that the example config is valid and matches the
+// defaults
+func TestExampleConfig(t *testing.T) {
+	c, err := Load("../traderbot.example.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, Default) {
+		t.Fatal("example config should match the defaults", c)
+	}
+}
@@ -0,0 +1,103 @@
+package indicators
+
+// this file contains the moving averages.
+
+// SMA is the simple moving average of the last period values
+type SMA struct {
+	period int
+	window *window
+	sum    float64
+	count  int
+}
+
+// NewSMA returns a simple moving average over the period
+func NewSMA(period int) *SMA {
+	return &SMA{period: period, window: newWindow(period)}
+}
+
+// Update adds a value and returns the average, false until period values
+// have been added
+func (s *SMA) Update(v float64) (float64, bool) {
+	old, full := s.window.push(v)
+	s.sum += v
+	if full {
+		s.sum -= old
+	} else {
+		s.count++
+	}
+	return s.Value()
+}
+
+// Value returns the average, false until period values have been added
+func (s *SMA) Value() (float64, bool) {
+	if s.count < s.period {
+		return 0, false
+	}
+	return s.sum / float64(s.period), true
+}
+
+// SMASeries returns the simple moving average of every value
+func SMASeries(values []float64, period int) []float64 {
+	return series(values, NewSMA(period).Update)
+}
+
+// EMA is the exponential moving average of the values. It is seeded with the
+// simple average of the first period values
+type EMA struct {
+	period int
+	alpha  float64
+	seed   *SMA
+	value  float64
+	ready  bool
+}
+
+// NewEMA returns an exponential moving average over the period, the weight of
+// each new value is 2/(period+1)
+func NewEMA(period int) *EMA {
+	return &EMA{period: period, alpha: 2 / float64(period+1), seed: NewSMA(period)}
+}
+
+// Update adds a value and returns the average, false until period values
+// have been added
+func (e *EMA) Update(v float64) (float64, bool) {
+	if !e.ready {
+		e.value, e.ready = e.seed.Update(v)
+		return e.value, e.ready
+	}
+	e.value += e.alpha * (v - e.value)
+	return e.value, true
+}
+
+// Value returns the average, false until period values have been added
+func (e *EMA) Value() (float64, bool) {
+	return e.value, e.ready
+}
+
+// EMASeries returns the exponential moving average of every value
+func EMASeries(values []float64, period int) []float64 {
+	return series(values, NewEMA(period).Update)
+}
+
+// wilder is Wilder's smoothing, an exponential average with a weight of
+// 1/period seeded with the simple average of the first period values
+type wilder struct {
+	period int
+	seed   *SMA
+	value  float64
+	ready  bool
+}
+
+// newWilder returns Wilder's smoothing over the period
+func newWilder(period int) *wilder {
+	return &wilder{period: period, seed: NewSMA(period)}
+}
+
+// update adds a value and returns the smoothed value
+func (w *wilder) update(v float64) (float64, bool) {
+	if !w.ready {
+		w.value, w.ready = w.seed.Update(v)
+		return w.value, w.ready
+	}
+	w.value = (w.value*float64(w.period-1) + v) / float64(w.period)
+	return w.value, true
+}
@@ -0,0 +1,127 @@
+// Package indicators computes technical indicators over price and bar series.
+// Every indicator can be updated one value or bar at a time as prices come
+// in, or computed over a whole series at once. The series functions return a
+// value for every input, the values before the indicator has seen enough
+// input are NaN.
+package indicators
+
+import (
+	"math"
+	"time"
+)
+
+// Bar is the open, high, low, close and volume of a period
+type Bar struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// Typical returns the typical price of the bar, the average of the high, low
+// and close
+func (b Bar) Typical() float64 {
+	return (b.High + b.Low + b.Close) / 3
+}
+
+// Closes returns the close prices of the bars
+func Closes(bars []Bar) []float64 {
+	closes := make([]float64, len(bars))
+	for i, b := range bars {
+		closes[i] = b.Close
+	}
+	return closes
+}
+
+// Resample combines the bars, oldest first, into bars of the interval. The
+// bars are grouped by the start of their interval in UTC so the first and last
+// bar may cover part of an interval
+func Resample(bars []Bar, interval time.Duration) []Bar {
+	if interval <= 0 {
+		return append([]Bar(nil), bars...)
+	}
+	var out []Bar
+	for _, b := range bars {
+		start := b.Time.UTC().Truncate(interval)
+		if n := len(out); n > 0 && out[n-1].Time.Equal(start) {
+			last := &out[n-1]
+			last.High = math.Max(last.High, b.High)
+			last.Low = math.Min(last.Low, b.Low)
+			last.Close = b.Close
+			last.Volume += b.Volume
+			continue
+		}
+		b.Time = start
+		out = append(out, b)
+	}
+	return out
+}
+
+// series runs an update function over the values and returns the results,
+// NaN where the indicator was not ready
+func series(values []float64, update func(float64) (float64, bool)) []float64 {
+	out := make([]float64, len(values))
+	for i, v := range values {
+		value, ok := update(v)
+		if !ok {
+			value = math.NaN()
+		}
+		out[i] = value
+	}
+	return out
+}
+
+// barSeries runs an update function over the bars and returns the results,
+// NaN where the indicator was not ready
+func barSeries(bars []Bar, update func(Bar) (float64, bool)) []float64 {
+	out := make([]float64, len(bars))
+	for i, b := range bars {
+		value, ok := update(b)
+		if !ok {
+			value = math.NaN()
+		}
+		out[i] = value
+	}
+	return out
+}
+
+// window is a fixed size window of the latest values
+type window struct {
+	values []float64
+	next   int
+	full   bool
+}
+
+// newWindow returns a window of the size
+func newWindow(size int) *window {
+	if size < 1 {
+		size = 1
+	}
+	return &window{values: make([]float64, size)}
+}
+
+// push adds a value and returns the value it replaced and true if the window
+// was full
+func (w *window) push(v float64) (float64, bool) {
+	old, full := w.values[w.next], w.full
+	w.values[w.next] = v
+	w.next++
+	if w.next == len(w.values) {
+		w.next = 0
+		w.full = true
+	}
+	return old, full
+}
+
+// each calls f with every value in the window
+func (w *window) each(f func(float64)) {
+	n := w.next
+	if w.full {
+		n = len(w.values)
+	}
+	for _, v := range w.values[:n] {
+		f(v)
+	}
+}
@@ -0,0 +1,175 @@
+package indicators
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// The closes of the EMA and RSI examples from StockCharts
+var (
+	emaCloses = []float64{
+		22.27, 22.19, 22.08, 22.17, 22.18, 22.13, 22.23, 22.43, 22.24, 22.29,
+		22.15, 22.39, 22.38, 22.61, 23.36, 24.05, 23.75, 23.83, 23.95, 23.63,
+		23.82, 23.87, 23.65, 23.19, 23.10, 23.33, 22.68, 23.10, 22.40, 22.17,
+	}
+	rsiCloses = []float64{
+		44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08,
+		45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64,
+		46.21, 46.25, 45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57,
+		43.42, 42.66, 43.13,
+	}
+)
+
+// near returns true if a and b are within tolerance of each other
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// checkSeries checks the series is NaN for the first warmup values and then
+// matches the expected values to two decimals
+func checkSeries(t *testing.T, name string, got []float64, warmup int, expected []float64) {
+	t.Helper()
+	for i := 0; i < warmup; i++ {
+		if !math.IsNaN(got[i]) {
+			t.Fatalf("%v: expected NaN at %v, got %v", name, i, got[i])
+		}
+	}
+	for i, e := range expected {
+		if !near(got[warmup+i], e, 0.005) {
+			t.Fatalf("%v: expected %v at %v, got %v", name, e, warmup+i, got[warmup+i])
+		}
+	}
+}
+
+// TestAverages tests the SMA and EMA against reference values
+func TestAverages(t *testing.T) {
+	checkSeries(t, "sma", SMASeries([]float64{1, 2, 3, 4, 5, 6}, 3), 2, []float64{2, 3, 4, 5})
+
+	// The EMA is seeded with the SMA of the first 10 closes
+	checkSeries(t, "ema", EMASeries(emaCloses, 10), 9, []float64{
+		22.22, 22.21, 22.24, 22.27, 22.33, 22.52, 22.80, 22.97, 23.13, 23.28,
+		23.34, 23.43, 23.51, 23.53, 23.47, 23.40, 23.39, 23.26, 23.23, 23.08,
+		22.92,
+	})
+
+	// Updating one value at a time gives the same values as the series
+	ema := NewEMA(10)
+	series := EMASeries(emaCloses, 10)
+	for i, v := range emaCloses {
+		value, ok := ema.Update(v)
+		if ok != !math.IsNaN(series[i]) || (ok && value != series[i]) {
+			t.Fatal("streaming ema doesn't match the series at", i, value, series[i])
+		}
+	}
+	if value, ok := ema.Value(); !ok || value != series[len(series)-1] {
+		t.Fatal("unexpected ema value", value, ok)
+	}
+}
+
+// TestOscillators tests the RSI, MACD and stochastic oscillator against
+// reference values
+func TestOscillators(t *testing.T) {
+	// The StockCharts example rounds the averages, these are the values
+	// without rounding
+	checkSeries(t, "rsi", RSISeries(rsiCloses, 14), 14, []float64{
+		70.46, 66.25, 66.48, 69.35, 66.29, 57.92, 62.88, 63.21, 56.01, 62.34,
+		54.67, 50.39, 40.02, 41.49, 41.90, 45.50, 37.32, 33.09, 37.79,
+	})
+	if rsi := RSISeries([]float64{1, 2, 3, 4}, 3); rsi[3] != 100 {
+		t.Fatal("expected an rsi of 100 without losses, got", rsi[3])
+	}
+
+	// The MACD line is ready once the slow EMA is and the signal line 3
+	// values later
+	macd := MACDSeries(rsiCloses, 3, 6, 4)
+	for i := 0; i < 8; i++ {
+		if !math.IsNaN(macd[i].MACD) {
+			t.Fatal("expected NaN at", i, macd[i])
+		}
+	}
+	if !near(macd[8].MACD, 0.4138, 0.0001) || !near(macd[8].Signal, 0.3328, 0.0001) {
+		t.Fatal("unexpected macd", macd[8])
+	}
+	last := macd[len(macd)-1]
+	if !near(last.MACD, -0.4377, 0.0001) || !near(last.Signal, -0.4352, 0.0001) || !near(last.Histogram, -0.0025, 0.0001) {
+		t.Fatal("unexpected macd", last)
+	}
+	m := NewMACD(3, 6, 4)
+	for _, v := range rsiCloses {
+		m.Update(v)
+	}
+	if value, ok := m.Value(); !ok || value != last {
+		t.Fatal("streaming macd doesn't match the series", value, last)
+	}
+
+	bars := []Bar{
+		{High: 10, Low: 8, Close: 9},
+		{High: 11, Low: 9, Close: 10},
+		{High: 12, Low: 10, Close: 12},
+		{High: 12, Low: 7, Close: 8},
+		{High: 9, Low: 7, Close: 9},
+	}
+	stoch := StochasticSeries(bars, 3, 2)
+	if !math.IsNaN(stoch[2].K) {
+		t.Fatal("expected NaN until %D is ready, got", stoch[2])
+	}
+	// %K is 100 at the high of 8-12, then 20 in 7-12 and 40 in 7-12
+	expected := []StochasticValue{{K: 20, D: 60}, {K: 40, D: 30}}
+	for i, e := range expected {
+		if s := stoch[3+i]; !near(s.K, e.K, 1e-9) || !near(s.D, e.D, 1e-9) {
+			t.Fatal("unexpected stochastic", s, "expected", e)
+		}
+	}
+}
+
+// TestVolatility tests the Bollinger Bands, ATR and VWAP against reference
+// values
+func TestVolatility(t *testing.T) {
+	// The mean of 1-5 is 3 and the population standard deviation is sqrt(2)
+	bb := BollingerSeries([]float64{1, 2, 3, 4, 5, 6}, 5, 2)
+	if !math.IsNaN(bb[3].Middle) {
+		t.Fatal("expected NaN during warmup, got", bb[3])
+	}
+	if bb[4].Middle != 3 || !near(bb[4].Upper, 3+2*math.Sqrt2, 1e-9) || !near(bb[4].Lower, 3-2*math.Sqrt2, 1e-9) {
+		t.Fatal("unexpected bands", bb[4])
+	}
+	if bb[5].Middle != 4 || !near(bb[5].Upper-bb[5].Middle, 2*math.Sqrt2, 1e-9) {
+		t.Fatal("unexpected bands", bb[5])
+	}
+
+	// The true ranges are 2, 3 with the gap up from 9, 4 and 2, the first
+	// average is 3 and the next (3*2+2)/3
+	bars := []Bar{
+		{High: 10, Low: 8, Close: 9},
+		{High: 12, Low: 11, Close: 11.5},
+		{High: 13, Low: 9, Close: 12},
+		{High: 12, Low: 10, Close: 11},
+	}
+	checkSeries(t, "atr", ATRSeries(bars, 3), 2, []float64{3, 8.0 / 3})
+	atr := NewATR(3)
+	for _, b := range bars {
+		atr.Update(b)
+	}
+	if value, ok := atr.Value(); !ok || !near(value, 8.0/3, 1e-9) {
+		t.Fatal("unexpected atr", value, ok)
+	}
+
+	// The VWAP weights the typical prices by volume and starts over each day
+	day := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	vbars := []Bar{
+		{Time: day, High: 11, Low: 9, Close: 10, Volume: 1},
+		{Time: day.Add(time.Hour), High: 13, Low: 11, Close: 12, Volume: 3},
+		{Time: day.Add(2 * time.Hour), High: 13, Low: 11, Close: 12},
+		{Time: day.Add(24 * time.Hour), High: 21, Low: 19, Close: 20, Volume: 2},
+	}
+	// Resampled by day the first three bars are combined
+	if days := Resample(vbars, 24*time.Hour); len(days) != 2 || days[0].High != 13 || days[0].Low != 9 || days[0].Close != 12 || days[0].Volume != 4 || !days[1].Time.Equal(day.Add(24*time.Hour)) {
+		t.Fatal("unexpected daily bars", days)
+	}
+	checkSeries(t, "vwap", VWAPSeries(vbars, 24*time.Hour), 0, []float64{10, 11.5, 11.5, 20})
+	checkSeries(t, "vwap", VWAPSeries(vbars, 0), 0, []float64{10, 11.5, 11.5, 86.0 / 6})
+	if vwap := VWAPSeries([]Bar{{High: 1, Low: 1, Close: 1}}, 0); !math.IsNaN(vwap[0]) {
+		t.Fatal("expected NaN without volume, got", vwap[0])
+	}
+}
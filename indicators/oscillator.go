@@ -0,0 +1,186 @@
+package indicators
+
+// this file contains the momentum oscillators, RSI, MACD and the stochastic
+// oscillator.
+
+import "math"
+
+// RSI is Wilder's relative strength index of the values, from 0 to 100
+type RSI struct {
+	gain  *wilder
+	loss  *wilder
+	last  float64
+	count int
+	value float64
+	ready bool
+}
+
+// NewRSI returns a relative strength index over the period, 14 is common
+func NewRSI(period int) *RSI {
+	return &RSI{gain: newWilder(period), loss: newWilder(period)}
+}
+
+// Update adds a value and returns the index, false until period changes have
+// been seen
+func (r *RSI) Update(v float64) (float64, bool) {
+	r.count++
+	if r.count == 1 {
+		r.last = v
+		return 0, false
+	}
+	change := v - r.last
+	r.last = v
+	gain, _ := r.gain.update(math.Max(change, 0))
+	loss, ok := r.loss.update(math.Max(-change, 0))
+	if !ok {
+		return 0, false
+	}
+	r.ready = true
+	if loss == 0 {
+		r.value = 100
+	} else {
+		r.value = 100 - 100/(1+gain/loss)
+	}
+	return r.value, true
+}
+
+// Value returns the index, false until period changes have been seen
+func (r *RSI) Value() (float64, bool) {
+	return r.value, r.ready
+}
+
+// RSISeries returns the relative strength index of every value
+func RSISeries(values []float64, period int) []float64 {
+	return series(values, NewRSI(period).Update)
+}
+
+// MACDValue is the MACD line, its signal line and their difference
+type MACDValue struct {
+	MACD      float64
+	Signal    float64
+	Histogram float64
+}
+
+// MACD is the moving average convergence divergence of the values, the
+// difference of a fast and slow EMA and an EMA of that difference
+type MACD struct {
+	fast   *EMA
+	slow   *EMA
+	signal *EMA
+	value  MACDValue
+	ready  bool
+}
+
+// NewMACD returns a MACD with the periods of the EMAs, 12, 26 and 9 are
+// common
+func NewMACD(fast, slow, signal int) *MACD {
+	return &MACD{fast: NewEMA(fast), slow: NewEMA(slow), signal: NewEMA(signal)}
+}
+
+// Update adds a value and returns the MACD, false until the signal line has
+// seen signal MACD values
+func (m *MACD) Update(v float64) (MACDValue, bool) {
+	fast, fastOK := m.fast.Update(v)
+	slow, slowOK := m.slow.Update(v)
+	if !fastOK || !slowOK {
+		return MACDValue{}, false
+	}
+	line := fast - slow
+	signal, ok := m.signal.Update(line)
+	if !ok {
+		return MACDValue{MACD: line}, false
+	}
+	m.value = MACDValue{MACD: line, Signal: signal, Histogram: line - signal}
+	m.ready = true
+	return m.value, true
+}
+
+// Value returns the MACD, false until the signal line is ready
+func (m *MACD) Value() (MACDValue, bool) {
+	return m.value, m.ready
+}
+
+// MACDSeries returns the MACD of every value, the fields are NaN until the
+// signal line is ready
+func MACDSeries(values []float64, fast, slow, signal int) []MACDValue {
+	m := NewMACD(fast, slow, signal)
+	out := make([]MACDValue, len(values))
+	for i, v := range values {
+		value, ok := m.Update(v)
+		if !ok {
+			value = MACDValue{MACD: math.NaN(), Signal: math.NaN(), Histogram: math.NaN()}
+		}
+		out[i] = value
+	}
+	return out
+}
+
+// StochasticValue is the %K and %D of the stochastic oscillator
+type StochasticValue struct {
+	K float64
+	D float64
+}
+
+// Stochastic is the stochastic oscillator of the bars. %K is where the close
+// is in the range of the last period bars from 0 to 100 and %D is the simple
+// average of the last smooth %K values
+type Stochastic struct {
+	highs *window
+	lows  *window
+	count int
+	d     *SMA
+	value StochasticValue
+	ready bool
+}
+
+// NewStochastic returns a stochastic oscillator over the period with %D
+// smoothed over smooth values, 14 and 3 are common
+func NewStochastic(period, smooth int) *Stochastic {
+	return &Stochastic{highs: newWindow(period), lows: newWindow(period), d: NewSMA(smooth)}
+}
+
+// Update adds a bar and returns the oscillator, false until %D is ready
+func (s *Stochastic) Update(b Bar) (StochasticValue, bool) {
+	s.highs.push(b.High)
+	s.lows.push(b.Low)
+	if s.count < len(s.highs.values) {
+		s.count++
+	}
+	if s.count < len(s.highs.values) {
+		return StochasticValue{}, false
+	}
+	high, low := math.Inf(-1), math.Inf(1)
+	s.highs.each(func(v float64) { high = math.Max(high, v) })
+	s.lows.each(func(v float64) { low = math.Min(low, v) })
+	k := 50.0
+	if high > low {
+		k = (b.Close - low) / (high - low) * 100
+	}
+	d, ok := s.d.Update(k)
+	if !ok {
+		return StochasticValue{K: k}, false
+	}
+	s.value = StochasticValue{K: k, D: d}
+	s.ready = true
+	return s.value, true
+}
+
+// Value returns the oscillator, false until %D is ready
+func (s *Stochastic) Value() (StochasticValue, bool) {
+	return s.value, s.ready
+}
+
+// StochasticSeries returns the stochastic oscillator of every bar, the fields
+// are NaN until %D is ready
+func StochasticSeries(bars []Bar, period, smooth int) []StochasticValue {
+	s := NewStochastic(period, smooth)
+	out := make([]StochasticValue, len(bars))
+	for i, b := range bars {
+		value, ok := s.Update(b)
+		if !ok {
+			value = StochasticValue{K: math.NaN(), D: math.NaN()}
+		}
+		out[i] = value
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package indicators
+
+// this file contains the volatility and volume indicators, Bollinger Bands,
+// ATR and VWAP.
+
+import (
+	"math"
+	"time"
+)
+
+// BollingerValue is the middle, upper and lower band
+type BollingerValue struct {
+	Middle float64
+	Upper  float64
+	Lower  float64
+}
+
+// Bollinger is the Bollinger Bands of the values, the simple average of the
+// last period values and the bands k standard deviations above and below it
+type Bollinger struct {
+	k     float64
+	sma   *SMA
+	value BollingerValue
+	ready bool
+}
+
+// NewBollinger returns Bollinger Bands over the period k standard deviations
+// wide, 20 and 2 are common
+func NewBollinger(period int, k float64) *Bollinger {
+	return &Bollinger{k: k, sma: NewSMA(period)}
+}
+
+// Update adds a value and returns the bands, false until period values have
+// been added
+func (b *Bollinger) Update(v float64) (BollingerValue, bool) {
+	mean, ok := b.sma.Update(v)
+	if !ok {
+		return BollingerValue{}, false
+	}
+	// The population standard deviation of the window
+	var variance float64
+	b.sma.window.each(func(x float64) { variance += (x - mean) * (x - mean) })
+	sd := math.Sqrt(variance / float64(b.sma.period))
+	b.value = BollingerValue{Middle: mean, Upper: mean + b.k*sd, Lower: mean - b.k*sd}
+	b.ready = true
+	return b.value, true
+}
+
+// Value returns the bands, false until period values have been added
+func (b *Bollinger) Value() (BollingerValue, bool) {
+	return b.value, b.ready
+}
+
+// BollingerSeries returns the Bollinger Bands of every value, the fields are
+// NaN until the bands are ready
+func BollingerSeries(values []float64, period int, k float64) []BollingerValue {
+	b := NewBollinger(period, k)
+	out := make([]BollingerValue, len(values))
+	for i, v := range values {
+		value, ok := b.Update(v)
+		if !ok {
+			value = BollingerValue{Middle: math.NaN(), Upper: math.NaN(), Lower: math.NaN()}
+		}
+		out[i] = value
+	}
+	return out
+}
+
+// ATR is Wilder's average true range of the bars
+type ATR struct {
+	smooth *wilder
+	last   float64
+	seen   bool
+}
+
+// NewATR returns the average true range over the period, 14 is common
+func NewATR(period int) *ATR {
+	return &ATR{smooth: newWilder(period)}
+}
+
+// Update adds a bar and returns the average true range, false until period
+// bars have been added. The true range of the first bar is its range
+func (a *ATR) Update(b Bar) (float64, bool) {
+	tr := b.High - b.Low
+	if a.seen {
+		tr = math.Max(tr, math.Max(math.Abs(b.High-a.last), math.Abs(b.Low-a.last)))
+	}
+	a.last, a.seen = b.Close, true
+	return a.smooth.update(tr)
+}
+
+// Value returns the average true range, false until period bars have been
+// added
+func (a *ATR) Value() (float64, bool) {
+	return a.smooth.value, a.smooth.ready
+}
+
+// ATRSeries returns the average true range of every bar
+func ATRSeries(bars []Bar, period int) []float64 {
+	return barSeries(bars, NewATR(period).Update)
+}
+
+// VWAP is the volume weighted average of the typical prices of the bars. It
+// starts over at the start of every session
+type VWAP struct {
+	session time.Duration
+	start   time.Time
+	pv      float64
+	volume  float64
+}
+
+// NewVWAP returns a volume weighted average price that starts over every
+// session, ie 24h for a daily VWAP. A session of 0 never starts over
+func NewVWAP(session time.Duration) *VWAP {
+	return &VWAP{session: session}
+}
+
+// Update adds a bar and returns the average, false while there is no volume
+// in the session
+func (v *VWAP) Update(b Bar) (float64, bool) {
+	if v.session > 0 {
+		start := b.Time.UTC().Truncate(v.session)
+		if !start.Equal(v.start) {
+			v.start, v.pv, v.volume = start, 0, 0
+		}
+	}
+	v.pv += b.Typical() * b.Volume
+	v.volume += b.Volume
+	return v.Value()
+}
+
+// Value returns the average, false while there is no volume in the session
+func (v *VWAP) Value() (float64, bool) {
+	if v.volume == 0 {
+		return 0, false
+	}
+	return v.pv / v.volume, true
+}
+
+// VWAPSeries returns the volume weighted average price of every bar
+func VWAPSeries(bars []Bar, session time.Duration) []float64 {
+	return barSeries(bars, NewVWAP(session).Update)
+}
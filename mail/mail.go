@@ -54,8 +54,10 @@ func performanceToBody(performance metrics.PortfolioPerformance) string {
 		Percent Qty Increase: %v
 		Absolute Value Increase: %v
 		Percent Value Increase: %v
+		Net Deposits Qty: %v
+		Net Deposits Value: %v
 		
-		`, a.Symbol, a.QtyIncreaseAbs, a.QtyIncreasePercent, a.ValueIncreaseAbs, a.ValueIncreasePercent)
+		`, a.Symbol, a.QtyIncreaseAbs, a.QtyIncreasePercent, a.ValueIncreaseAbs, a.ValueIncreasePercent, a.NetFlowQty, a.NetFlowValue)
 		body += s
 	}
 	return body
@@ -82,6 +84,17 @@ func EmailLifeTimePerformance() error {
 	return SendEmail(mail)
 }
 
+// EmailAlert sends an alert email
+func EmailAlert(subject, body string) error {
+	mail := Mail{
+		senderID: SenderEmail,
+		toIds:    []string{ToEmail},
+		subject:  "Trader Alert: " + subject,
+		body:     body,
+	}
+	return SendEmail(mail)
+}
+
 // SendEmail sends an email, based on the provided Mail parameters
 //
 // NOTE: currently hardcoded for Gmail
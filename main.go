@@ -4,24 +4,37 @@ package main
 // trader
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/backtest"
+	"github.com/MSevey/traderbot/config"
 	"github.com/MSevey/traderbot/mail"
+	"github.com/MSevey/traderbot/metrics"
+	"github.com/MSevey/traderbot/paper"
 	"github.com/MSevey/traderbot/trader"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	// the following the time intervals that the loops should run
-	binanceLoopTime = 2 * time.Second // if running all day set to 10s
-	metricsLoopTime = 12 * time.Hour
+	// shutdownTimeout is how long to wait for the trader to shut down
+	shutdownTimeout = time.Minute
+)
 
-	bnbBalanceTarget = 10 // set but binance trading levels
+const (
+	// the exchanges that can be traded on
+	binanceExchange = "binance"
+	paperExchange   = "paper"
 )
 
 var log = logrus.New()
@@ -40,64 +53,482 @@ func initLogger() {
 }
 
 func main() {
+	envName := flag.String("env", os.Getenv(api.EnvironmentVar), fmt.Sprintf("exchange environment profile, one of %v", api.EnvironmentNames()))
+	configFile := flag.String("config", os.Getenv(config.FileVar), "YAML config file of the trading criteria, symbols and loop intervals, reloaded on SIGHUP")
+	strategyName := flag.String("strategy", os.Getenv("traderStrategy"), fmt.Sprintf("trading strategy, one of %v", trader.StrategyNames()))
+	exchangeName := flag.String("exchange", os.Getenv("traderExchange"), "exchange to trade on, binance or paper")
+	stateDir := flag.String("state-dir", os.Getenv("traderStateDir"), "directory the trader state is saved to, defaults to $HOME/traderstate")
+	shutdownPolicy := flag.String("shutdown", os.Getenv("traderShutdownPolicy"), fmt.Sprintf("what to do with the lots and open orders on shutdown, one of %v", trader.ShutdownPolicies()))
+	takeProfit := flag.Float64("shutdown-take-profit", trader.DefaultShutdownConfig.TakeProfit, "fraction above the lot price the take-profit sells are placed at on shutdown")
+	ledgerFile := flag.String("ledger", "", "write the realized profit and loss ledger of the trader state as csv to the file and exit")
+	rebalancePreview := flag.Bool("rebalance-preview", false, "print the trades the rebalance strategy would make at the current balances and prices and exit")
+	clearHalt := flag.Bool("clear-halt", false, "clear a trading halt from the risk limits saved in the trader state")
+	paperBalances := flag.String("paper-balances", "", "starting balances of the paper exchange as ASSET=qty,ASSET=qty, defaults to the account balances")
+	backtestData := flag.String("backtest", "", "run a backtest instead of trading, the kline csv file of each symbol as SYMBOL=file,SYMBOL=file")
+	backtestBalances := flag.String("backtest-balances", "USDT=1000", "starting balances of the backtest as ASSET=qty,ASSET=qty")
+	backtestFee := flag.Float64("backtest-fee", 0.001, "fee charged on each backtest fill as a fraction")
+	backtestSlippage := flag.Float64("backtest-slippage", 0.0005, "slippage of each backtest fill as a fraction of the price")
+	flag.Parse()
+
 	initLogger()
 	api.InitLogger()
 
+	// Load the config, defaulting to the built in values
+	cfg := config.Default
+	if *configFile != "" {
+		var err error
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("config", *configFile)
+	}
+
+	// Select the exchange environment, defaulting to production
+	if *envName == "" {
+		*envName = api.ProductionEnv
+	}
+	env, err := api.LoadEnvironment(*envName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.SetEnvironment(env)
+	fmt.Println("environment", env.Name, "live trading", env.LiveTrading)
+
+	// Select the trading strategy, defaulting to the dip strategy
+	if *strategyName == "" {
+		*strategyName = trader.DipStrategyName
+	}
+	strategy, err := trader.NewStrategy(*strategyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	configureStrategy(strategy, cfg)
+	fmt.Println("strategy", strategy.Name())
+
+	// Run a backtest instead of trading
+	if *backtestData != "" {
+		btConfig := backtest.Config{
+			Strategy: strategy,
+			Fee:      *backtestFee,
+			Slippage: *backtestSlippage,
+		}
+		if err := runBacktest(btConfig, *backtestData, *backtestBalances); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Select the exchange, defaulting to binance
+	if *exchangeName == "" {
+		*exchangeName = binanceExchange
+	}
+	if *exchangeName != binanceExchange && *exchangeName != paperExchange {
+		log.Fatalf("unknown exchange %v, expected %v or %v", *exchangeName, binanceExchange, paperExchange)
+	}
+	fmt.Println("exchange", *exchangeName)
+
+	// Keep the state of each environment and exchange separate so paper
+	// trading lots are never sold on the real exchange
+	if *stateDir == "" {
+		*stateDir = filepath.Join(os.Getenv("HOME"), "traderstate")
+	}
+	*stateDir = filepath.Join(*stateDir, env.Name, *exchangeName)
+
+	// Export the ledger instead of trading
+	if *ledgerFile != "" {
+		if err := exportLedger(strategy, *stateDir, *ledgerFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Preview the rebalance instead of trading
+	if *rebalancePreview {
+		if err := previewRebalance(strategy, cfg, *stateDir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Select the shutdown policy, defaulting to leaving the lots as they are
+	shutdown := trader.DefaultShutdownConfig
+	shutdown.TakeProfit = *takeProfit
+	if *shutdownPolicy != "" {
+		shutdown.Policy = trader.ShutdownPolicy(*shutdownPolicy)
+	}
+	if err := shutdown.Policy.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("shutdown policy", shutdown.Policy)
+
 	// Create channel to control go routines
 	//
 	// TODO: look at importing Nebulous Labs thread repo
 	done := make(chan struct{})
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	tradeReload := make(chan config.Config, 1)
+	emailReload := make(chan config.Config, 1)
 
 	// start trading
-	go trade(done)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		trade(done, tradeReload, strategy, cfg, tradeOptions{
+			exchange:      *exchangeName,
+			paperBalances: *paperBalances,
+			stateDir:      *stateDir,
+			shutdown:      shutdown,
+			clearHalt:     *clearHalt,
+		})
+	}()
 
 	// Send Email Summaries
-	go emailSummaries(done)
+	go func() {
+		defer wg.Done()
+		emailSummaries(done, emailReload, cfg)
+	}()
 
-	// Listen for crtl+c to end
-	for {
+	// Listen for crtl+c or SIGTERM to end and SIGHUP to reload the config
+	var s os.Signal
+	for s == nil {
 		select {
-		case <-sig:
-			close(done)
-			return
-		default:
+		case s = <-sig:
+		case <-hup:
+			reloaded, err := reloadConfig(*configFile, cfg)
+			if err != nil {
+				fmt.Println("config not reloaded:", err)
+				log.Warn("WARN: config not reloaded: ", err)
+				continue
+			}
+			cfg = reloaded
+			sendConfig(tradeReload, cfg)
+			sendConfig(emailReload, cfg)
+			log.Info("Config reloaded from ", *configFile)
+		}
+	}
+	fmt.Println("received", s, "shutting down")
+	log.Info("Received ", s, ", shutting down")
+	close(done)
+
+	// Wait for the in flight requests to finish and the state to be saved, a
+	// second signal or the timeout exits immediately
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case s = <-sig:
+		log.Warn("Received ", s, " during shutdown, exiting")
+	case <-time.After(shutdownTimeout):
+		log.Warn("Shutdown timed out after ", shutdownTimeout)
+	}
+}
+
+// reloadConfig loads the config file again, checking that it only changes the
+// parameters that can be reloaded while trading
+func reloadConfig(filename string, current config.Config) (config.Config, error) {
+	if filename == "" {
+		return config.Config{}, errors.New("no config file to reload")
+	}
+	reloaded, err := config.Load(filename)
+	if err != nil {
+		return config.Config{}, err
+	}
+	if err := reloaded.CheckReload(current); err != nil {
+		return config.Config{}, err
+	}
+	return reloaded, nil
+}
+
+// sendConfig sends a reloaded config to a go routine, replacing any config the
+// go routine has not picked up yet
+func sendConfig(ch chan config.Config, c config.Config) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- c
+}
+
+// configureTrader applies the config to the trader
+func configureTrader(t *trader.Trader, c config.Config) {
+	t.SetBuyBalanceLimit(c.BuyBalanceLimit)
+	t.SetAdaptiveConfig(c.AdaptiveConfig())
+	t.SetRiskConfig(c.RiskConfig())
+	t.SetExitConfig(c.ExitConfig())
+	t.SetFeeConfig(c.FeeConfig())
+	t.SetLotPolicy(c.LotPolicy)
+	t.SetArbitrageConfig(c.ArbitrageConfig())
+	for _, symbol := range c.Symbols {
+		t.SetBudget(symbol, c.Budgets[symbol])
+	}
+}
+
+// configureStrategy applies the config to the strategy
+func configureStrategy(strategy trader.Strategy, c config.Config) {
+	switch s := strategy.(type) {
+	case *trader.DipStrategy:
+		s.SetConfig(c.DipConfig())
+	case *trader.GridStrategy:
+		s.SetConfig(c.GridConfig())
+	case *trader.RebalanceStrategy:
+		s.SetConfig(c.RebalanceConfig())
+	}
+}
+
+// runBacktest replays the kline csv files through the strategy and prints the
+// summary of the backtest
+func runBacktest(config backtest.Config, data, balances string) error {
+	pairs, err := parsePairs(data)
+	if err != nil {
+		return err
+	}
+	var series [][]backtest.Tick
+	for symbol, filename := range pairs {
+		bars, err := backtest.LoadBarsFile(filename)
+		if err != nil {
+			return err
+		}
+		series = append(series, backtest.BarTicks(symbol, bars, true))
+	}
+
+	config.InitialBalances, err = parseBalances(balances)
+	if err != nil {
+		return err
+	}
+
+	result, err := backtest.New(config).Run(backtest.MergeTicks(series...))
+	if err != nil {
+		return err
+	}
+	for _, trade := range result.Trades {
+		fmt.Println(trade.Time.Format(time.RFC3339), trade.Side, trade.Symbol, trade.Quantity, "@", trade.Price, "fee", trade.Fee, trade.FeeAsset)
+	}
+	fmt.Print(result.Summary)
+	return nil
+}
+
+// exportLedger writes the ledger of the trader state in the directory as csv
+// to the file and prints its summary
+func exportLedger(strategy trader.Strategy, dir, filename string) error {
+	t := trader.NewTrader(strategy)
+	if err := t.Load(dir); err != nil {
+		return err
+	}
+	ledger := t.Ledger()
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := trader.WriteLedgerCSV(f, ledger); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s := trader.SummarizeLedger(ledger)
+	fmt.Printf("ledger %v: %v closed lots, %v wins, %v losses, pnl %.2f, fees %.2f, short term %.2f, long term %.2f, win rate %.4f, payoff %.4f\n",
+		filename, s.Lots, s.Wins, s.Losses, s.PnL, s.Fees, s.ShortTermPnL, s.LongTermPnL, s.WinRate(), s.Payoff())
+	if gs, ok := strategy.(*trader.GridStrategy); ok {
+		// Report on the levels the grid trades, rounded to the symbol rules
+		info, err := api.NewBinanceClient().GetBinanceExchangeInfo()
+		if err != nil {
+			return err
+		}
+		rules, err := info.Rules()
+		if err != nil {
+			return err
 		}
+		gs.SetRules(rules[strategy.Symbols()[0]])
+		for _, level := range gs.Report(ledger) {
+			fmt.Printf("grid level %v: %v round trips, quantity %v, pnl %.2f, fees %.2f\n", level.Price, level.RoundTrips, level.Quantity, level.PnL, level.Fees)
+		}
+	}
+	return nil
+}
+
+// previewRebalance prints the weights of the assets and the trades the
+// rebalance strategy would make with the account balances and the current
+// prices
+func previewRebalance(strategy trader.Strategy, cfg config.Config, dir string) error {
+	t := trader.NewTrader(strategy)
+	configureTrader(t, cfg)
+	if err := t.Load(dir); err != nil {
+		return err
+	}
+	client := api.NewBinanceClient()
+	account, err := client.GetAccountInfo()
+	if err != nil {
+		return err
+	}
+	if err := t.UpdateBalances(account); err != nil {
+		return err
+	}
+	prices := make(map[string]float64)
+	for _, symbol := range strategy.Symbols() {
+		tp, err := client.GetCoinPrice(symbol)
+		if err != nil {
+			return err
+		}
+		prices[symbol], err = strconv.ParseFloat(tp.Price, 64)
+		if err != nil {
+			return err
+		}
+	}
+	plan, err := t.PreviewRebalance(prices)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("portfolio value %.2f %v\n", plan.Total, cfg.Rebalance.ValueAsset)
+	for _, a := range plan.Assets {
+		fmt.Printf("%v: balance %v, value %.2f, weight %.4f, target %.4f, drift %+.4f\n", a.Asset, a.Balance, a.Value, a.Weight, a.Target, a.Drift())
+	}
+	if len(plan.Trades) == 0 {
+		fmt.Println("no trades, the assets are within the min trade of their targets")
+	}
+	for _, trade := range plan.Trades {
+		fmt.Printf("trade %v, value %.2f\n", trade, trade.Value)
+	}
+	return nil
+}
+
+// parseBalances parses a list of ASSET=qty pairs separated by commas
+func parseBalances(s string) (map[string]float64, error) {
+	pairs, err := parsePairs(s)
+	if err != nil {
+		return nil, err
 	}
+	balances := make(map[string]float64)
+	for asset, qty := range pairs {
+		balances[asset], err = strconv.ParseFloat(qty, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return balances, nil
 }
 
-func emailSummaries(done chan struct{}) {
+// parsePairs parses a list of KEY=value pairs separated by commas
+func parsePairs(s string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid pair %q, expected KEY=value", pair)
+		}
+		pairs[strings.ToUpper(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return pairs, nil
+}
+
+// emailSummaries sends the performance summary emails and keeps the metrics up
+// to date
+func emailSummaries(done chan struct{}, reload chan config.Config, cfg config.Config) {
 	// Send emails on start up
 	if err := mail.EmailLifeTimePerformance(); err != nil {
 		log.Warn("couldn't send life time summary email", err)
 	}
 
 	// Send emails on intervals
+	emailTicker := time.NewTicker(cfg.EmailInterval)
+	metricsTicker := time.NewTicker(cfg.MetricsLoopTime)
+	defer func() {
+		emailTicker.Stop()
+		metricsTicker.Stop()
+	}()
 	for {
-		// Send Summary once a minute
-		lifeTimeSignal := time.After(24 * time.Hour)
 		select {
 		case <-done:
 			return
-		case <-lifeTimeSignal:
+		case c := <-reload:
+			if c.EmailInterval != cfg.EmailInterval {
+				emailTicker.Stop()
+				emailTicker = time.NewTicker(c.EmailInterval)
+			}
+			if c.MetricsLoopTime != cfg.MetricsLoopTime {
+				metricsTicker.Stop()
+				metricsTicker = time.NewTicker(c.MetricsLoopTime)
+			}
+			cfg = c
+		case <-emailTicker.C:
 			if err := mail.EmailLifeTimePerformance(); err != nil {
 				log.Warn("couldn't send life time summary email", err)
 			}
+		case <-metricsTicker.C:
+			if _, err := metrics.SyncCashFlows(); err != nil {
+				log.Warn("couldn't sync cash flows", err)
+			}
 		}
 	}
 }
 
-// trade trades on the binance exchange
-func trade(done chan struct{}) {
-	// Initialize trader
-	t := trader.NewTrader()
+// tradeOptions are the options of the trading loop that are set on start up
+type tradeOptions struct {
+	exchange      string
+	paperBalances string
+	stateDir      string
+	shutdown      trader.ShutdownConfig
+	clearHalt     bool
+}
+
+// trade trades on the binance exchange, or on the paper exchange with the
+// live binance prices
+func trade(done chan struct{}, reload chan config.Config, strategy trader.Strategy, cfg config.Config, opts tradeOptions) {
+	// Initialize trader and reload its saved state
+	t := trader.NewTrader(strategy)
+	configureTrader(t, cfg)
+	if err := t.Load(opts.stateDir); err != nil {
+		log.Warn("Couldn't load trader state", err)
+		return
+	}
+	if opts.clearHalt {
+		t.ClearHalt()
+	}
+	if halted, reason := t.Halted(); halted {
+		fmt.Println("trading halted:", reason)
+	}
+
+	// Alert when the risk limits halt trading
+	t.SubscribeRisk(riskEvent)
 
 	// Create client for binance requests
 	binanceClient := api.NewBinanceClient()
 
+	// Pause trading while the exchange is unavailable
+	binanceClient.Breaker.Subscribe(func(e api.BreakerEvent) {
+		breakerEvent(t, e)
+	})
+
+	// Select the exchange to trade on
+	var exchange trader.Exchange = binanceClient
+	if opts.exchange == paperExchange {
+		var balances map[string]float64
+		if opts.paperBalances != "" {
+			var err error
+			balances, err = parseBalances(opts.paperBalances)
+			if err != nil {
+				log.Warn("Couldn't parse paper balances", err)
+				return
+			}
+		}
+		paperClient, err := paper.NewFromAccount(binanceClient, balances)
+		if err != nil {
+			log.Warn("Couldn't create paper exchange", err)
+			return
+		}
+		exchange = paperClient
+	}
+
 	// Get account information
-	account, err := binanceClient.GetAccountInfo()
+	account, err := exchange.GetAccountInfo()
 	if err != nil {
 		log.Warn("Couldn't get account info", err)
 		return
@@ -109,50 +540,59 @@ func trade(done chan struct{}) {
 	// Update balances
 	t.UpdateBalances(account)
 
-	fmt.Println("btcBalance", t.BtcBalance())
-	fmt.Println("bnbBalance", t.BnbBalance())
-	fmt.Println("usdtBalance", t.UsdtBalance())
-	fmt.Println("minBalance", t.MinBalance())
+	for asset, bal := range t.Balances() {
+		if bal > 0 {
+			fmt.Println(asset, "balance", bal, "min balance", t.MinBalance(asset))
+		}
+	}
 
+	ticker := time.NewTicker(cfg.BinanceLoopTime)
+	defer func() { ticker.Stop() }()
 	for {
-		// Ping exchange to get up to date limits
-		info, err := binanceClient.GetBinanceExchangeInfo()
-		if err != nil {
-			log.Warn("WARN: error getting exchange info:", err)
+		select {
+		case <-done:
+			// apply the shutdown policy and persist trader state
+			if err := t.Shutdown(exchange, opts.shutdown); err != nil {
+				log.Warn("error shutting down trader", err)
+			}
+			return
+		case c := <-reload:
+			// Apply the reloaded config between steps so the strategy is
+			// never changed while it is deciding
+			configureTrader(t, c)
+			configureStrategy(strategy, c)
+			if c.BinanceLoopTime != cfg.BinanceLoopTime {
+				ticker.Stop()
+				ticker = time.NewTicker(c.BinanceLoopTime)
+			}
+			cfg = c
 			continue
+		case <-ticker.C:
 		}
-		t.UpdateLimits(info)
 
-		btcPrice, btcSymbol, err := t.UpdateBTCBuyerPrices(binanceClient)
-		if err != nil {
-			log.Warn("error updating buyer prices:", err)
+		// Don't trade while the exchange is unavailable
+		if t.Paused() {
 			continue
 		}
 
-		// Buy BTC (currently inverting for testing)
-		if !t.CanBuyBTC() {
-			t.TryBTCBuy(binanceClient, btcPrice, btcSymbol)
-
+		// Ping exchange to get up to date limits
+		info, err := exchange.GetBinanceExchangeInfo()
+		if err != nil {
+			logTradeError("WARN: error getting exchange info:", err)
+			continue
 		}
+		t.UpdateLimits(info)
 
-		if t.MinBalance() < t.BtcBalance() {
-			btcPrice, err := t.UpdateBTCSellerPrices(binanceClient)
-			if err != nil {
-				log.Warn("error updating seller prices:", err)
-				continue
-			}
-			// Buy BNB
-			if t.BnbBalance() < bnbBalanceTarget {
-				t.TryBNBBuy(binanceClient)
-			}
-			//Sell BTC
-			t.TryBTCSell(binanceClient, btcPrice)
+		// Run the strategy
+		if err := t.Step(exchange); err != nil {
+			logTradeError("error running strategy:", err)
+			continue
 		}
 
 		// Update Balances
-		account, err := binanceClient.GetAccountInfo()
+		account, err := exchange.GetAccountInfo()
 		if err != nil {
-			log.Warn(err)
+			logTradeError("error getting account info:", err)
 			continue
 		}
 		if !account.CanTrade {
@@ -160,17 +600,44 @@ func trade(done chan struct{}) {
 			continue
 		}
 		t.UpdateBalances(account)
+	}
+}
 
-		select {
-		case <-done:
-			// persist minBalance
-			if err := os.Setenv("binanceMinBalance", strconv.FormatFloat(t.MinBalance(), 'f', -1, 64)); err != nil {
-				log.Warn(err)
-			}
-			// submit all order heap as sell orders
-			return
-		default:
-		}
-		time.Sleep(binanceLoopTime)
+// logTradeError logs an error from the trading loop. Errors from requests
+// rejected by the circuit breaker are not logged since the breaker already
+// reported the outage
+func logTradeError(msg string, err error) {
+	if err == api.ErrCircuitOpen {
+		return
+	}
+	log.Warn(msg, err)
+}
+
+// riskEvent sends an alert when trading is halted by the risk limits
+func riskEvent(e trader.RiskEvent) {
+	subject := fmt.Sprintf("trading halted, %v breached", e.Limit)
+	body := fmt.Sprintf("Trading was halted at %v\n\nReason: %v\n\nRestart the trader with -clear-halt to resume trading", e.Time, e.Reason)
+	if err := mail.EmailAlert(subject, body); err != nil {
+		log.Warn("couldn't send risk alert email", err)
+	}
+}
+
+// breakerEvent pauses or resumes the trader when the state of the exchange
+// circuit breaker changes and sends a notification
+func breakerEvent(t *trader.Trader, e api.BreakerEvent) {
+	// Half-open events and failed probes happen every time the exchange is
+	// probed, only act on the transitions that change trading
+	switch {
+	case e.To == api.BreakerOpen && e.From == api.BreakerClosed:
+		t.Pause(e.Reason)
+	case e.To == api.BreakerClosed:
+		t.Resume(e.Reason)
+	default:
+		return
+	}
+	subject := fmt.Sprintf("exchange circuit breaker %v", e.To)
+	body := fmt.Sprintf("Circuit breaker changed from %v to %v at %v\n\nReason: %v", e.From, e.To, e.Time, e.Reason)
+	if err := mail.EmailAlert(subject, body); err != nil {
+		log.Warn("couldn't send circuit breaker alert email", err)
 	}
 }
@@ -0,0 +1,317 @@
+package metrics
+
+// this file contains the code for tracking the external cash flows of the
+// portfolio. Deposits, withdrawals and transfers out of the spot wallet are not
+// the result of trading, so they are recorded locally and used to adjust the
+// performance calculations.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"gitlab.com/NebulousLabs/Sia/persist"
+)
+
+// CashFlowType is the type of an external cash flow
+type CashFlowType string
+
+const (
+	// CashFlowDeposit is a deposit into the account
+	CashFlowDeposit CashFlowType = "deposit"
+
+	// CashFlowWithdrawal is a withdrawal from the account
+	CashFlowWithdrawal CashFlowType = "withdrawal"
+
+	// CashFlowTransfer is a transfer between the spot wallet and another
+	// wallet of the account
+	CashFlowTransfer CashFlowType = "transfer"
+)
+
+type (
+	// CashFlow is an external flow of an asset into or out of the portfolio
+	CashFlow struct {
+		ID    string       `json:"id"`
+		Type  CashFlowType `json:"type"`
+		Asset string       `json:"asset"`
+		// Quantity is positive for flows into the portfolio and negative for
+		// flows out of the portfolio
+		Quantity float64   `json:"quantity"`
+		Value    float64   `json:"value"`
+		Time     time.Time `json:"time"`
+	}
+
+	// CashFlowLedger is the persisted record of the external cash flows
+	CashFlowLedger struct {
+		Flows    []CashFlow `json:"flows"`
+		LastSync time.Time  `json:"lastsync"`
+	}
+
+	// netFlow is the net external flow of an asset
+	netFlow struct {
+		quantity float64
+		value    float64
+	}
+
+	// historyWindow is the time range of a history request
+	historyWindow struct {
+		start time.Time
+		end   time.Time
+	}
+)
+
+var (
+	// cashFlowMetadata is the metadata for the persisted file that stores the
+	// cash flow ledger
+	cashFlowMetadata = persist.Metadata{
+		Header:  "CashFlows",
+		Version: "v1.0.0",
+	}
+
+	// cashFlowFile is the filename for the persisted cash flow ledger
+	cashFlowFile = "cashflows.json"
+
+	// cashFlowOverlap is how far before the last sync the history is
+	// requested again, so that flows that were pending during the last sync
+	// are picked up once they complete
+	cashFlowOverlap = 7 * 24 * time.Hour
+)
+
+// LoadCashFlows loads the cash flow ledger from disk. An empty ledger is
+// returned if one has not been saved yet
+func LoadCashFlows() (CashFlowLedger, error) {
+	ledger := CashFlowLedger{}
+	err := persist.LoadJSON(cashFlowMetadata, &ledger, filepath.Join(metricsDir, cashFlowFile))
+	if os.IsNotExist(err) {
+		return CashFlowLedger{}, nil
+	}
+	return ledger, err
+}
+
+// saveCashFlows saves the cash flow ledger to disk
+func saveCashFlows(ledger CashFlowLedger) error {
+	if err := os.MkdirAll(metricsDir, 0777); err != nil {
+		return err
+	}
+	return persist.SaveJSON(cashFlowMetadata, ledger, filepath.Join(metricsDir, cashFlowFile))
+}
+
+// RecordCashFlow adds a cash flow to the ledger on disk, it is for flows that
+// are not reported by the exchange history endpoints. Flows that are already
+// recorded are ignored
+func RecordCashFlow(flow CashFlow) error {
+	ledger, err := LoadCashFlows()
+	if err != nil {
+		return err
+	}
+	ledger.add(flow)
+	return saveCashFlows(ledger)
+}
+
+// add adds a flow to the ledger if it has not been recorded, it returns true
+// if the flow was added
+func (l *CashFlowLedger) add(flow CashFlow) bool {
+	if l.has(flow) {
+		return false
+	}
+	l.Flows = append(l.Flows, flow)
+	return true
+}
+
+// has returns true if the flow has been recorded
+func (l CashFlowLedger) has(flow CashFlow) bool {
+	for _, f := range l.Flows {
+		if f.Type == flow.Type && f.ID == flow.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// netFlows returns the net flow of each asset since the provided time
+func (l CashFlowLedger) netFlows(since time.Time) map[string]netFlow {
+	flows := make(map[string]netFlow)
+	for _, f := range l.Flows {
+		if f.Time.Before(since) {
+			continue
+		}
+		nf := flows[f.Asset]
+		nf.quantity += f.Quantity
+		nf.value += f.Value
+		flows[f.Asset] = nf
+	}
+	return flows
+}
+
+// SyncCashFlows pulls the deposit, withdrawal and transfer history from the
+// exchange and records any new flows on disk. The history is requested in
+// windows as Binance limits the time range of a request
+//
+// NOTE: flows are valued at the price when they are first recorded, so the
+// sync should be run regularly to keep the values close to the price at the
+// time of the flow
+func SyncCashFlows() (CashFlowLedger, error) {
+	ledger, err := LoadCashFlows()
+	if err != nil {
+		return CashFlowLedger{}, err
+	}
+	var start time.Time
+	if !ledger.LastSync.IsZero() {
+		start = ledger.LastSync.Add(-cashFlowOverlap)
+	}
+	syncTime := time.Now()
+
+	client := api.NewBinanceClient()
+	var flows []CashFlow
+	for _, w := range historyWindows(start, syncTime) {
+		windowFlows, err := cashFlows(client, w.start, w.end)
+		if err == api.ErrNoWalletAPI {
+			// Nothing to sync, only manually recorded flows are available
+			return ledger, nil
+		}
+		if err != nil {
+			return CashFlowLedger{}, err
+		}
+		flows = append(flows, windowFlows...)
+	}
+
+	// Value and record the new flows
+	snapshot, err := client.GetTickerSnapshot()
+	if err != nil {
+		return CashFlowLedger{}, err
+	}
+	ledger.record(flows, snapshot, syncTime)
+	if err := saveCashFlows(ledger); err != nil {
+		return CashFlowLedger{}, err
+	}
+	return ledger, nil
+}
+
+// historyWindows splits the time between start and end into windows no longer
+// than the api.HistoryWindow. A zero start is a single window with no range,
+// left for Binance to default
+func historyWindows(start, end time.Time) []historyWindow {
+	if start.IsZero() {
+		return []historyWindow{{}}
+	}
+	var windows []historyWindow
+	for start.Before(end) {
+		w := historyWindow{start: start, end: start.Add(api.HistoryWindow)}
+		if w.end.After(end) {
+			w.end = end
+		}
+		windows = append(windows, w)
+		start = w.end
+	}
+	return windows
+}
+
+// cashFlows returns the deposits, withdrawals and transfers of the spot
+// wallet between the start and end times
+func cashFlows(client *api.Client, start, end time.Time) ([]CashFlow, error) {
+	var flows []CashFlow
+	deposits, err := client.GetDepositHistory(start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deposits {
+		if d.Status != api.DepositSuccess {
+			continue
+		}
+		qty, err := strconv.ParseFloat(d.Amount, 64)
+		if err != nil {
+			return nil, err
+		}
+		flows = append(flows, CashFlow{
+			ID:       d.ID,
+			Type:     CashFlowDeposit,
+			Asset:    d.Coin,
+			Quantity: qty,
+			Time:     time.Unix(0, d.InsertTime*int64(time.Millisecond)),
+		})
+	}
+
+	withdrawals, err := client.GetWithdrawHistory(start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range withdrawals {
+		if w.Status != api.WithdrawCompleted {
+			continue
+		}
+		qty, err := strconv.ParseFloat(w.Amount, 64)
+		if err != nil {
+			return nil, err
+		}
+		fee, err := strconv.ParseFloat(w.TransactionFee, 64)
+		if err != nil {
+			return nil, err
+		}
+		t, err := w.Time()
+		if err != nil {
+			return nil, err
+		}
+		flows = append(flows, CashFlow{
+			ID:       w.ID,
+			Type:     CashFlowWithdrawal,
+			Asset:    w.Coin,
+			Quantity: -(qty + fee),
+			Time:     t,
+		})
+	}
+
+	// Transfers out of the spot wallet are outflows and transfers into the
+	// spot wallet are inflows
+	for transferType, sign := range map[string]float64{
+		api.TransferSpotToFunding: -1,
+		api.TransferFundingToSpot: 1,
+	} {
+		transfers, err := client.GetTransferHistory(transferType, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, tr := range transfers {
+			if tr.Status != "CONFIRMED" {
+				continue
+			}
+			qty, err := strconv.ParseFloat(tr.Amount, 64)
+			if err != nil {
+				return nil, err
+			}
+			flows = append(flows, CashFlow{
+				ID:       strconv.FormatInt(tr.TranID, 10),
+				Type:     CashFlowTransfer,
+				Asset:    tr.Asset,
+				Quantity: sign * qty,
+				Time:     time.Unix(0, tr.Timestamp*int64(time.Millisecond)),
+			})
+		}
+	}
+	return flows, nil
+}
+
+// record values the flows at the prices of the snapshot and adds the new ones
+// to the ledger. A flow that can't be priced is left for a later sync, the
+// last sync is kept before it so it is requested again
+func (l *CashFlowLedger) record(flows []CashFlow, snapshot api.TickerSnapshot, syncTime time.Time) {
+	lastSync := syncTime
+	for _, flow := range flows {
+		if l.has(flow) {
+			continue
+		}
+		price, ok := snapshot.Rate(flow.Asset, "USDT")
+		if !ok {
+			fmt.Println("WARN: no USDT price for", flow.Asset, "leaving", flow.Type, flow.ID, "unsynced")
+			if flow.Time.Before(lastSync) {
+				lastSync = flow.Time
+			}
+			continue
+		}
+		flow.Value = flow.Quantity * price
+		l.add(flow)
+	}
+	l.LastSync = lastSync
+}
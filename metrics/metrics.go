@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strconv"
 	"time"
 
@@ -61,13 +60,17 @@ type (
 		Assets []AssetPerformance
 	}
 
-	// AssetPerformance contains information about the performance of an asset
+	// AssetPerformance contains information about the performance of an
+	// asset. The increases are adjusted for the net external cash flows of the
+	// asset
 	AssetPerformance struct {
 		Symbol               string
 		QtyIncreaseAbs       float64
 		QtyIncreasePercent   float64
 		ValueIncreaseAbs     float64
 		ValueIncreasePercent float64
+		NetFlowQty           float64
+		NetFlowValue         float64
 	}
 )
 
@@ -95,20 +98,24 @@ var (
 )
 
 // LifeTimePortfolioPerformance calculates the lifetime performance of the
-// portfolio
+// portfolio. Deposits, withdrawals and transfers since the initial balance are
+// added to the initial balance so they do not show up as profit or loss. If
+// the cash flows can't be synced the flows saved on disk are used
 func LifeTimePortfolioPerformance() (PortfolioPerformance, error) {
 	// Get initial Balance
 	initial, err := initialBalance()
 	if err != nil {
 		return PortfolioPerformance{}, err
 	}
-	// Create map of assets for comparision
-	initialAssetMap := make(map[string]Asset)
-	for _, asset := range initial.Assets {
-		if _, ok := initialAssetMap[asset.Symbol]; ok {
-			continue
+
+	// Get external cash flows since the initial balance
+	ledger, err := SyncCashFlows()
+	if err != nil {
+		fmt.Println("WARN: unable to sync cash flows, using the saved cash flows:", err)
+		ledger, err = LoadCashFlows()
+		if err != nil {
+			return PortfolioPerformance{}, err
 		}
-		initialAssetMap[asset.Symbol] = asset
 	}
 
 	// Get Current balance
@@ -116,37 +123,52 @@ func LifeTimePortfolioPerformance() (PortfolioPerformance, error) {
 	if err != nil {
 		return PortfolioPerformance{}, err
 	}
+	return portfolioPerformance(initial, current, ledger.netFlows(initial.Updated)), nil
+}
 
-	// Calculate performance
-	//
-	// NOTE: Ignore any assets in initial balance that aren't in current balance
-	var performance PortfolioPerformance
-	for _, asset := range current.Assets {
-		var ap AssetPerformance
-		// Check if asset was part of initial balance
-		initialAsset, ok := initialAssetMap[asset.Symbol]
-		if ok {
-			// Calculate performance
-			ap.Symbol = asset.Symbol
-			ap.QtyIncreaseAbs = asset.Quantity - initialAsset.Quantity
-			ap.QtyIncreasePercent = ((asset.Quantity - initialAsset.Quantity) / initialAsset.Quantity) * 100
-			ap.ValueIncreaseAbs = asset.Value - initialAsset.Value
-			ap.ValueIncreasePercent = ((asset.Value - initialAsset.Value) / initialAsset.Value) * 100
-			// Add to portfolio performance
-			performance.Assets = append(performance.Assets, ap)
+// portfolioPerformance calculates the performance of the current portfolio
+// against the initial portfolio adjusted by the net external flows of each
+// asset
+//
+// NOTE: Ignore any assets in initial balance that aren't in current balance
+func portfolioPerformance(initial, current Portfolio, flows map[string]netFlow) PortfolioPerformance {
+	// Create map of assets for comparision
+	initialAssetMap := make(map[string]Asset)
+	for _, asset := range initial.Assets {
+		if _, ok := initialAssetMap[asset.Symbol]; ok {
 			continue
 		}
+		initialAssetMap[asset.Symbol] = asset
+	}
+
+	var performance PortfolioPerformance
+	for _, asset := range current.Assets {
+		// The basis is the initial balance plus any net external flows
+		initialAsset := initialAssetMap[asset.Symbol]
+		flow := flows[asset.Symbol]
+		basisQty := initialAsset.Quantity + flow.quantity
+		basisValue := initialAsset.Value + flow.value
+
 		// Calculate performance
-		ap.Symbol = asset.Symbol
-		ap.QtyIncreaseAbs = asset.Quantity
-		ap.QtyIncreasePercent = 100
-		ap.ValueIncreaseAbs = asset.Value
-		ap.ValueIncreasePercent = 100
+		ap := AssetPerformance{
+			Symbol:               asset.Symbol,
+			QtyIncreaseAbs:       asset.Quantity - basisQty,
+			QtyIncreasePercent:   100,
+			ValueIncreaseAbs:     asset.Value - basisValue,
+			ValueIncreasePercent: 100,
+			NetFlowQty:           flow.quantity,
+			NetFlowValue:         flow.value,
+		}
+		if basisQty > 0 {
+			ap.QtyIncreasePercent = (ap.QtyIncreaseAbs / basisQty) * 100
+		}
+		if basisValue > 0 {
+			ap.ValueIncreasePercent = (ap.ValueIncreaseAbs / basisValue) * 100
+		}
 		// Add to portfolio performance
 		performance.Assets = append(performance.Assets, ap)
 	}
-
-	return performance, nil
+	return performance
 }
 
 // initialBalance returns the initial balance of the trader that was saved on
@@ -154,13 +176,16 @@ func LifeTimePortfolioPerformance() (PortfolioPerformance, error) {
 func initialBalance() (Portfolio, error) {
 	filename := filepath.Join(metricsDir, initialBalanceFile+balanceExtension)
 	portfolio := Portfolio{}
-	err := persist.LoadJSON(initialBalanceMetadata, portfolio, filename)
+	err := persist.LoadJSON(initialBalanceMetadata, &portfolio, filename)
 	if os.IsNotExist(err) {
 		// No initial balance found, create an initial balance
 		if err = os.MkdirAll(metricsDir, 0777); err != nil {
 			return Portfolio{}, err
 		}
 		portfolio, err = PortfolioBalance()
+		if err != nil {
+			return Portfolio{}, err
+		}
 		err = persist.SaveJSON(initialBalanceMetadata, portfolio, filename)
 	}
 	if err != nil {
@@ -181,8 +206,20 @@ func PortfolioBalance() (Portfolio, error) {
 	if err != nil {
 		return Portfolio{}, err
 	}
+	// Get the prices of all the coins at once so the assets are valued
+	// consistently
+	snapshot, err := client.GetTickerSnapshot()
+	if err != nil {
+		return Portfolio{}, err
+	}
+	return portfolioValue(accountInfo, snapshot), nil
+}
+
+// portfolioValue values the assets of an account in USDT using the prices of
+// the snapshot
+func portfolioValue(accountInfo api.AccountInfo, snapshot api.TickerSnapshot) Portfolio {
 	var p Portfolio
-	p.Updated = time.Now()
+	p.Updated = snapshot.Time
 	for _, asset := range accountInfo.Balances {
 		// Check for no zero assets
 		free, err := strconv.ParseFloat(asset.Free, 64)
@@ -199,21 +236,13 @@ func PortfolioBalance() (Portfolio, error) {
 			continue
 		}
 
-		// Get current price
-		tp, err := client.GetCoinPrice(asset.Asset + "USDT")
-		if err != nil {
-			return Portfolio{}, err
-		}
-		if reflect.DeepEqual(tp, api.TickerPrice{}) {
-			// TODO - need to update this to log message and then try to get
-			// asset value in BTC
-			fmt.Println("No ticker price information")
+		// Get current price, converting through another coin if there is no
+		// USDT market for the asset
+		price, ok := snapshot.Rate(asset.Asset, "USDT")
+		if !ok {
+			fmt.Println("No ticker price information for", asset.Asset)
 			continue
 		}
-		price, err := strconv.ParseFloat(tp.Price, 32)
-		if err != nil {
-			return Portfolio{}, err
-		}
 
 		// Update Portfolio
 		qty := free + locked
@@ -225,5 +254,5 @@ func PortfolioBalance() (Portfolio, error) {
 		})
 		p.Value += value
 	}
-	return p, err
+	return p
 }
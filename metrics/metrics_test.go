@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+// TestNetFlows tests that the net flows of each asset only include the flows
+// since the provided time
+func TestNetFlows(t *testing.T) {
+	start := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	ledger := CashFlowLedger{Flows: []CashFlow{
+		{ID: "1", Type: CashFlowDeposit, Asset: "BTC", Quantity: 1, Value: 6000, Time: start.Add(-time.Hour)},
+		{ID: "2", Type: CashFlowDeposit, Asset: "BTC", Quantity: 0.5, Value: 3000, Time: start},
+		{ID: "3", Type: CashFlowWithdrawal, Asset: "BTC", Quantity: -0.2, Value: -1300, Time: start.Add(time.Hour)},
+		{ID: "4", Type: CashFlowTransfer, Asset: "USDT", Quantity: -100, Value: -100, Time: start.Add(2 * time.Hour)},
+	}}
+
+	tests := []struct {
+		name     string
+		since    time.Time
+		expected map[string]netFlow
+	}{
+		{"all", time.Time{}, map[string]netFlow{"BTC": {1.3, 7700}, "USDT": {-100, -100}}},
+		{"from the start", start, map[string]netFlow{"BTC": {0.3, 1700}, "USDT": {-100, -100}}},
+		{"after the btc flows", start.Add(90 * time.Minute), map[string]netFlow{"USDT": {-100, -100}}},
+		{"none", start.Add(3 * time.Hour), map[string]netFlow{}},
+	}
+	for _, test := range tests {
+		flows := ledger.netFlows(test.since)
+		if len(flows) != len(test.expected) {
+			t.Fatalf("%v: expected %v, got %v", test.name, test.expected, flows)
+		}
+		for asset, e := range test.expected {
+			if f := flows[asset]; !near(f.quantity, e.quantity) || !near(f.value, e.value) {
+				t.Fatalf("%v: expected %v %v, got %v", test.name, asset, e, f)
+			}
+		}
+	}
+}
+
+// TestCashFlowDedup tests that flows are only recorded once by type and id,
+// both in memory and on disk
+func TestCashFlowDedup(t *testing.T) {
+	deposit := CashFlow{ID: "1", Type: CashFlowDeposit, Asset: "BTC", Quantity: 1}
+	tests := []struct {
+		name  string
+		flow  CashFlow
+		added bool
+	}{
+		{"new flow", deposit, true},
+		{"same flow", deposit, false},
+		{"same id of another type", CashFlow{ID: "1", Type: CashFlowWithdrawal, Asset: "BTC", Quantity: -1}, true},
+		{"new id", CashFlow{ID: "2", Type: CashFlowDeposit, Asset: "BTC", Quantity: 1}, true},
+	}
+	var ledger CashFlowLedger
+	for _, test := range tests {
+		if added := ledger.add(test.flow); added != test.added {
+			t.Fatalf("%v: expected added %v, got %v", test.name, test.added, added)
+		}
+	}
+	if len(ledger.Flows) != 3 {
+		t.Fatal("expected 3 flows, got", ledger.Flows)
+	}
+
+	defer func(dir string) { metricsDir = dir }(metricsDir)
+	metricsDir = t.TempDir()
+	for _, test := range tests {
+		if err := RecordCashFlow(test.flow); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved, err := LoadCashFlows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Flows) != 3 {
+		t.Fatal("expected 3 saved flows, got", saved.Flows)
+	}
+}
+
+// TestHistoryWindows tests that the history is requested in windows no longer
+// than Binance accepts
+func TestHistoryWindows(t *testing.T) {
+	start := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(200 * 24 * time.Hour)
+	windows := historyWindows(start, end)
+	if len(windows) != 3 || !windows[0].start.Equal(start) || !windows[2].end.Equal(end) {
+		t.Fatal("unexpected windows", windows)
+	}
+	for i, w := range windows {
+		if w.end.Sub(w.start) > api.HistoryWindow || (i > 0 && !w.start.Equal(windows[i-1].end)) {
+			t.Fatal("unexpected window", w)
+		}
+	}
+	if windows = historyWindows(time.Time{}, end); len(windows) != 1 || !windows[0].start.IsZero() || !windows[0].end.IsZero() {
+		t.Fatal("expected a single window for Binance to default, got", windows)
+	}
+}
+
+// TestRecordCashFlows tests that the flows are valued when they are recorded
+// and that a flow that can't be priced is left for a later sync
+func TestRecordCashFlows(t *testing.T) {
+	start := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := api.TickerSnapshot{Prices: map[string]float64{"BTCUSDT": 6000}}
+	flows := []CashFlow{
+		{ID: "1", Type: CashFlowDeposit, Asset: "BTC", Quantity: 1, Time: start},
+		{ID: "2", Type: CashFlowDeposit, Asset: "XYZ", Quantity: 5, Time: start.Add(time.Hour)},
+	}
+	var ledger CashFlowLedger
+	ledger.record(flows, snapshot, start.Add(2*time.Hour))
+	if len(ledger.Flows) != 1 || ledger.Flows[0].Value != 6000 || !ledger.LastSync.Equal(start.Add(time.Hour)) {
+		t.Fatal("expected the unpriced flow to be left unsynced, got", ledger)
+	}
+
+	// Once it can be priced it is recorded
+	snapshot.Prices["XYZUSDT"] = 2
+	ledger.record(flows, snapshot, start.Add(3*time.Hour))
+	if len(ledger.Flows) != 2 || ledger.Flows[1].Value != 10 || !ledger.LastSync.Equal(start.Add(3*time.Hour)) {
+		t.Fatal("expected the flow to be recorded, got", ledger)
+	}
+}
+
+// TestPortfolioPerformance tests that the net external flows are added to the
+// initial balance before the performance is calculated
+func TestPortfolioPerformance(t *testing.T) {
+	initial := Portfolio{Assets: []Asset{{Symbol: "BTC", Quantity: 1, Value: 6000}}}
+	current := Portfolio{Assets: []Asset{
+		{Symbol: "BTC", Quantity: 1.6, Value: 11200},
+		{Symbol: "BNB", Quantity: 2, Value: 30},
+	}}
+	flows := map[string]netFlow{"BTC": {0.5, 3000}}
+
+	p := portfolioPerformance(initial, current, flows)
+	if len(p.Assets) != 2 {
+		t.Fatal("expected 2 assets, got", p.Assets)
+	}
+	// The deposit of 0.5 BTC is not counted as a gain
+	btc := p.Assets[0]
+	if !near(btc.QtyIncreaseAbs, 0.1) || !near(btc.QtyIncreasePercent, 0.1/1.5*100) || !near(btc.ValueIncreaseAbs, 2200) || btc.NetFlowQty != 0.5 || btc.NetFlowValue != 3000 {
+		t.Fatal("unexpected btc performance", btc)
+	}
+	// Assets without a basis are a 100% increase
+	if bnb := p.Assets[1]; bnb.QtyIncreaseAbs != 2 || bnb.QtyIncreasePercent != 100 || bnb.ValueIncreasePercent != 100 {
+		t.Fatal("unexpected bnb performance", bnb)
+	}
+}
+
+// near returns true if a and b are within a small tolerance of each other
+func near(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
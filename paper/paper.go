@@ -0,0 +1,552 @@
+package paper
+
+// the paper package is a paper trading exchange. It trades on live or replayed
+// prices and keeps virtual balances so the trader can be run end to end,
+// including the buy order heap and balance updates, without risking funds.
+// Limit orders rest on a virtual book and fill when the price crosses them,
+// and fills are charged the maker and taker fees of the real account, paid in
+// BNB at a discount while the BNB balance covers them.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+// commissionDivisor converts the commissions of api.AccountInfo into a
+// fraction, a commission of 10 is a 0.1% fee
+const commissionDivisor = 10000
+
+var (
+	// errInsufficientBalance mirrors the error Binance returns when an order
+	// can't be covered by the free balance
+	errInsufficientBalance = api.Error{Code: -2010, Msg: "Account has insufficient balance for requested action."}
+
+	// errUnknownOrder mirrors the error Binance returns for an order that
+	// does not exist
+	errUnknownOrder = api.Error{Code: -2011, Msg: "Unknown order sent."}
+
+	// errNoPrice is returned when there is no price for a symbol
+	errNoPrice = errors.New("no price for symbol")
+
+	// errWouldTrigger mirrors the error Binance returns for a stop order that
+	// would trigger as soon as it is placed
+	errWouldTrigger = api.Error{Code: -2010, Msg: "Stop price would trigger immediately."}
+)
+
+// PriceSource provides the prices the paper exchange trades on. The Binance
+// api client is a PriceSource for trading on live prices
+type PriceSource interface {
+	GetCoinPrice(symbol string) (api.TickerPrice, error)
+}
+
+// Config is the configuration of a paper exchange
+type Config struct {
+	// Balances are the starting free balances keyed by asset
+	Balances map[string]float64
+
+	// MakerCommission and TakerCommission are the fees in the units of
+	// api.AccountInfo, ie 10 for 0.1%
+	MakerCommission int
+	TakerCommission int
+
+	// BNBDiscount is the discount on fees paid in BNB. When it is set the
+	// fees are paid from the free BNB balance if it covers them, otherwise
+	// they are taken from the asset received
+	BNBDiscount float64
+}
+
+// Exchange is a paper trading exchange
+type Exchange struct {
+	source PriceSource
+	config Config
+
+	free   map[string]float64
+	locked map[string]float64
+	prices map[string]float64
+
+	// orders are the open orders keyed by order id, closed orders are kept
+	// in history
+	orders  map[int]*api.Order
+	history map[int]*api.Order
+	nextID  int
+
+	// trades are the fills of the orders with their commission keyed by
+	// order id
+	trades      map[int][]api.Trade
+	nextTradeID int
+
+	mu sync.Mutex
+}
+
+// New creates a new paper exchange trading on the prices of the source
+func New(source PriceSource, config Config) *Exchange {
+	e := &Exchange{
+		source:  source,
+		config:  config,
+		free:    make(map[string]float64),
+		locked:  make(map[string]float64),
+		prices:  make(map[string]float64),
+		orders:  make(map[int]*api.Order),
+		history: make(map[int]*api.Order),
+		trades:  make(map[int][]api.Trade),
+	}
+	for asset, qty := range config.Balances {
+		e.free[asset] = qty
+	}
+	return e
+}
+
+// NewFromAccount creates a new paper exchange trading on the prices of the
+// client with the fees of the account. If balances is nil the balances of the
+// account are used as the starting balances
+func NewFromAccount(c *api.Client, balances map[string]float64) (*Exchange, error) {
+	account, err := c.GetAccountInfo()
+	if err != nil {
+		return nil, err
+	}
+	config := Config{
+		Balances:        balances,
+		MakerCommission: account.MakerCommission,
+		TakerCommission: account.TakerCommission,
+		BNBDiscount:     api.BNBFeeDiscount,
+	}
+	if config.Balances == nil {
+		config.Balances = make(map[string]float64)
+		for _, asset := range account.Balances {
+			free, err := strconv.ParseFloat(asset.Free, 64)
+			if err != nil {
+				return nil, err
+			}
+			locked, err := strconv.ParseFloat(asset.Locked, 64)
+			if err != nil {
+				return nil, err
+			}
+			if free+locked > 0 {
+				config.Balances[asset.Asset] = free + locked
+			}
+		}
+	}
+	return New(c, config), nil
+}
+
+// GetCoinPrice returns the current price of a symbol from the price source.
+// Open orders on the symbol are filled if the price crosses them
+func (e *Exchange) GetCoinPrice(symbol string) (api.TickerPrice, error) {
+	tp, err := e.source.GetCoinPrice(symbol)
+	if err != nil {
+		return api.TickerPrice{}, err
+	}
+	price, err := strconv.ParseFloat(tp.Price, 64)
+	if err != nil {
+		return api.TickerPrice{}, err
+	}
+	e.UpdatePrice(symbol, price)
+	return tp, nil
+}
+
+// UpdatePrice sets the price of a symbol and fills the open orders the price
+// crosses. It is used to replay historical prices
+func (e *Exchange) UpdatePrice(symbol string, price float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.prices[symbol] = price
+
+	// Fill resting orders at their limit price as the maker, oldest first
+	var ids []int
+	for id, o := range e.orders {
+		if o.Symbol == symbol {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		o := e.orders[id]
+		limit, _ := strconv.ParseFloat(o.Price, 64)
+
+		// Stop orders are placed on the book as limit orders once the price
+		// reaches their stop price, filling as the taker if marketable
+		if !o.IsWorking {
+			stop, _ := strconv.ParseFloat(o.StopPrice, 64)
+			if !triggers(o.Type, o.Side, stop, price) {
+				continue
+			}
+			o.IsWorking = true
+			if crosses(o.Side, limit, price) {
+				e.fill(o, price, false)
+			}
+			continue
+		}
+		if crosses(o.Side, limit, price) {
+			e.fill(o, limit, true)
+		}
+	}
+}
+
+// crosses returns true if a limit order on the side is marketable at the price
+func crosses(side api.Side, limit, price float64) bool {
+	if side == api.SideBuy {
+		return price <= limit
+	}
+	return price >= limit
+}
+
+// triggers returns true if a stop order is triggered at the price. Stop losses
+// trigger when the price moves against the side and take profits when it
+// moves in favor of it
+func triggers(orderType api.OrderType, side api.Side, stop, price float64) bool {
+	stopLoss := orderType == api.OrderTypeStopLossLimit
+	if (side == api.SideSell) == stopLoss {
+		return price <= stop
+	}
+	return price >= stop
+}
+
+// GetAccountInfo returns the virtual balances with the fees of the exchange
+func (e *Exchange) GetAccountInfo() (api.AccountInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	account := api.AccountInfo{
+		MakerCommission: e.config.MakerCommission,
+		TakerCommission: e.config.TakerCommission,
+		CanTrade:        true,
+		UpdateTime:      time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	assets := make(map[string]struct{})
+	for asset := range e.free {
+		assets[asset] = struct{}{}
+	}
+	for asset := range e.locked {
+		assets[asset] = struct{}{}
+	}
+	var names []string
+	for asset := range assets {
+		names = append(names, asset)
+	}
+	sort.Strings(names)
+	for _, asset := range names {
+		account.Balances = append(account.Balances, api.Asset{
+			Asset:  asset,
+			Free:   formatFloat(e.free[asset]),
+			Locked: formatFloat(e.locked[asset]),
+		})
+	}
+	return account, nil
+}
+
+// GetBinanceExchangeInfo returns the exchange info of the price source if it
+// provides it
+func (e *Exchange) GetBinanceExchangeInfo() (api.ExchangeInfo, error) {
+	if source, ok := e.source.(interface {
+		GetBinanceExchangeInfo() (api.ExchangeInfo, error)
+	}); ok {
+		return source.GetBinanceExchangeInfo()
+	}
+	return api.ExchangeInfo{}, nil
+}
+
+// Get24hrStats returns the 24hr stats of the price source if it provides them
+func (e *Exchange) Get24hrStats(symbol string) (api.Stats24hr, error) {
+	if source, ok := e.source.(interface {
+		Get24hrStats(symbol string) (api.Stats24hr, error)
+	}); ok {
+		return source.Get24hrStats(symbol)
+	}
+	return api.Stats24hr{Symbol: symbol}, nil
+}
+
+// GetAllBookTickers returns a book for every symbol with a price. The paper
+// exchange fills at the price so the books have no spread, and no quantity
+// since there is no depth
+func (e *Exchange) GetAllBookTickers() ([]api.BookTicker, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	books := make([]api.BookTicker, 0, len(e.prices))
+	for symbol, price := range e.prices {
+		p := formatFloat(price)
+		books = append(books, api.BookTicker{Symbol: symbol, BidPrice: p, BidQty: "0", AskPrice: p, AskQty: "0"})
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].Symbol < books[j].Symbol })
+	return books, nil
+}
+
+// GetOpenOrders returns the open orders
+func (e *Exchange) GetOpenOrders() ([]api.Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var orders []api.Order
+	for _, o := range e.orders {
+		orders = append(orders, *o)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OrderID < orders[j].OrderID })
+	return orders, nil
+}
+
+// PostNewLimitOrder places a limit order on the virtual book. The balance for
+// the order is locked and the order is filled immediately as the taker if it
+// is marketable
+func (e *Exchange) PostNewLimitOrder(symbol string, side api.Side, quantity, price float64) (api.Result, error) {
+	return e.placeOrder(symbol, side, api.OrderTypeLimit, quantity, price, 0)
+}
+
+// PostNewStopLimitOrder places a STOP_LOSS_LIMIT or TAKE_PROFIT_LIMIT order.
+// The balance for the order is locked and the limit order is placed on the
+// virtual book once the price reaches the stop price
+func (e *Exchange) PostNewStopLimitOrder(symbol string, side api.Side, orderType api.OrderType, quantity, price, stopPrice float64) (api.Result, error) {
+	if orderType != api.OrderTypeStopLossLimit && orderType != api.OrderTypeTakeProfitLimit {
+		return api.Result{}, fmt.Errorf("order type %v is not a stop limit order type", orderType)
+	}
+	return e.placeOrder(symbol, side, orderType, quantity, price, stopPrice)
+}
+
+// placeOrder places an order on the virtual book
+func (e *Exchange) placeOrder(symbol string, side api.Side, orderType api.OrderType, quantity, price, stopPrice float64) (api.Result, error) {
+	if err := side.Validate(); err != nil {
+		return api.Result{}, err
+	}
+	base, quote, ok := api.SplitSymbol(symbol)
+	if !ok {
+		return api.Result{}, fmt.Errorf("invalid symbol %v", symbol)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	current, ok := e.prices[symbol]
+	if !ok {
+		return api.Result{}, errNoPrice
+	}
+	stop := orderType != api.OrderTypeLimit
+	if stop && triggers(orderType, side, stopPrice, current) {
+		return api.Result{}, errWouldTrigger
+	}
+
+	// Lock the balance for the order
+	asset, amount := quote, quantity*price
+	if side == api.SideSell {
+		asset, amount = base, quantity
+	}
+	if e.free[asset] < amount {
+		return api.Result{}, errInsufficientBalance
+	}
+	e.free[asset] -= amount
+	e.locked[asset] += amount
+
+	e.nextID++
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	o := &api.Order{
+		Symbol:              symbol,
+		OrderID:             e.nextID,
+		ClientOrderID:       fmt.Sprintf("paper-%v", e.nextID),
+		Price:               formatFloat(price),
+		OrigQty:             formatFloat(quantity),
+		ExecutedQty:         "0",
+		CummulativeQuoteQty: "0",
+		Status:              api.OrderStatusNew,
+		TimeInForce:         api.TimeInForceGTC,
+		Type:                orderType,
+		Side:                side,
+		Time:                now,
+		UpdateTime:          now,
+		IsWorking:           !stop,
+	}
+	if stop {
+		o.StopPrice = formatFloat(stopPrice)
+	}
+	e.orders[o.OrderID] = o
+
+	// Marketable orders fill at the current price as the taker
+	if !stop && crosses(side, price, current) {
+		e.fill(o, current, false)
+	}
+	return result(o), nil
+}
+
+// GetOrder returns an order
+func (e *Exchange) GetOrder(symbol string, orderID int) (api.Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if o, ok := e.orders[orderID]; ok && o.Symbol == symbol {
+		return *o, nil
+	}
+	if o, ok := e.history[orderID]; ok && o.Symbol == symbol {
+		return *o, nil
+	}
+	return api.Order{}, errUnknownOrder
+}
+
+// CancelOrder cancels an open order and unlocks its balance
+func (e *Exchange) CancelOrder(symbol string, orderID int) (api.Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	o, ok := e.orders[orderID]
+	if !ok || o.Symbol != symbol {
+		return api.Order{}, errUnknownOrder
+	}
+	base, quote, _ := api.SplitSymbol(symbol)
+	price, _ := strconv.ParseFloat(o.Price, 64)
+	origQty, _ := strconv.ParseFloat(o.OrigQty, 64)
+	if o.Side == api.SideBuy {
+		e.locked[quote] -= origQty * price
+		e.free[quote] += origQty * price
+	} else {
+		e.locked[base] -= origQty
+		e.free[base] += origQty
+	}
+	e.close(o, api.OrderStatusCanceled)
+	return *o, nil
+}
+
+// fill fills an order at the price as the maker or the taker, charging the
+// commission in BNB at the discount if the BNB balance covers it and on the
+// asset received otherwise
+//
+// NOTE: the caller must hold the lock
+func (e *Exchange) fill(o *api.Order, price float64, maker bool) {
+	commission := e.config.TakerCommission
+	if maker {
+		commission = e.config.MakerCommission
+	}
+	base, quote, _ := api.SplitSymbol(o.Symbol)
+	limit, _ := strconv.ParseFloat(o.Price, 64)
+	qty, _ := strconv.ParseFloat(o.OrigQty, 64)
+	fee := float64(commission) / commissionDivisor
+
+	// The asset received and the amount of it the fee is charged on
+	received, amount := base, qty
+	if o.Side == api.SideBuy {
+		// The quote locked at the limit price is released and the buy is
+		// paid for at the fill price
+		e.locked[quote] -= qty * limit
+		e.free[quote] += qty*limit - qty*price
+	} else {
+		e.locked[base] -= qty
+		received, amount = quote, qty*price
+	}
+	commissionAsset, commissionQty := received, amount*fee
+	if bnbFee, ok := e.bnbFee(quote, qty*price*fee); ok && fee > 0 {
+		commissionAsset, commissionQty = "BNB", bnbFee
+	}
+	e.free[received] += amount
+	e.free[commissionAsset] -= commissionQty
+
+	e.nextTradeID++
+	e.trades[o.OrderID] = append(e.trades[o.OrderID], api.Trade{
+		Symbol:          o.Symbol,
+		ID:              e.nextTradeID,
+		OrderID:         o.OrderID,
+		Price:           formatFloat(price),
+		Qty:             formatFloat(qty),
+		QuoteQty:        formatFloat(qty * price),
+		Commission:      formatFloat(commissionQty),
+		CommissionAsset: commissionAsset,
+		Time:            time.Now().UnixNano() / int64(time.Millisecond),
+		IsBuyer:         o.Side == api.SideBuy,
+		IsMaker:         maker,
+	})
+
+	o.ExecutedQty = o.OrigQty
+	o.CummulativeQuoteQty = formatFloat(qty * price)
+	e.close(o, api.OrderStatusFilled)
+}
+
+// bnbFee returns the fee in BNB for a fee valued in the quote asset if fees
+// are paid in BNB and the free BNB balance covers it
+//
+// NOTE: the caller must hold the lock
+func (e *Exchange) bnbFee(quote string, fee float64) (float64, bool) {
+	if e.config.BNBDiscount <= 0 {
+		return 0, false
+	}
+	snapshot := api.TickerSnapshot{Prices: e.prices}
+	rate, ok := snapshot.Rate(quote, "BNB")
+	if !ok {
+		return 0, false
+	}
+	bnbFee := fee * rate * (1 - e.config.BNBDiscount)
+	return bnbFee, e.free["BNB"] >= bnbFee
+}
+
+// GetOrderTrades returns the fills of an order with the commission paid on
+// each
+func (e *Exchange) GetOrderTrades(symbol string, orderID int) ([]api.Trade, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var trades []api.Trade
+	for _, trade := range e.trades[orderID] {
+		if trade.Symbol == symbol {
+			trades = append(trades, trade)
+		}
+	}
+	return trades, nil
+}
+
+// close moves an order from the book to the history
+//
+// NOTE: the caller must hold the lock
+func (e *Exchange) close(o *api.Order, status api.OrderStatus) {
+	o.Status = status
+	o.IsWorking = false
+	o.UpdateTime = time.Now().UnixNano() / int64(time.Millisecond)
+	delete(e.orders, o.OrderID)
+	e.history[o.OrderID] = o
+}
+
+// result converts an order into the response of an order submission
+func result(o *api.Order) api.Result {
+	return api.Result{
+		OrderRespHeader: api.OrderRespHeader{
+			Symbol:          o.Symbol,
+			OrderID:         o.OrderID,
+			ClientOrderID:   o.ClientOrderID,
+			TransactionTime: o.UpdateTime,
+		},
+		Price:               o.Price,
+		OrigQty:             o.OrigQty,
+		ExecutedQty:         o.ExecutedQty,
+		CummulativeQuoteQty: o.CummulativeQuoteQty,
+		Status:              o.Status,
+		TimeInForce:         o.TimeInForce,
+		Type:                o.Type,
+		Side:                o.Side,
+	}
+}
+
+// formatFloat formats a float the way the exchange returns decimals
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// StaticPrices is a PriceSource that serves prices set by the caller. It is
+// used to run the paper exchange on replayed prices
+type StaticPrices struct {
+	prices map[string]float64
+	mu     sync.Mutex
+}
+
+// NewStaticPrices returns a new StaticPrices
+func NewStaticPrices() *StaticPrices {
+	return &StaticPrices{prices: make(map[string]float64)}
+}
+
+// Set sets the price of a symbol
+func (sp *StaticPrices) Set(symbol string, price float64) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.prices[symbol] = price
+}
+
+// GetCoinPrice implements the PriceSource interface
+func (sp *StaticPrices) GetCoinPrice(symbol string) (api.TickerPrice, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	price, ok := sp.prices[symbol]
+	if !ok {
+		return api.TickerPrice{}, errNoPrice
+	}
+	return api.TickerPrice{Symbol: symbol, Price: formatFloat(price)}, nil
+}
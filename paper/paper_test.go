@@ -0,0 +1,215 @@
+package paper
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+// balance returns the free and locked balance of an asset
+func balance(t *testing.T, e *Exchange, asset string) (free, locked float64) {
+	account, err := e.GetAccountInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range account.Balances {
+		if a.Asset == asset {
+			return parse(t, a.Free), parse(t, a.Locked)
+		}
+	}
+	return 0, 0
+}
+
+// TestPaperOrders tests that marketable orders fill immediately as the taker
+// and resting orders fill as the maker once the price crosses them
+func TestPaperOrders(t *testing.T) {
+	prices := NewStaticPrices()
+	e := New(prices, Config{
+		Balances:        map[string]float64{"USDT": 1000},
+		MakerCommission: 10,
+		TakerCommission: 20,
+	})
+	prices.Set(api.BTCUSDT, 100)
+	if _, err := e.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+
+	// A buy above the price fills at the price as the taker
+	result, err := e.PostNewLimitOrder(api.BTCUSDT, api.SideBuy, 2, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qty, price, err := result.Executed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != api.OrderStatusFilled || qty != 2 || price != 100 {
+		t.Fatal("unexpected taker fill", result)
+	}
+	if free, _ := balance(t, e, "USDT"); free != 800 {
+		t.Fatal("expected 800 USDT, got", free)
+	}
+	if free, _ := balance(t, e, "BTC"); math.Abs(free-2*0.998) > 1e-12 {
+		t.Fatal("expected taker fee taken from BTC, got", free)
+	}
+
+	// A sell above the price rests on the book with the BTC locked
+	result, err = e.PostNewLimitOrder(api.BTCUSDT, api.SideSell, 1, 110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != api.OrderStatusNew {
+		t.Fatal("expected resting order", result)
+	}
+	if _, locked := balance(t, e, "BTC"); locked != 1 {
+		t.Fatal("expected 1 BTC locked, got", locked)
+	}
+	if _, err := e.PostNewLimitOrder(api.BTCUSDT, api.SideSell, 1, 110); err != errInsufficientBalance {
+		t.Fatal("expected errInsufficientBalance, got", err)
+	}
+
+	// The sell fills at its limit price as the maker once the price crosses
+	prices.Set(api.BTCUSDT, 111)
+	if _, err := e.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+	order, err := e.GetOrder(api.BTCUSDT, result.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != api.OrderStatusFilled {
+		t.Fatal("expected resting order to fill", order)
+	}
+	if free, _ := balance(t, e, "USDT"); math.Abs(free-(800+110*0.999)) > 1e-9 {
+		t.Fatal("unexpected USDT after maker fill", free)
+	}
+	if orders, _ := e.GetOpenOrders(); len(orders) != 0 {
+		t.Fatal("expected no open orders", orders)
+	}
+
+	// Canceling a resting order unlocks the balance
+	result, err = e.PostNewLimitOrder(api.BTCUSDT, api.SideBuy, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CancelOrder(api.BTCUSDT, result.OrderID); err != nil {
+		t.Fatal(err)
+	}
+	if free, locked := balance(t, e, "USDT"); math.Abs(free-(800+110*0.999)) > 1e-9 || locked != 0 {
+		t.Fatal("expected balance unlocked after cancel", free, locked)
+	}
+}
+
+// TestPaperStopOrders tests that stop orders rest off the book until the price
+// reaches their stop price
+func TestPaperStopOrders(t *testing.T) {
+	prices := NewStaticPrices()
+	e := New(prices, Config{Balances: map[string]float64{"BTC": 2}})
+	update := func(price float64) {
+		prices.Set(api.BTCUSDT, price)
+		if _, err := e.GetCoinPrice(api.BTCUSDT); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update(100)
+
+	// Stops that would trigger immediately are rejected
+	if _, err := e.PostNewStopLimitOrder(api.BTCUSDT, api.SideSell, api.OrderTypeStopLossLimit, 1, 99, 101); err != errWouldTrigger {
+		t.Fatal("expected errWouldTrigger, got", err)
+	}
+	if _, err := e.PostNewStopLimitOrder(api.BTCUSDT, api.SideSell, api.OrderTypeLimit, 1, 99, 98); err == nil {
+		t.Fatal("expected error for limit order type")
+	}
+
+	stopLoss, err := e.PostNewStopLimitOrder(api.BTCUSDT, api.SideSell, api.OrderTypeStopLossLimit, 1, 89, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	takeProfit, err := e.PostNewStopLimitOrder(api.BTCUSDT, api.SideSell, api.OrderTypeTakeProfitLimit, 1, 119, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free, locked := balance(t, e, "BTC"); free != 0 || locked != 2 {
+		t.Fatal("expected the BTC to be locked", free, locked)
+	}
+
+	// A price between the limit and the stop of the take profit does not
+	// trigger it
+	update(119.5)
+	if order, _ := e.GetOrder(api.BTCUSDT, takeProfit.OrderID); order.Status != api.OrderStatusNew || order.IsWorking {
+		t.Fatal("take profit should not be triggered", order)
+	}
+
+	// The stop loss triggers and fills at the price
+	update(89.5)
+	order, err := e.GetOrder(api.BTCUSDT, stopLoss.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != api.OrderStatusFilled || order.CummulativeQuoteQty != "89.5" {
+		t.Fatal("expected stop loss to fill at the price", order)
+	}
+
+	// The take profit triggers and fills once the price reaches it
+	update(121)
+	if order, _ := e.GetOrder(api.BTCUSDT, takeProfit.OrderID); order.Status != api.OrderStatusFilled {
+		t.Fatal("expected take profit to fill", order)
+	}
+}
+
+// parse parses a balance
+func parse(t *testing.T, s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// TestPaperBNBFees tests that fees are paid in BNB at the discount while the
+// BNB balance covers them and are recorded on the trades
+func TestPaperBNBFees(t *testing.T) {
+	prices := NewStaticPrices()
+	e := New(prices, Config{
+		Balances:        map[string]float64{"USDT": 1000, "BNB": 0.01},
+		TakerCommission: 10,
+		BNBDiscount:     0.25,
+	})
+	for symbol, price := range map[string]float64{api.BTCUSDT: 100, api.BNBUSDT: 10} {
+		prices.Set(symbol, price)
+		if _, err := e.GetCoinPrice(symbol); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The 0.1 USDT fee is paid with 0.0075 BNB
+	result, err := e.PostNewLimitOrder(api.BTCUSDT, api.SideBuy, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free, _ := balance(t, e, "BTC"); free != 1 {
+		t.Fatal("expected the full BTC to be received, got", free)
+	}
+	trades, err := e.GetOrderTrades(api.BTCUSDT, result.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1 || trades[0].CommissionAsset != "BNB" || math.Abs(parse(t, trades[0].Commission)-0.0075) > 1e-12 || trades[0].IsMaker {
+		t.Fatal("unexpected trades", trades)
+	}
+
+	// Once the BNB runs out the fee is taken from the asset received
+	result, err = e.PostNewLimitOrder(api.BTCUSDT, api.SideSell, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trades, _ = e.GetOrderTrades(api.BTCUSDT, result.OrderID)
+	if len(trades) != 1 || trades[0].CommissionAsset != "USDT" || math.Abs(parse(t, trades[0].Commission)-0.1) > 1e-12 {
+		t.Fatal("unexpected trades", trades)
+	}
+	if free, _ := balance(t, e, "USDT"); math.Abs(free-999.9) > 1e-9 {
+		t.Fatal("expected the fee taken from the USDT, got", free)
+	}
+}
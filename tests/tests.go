@@ -4,7 +4,3 @@ package tests
 // the trader bot. Tests contained in this package should cover large portion or
 // functions of the trader while smaller unit tests should be written in a
 // corresponding <file>_test.go
-
-// TESTS TO WRITE
-//
-// 1) Test order heap
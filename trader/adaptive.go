@@ -0,0 +1,277 @@
+package trader
+
+// this file contains the adaptive threshold controller. Instead of a constant
+// diff limit the buy and sell thresholds of each pair are widened or narrowed
+// based on how often the pair trades, the profit realized per round trip and
+// the recent volatility of the price. Every adjustment is logged with the
+// reason it was made.
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultAdaptiveConfig is the default configuration of the adaptive
+// threshold controller, it is disabled by default
+var DefaultAdaptiveConfig = AdaptiveConfig{
+	Initial:          diffLimit,
+	MinDiff:          diffLimit,
+	MaxDiff:          0.05,
+	Window:           24 * time.Hour,
+	Interval:         time.Hour,
+	TargetRoundTrips: 6,
+	Step:             0.2,
+	MinProfit:        0.002,
+	VolatilityFactor: 0.1,
+}
+
+// AdaptiveConfig is the configuration of the adaptive threshold controller
+type AdaptiveConfig struct {
+	Enabled bool
+
+	// Initial is the threshold a pair starts with and MinDiff and MaxDiff
+	// are the bounds the thresholds are kept within
+	Initial float64
+	MinDiff float64
+	MaxDiff float64
+
+	// Window is how far back the trades and prices are looked at and
+	// Interval is how often the thresholds are adjusted
+	Window   time.Duration
+	Interval time.Duration
+
+	// TargetRoundTrips is the number of trades per window the controller
+	// aims for. The thresholds are widened when there are more than twice as
+	// many and narrowed when there are less than half as many
+	TargetRoundTrips int
+
+	// Step is the fraction the thresholds are moved by in an adjustment
+	Step float64
+
+	// MinProfit is the profit per round trip, as a fraction, below which the
+	// sell threshold is widened
+	MinProfit float64
+
+	// VolatilityFactor keeps the thresholds at least this multiple of the
+	// volatility of the price over the window so noise does not trigger
+	// trades
+	VolatilityFactor float64
+}
+
+// Thresholds are the fractions the price has to move for a buy or a sell of a
+// pair
+type Thresholds struct {
+	Buy  float64 `json:"buy"`
+	Sell float64 `json:"sell"`
+}
+
+type (
+	// adaptive is the state of the adaptive threshold controller of a pair
+	adaptive struct {
+		thresholds Thresholds
+		lastAdjust time.Time
+
+		trades     []time.Time
+		roundTrips []roundTrip
+		prices     []pricePoint
+	}
+
+	// roundTrip is the profit realized by selling a lot
+	roundTrip struct {
+		time   time.Time
+		profit float64
+	}
+
+	// pricePoint is a price of a pair at a point in time
+	pricePoint struct {
+		time  time.Time
+		price float64
+	}
+)
+
+// SetAdaptiveConfig sets the configuration of the adaptive threshold
+// controller
+func (t *Trader) SetAdaptiveConfig(config AdaptiveConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.adaptiveConfig = config
+}
+
+// Thresholds returns the adapted thresholds of a pair, they are zero if the
+// controller is disabled or has not seen a price of the pair
+func (t *Trader) Thresholds(symbol string) Thresholds {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.thresholds(t.pair(symbol))
+}
+
+// thresholds returns the adapted thresholds of a pair for the strategy
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) thresholds(p *Pair) Thresholds {
+	if !t.adaptiveConfig.Enabled {
+		return Thresholds{}
+	}
+	return p.adaptive.thresholds
+}
+
+// observePrice records a price of the pair and adjusts the thresholds if it is
+// time to
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) observePrice(p *Pair, now time.Time, price float64) {
+	config := t.adaptiveConfig
+	if !config.Enabled {
+		return
+	}
+	a := &p.adaptive
+	a.prices = append(a.prices, pricePoint{time: now, price: price})
+	if a.thresholds == (Thresholds{}) {
+		a.thresholds = Thresholds{Buy: config.Initial, Sell: config.Initial}
+	}
+	if a.lastAdjust.IsZero() {
+		a.lastAdjust = now
+		return
+	}
+	if now.Sub(a.lastAdjust) >= config.Interval {
+		t.adjust(p, now)
+	}
+}
+
+// observeFill records a fill of the pair at the time of the last price seen.
+// Sells of a lot are recorded as a round trip with the profit realized
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) observeFill(p *Pair, lotPrice, price float64) {
+	if !t.adaptiveConfig.Enabled {
+		return
+	}
+	a := &p.adaptive
+	now := time.Now()
+	if len(a.prices) > 0 {
+		now = a.prices[len(a.prices)-1].time
+	}
+	a.trades = append(a.trades, now)
+	if lotPrice > 0 {
+		a.roundTrips = append(a.roundTrips, roundTrip{time: now, profit: (price - lotPrice) / lotPrice})
+	}
+}
+
+// adjust widens or narrows the thresholds of the pair based on the trades and
+// prices in the window
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) adjust(p *Pair, now time.Time) {
+	config := t.adaptiveConfig
+	a := &p.adaptive
+	a.lastAdjust = now
+	a.prune(now.Add(-config.Window))
+
+	before := a.thresholds
+	var reasons []string
+	widen := 1 + config.Step
+	narrow := 1 - config.Step
+
+	// Trade frequency
+	trades := len(a.trades)
+	var profit float64
+	for _, rt := range a.roundTrips {
+		profit += rt.profit
+	}
+	if len(a.roundTrips) > 0 {
+		profit /= float64(len(a.roundTrips))
+	}
+	profitable := len(a.roundTrips) == 0 || profit >= config.MinProfit
+	switch {
+	case trades > 2*config.TargetRoundTrips:
+		a.thresholds.Buy *= widen
+		a.thresholds.Sell *= widen
+		reasons = append(reasons, fmt.Sprintf("%v trades in the window, more than twice the target of %v", trades, config.TargetRoundTrips))
+	case 2*trades < config.TargetRoundTrips && profitable:
+		a.thresholds.Buy *= narrow
+		a.thresholds.Sell *= narrow
+		reasons = append(reasons, fmt.Sprintf("%v trades in the window, less than half the target of %v", trades, config.TargetRoundTrips))
+	}
+
+	// Realized profit per round trip
+	if !profitable {
+		a.thresholds.Sell *= widen
+		reasons = append(reasons, fmt.Sprintf("profit per round trip of %v below the minimum of %v", formatPercent(profit), formatPercent(config.MinProfit)))
+	}
+
+	// Volatility
+	floor := config.VolatilityFactor * a.volatility()
+	if a.thresholds.Buy < floor {
+		a.thresholds.Buy = floor
+		reasons = append(reasons, fmt.Sprintf("buy threshold raised to %v times the volatility", config.VolatilityFactor))
+	}
+	if a.thresholds.Sell < floor {
+		a.thresholds.Sell = floor
+		reasons = append(reasons, fmt.Sprintf("sell threshold raised to %v times the volatility", config.VolatilityFactor))
+	}
+
+	// Bounds
+	a.thresholds.Buy = math.Max(config.MinDiff, math.Min(config.MaxDiff, a.thresholds.Buy))
+	a.thresholds.Sell = math.Max(config.MinDiff, math.Min(config.MaxDiff, a.thresholds.Sell))
+
+	if a.thresholds == before {
+		return
+	}
+	t.log.WithFields(logrus.Fields{
+		"symbol":     p.Symbol,
+		"buyBefore":  before.Buy,
+		"buyAfter":   a.thresholds.Buy,
+		"sellBefore": before.Sell,
+		"sellAfter":  a.thresholds.Sell,
+		"trades":     trades,
+		"roundTrips": len(a.roundTrips),
+		"profit":     profit,
+		"volatility": a.volatility(),
+	}).Infof("%v thresholds adjusted: %v", p.Symbol, strings.Join(reasons, "; "))
+	t.persistState()
+}
+
+// prune drops the trades and prices from before the cutoff
+func (a *adaptive) prune(cutoff time.Time) {
+	i := 0
+	for i < len(a.trades) && a.trades[i].Before(cutoff) {
+		i++
+	}
+	a.trades = a.trades[i:]
+	i = 0
+	for i < len(a.roundTrips) && a.roundTrips[i].time.Before(cutoff) {
+		i++
+	}
+	a.roundTrips = a.roundTrips[i:]
+	i = 0
+	for i < len(a.prices) && a.prices[i].time.Before(cutoff) {
+		i++
+	}
+	a.prices = a.prices[i:]
+}
+
+// volatility returns the standard deviation of the prices in the window as a
+// fraction of their mean
+func (a *adaptive) volatility() float64 {
+	if len(a.prices) < 2 {
+		return 0
+	}
+	var mean float64
+	for _, pp := range a.prices {
+		mean += pp.price
+	}
+	mean /= float64(len(a.prices))
+	var variance float64
+	for _, pp := range a.prices {
+		variance += (pp.price - mean) * (pp.price - mean)
+	}
+	variance /= float64(len(a.prices))
+	if mean == 0 {
+		return 0
+	}
+	return math.Sqrt(variance) / mean
+}
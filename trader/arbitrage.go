@@ -0,0 +1,683 @@
+package trader
+
+// this file contains the triangular arbitrage detector. A triangle is three
+// symbols between three assets, trading the start asset around the loop in
+// either direction ends in the start asset again. Every step the detector
+// fetches the book tickers, trades the configured amount around each loop at
+// the best bid and ask with the taker fee and the lot size rounding of each
+// leg, and logs the loops that return more than the minimum edge. With
+// execution enabled the best loop is traded one leg at a time, each leg
+// waiting for its fill. When a leg fails or only partly fills the assets held
+// are traded back to the start asset so the account isn't left holding the
+// middle of a loop.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+const (
+	// arbitrageTag is the tag of the arbitrage orders
+	arbitrageTag = "arbitrage"
+
+	// arbitragePollInterval is how often a leg is polled while waiting for
+	// its fill
+	arbitragePollInterval = 250 * time.Millisecond
+)
+
+// DefaultTriangle is the triangle of the BNB, BTC and USDT markets, it is
+// always watched
+var DefaultTriangle = Triangle{
+	Start:   "USDT",
+	Symbols: []string{api.BNBBTC, api.BNBUSDT, api.BTCUSDT},
+}
+
+// DefaultArbitrageConfig is the default configuration of the arbitrage
+// detector, it is disabled by default
+var DefaultArbitrageConfig = ArbitrageConfig{
+	Amount:     20,
+	MinEdge:    0.001,
+	LegTimeout: 5 * time.Second,
+}
+
+// ArbitrageConfig is the configuration of the arbitrage detector
+type ArbitrageConfig struct {
+	Enabled bool
+
+	// Triangles are watched next to the DefaultTriangle
+	Triangles []Triangle
+
+	// Amount is how much of the start asset is traded around a loop, it is
+	// limited by the depth of the book
+	Amount float64
+
+	// MinEdge is the least return of a loop after fees and rounding as a
+	// fraction of the amount
+	MinEdge float64
+
+	// Execute trades the best loop instead of only logging it, LegTimeout
+	// is how long a leg can take to fill before it is canceled
+	Execute    bool
+	LegTimeout time.Duration
+}
+
+// Triangle is three symbols that form a loop between three assets, the loop
+// starts and ends in Start
+type Triangle struct {
+	Start   string
+	Symbols []string
+}
+
+// ArbitrageLeg is an order of a loop
+type ArbitrageLeg struct {
+	Symbol   string
+	Side     api.Side
+	Price    float64
+	Quantity float64
+
+	// From is the asset sold and To the asset bought
+	From string
+	To   string
+
+	// depth is the quantity at the price on the book, 0 if unknown
+	depth float64
+}
+
+// Opportunity is a loop that returns more of the start asset than it trades
+type Opportunity struct {
+	Time     time.Time
+	Triangle Triangle
+	Legs     []ArbitrageLeg
+
+	// Amount is the start asset sold by the first leg and Return the start
+	// asset bought by the last leg after the fees
+	Amount float64
+	Return float64
+	Edge   float64
+}
+
+// ArbitrageResult is the outcome of trading a loop
+type ArbitrageResult struct {
+	Opportunity Opportunity
+
+	// Filled are the legs that filled with the quantity and average price
+	// they executed at
+	Filled []ArbitrageLeg
+
+	// Spent is the start asset sold and Returned is the start asset bought
+	// back, including what was unwound
+	Spent    float64
+	Returned float64
+
+	// Fees are the commissions of the legs valued in the start asset
+	Fees float64
+
+	// Unwound is set when a leg failed and the assets held were traded back
+	// to the start asset
+	Unwound bool
+	Err     error
+
+	// taken is the part of the fees taken from the assets received, it is
+	// already out of Returned
+	taken float64
+}
+
+// ArbitrageStats are the counts of the loops seen and traded
+type ArbitrageStats struct {
+	Scans         int
+	Opportunities int
+	Executed      int
+	Failed        int
+
+	// Profit is the profit of the traded loops keyed by start asset
+	Profit map[string]float64
+}
+
+// bookSource is implemented by exchanges that provide the best bid and ask of
+// every symbol
+type bookSource interface {
+	GetAllBookTickers() ([]api.BookTicker, error)
+}
+
+// Validate returns an error if the symbols don't form a loop through the start
+// asset
+func (tr Triangle) Validate() error {
+	if len(tr.Symbols) != 3 {
+		return fmt.Errorf("triangle must have 3 symbols, got %v", tr.Symbols)
+	}
+	count := make(map[string]int)
+	for _, symbol := range tr.Symbols {
+		base, quote, ok := api.SplitSymbol(symbol)
+		if !ok {
+			return fmt.Errorf("unknown triangle symbol %q", symbol)
+		}
+		count[base]++
+		count[quote]++
+	}
+	if len(count) != 3 {
+		return fmt.Errorf("triangle %v doesn't form a loop between 3 assets", tr.Symbols)
+	}
+	for asset, n := range count {
+		if n != 2 {
+			return fmt.Errorf("triangle %v doesn't form a loop, %v is in %v symbols", tr.Symbols, asset, n)
+		}
+	}
+	if count[tr.Start] == 0 {
+		return fmt.Errorf("triangle %v doesn't trade the start asset %q", tr.Symbols, tr.Start)
+	}
+	return nil
+}
+
+// loops returns the assets of the loops in both directions, starting and
+// ending in the start asset
+func (tr Triangle) loops() [][]string {
+	var others []string
+	for _, symbol := range tr.Symbols {
+		base, quote, _ := api.SplitSymbol(symbol)
+		for _, asset := range []string{base, quote} {
+			if asset != tr.Start && !containsString(others, asset) {
+				others = append(others, asset)
+			}
+		}
+	}
+	if len(others) != 2 {
+		return nil
+	}
+	return [][]string{
+		{tr.Start, others[0], others[1], tr.Start},
+		{tr.Start, others[1], others[0], tr.Start},
+	}
+}
+
+// market returns the symbol between two assets of the triangle and the side
+// that sells from for to
+func (tr Triangle) market(from, to string) (string, api.Side, bool) {
+	for _, symbol := range tr.Symbols {
+		base, quote, _ := api.SplitSymbol(symbol)
+		if base == from && quote == to {
+			return symbol, api.SideSell, true
+		}
+		if base == to && quote == from {
+			return symbol, api.SideBuy, true
+		}
+	}
+	return "", "", false
+}
+
+// Validate returns an error if a triangle is invalid or the amounts can't be
+// traded
+func (c ArbitrageConfig) Validate() error {
+	for _, tr := range c.Triangles {
+		if err := tr.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Amount <= 0 {
+		return fmt.Errorf("arbitrage amount must be positive, got %v", c.Amount)
+	}
+	if c.MinEdge < 0 {
+		return fmt.Errorf("arbitrage min edge can't be negative, got %v", c.MinEdge)
+	}
+	if c.Execute && c.LegTimeout <= 0 {
+		return fmt.Errorf("arbitrage leg timeout must be positive, got %v", c.LegTimeout)
+	}
+	return nil
+}
+
+// triangles returns the triangles that are watched
+func (c ArbitrageConfig) triangles() []Triangle {
+	return append([]Triangle{DefaultTriangle}, c.Triangles...)
+}
+
+// Profit returns the start asset the loop returns over the amount
+func (o Opportunity) Profit() float64 {
+	return o.Return - o.Amount
+}
+
+// Route returns the assets of the loop, ie USDT>BTC>BNB>USDT
+func (o Opportunity) Route() string {
+	if len(o.Legs) == 0 {
+		return ""
+	}
+	assets := []string{o.Legs[0].From}
+	for _, leg := range o.Legs {
+		assets = append(assets, leg.To)
+	}
+	return strings.Join(assets, ">")
+}
+
+// Profit returns the start asset returned over the start asset spent
+func (r ArbitrageResult) Profit() float64 {
+	return r.Returned - r.Spent
+}
+
+// SetArbitrageConfig sets the configuration of the arbitrage detector
+func (t *Trader) SetArbitrageConfig(config ArbitrageConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.arbitrageConfig = config
+}
+
+// ArbitrageStats returns the counts of the loops seen and traded
+func (t *Trader) ArbitrageStats() ArbitrageStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := t.arbitrage
+	stats.Profit = make(map[string]float64, len(t.arbitrage.Profit))
+	for asset, profit := range t.arbitrage.Profit {
+		stats.Profit[asset] = profit
+	}
+	return stats
+}
+
+// FindArbitrage returns the loops of the triangles that return at least the
+// minimum edge at the books of the snapshot, best first
+func (t *Trader) FindArbitrage(snapshot api.TickerSnapshot) []Opportunity {
+	t.mu.Lock()
+	config := t.arbitrageConfig
+	fee := t.fees().Taker
+	rules := make(map[string]api.SymbolRules, len(t.rules))
+	for symbol, r := range t.rules {
+		rules[symbol] = r
+	}
+	t.mu.Unlock()
+
+	var opportunities []Opportunity
+	for _, tr := range config.triangles() {
+		if tr.Validate() != nil {
+			continue
+		}
+		for _, loop := range tr.loops() {
+			o, ok := evaluateLoop(tr, loop, snapshot, rules, fee, config.Amount)
+			if ok && o.Edge >= config.MinEdge {
+				opportunities = append(opportunities, o)
+			}
+		}
+	}
+	sort.SliceStable(opportunities, func(i, j int) bool { return opportunities[i].Edge > opportunities[j].Edge })
+	return opportunities
+}
+
+// evaluateLoop trades the amount around the loop at the best bid and ask. The
+// amount is scaled down to what the top of the book can fill
+func evaluateLoop(tr Triangle, loop []string, snapshot api.TickerSnapshot, rules map[string]api.SymbolRules, fee, amount float64) (Opportunity, bool) {
+	for try := 0; try < 3; try++ {
+		o, ok := simulateLoop(tr, loop, snapshot, rules, fee, amount)
+		if !ok {
+			return Opportunity{}, false
+		}
+		scale := 1.0
+		for _, leg := range o.Legs {
+			if leg.depth > 0 && leg.Quantity > leg.depth && leg.depth/leg.Quantity < scale {
+				scale = leg.depth / leg.Quantity
+			}
+		}
+		if scale == 1 {
+			return o, true
+		}
+		amount *= scale
+	}
+	return Opportunity{}, false
+}
+
+// simulateLoop trades the amount around the loop, rounding each leg down to
+// the lot size and paying the fee on the asset bought
+func simulateLoop(tr Triangle, loop []string, snapshot api.TickerSnapshot, rules map[string]api.SymbolRules, fee, amount float64) (Opportunity, bool) {
+	o := Opportunity{Time: snapshot.Time, Triangle: tr}
+	held := amount
+	for i := 0; i+1 < len(loop); i++ {
+		symbol, side, ok := tr.market(loop[i], loop[i+1])
+		if !ok {
+			return Opportunity{}, false
+		}
+		book, ok := snapshot.Book(symbol)
+		if !ok || book.BidPrice <= 0 || book.AskPrice <= 0 {
+			return Opportunity{}, false
+		}
+		leg := ArbitrageLeg{Symbol: symbol, Side: side, From: loop[i], To: loop[i+1]}
+		r := rules[symbol]
+		spent := 0.0
+		if side == api.SideBuy {
+			leg.Price, leg.depth = book.AskPrice, book.AskQty
+			leg.Quantity = r.RoundQuantity(held / leg.Price)
+			spent = leg.Quantity * leg.Price
+			held = leg.Quantity * (1 - fee)
+		} else {
+			leg.Price, leg.depth = book.BidPrice, book.BidQty
+			leg.Quantity = r.RoundQuantity(held)
+			spent = leg.Quantity
+			held = leg.Quantity * leg.Price * (1 - fee)
+		}
+		if !r.Valid(leg.Quantity, leg.Price) {
+			return Opportunity{}, false
+		}
+		if i == 0 {
+			o.Amount = spent
+		}
+		o.Legs = append(o.Legs, leg)
+	}
+	o.Return = held
+	o.Edge = o.Return/o.Amount - 1
+	return o, true
+}
+
+// scanArbitrage looks for arbitrage in the book tickers of the exchange if it
+// provides them, logs the opportunities and trades the best one if execution
+// is enabled. Errors are logged, trading carries on
+func (t *Trader) scanArbitrage(ex Exchange, now time.Time) {
+	t.mu.Lock()
+	config := t.arbitrageConfig
+	t.mu.Unlock()
+	if !config.Enabled {
+		return
+	}
+	source, ok := ex.(bookSource)
+	if !ok {
+		return
+	}
+	books, err := source.GetAllBookTickers()
+	if err != nil {
+		t.log.Warn("WARN: unable to get the book tickers for arbitrage: ", err)
+		return
+	}
+	snapshot, err := api.NewTickerSnapshot(now, nil, books)
+	if err != nil {
+		t.log.Warn("WARN: unable to parse the book tickers for arbitrage: ", err)
+		return
+	}
+	opportunities := t.FindArbitrage(snapshot)
+	t.mu.Lock()
+	t.arbitrage.Scans++
+	t.arbitrage.Opportunities += len(opportunities)
+	t.mu.Unlock()
+	for _, o := range opportunities {
+		t.log.WithField("edge", o.Edge).Infof("***arbitrage*** %v trading %v %v returns %v, expected profit %v %v",
+			o.Route(), o.Amount, o.Triangle.Start, o.Return, o.Profit(), o.Triangle.Start)
+	}
+	if !config.Execute || len(opportunities) == 0 {
+		return
+	}
+	best := opportunities[0]
+	t.mu.Lock()
+	available := t.balances[best.Triangle.Start] - t.minBalances[best.Triangle.Start]
+	t.mu.Unlock()
+	if available < best.Amount {
+		t.log.Infof("arbitrage %v not traded, %v %v available", best.Route(), available, best.Triangle.Start)
+		return
+	}
+	t.ExecuteArbitrage(ex, best, config.LegTimeout)
+}
+
+// ExecuteArbitrage trades the legs of the loop one at a time, waiting up to the
+// timeout for each to fill. Nothing is traded unless the whole loop is within
+// the risk limits. When a leg fails or partly fills the loop stops and the
+// assets held are traded back to the start asset, even while halted. The
+// fills and the profit of the loop are recorded like any other trade
+func (t *Trader) ExecuteArbitrage(ex Exchange, o Opportunity, timeout time.Duration) ArbitrageResult {
+	started := time.Now()
+	t.mu.Lock()
+	fee := t.fees().Taker
+	t.mu.Unlock()
+	start := o.Triangle.Start
+	r := ArbitrageResult{Opportunity: o}
+
+	// The whole loop is checked against the limits so it isn't stopped part
+	// way by them
+	var intents []OrderIntent
+	for _, leg := range o.Legs {
+		intents = append(intents, leg.intent())
+	}
+	if err := t.checkIntents(time.Now(), intents); err != nil {
+		r.Err = err
+	}
+
+	// held are the assets of the loop other than the start asset
+	held := make(map[string]float64)
+	for i, leg := range o.Legs {
+		if r.Err != nil {
+			break
+		}
+		input := o.Amount
+		if i > 0 {
+			input = held[leg.From]
+		}
+		leg.Quantity = input
+		if leg.Side == api.SideBuy {
+			leg.Quantity = input / leg.Price
+		}
+		leg.Quantity = t.rulesFor(leg.Symbol).RoundQuantity(leg.Quantity)
+		if !t.rulesFor(leg.Symbol).Valid(leg.Quantity, leg.Price) {
+			r.Err = fmt.Errorf("%v leg of %v is below the lot size", leg.Symbol, leg.Quantity)
+			break
+		}
+		qty, price, received, err := t.placeLeg(ex, &r, leg, fee, timeout)
+		if qty > 0 {
+			used := qty
+			if leg.Side == api.SideBuy {
+				used = qty * price
+			}
+			if leg.From == start {
+				r.Spent += used
+			} else {
+				held[leg.From] -= used
+			}
+			if leg.To == start {
+				r.Returned += received
+			} else {
+				held[leg.To] += received
+			}
+			filled := leg
+			filled.Quantity, filled.Price = qty, price
+			r.Filled = append(r.Filled, filled)
+		}
+		if err == nil && qty < leg.Quantity*(1-1e-9) {
+			err = fmt.Errorf("%v leg filled %v of %v", leg.Symbol, qty, leg.Quantity)
+		}
+		if err != nil {
+			r.Err = err
+			break
+		}
+	}
+
+	// Trade what is held back to the start asset, dust below the lot size is
+	// left
+	if r.Err != nil {
+		var assets []string
+		for asset := range held {
+			assets = append(assets, asset)
+		}
+		sort.Strings(assets)
+		for _, asset := range assets {
+			returned, ok := t.unwind(ex, &r, asset, held[asset], fee, timeout)
+			r.Returned += returned
+			r.Unwound = r.Unwound || ok
+		}
+	}
+
+	t.mu.Lock()
+	if r.Err != nil {
+		t.arbitrage.Failed++
+	} else {
+		t.arbitrage.Executed++
+	}
+	if t.arbitrage.Profit == nil {
+		t.arbitrage.Profit = make(map[string]float64)
+	}
+	t.arbitrage.Profit[start] += r.Profit()
+	// The fees were recorded with the fills, the part taken from the assets
+	// received is added back so it isn't counted twice
+	if r.Spent > 0 {
+		now := time.Now()
+		t.recordProfit(now, start, r.Profit()+r.taken)
+		t.closeLoop(r, started, now)
+	}
+	t.mu.Unlock()
+	if r.Err != nil {
+		t.log.Warn("WARN: arbitrage "+o.Route()+" failed: ", r.Err)
+	}
+	if len(r.Filled) > 0 {
+		if account, err := ex.GetAccountInfo(); err != nil {
+			t.log.Warn("WARN: unable to update the balances after arbitrage: ", err)
+		} else if err := t.UpdateBalances(account); err != nil {
+			t.log.Warn("WARN: unable to update the balances after arbitrage: ", err)
+		}
+	}
+	t.log.Infof("arbitrage %v spent %v %v and returned %v, profit %v, unwound %v", o.Route(), r.Spent, start, r.Returned, r.Profit(), r.Unwound)
+	return r
+}
+
+// unwind trades the quantity of an asset back to the start asset of the
+// loop at the current book and returns the start asset received. It returns
+// false if no order was placed
+func (t *Trader) unwind(ex Exchange, r *ArbitrageResult, asset string, qty, fee float64, timeout time.Duration) (float64, bool) {
+	tr := r.Opportunity.Triangle
+	symbol, side, ok := tr.market(asset, tr.Start)
+	source, isSource := ex.(bookSource)
+	if !ok || !isSource || qty <= 0 {
+		return 0, false
+	}
+	books, err := source.GetAllBookTickers()
+	var snapshot api.TickerSnapshot
+	if err == nil {
+		snapshot, err = api.NewTickerSnapshot(time.Now(), nil, books)
+	}
+	if err != nil {
+		t.log.Warn("WARN: unable to get the book to unwind arbitrage: ", err)
+		return 0, false
+	}
+	book, _ := snapshot.Book(symbol)
+	leg := ArbitrageLeg{Symbol: symbol, Side: side, From: asset, To: tr.Start, Price: book.BidPrice, Quantity: qty}
+	if side == api.SideBuy {
+		leg.Price, leg.Quantity = book.AskPrice, qty/book.AskPrice
+	}
+	rules := t.rulesFor(symbol)
+	leg.Quantity = rules.RoundQuantity(leg.Quantity)
+	if leg.Price <= 0 || !rules.Valid(leg.Quantity, leg.Price) {
+		t.log.Infof("arbitrage left holding %v %v, below the lot size of %v", qty, asset, symbol)
+		return 0, false
+	}
+	_, _, received, err := t.placeLeg(ex, r, leg, fee, timeout)
+	if err != nil {
+		t.log.Warn("WARN: unable to unwind arbitrage "+asset+": ", err)
+	}
+	return received, true
+}
+
+// intent returns the order intent of the leg
+func (leg ArbitrageLeg) intent() OrderIntent {
+	return OrderIntent{
+		Symbol:   leg.Symbol,
+		Side:     leg.Side,
+		Quantity: leg.Quantity,
+		Price:    leg.Price,
+		NoLot:    true,
+		Tag:      arbitrageTag,
+		Reason:   fmt.Sprintf("arbitrage %v to %v", leg.From, leg.To),
+	}
+}
+
+// placeLeg places the order of a leg and waits up to the timeout for it to
+// fill, canceling it if it doesn't. It returns the quantity and average price
+// executed and the asset received after the commission. The fill is recorded
+// and its commission added to the fees of the result. The legs are not
+// checked against the limits, the loop is checked before its first leg and
+// unwinding has to go through while trading is halted
+func (t *Trader) placeLeg(ex Exchange, r *ArbitrageResult, leg ArbitrageLeg, fee float64, timeout time.Duration) (float64, float64, float64, error) {
+	intent := leg.intent()
+	result, err := t.post(ex, intent)
+	if err == nil && result.OrderID == 0 {
+		err = errNoOrderID
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	t.mu.Lock()
+	t.recordOrder(time.Now())
+	t.mu.Unlock()
+	t.log.WithFields(orderFields(intent)).WithField("orderID", result.OrderID).Info(intent.Reason)
+
+	status := result.Status
+	qty, price, err := result.Executed()
+	deadline := time.Now().Add(timeout)
+	for err == nil && !status.Final() && time.Now().Before(deadline) {
+		time.Sleep(arbitragePollInterval)
+		var o api.Order
+		if o, err = ex.GetOrder(leg.Symbol, result.OrderID); err == nil {
+			status = o.Status
+			qty, price, err = o.Executed()
+		}
+	}
+	if !status.Final() {
+		if o, cancelErr := ex.CancelOrder(leg.Symbol, result.OrderID); cancelErr == nil {
+			qty, price, err = o.Executed()
+		} else if err == nil {
+			err = cancelErr
+		}
+	}
+	if qty == 0 {
+		if err == nil {
+			err = fmt.Errorf("%v order %v %v", leg.Symbol, result.OrderID, status)
+		}
+		return 0, 0, 0, err
+	}
+
+	// The commission is taken from the asset received unless it was paid in
+	// BNB
+	received := qty
+	if leg.Side == api.SideSell {
+		received = qty * price
+	}
+	o := &OpenOrder{OrderID: result.OrderID, Intent: intent}
+	commissions := t.orderCommissions(ex, o)
+	if commissions != nil {
+		received -= commissions[leg.To]
+	} else {
+		received *= 1 - fee
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.persistState()
+	t.recordFill(intent, price, qty, commissions)
+
+	// The fees are valued through the quote asset like the fill valued them
+	// so the same amount is added back to the profit of the loop
+	start := r.Opportunity.Triangle.Start
+	quote := t.pair(leg.Symbol).Quote
+	snapshot := api.TickerSnapshot{Prices: t.prices}
+	toStart, _ := snapshot.Rate(quote, start)
+	for asset, paid := range commissions {
+		rate, ok := snapshot.Rate(asset, quote)
+		rate *= toStart
+		if !ok || toStart == 0 {
+			t.log.Warnf("WARN: no %v rate for %v, arbitrage fee not valued", start, asset)
+			continue
+		}
+		r.Fees += paid * rate
+		if asset == leg.To {
+			r.taken += paid * rate
+		}
+	}
+	return qty, price, received, err
+}
+
+// rulesFor returns the symbol rules of a symbol
+func (t *Trader) rulesFor(symbol string) api.SymbolRules {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rules[symbol]
+}
+
+// containsString returns true if the list contains the string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package trader
+
+// this file contains the rolling 24 hour statistics of each pair. The trader
+// keeps the open, high and low of the prices it has seen over the last 24
+// hours in one minute candles, which are passed to the strategies for their
+// indicators, and refreshes the statistics from the exchange
+// every hour so the range is known from the start and includes the volumes.
+
+import (
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/indicators"
+)
+
+const (
+	// dailyWindow is the length of the rolling statistics
+	dailyWindow = 24 * time.Hour
+
+	// dailyStatsInterval is how often the 24hr stats are fetched from the
+	// exchange
+	dailyStatsInterval = time.Hour
+)
+
+// DailyStats are the rolling 24 hour statistics of a pair
+type DailyStats struct {
+	Open        float64
+	High        float64
+	Low         float64
+	Volume      float64
+	QuoteVolume float64
+}
+
+// Position returns where the price is in the daily range, 0 at the low and 1
+// at the high. It returns false if the range is not known
+func (d DailyStats) Position(price float64) (float64, bool) {
+	if d.High <= d.Low || d.Low <= 0 {
+		return 0, false
+	}
+	return (price - d.Low) / (d.High - d.Low), true
+}
+
+type (
+	// daily is the rolling 24 hour statistics of a pair
+	daily struct {
+		candles []candle
+
+		// exchange are the last stats fetched from the exchange and updated
+		// is when they were fetched, fetched is the last time a fetch was
+		// tried
+		exchange api.DailyStats
+		updated  time.Time
+		fetched  time.Time
+	}
+
+	// candle is the open, high, low and close of the prices seen in a
+	// minute
+	candle struct {
+		time  time.Time
+		open  float64
+		high  float64
+		low   float64
+		close float64
+	}
+)
+
+// statsSource is implemented by exchanges that provide the 24hr stats of a
+// symbol
+type statsSource interface {
+	Get24hrStats(symbol string) (api.Stats24hr, error)
+}
+
+// apply records a price in the candle of its minute and drops the candles
+// that are out of the window
+func (d *daily) apply(now time.Time, price float64) {
+	minute := now.Truncate(time.Minute)
+	if n := len(d.candles); n > 0 && d.candles[n-1].time.Equal(minute) {
+		c := &d.candles[n-1]
+		if price > c.high {
+			c.high = price
+		}
+		if price < c.low {
+			c.low = price
+		}
+		c.close = price
+	} else {
+		d.candles = append(d.candles, candle{time: minute, open: price, high: price, low: price, close: price})
+	}
+	i := 0
+	for i < len(d.candles) && now.Sub(d.candles[i].time) > dailyWindow {
+		i++
+	}
+	d.candles = d.candles[i:]
+}
+
+// bars returns the candles as indicator bars, oldest first. The trader only
+// sees prices so the bars have no volume
+func (d *daily) bars() []indicators.Bar {
+	bars := make([]indicators.Bar, len(d.candles))
+	for i, c := range d.candles {
+		bars[i] = indicators.Bar{Time: c.time, Open: c.open, High: c.high, Low: c.low, Close: c.close}
+	}
+	return bars
+}
+
+// stats returns the statistics of the prices seen, combined with the stats
+// from the exchange while they are fresh
+func (d *daily) stats(now time.Time) DailyStats {
+	var s DailyStats
+	for i, c := range d.candles {
+		if i == 0 {
+			s = DailyStats{Open: c.open, High: c.high, Low: c.low}
+			continue
+		}
+		if c.high > s.High {
+			s.High = c.high
+		}
+		if c.low < s.Low {
+			s.Low = c.low
+		}
+	}
+	if d.updated.IsZero() || now.Sub(d.updated) > 2*dailyStatsInterval {
+		return s
+	}
+	e := d.exchange
+	s.Open = e.Open
+	s.Volume = e.Volume
+	s.QuoteVolume = e.QuoteVolume
+	if e.High > s.High {
+		s.High = e.High
+	}
+	if e.Low > 0 && (e.Low < s.Low || s.Low == 0) {
+		s.Low = e.Low
+	}
+	return s
+}
+
+// DailyStats returns the rolling 24 hour statistics of a pair
+func (t *Trader) DailyStats(symbol string, now time.Time) DailyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pair(symbol).daily.stats(now)
+}
+
+// UpdateDailyStats records the 24hr stats of a symbol from the exchange
+func (t *Trader) UpdateDailyStats(symbol string, stats api.Stats24hr, now time.Time) error {
+	d, err := stats.Daily()
+	if err != nil {
+		return err
+	}
+	if d.High == 0 {
+		// The exchange doesn't provide stats
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.pair(symbol)
+	p.daily.exchange = d
+	p.daily.updated = now
+	return nil
+}
+
+// refreshDailyStats fetches the 24hr stats of the strategy's symbols that are
+// due for a refresh if the exchange provides them. Errors are logged, trading
+// continues on the prices seen
+func (t *Trader) refreshDailyStats(ex Exchange, now time.Time) {
+	source, ok := ex.(statsSource)
+	if !ok {
+		return
+	}
+	for _, symbol := range t.strategy.Symbols() {
+		t.mu.Lock()
+		d := &t.pair(symbol).daily
+		due := d.fetched.IsZero() || now.Sub(d.fetched) >= dailyStatsInterval
+		if due {
+			d.fetched = now
+		}
+		t.mu.Unlock()
+		if !due {
+			continue
+		}
+		stats, err := source.Get24hrStats(symbol)
+		if err == nil {
+			err = t.UpdateDailyStats(symbol, stats, now)
+		}
+		if err != nil {
+			t.log.WithField("symbol", symbol).Warn("WARN: unable to update 24hr stats: ", err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package trader
+
+// this file contains the dollar-cost averaging mode of the dip strategy. Next
+// to the dip buys a fixed amount of the quote asset is bought on a cron-like
+// schedule, boosted by a multiplier while the price is below its moving
+// average. The DCA buys have their own budget, can be paused for configured
+// periods and are added to the buy order heap like any other buy so the dip
+// strategy sells them. The schedule, the amount spent and the moving average
+// samples are saved with the trader state. The schedule only moves on once a
+// DCA buy fills.
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+// dcaTag is the tag of the DCA buys
+const dcaTag = "dca"
+
+// DefaultDCAConfig is the default configuration of the DCA buys, they are
+// disabled by default
+var DefaultDCAConfig = DCAConfig{
+	Symbol:      api.BTCUSDT,
+	Schedule:    "daily",
+	QuoteAmount: buyBalanceLimit,
+	MAInterval:  time.Hour,
+	MAPeriods:   7 * 24,
+}
+
+// DCAConfig is the configuration of the DCA buys
+type DCAConfig struct {
+	Enabled bool
+
+	// Symbol is bought on the cron-like Schedule, it should be one of the
+	// symbols of the strategy for its lots to be sold
+	Symbol   string
+	Schedule string
+
+	// QuoteAmount is how much of the quote asset is bought each time unless
+	// Sizer is set to size the buys
+	QuoteAmount float64
+	Sizer       PositionSizer
+
+	// The moving average is of one price every MAInterval over MAPeriods
+	// intervals, the amount is multiplied by BelowMAMultiplier while the
+	// price is below it. The multiplier is not used when 0
+	MAInterval        time.Duration
+	MAPeriods         int
+	BelowMAMultiplier float64
+
+	// Budget is the most of the quote asset the DCA buys can spend, 0 for no
+	// limit
+	Budget float64
+
+	// Pauses are the periods no DCA buys are made in
+	Pauses []Pause
+}
+
+// Pause is a period from Start until End
+type Pause struct {
+	Start time.Time
+	End   time.Time
+}
+
+// dcaState is the state of the DCA buys, it is saved with the trader state
+type dcaState struct {
+	// Schedule is the schedule Next was set from, Next is reset when the
+	// schedule changes
+	Schedule string    `json:"schedule"`
+	Next     time.Time `json:"next"`
+
+	// Due is when the next buy is due once the buy decided on fills, Next is
+	// only moved to it on the fill so a buy that is dropped or rejected is
+	// decided on again
+	Due time.Time `json:"due"`
+
+	// Spent is the quote asset spent on the DCA buys that filled
+	Spent float64 `json:"spent"`
+
+	// Samples are the prices the moving average is of, one per interval
+	Samples    []float64 `json:"samples,omitempty"`
+	LastSample time.Time `json:"lastsample"`
+
+	schedule Schedule
+}
+
+// Validate returns an error if the DCA buys are enabled and can't be made
+func (c DCAConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, _, ok := api.SplitSymbol(c.Symbol); !ok {
+		return fmt.Errorf("unknown dca symbol %q", c.Symbol)
+	}
+	schedule, err := ParseSchedule(c.Schedule)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("dca schedule %q never runs", c.Schedule)
+	}
+	if c.QuoteAmount <= 0 {
+		return fmt.Errorf("dca quote amount must be positive, got %v", c.QuoteAmount)
+	}
+	if c.BelowMAMultiplier < 0 {
+		return fmt.Errorf("dca multiplier can't be negative, got %v", c.BelowMAMultiplier)
+	}
+	if c.BelowMAMultiplier > 0 && (c.MAInterval <= 0 || c.MAPeriods < 1) {
+		return fmt.Errorf("dca moving average needs a positive interval and periods, got %v and %v", c.MAInterval, c.MAPeriods)
+	}
+	for _, p := range c.Pauses {
+		if !p.End.After(p.Start) {
+			return fmt.Errorf("dca pause must end after it starts, got %v to %v", p.Start, p.End)
+		}
+	}
+	return nil
+}
+
+// paused returns true if the time is in one of the pauses
+func (c DCAConfig) paused(t time.Time) bool {
+	for _, p := range c.Pauses {
+		if !t.Before(p.Start) && t.Before(p.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// dcaBuy returns the DCA buy if one is due. Buys that are due while paused or
+// once the budget is spent are skipped, a buy that doesn't fill is returned
+// again until it does
+func (ds *DipStrategy) dcaBuy(market MarketData, portfolio PortfolioState) []OrderIntent {
+	config := ds.config.DCA
+	s := &ds.dca
+	s.sample(config, market.Time, market.Price)
+
+	if s.Schedule != config.Schedule || s.schedule.String() != config.Schedule {
+		schedule, err := ParseSchedule(config.Schedule)
+		if err != nil {
+			return nil
+		}
+		if s.Schedule != config.Schedule {
+			s.Next = time.Time{}
+		}
+		s.Schedule, s.schedule = config.Schedule, schedule
+	}
+	if s.Next.IsZero() {
+		s.Next = s.schedule.Next(market.Time)
+		return nil
+	}
+	if market.Time.Before(s.Next) {
+		return nil
+	}
+	// Buys missed while the trader was stopped are only made once
+	next := s.schedule.Next(market.Time)
+	if config.paused(market.Time) {
+		s.Next = next
+		return nil
+	}
+	// The buy on the book moves Next once it fills
+	for _, intent := range portfolio.Pending {
+		if intent.Tag == dcaTag {
+			return nil
+		}
+	}
+
+	amount := config.QuoteAmount
+	if config.Sizer != nil {
+		sized, ok := config.Sizer.Size(market, portfolio, market.Price)
+		if !ok {
+			s.Next = next
+			return nil
+		}
+		amount = sized
+	}
+	reason := "scheduled dca buy"
+	if ma, ok := s.average(config); ok && config.BelowMAMultiplier > 0 && market.Price < ma {
+		amount *= config.BelowMAMultiplier
+		reason = fmt.Sprintf("scheduled dca buy boosted %vx, price below the moving average of %v", config.BelowMAMultiplier, ma)
+	}
+	if config.Budget > 0 && s.Spent+amount > config.Budget {
+		amount = config.Budget - s.Spent
+	}
+	_, quote, _ := api.SplitSymbol(market.Symbol)
+	if amount <= 0 || portfolio.Balances[quote]-portfolio.MinBalances[quote] < amount {
+		s.Next = next
+		return nil
+	}
+	qty := market.Rules.RoundQuantity(amount / market.Price)
+	if !market.Rules.Valid(qty, market.Price) {
+		s.Next = next
+		return nil
+	}
+	s.Due = next
+	return []OrderIntent{{
+		Symbol:   market.Symbol,
+		Side:     api.SideBuy,
+		Quantity: qty,
+		Price:    market.Price,
+		Tag:      dcaTag,
+		Reason:   reason,
+	}}
+}
+
+// sample records a price for the moving average once per interval
+func (s *dcaState) sample(config DCAConfig, now time.Time, price float64) {
+	if config.MAInterval <= 0 || config.MAPeriods < 1 || now.Sub(s.LastSample) < config.MAInterval {
+		return
+	}
+	s.LastSample = now
+	s.Samples = append(s.Samples, price)
+	if len(s.Samples) > config.MAPeriods {
+		s.Samples = s.Samples[len(s.Samples)-config.MAPeriods:]
+	}
+}
+
+// average returns the moving average, false until there is a sample for every
+// period
+func (s *dcaState) average(config DCAConfig) (float64, bool) {
+	if config.MAPeriods < 1 || len(s.Samples) < config.MAPeriods {
+		return 0, false
+	}
+	var sum float64
+	for _, price := range s.Samples[len(s.Samples)-config.MAPeriods:] {
+		sum += price
+	}
+	return sum / float64(config.MAPeriods), true
+}
+
+// Filled implements the fillObserver interface, the DCA buys are counted
+// against the DCA budget and move the schedule to the next buy
+func (ds *DipStrategy) Filled(intent OrderIntent, price, quantity float64) {
+	if intent.Tag == dcaTag && intent.Side == api.SideBuy {
+		ds.dca.Spent += price * quantity
+		if ds.dca.Due.After(ds.dca.Next) {
+			ds.dca.Next = ds.dca.Due
+		}
+	}
+}
+
+// MarshalState implements the statefulStrategy interface
+func (ds *DipStrategy) MarshalState() ([]byte, error) {
+	if !ds.config.DCA.Enabled && ds.dca.Spent == 0 {
+		return nil, nil
+	}
+	return json.Marshal(ds.dca)
+}
+
+// UnmarshalState implements the statefulStrategy interface
+func (ds *DipStrategy) UnmarshalState(data []byte) error {
+	return json.Unmarshal(data, &ds.dca)
+}
@@ -0,0 +1,278 @@
+package trader
+
+// this file contains the dip strategy, the original algorithm of the trader
+// bot. It buys each of its symbols when the price rebounds after dipping by the
+// diff limit below the base price and sells the lots from the buy order heap
+// once the price is above them by the diff limit. It also keeps a BNB balance
+// to pay fees with.
+
+import (
+	"github.com/MSevey/traderbot/api"
+)
+
+// DipStrategyName is the name of the dip strategy
+const DipStrategyName = "dip"
+
+// DefaultDipConfig is the default configuration of the dip strategy
+var DefaultDipConfig = DipConfig{
+	Symbols:        []string{api.BTCUSDT},
+	BNBSymbol:      api.BNBBTC,
+	BuyQuoteAmount: buyBalanceLimit,
+	DiffLimit:      diffLimit,
+	BNBTarget:      bnbBalanceTarget,
+	DCA:            DefaultDCAConfig,
+}
+
+// DipConfig is the configuration of the dip strategy
+type DipConfig struct {
+	// Symbols are the markets that are traded, ie BTCUSDT
+	Symbols []string
+
+	// BNBSymbol is the market used to buy BNB to pay fees with, ie BNBBTC
+	BNBSymbol string
+
+	// BuyQuoteAmount is how much of the quote asset to buy at a time, the BNB
+	// buys are valued in USDT
+	BuyQuoteAmount float64
+
+	// Sizer sizes the buys of the symbols, BuyQuoteAmount is bought when it
+	// is nil
+	Sizer PositionSizer
+
+	// DiffLimit is the fraction the price has to move for a buy or sell
+	DiffLimit float64
+
+	// BNBTarget is the BNB balance to keep for fees
+	BNBTarget float64
+
+	// MaxBuyRange is the highest position in the daily range a buy is made
+	// at, ie 0.5 only buys in the lower half of the range. MinSellRange is
+	// the lowest position in the daily range a sell is made at. They are not
+	// used when 0 or while the daily range is not known
+	MaxBuyRange  float64
+	MinSellRange float64
+
+	// DCA are the scheduled buys made next to the dip buys
+	DCA DCAConfig
+}
+
+// DipStrategy is the buy the dip strategy
+type DipStrategy struct {
+	config DipConfig
+	dca    dcaState
+}
+
+// NewDipStrategy returns a new dip strategy
+func NewDipStrategy(config DipConfig) *DipStrategy {
+	return &DipStrategy{config: config}
+}
+
+// SetConfig changes the configuration of the strategy. It must not be called
+// while the strategy is deciding
+func (ds *DipStrategy) SetConfig(config DipConfig) {
+	ds.config = config
+}
+
+// Name implements the Strategy interface
+func (ds *DipStrategy) Name() string { return DipStrategyName }
+
+// Symbols implements the Strategy interface. The BNB buys are valued in USDT so
+// the USDT market of the asset BNB is bought with is included
+func (ds *DipStrategy) Symbols() []string {
+	symbols := append([]string{}, ds.config.Symbols...)
+	if ds.config.DCA.Enabled {
+		symbols = appendSymbol(symbols, ds.config.DCA.Symbol)
+	}
+	symbols = appendSymbol(symbols, ds.config.BNBSymbol)
+	if _, quote, ok := api.SplitSymbol(ds.config.BNBSymbol); ok && quote != "USDT" {
+		symbols = appendSymbol(symbols, quote+"USDT")
+	}
+	return symbols
+}
+
+// appendSymbol appends a symbol if it is not already in the symbols
+func appendSymbol(symbols []string, symbol string) []string {
+	for _, s := range symbols {
+		if s == symbol {
+			return symbols
+		}
+	}
+	return append(symbols, symbol)
+}
+
+// Decide implements the Strategy interface
+func (ds *DipStrategy) Decide(market MarketData, portfolio PortfolioState) []OrderIntent {
+	base, quote, ok := api.SplitSymbol(market.Symbol)
+	if !ok {
+		return nil
+	}
+	var intents []OrderIntent
+	if ds.config.DCA.Enabled && market.Symbol == ds.config.DCA.Symbol {
+		intents = append(intents, ds.dcaBuy(market, portfolio)...)
+	}
+	if market.Symbol == ds.config.BNBSymbol {
+		if portfolio.MinBalances[quote] < portfolio.Balances[quote] && portfolio.Balances[base] < ds.config.BNBTarget && !portfolio.HasPending(market.Symbol, api.SideBuy) {
+			intents = append(intents, ds.buyBNB(market)...)
+		}
+		return intents
+	}
+	for _, symbol := range ds.config.Symbols {
+		if symbol != market.Symbol {
+			continue
+		}
+		// Buy only while the quote balance covers a buy and the pair is
+		// within its budget, waiting for any buy on the book to finish
+		if portfolio.CanBuy && !portfolio.HasPending(market.Symbol, api.SideBuy) {
+			intents = append(intents, ds.buy(market, portfolio)...)
+		}
+		if portfolio.MinBalances[base] < portfolio.Balances[base] {
+			intents = append(intents, ds.sell(market, portfolio)...)
+		}
+		return intents
+	}
+	return intents
+}
+
+// rebounding returns true if the price has dipped by the diff limit below the
+// base price and is now rising, along with the size of the dip
+func (ds *DipStrategy) rebounding(market MarketData) (bool, float64) {
+	// Check to make sure base price is set
+	if market.Buyer.Base == 0 {
+		return false, 0
+	}
+	// Compare to previous price
+	if market.Price <= market.Buyer.Last || market.Buyer.Last == 0 {
+		return false, 0
+	}
+	diff := (market.Buyer.Base - market.Price) / market.Buyer.Base
+	return diff >= ds.diffLimit(market.Thresholds.Buy), diff
+}
+
+// diffLimit returns the adapted threshold if one is set and the diff limit of
+// the config otherwise
+func (ds *DipStrategy) diffLimit(adapted float64) float64 {
+	if adapted > 0 {
+		return adapted
+	}
+	return ds.config.DiffLimit
+}
+
+// inRange returns true if the price is within the part of the daily range
+// the range rules allow for the side
+func (ds *DipStrategy) inRange(market MarketData, side api.Side) bool {
+	position, ok := market.Daily.Position(market.Price)
+	if !ok {
+		return true
+	}
+	switch side {
+	case api.SideBuy:
+		return ds.config.MaxBuyRange == 0 || position <= ds.config.MaxBuyRange
+	case api.SideSell:
+		return ds.config.MinSellRange == 0 || position >= ds.config.MinSellRange
+	}
+	return true
+}
+
+// buy returns a buy of the traded symbol if the price is rebounding from a dip
+// in the allowed part of the daily range, sized by the sizer of the config
+func (ds *DipStrategy) buy(market MarketData, portfolio PortfolioState) []OrderIntent {
+	ok, diff := ds.rebounding(market)
+	if !ok || !ds.inRange(market, api.SideBuy) {
+		return nil
+	}
+	qty, capped, ok := sizeBuy(ds.config.Sizer, ds.config.BuyQuoteAmount, market, portfolio, market.Price)
+	if !ok {
+		return nil
+	}
+	reason := "price rebounding after dip of " + formatPercent(diff)
+	if capped {
+		reason += ", capped by the available balance"
+	}
+	return []OrderIntent{{
+		Symbol:   market.Symbol,
+		Side:     api.SideBuy,
+		Quantity: qty,
+		Price:    market.Price,
+		Reason:   reason,
+	}}
+}
+
+// buyBNB returns a buy of BNB if the BNB price is rebounding from a dip
+func (ds *DipStrategy) buyBNB(market MarketData) []OrderIntent {
+	ok, diff := ds.rebounding(market)
+	if !ok {
+		return nil
+	}
+	_, quote, _ := api.SplitSymbol(market.Symbol)
+	snapshot := api.TickerSnapshot{Prices: market.Prices}
+	quotePrice, ok := snapshot.Rate(quote, "USDT")
+	if !ok || quotePrice == 0 {
+		return nil
+	}
+	return []OrderIntent{{
+		Symbol:   market.Symbol,
+		Side:     api.SideBuy,
+		Quantity: ds.config.BuyQuoteAmount / quotePrice / market.Price,
+		Price:    market.Price,
+		NoLot:    true,
+		Reason:   "BNB price rebounding after dip of " + formatPercent(diff),
+	}}
+}
+
+// sell returns a sell of the first lot of the lot policy if the price
+// is above it by the diff limit. If there are no lots, a sell is returned if
+// the price is falling back from a rise above the base price. Sells are only
+// made in the allowed part of the daily range and when the round trip clears
+// the minimum net profit after fees
+func (ds *DipStrategy) sell(market MarketData, portfolio PortfolioState) []OrderIntent {
+	// Check to make sure base price is set
+	if market.Seller.Base == 0 || !ds.inRange(market, api.SideSell) {
+		return nil
+	}
+	// Compare to previous price, only sell once the price stops rising
+	if market.Price >= market.Seller.Last {
+		return nil
+	}
+
+	// Prioritize selling against previous buy orders, the first lot of the
+	// lot policy is sold once it is profitable. With the specific lot policy
+	// the first profitable lot is sold
+	hasLots := false
+	for _, lot := range portfolio.Lots {
+		if lot.Symbol != market.Symbol {
+			continue
+		}
+		hasLots = true
+		diff := (market.Price - lot.Price) / lot.Price
+		if diff < ds.diffLimit(market.Thresholds.Sell) || !portfolio.Fees.Clears(lot.Price, market.Price) {
+			if portfolio.LotPolicy == LotSpecific {
+				continue
+			}
+			return nil
+		}
+		return []OrderIntent{{
+			Symbol:   market.Symbol,
+			Side:     api.SideSell,
+			Quantity: lot.Quantity,
+			Price:    market.Price,
+			LotID:    lot.ID,
+			Reason:   "price above lot by " + formatPercent(diff),
+		}}
+	}
+
+	// No lots, track against base price
+	if hasLots || portfolio.HasPending(market.Symbol, api.SideSell) {
+		return nil
+	}
+	diff := (market.Price - market.Seller.Base) / market.Seller.Base
+	if diff < ds.diffLimit(market.Thresholds.Sell) || !portfolio.Fees.Clears(market.Seller.Base, market.Price) {
+		return nil
+	}
+	return []OrderIntent{{
+		Symbol:   market.Symbol,
+		Side:     api.SideSell,
+		Quantity: ds.config.BuyQuoteAmount / market.Seller.Base,
+		Price:    market.Price,
+		Reason:   "price above base by " + formatPercent(diff),
+	}}
+}
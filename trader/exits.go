@@ -0,0 +1,273 @@
+package trader
+
+// this file contains the exits of the lots on the buy order heap. Each lot can
+// carry a stop-loss, a take-profit and a trailing stop that are set as
+// fractions of the lot price when the lot is bought. The exits are enforced
+// locally by selling the lot once the price reaches them, or the stop-loss or
+// take-profit of each lot is placed on the exchange as a STOP_LOSS_LIMIT or
+// TAKE_PROFIT_LIMIT order. The exchange only allows one of them to lock the
+// lot so the other and the trailing stop are always enforced locally. The lot
+// is removed from the heap when its exit fills like any other sell.
+
+import (
+	"fmt"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+// ExitMode is how the exits of the lots are enforced
+type ExitMode string
+
+// ExitKind is the exit that sold a lot
+type ExitKind string
+
+const (
+	// ExitLocal enforces the exits by selling the lot when the trader sees
+	// the price reach them
+	ExitLocal ExitMode = "local"
+
+	// ExitExchange places the stop-loss, or the take-profit of lots without
+	// one, on the exchange
+	ExitExchange ExitMode = "exchange"
+)
+
+const (
+	// ExitStopLoss sells a lot when the price falls to its stop-loss
+	ExitStopLoss ExitKind = "stop-loss"
+
+	// ExitTakeProfit sells a lot when the price rises to its take-profit
+	ExitTakeProfit ExitKind = "take-profit"
+
+	// ExitTrailingStop sells a lot when the price falls from the highest
+	// price since the lot was bought by the trailing stop
+	ExitTrailingStop ExitKind = "trailing-stop"
+)
+
+// DefaultExitConfig is the default configuration of the lot exits, lots have
+// no exits by default
+var DefaultExitConfig = ExitConfig{
+	Mode:        ExitLocal,
+	LimitOffset: 0.001,
+}
+
+// ExitConfig is the configuration of the lot exits
+type ExitConfig struct {
+	Mode ExitMode
+
+	// Exits are the exits of lots bought by intents without their own
+	Exits Exits
+
+	// LimitOffset is the fraction below the stop price the limit price of an
+	// exchange exit is placed at so it fills once triggered
+	LimitOffset float64
+}
+
+// Exits are the exits of a lot as fractions of the lot price, exits that are 0
+// are not used
+type Exits struct {
+	StopLoss     float64
+	TakeProfit   float64
+	TrailingStop float64
+}
+
+// ExitModes returns the names of the exit modes
+func ExitModes() []string {
+	return []string{string(ExitLocal), string(ExitExchange)}
+}
+
+// Validate returns an error if the mode is unknown
+func (m ExitMode) Validate() error {
+	switch m {
+	case ExitLocal, ExitExchange:
+		return nil
+	}
+	return fmt.Errorf("unknown exit mode %q, expected one of %v", m, ExitModes())
+}
+
+// stopOrderer is implemented by exchanges that support stop limit orders
+type stopOrderer interface {
+	PostNewStopLimitOrder(symbol string, side api.Side, orderType api.OrderType, quantity, price, stopPrice float64) (api.Result, error)
+}
+
+// SetExitConfig sets the configuration of the lot exits. It applies to the
+// lots bought after it is set
+func (t *Trader) SetExitConfig(config ExitConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.exitConfig = config
+}
+
+// setExits sets the exit prices of a new lot
+func (o *order) setExits(exits Exits) {
+	o.high = o.price
+	if exits.StopLoss > 0 {
+		o.stopLoss = o.price * (1 - exits.StopLoss)
+	}
+	if exits.TakeProfit > 0 {
+		o.takeProfit = o.price * (1 + exits.TakeProfit)
+	}
+	o.trailingStop = exits.TrailingStop
+}
+
+// exchangeExit returns the exit of the lot that is placed on the exchange
+// and its stop price
+func (o *order) exchangeExit() (ExitKind, float64) {
+	if o.stopLoss > 0 {
+		return ExitStopLoss, o.stopLoss
+	}
+	if o.takeProfit > 0 {
+		return ExitTakeProfit, o.takeProfit
+	}
+	return "", 0
+}
+
+// exitOrders returns the exits on the exchange keyed by lot
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) exitOrders() map[uint64]ExitKind {
+	exits := make(map[uint64]ExitKind)
+	for _, o := range t.openOrders {
+		if o.Intent.StopPrice > 0 && o.Intent.LotID != 0 {
+			exits[o.Intent.LotID] = o.Intent.Exit
+		}
+	}
+	return exits
+}
+
+// exitIntents tracks the highest price of the lots of the pair and returns a
+// sell for every lot that reached one of its locally enforced exits
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) exitIntents(p *Pair, price float64) []OrderIntent {
+	selling := t.selling(false)
+	onExchange := t.exitOrders()
+	var intents []OrderIntent
+	for _, o := range p.Buyer.orders {
+		if price > o.high {
+			o.high = price
+		}
+		if selling[o.id] {
+			continue
+		}
+		var kind ExitKind
+		var level float64
+		switch {
+		case o.stopLoss > 0 && price <= o.stopLoss && onExchange[o.id] != ExitStopLoss:
+			kind, level = ExitStopLoss, o.stopLoss
+		case o.trailingStop > 0 && price <= o.high*(1-o.trailingStop):
+			kind, level = ExitTrailingStop, o.high*(1-o.trailingStop)
+		case o.takeProfit > 0 && price >= o.takeProfit && onExchange[o.id] != ExitTakeProfit:
+			kind, level = ExitTakeProfit, o.takeProfit
+		default:
+			continue
+		}
+		intents = append(intents, OrderIntent{
+			Symbol:   p.Symbol,
+			Side:     api.SideSell,
+			Quantity: o.quantity,
+			Price:    price,
+			LotID:    o.id,
+			Exit:     kind,
+			Reason:   fmt.Sprintf("%v reached at %v, lot bought at %v", kind, level, o.price),
+		})
+	}
+	return intents
+}
+
+// placeExits places the exchange exit of every lot that needs one and doesn't
+// have a sell on the book. The exits are rounded to the symbol rules, lots
+// whose exit is rejected by the exchange are enforced locally
+func (t *Trader) placeExits(ex Exchange) {
+	if _, ok := ex.(stopOrderer); !ok {
+		return
+	}
+	t.mu.Lock()
+	config := t.exitConfig
+	if config.Mode != ExitExchange {
+		t.mu.Unlock()
+		return
+	}
+	selling := t.selling(true)
+	var intents []OrderIntent
+	for _, p := range t.pairs {
+		for _, o := range p.Buyer.orders {
+			kind, stop := o.exchangeExit()
+			if kind == "" || o.exitRejected || selling[o.id] {
+				continue
+			}
+			intents = append(intents, OrderIntent{
+				Symbol:    o.symbol,
+				Side:      api.SideSell,
+				Quantity:  o.quantity,
+				Price:     stop * (1 - config.LimitOffset),
+				StopPrice: stop,
+				LotID:     o.id,
+				Resting:   true,
+				Exit:      kind,
+				Reason:    fmt.Sprintf("%v placed on the exchange at %v", kind, stop),
+			})
+		}
+	}
+	t.mu.Unlock()
+
+	// Exits that fail for any other reason, ie a timeout, are placed again on
+	// the next step
+	for _, intent := range intents {
+		if err := t.submit(ex, intent, OpenOrder{}); err == errUnderMinimums || api.IsRejection(err) {
+			t.mu.Lock()
+			for _, o := range t.pair(intent.Symbol).Buyer.orders {
+				if o.id == intent.LotID {
+					o.exitRejected = true
+				}
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// cancelExits cancels the exchange exits of the lot an intent sells so the lot
+// can be sold. The quantity of the intent is reduced to what is left of the
+// lot, false is returned if nothing is left or an exit couldn't be canceled
+func (t *Trader) cancelExits(ex Exchange, intent OrderIntent) (OrderIntent, bool) {
+	if intent.Side != api.SideSell || intent.LotID == 0 {
+		return intent, true
+	}
+	var exits []OpenOrder
+	t.mu.Lock()
+	for _, o := range t.openOrders {
+		if o.Intent.LotID == intent.LotID && o.Intent.StopPrice > 0 {
+			exits = append(exits, *o)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, o := range exits {
+		canceled, err := ex.CancelOrder(o.Intent.Symbol, o.OrderID)
+		if err != nil {
+			t.log.WithField("orderID", o.OrderID).Warn("WARN: unable to cancel exit: ", err)
+			return intent, false
+		}
+		qty, price, err := canceled.Executed()
+		if err != nil {
+			t.log.WithField("orderID", o.OrderID).Warn("WARN: unable to read canceled exit: ", err)
+			return intent, false
+		}
+		o.update(qty, qty*price)
+		t.finish(ex, &o, api.OrderStatusCanceled)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, o := range t.pair(intent.Symbol).Buyer.orders {
+		if o.id != intent.LotID {
+			continue
+		}
+		if intent.Quantity > o.quantity {
+			intent.Quantity = o.quantity
+		}
+		// Let a new exit be placed for what is left if the sell fails
+		o.exitRejected = false
+		return intent, true
+	}
+	return intent, false
+}
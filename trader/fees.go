@@ -0,0 +1,146 @@
+package trader
+
+// this file contains the fee model of the trader. The maker and taker
+// commissions come from the account info and are discounted while fees can be
+// paid in BNB, which is why the trader keeps a BNB balance. Strategies use the
+// fees to check that the net profit of a round trip clears a minimum, and the
+// commission actually paid on each fill is recorded from the trades of the
+// order.
+
+import (
+	"github.com/MSevey/traderbot/api"
+	"github.com/sirupsen/logrus"
+)
+
+// commissionDivisor converts the commissions of api.AccountInfo into a
+// fraction, a commission of 10 is a 0.1% fee
+const commissionDivisor = 10000
+
+// DefaultFeeConfig is the default configuration of the fee model
+var DefaultFeeConfig = FeeConfig{
+	BNBDiscount: api.BNBFeeDiscount,
+}
+
+// FeeConfig is the configuration of the fee model
+type FeeConfig struct {
+	// BNBDiscount is the discount on fees paid in BNB, it applies while the
+	// BNB balance is not empty
+	BNBDiscount float64
+
+	// MinNetProfit is the least profit a round trip must make after the fees
+	// of the buy and the sell, as a fraction of the cost of the buy
+	MinNetProfit float64
+}
+
+// Fees are the trading fees of the account that are passed to a Strategy
+type Fees struct {
+	// Maker and Taker are the fees as fractions, after the BNB discount
+	Maker float64
+	Taker float64
+
+	// MinNetProfit is the least net profit a round trip must make
+	MinNetProfit float64
+}
+
+// NetProfit returns the profit of buying at buy and selling at sell after
+// paying the taker fee on both, as a fraction of the cost of the buy
+func (f Fees) NetProfit(buy, sell float64) float64 {
+	if buy <= 0 {
+		return 0
+	}
+	cost := buy * (1 + f.Taker)
+	return sell*(1-f.Taker)/cost - 1
+}
+
+// Clears returns true if the net profit of the round trip clears the minimum
+func (f Fees) Clears(buy, sell float64) bool {
+	return f.NetProfit(buy, sell) >= f.MinNetProfit
+}
+
+// tradeSource is implemented by exchanges that provide the trades of an order
+// with the commission paid on each
+type tradeSource interface {
+	GetOrderTrades(symbol string, orderID int) ([]api.Trade, error)
+}
+
+// SetFeeConfig sets the configuration of the fee model
+func (t *Trader) SetFeeConfig(config FeeConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.feeConfig = config
+}
+
+// Commissions returns the commission paid on all the fills keyed by asset
+func (t *Trader) Commissions() map[string]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	commissions := make(map[string]float64, len(t.commissions))
+	for asset, qty := range t.commissions {
+		commissions[asset] = qty
+	}
+	return commissions
+}
+
+// fees returns the fees of the account
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) fees() Fees {
+	discount := 1.0
+	if t.balances["BNB"] > 0 {
+		discount -= t.feeConfig.BNBDiscount
+	}
+	return Fees{
+		Maker:        float64(t.makerCommission) / commissionDivisor * discount,
+		Taker:        float64(t.takerCommission) / commissionDivisor * discount,
+		MinNetProfit: t.feeConfig.MinNetProfit,
+	}
+}
+
+// orderCommissions returns the commission paid on an order and the orders it
+// repriced keyed by asset. It returns nil if the exchange does not provide
+// the trades of an order
+func (t *Trader) orderCommissions(ex Exchange, o *OpenOrder) map[string]float64 {
+	source, ok := ex.(tradeSource)
+	if !ok {
+		return nil
+	}
+	commissions := make(map[string]float64)
+	for _, id := range append(append([]int{}, o.PriorOrderIDs...), o.OrderID) {
+		trades, err := source.GetOrderTrades(o.Intent.Symbol, id)
+		if err == nil {
+			var c map[string]float64
+			c, err = api.Commissions(trades)
+			for asset, qty := range c {
+				commissions[asset] += qty
+			}
+		}
+		if err != nil {
+			t.log.WithField("orderID", id).Warn("WARN: unable to get the commission of order: ", err)
+		}
+	}
+	return commissions
+}
+
+// recordCommissions records the commission paid on a fill and returns the
+// part of it paid in the asset and the value of all of it in the quote asset
+// of the pair
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) recordCommissions(p *Pair, intent OrderIntent, commissions map[string]float64, asset string) (float64, float64) {
+	if len(commissions) == 0 {
+		return 0, 0
+	}
+	snapshot := api.TickerSnapshot{Prices: t.prices}
+	var value float64
+	for a, qty := range commissions {
+		t.commissions[a] += qty
+		if rate, ok := snapshot.Rate(a, p.Quote); ok {
+			value += qty * rate
+		}
+	}
+	t.log.WithFields(orderFields(intent)).WithFields(logrus.Fields{
+		"commissions": commissions,
+		"value":       value,
+	}).Infof("Commission paid on %v %v", intent.Symbol, intent.Side)
+	return commissions[asset], value
+}
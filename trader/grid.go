@@ -0,0 +1,271 @@
+package trader
+
+// this file contains the grid strategy. The grid is a set of price levels
+// spaced arithmetically or geometrically between a lower and upper bound.
+// A resting buy is kept at every free level below the price, and every lot
+// bought at a level is sold with a resting sell one level up, which frees the
+// level so its buy is placed again. The lots of the grid are tracked on the buy
+// order heap like any other lot so the realized profit of each level comes
+// from the ledger. While the price is outside of the bounds the orders on the
+// book are kept and no new orders are placed beyond the bounds, so the grid
+// picks up again once the price returns.
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+// GridStrategyName is the name of the grid strategy
+const GridStrategyName = "grid"
+
+// GridSpacing is how the levels of the grid are spaced
+type GridSpacing string
+
+const (
+	// GridArithmetic spaces the levels by the same price difference
+	GridArithmetic GridSpacing = "arithmetic"
+
+	// GridGeometric spaces the levels by the same ratio
+	GridGeometric GridSpacing = "geometric"
+)
+
+// DefaultGridConfig is the default configuration of the grid strategy, the
+// bounds have to be set for the grid to trade
+var DefaultGridConfig = GridConfig{
+	Symbol:      api.BTCUSDT,
+	Levels:      10,
+	Spacing:     GridArithmetic,
+	QuoteAmount: buyBalanceLimit,
+}
+
+// GridConfig is the configuration of the grid strategy
+type GridConfig struct {
+	Symbol string
+
+	// Lower and Upper are the bounds of the grid, they are the lowest and
+	// highest levels
+	Lower float64
+	Upper float64
+
+	// Levels is the number of levels including the bounds
+	Levels  int
+	Spacing GridSpacing
+
+	// QuoteAmount is how much of the quote asset is bought at each level,
+	// unless Sizer is set to size the buys
+	QuoteAmount float64
+	Sizer       PositionSizer
+}
+
+// GridLevel is the realized profit of a level of the grid
+type GridLevel struct {
+	Price float64
+
+	// RoundTrips are the lots bought at the level that were sold
+	RoundTrips int
+	Quantity   float64
+	Fees       float64
+	PnL        float64
+}
+
+// GridSpacings returns the names of the grid spacings
+func GridSpacings() []string {
+	return []string{string(GridArithmetic), string(GridGeometric)}
+}
+
+// Validate returns an error if the grid can't be built
+func (c GridConfig) Validate() error {
+	if _, _, ok := api.SplitSymbol(c.Symbol); !ok {
+		return fmt.Errorf("unknown grid symbol %q", c.Symbol)
+	}
+	if c.Lower <= 0 || c.Upper <= c.Lower {
+		return fmt.Errorf("grid bounds must be 0 < lower < upper, got %v and %v", c.Lower, c.Upper)
+	}
+	if c.Levels < 2 {
+		return fmt.Errorf("grid must have at least 2 levels, got %v", c.Levels)
+	}
+	if c.Spacing != GridArithmetic && c.Spacing != GridGeometric {
+		return fmt.Errorf("unknown grid spacing %q, expected one of %v", c.Spacing, GridSpacings())
+	}
+	if c.QuoteAmount <= 0 {
+		return fmt.Errorf("grid quote amount must be positive, got %v", c.QuoteAmount)
+	}
+	return nil
+}
+
+// Prices returns the prices of the levels rounded down to the tick size of
+// the rules, lowest first. Levels that round to the same price are merged
+func (c GridConfig) Prices(rules api.SymbolRules) []float64 {
+	if c.Validate() != nil {
+		return nil
+	}
+	var prices []float64
+	n := float64(c.Levels - 1)
+	for i := 0; i < c.Levels; i++ {
+		price := c.Lower + (c.Upper-c.Lower)*float64(i)/n
+		if c.Spacing == GridGeometric {
+			price = c.Lower * math.Pow(c.Upper/c.Lower, float64(i)/n)
+		}
+		price = rules.RoundPrice(price)
+		if len(prices) > 0 && price <= prices[len(prices)-1] {
+			continue
+		}
+		prices = append(prices, price)
+	}
+	return prices
+}
+
+// GridStrategy is the grid trading strategy
+type GridStrategy struct {
+	config GridConfig
+
+	// rules are the rules of the symbol of the last decision, the report
+	// uses them to build the same levels as Decide
+	rules api.SymbolRules
+}
+
+// NewGridStrategy returns a new grid strategy
+func NewGridStrategy(config GridConfig) *GridStrategy {
+	return &GridStrategy{config: config}
+}
+
+// SetConfig changes the configuration of the strategy. It must not be called
+// while the strategy is deciding
+func (gs *GridStrategy) SetConfig(config GridConfig) {
+	gs.config = config
+}
+
+// SetRules sets the rules of the symbol the report is built with until the
+// strategy decides, ie when the ledger is reported without trading
+func (gs *GridStrategy) SetRules(rules api.SymbolRules) {
+	gs.rules = rules
+}
+
+// Name implements the Strategy interface
+func (gs *GridStrategy) Name() string { return GridStrategyName }
+
+// Symbols implements the Strategy interface
+func (gs *GridStrategy) Symbols() []string { return []string{gs.config.Symbol} }
+
+// Decide implements the Strategy interface. Every lot of the grid gets a sell
+// one level above the level it was bought at if it clears the fees and every
+// free level below the price gets a buy
+func (gs *GridStrategy) Decide(market MarketData, portfolio PortfolioState) []OrderIntent {
+	if market.Symbol != gs.config.Symbol {
+		return nil
+	}
+	gs.rules = market.Rules
+	_, quote, _ := api.SplitSymbol(market.Symbol)
+	levels := gs.config.Prices(market.Rules)
+	if len(levels) < 2 {
+		return nil
+	}
+
+	// A level is taken while it has a buy on the book, a lot or a sell of
+	// its lot on the book one level up
+	taken := make([]bool, len(levels))
+	for _, intent := range portfolio.Pending {
+		if intent.Symbol != market.Symbol {
+			continue
+		}
+		i := nearestLevel(levels, intent.Price)
+		if intent.Side == api.SideBuy {
+			taken[i] = true
+		} else if intent.LotID != 0 && i > 0 {
+			taken[i-1] = true
+		}
+	}
+
+	var intents []OrderIntent
+	for _, lot := range portfolio.Lots {
+		if lot.Symbol != market.Symbol {
+			continue
+		}
+		i := nearestLevel(levels, lot.Price)
+		sell := i + 1
+		if sell == len(levels) {
+			sell = i
+		}
+		// Dust left by rounding can't be sold and doesn't take the level
+		qty := market.Rules.RoundQuantity(lot.Quantity)
+		if !market.Rules.Valid(qty, levels[sell]) {
+			continue
+		}
+		taken[i] = true
+		// A lot at the top level has no level above it to sell at, it is
+		// held like a lot whose sell doesn't clear the fees
+		if sell == i || !portfolio.Fees.Clears(lot.Price, levels[sell]) {
+			continue
+		}
+		intents = append(intents, OrderIntent{
+			Symbol:   market.Symbol,
+			Side:     api.SideSell,
+			Quantity: qty,
+			Price:    levels[sell],
+			LotID:    lot.ID,
+			Resting:  true,
+			Reason:   fmt.Sprintf("grid level %v bought at %v", sell, lot.Price),
+		})
+	}
+
+	// Buys rest below the price, the top level only sells
+	available := portfolio.Balances[quote] - portfolio.MinBalances[quote]
+	for i := 0; i < len(levels)-1; i++ {
+		if taken[i] || levels[i] >= market.Price {
+			continue
+		}
+		qty, _, ok := sizeBuy(gs.config.Sizer, gs.config.QuoteAmount, market, portfolio, levels[i])
+		cost := qty * levels[i]
+		if !ok || cost > available {
+			continue
+		}
+		available -= cost
+		intents = append(intents, OrderIntent{
+			Symbol:   market.Symbol,
+			Side:     api.SideBuy,
+			Quantity: qty,
+			Price:    levels[i],
+			Resting:  true,
+			Reason:   fmt.Sprintf("grid level %v", i),
+		})
+	}
+	return intents
+}
+
+// Report returns the realized profit of each level of the grid from the
+// closed lots of the ledger. The levels are rounded to the rules of the last
+// decision or the rules set
+func (gs *GridStrategy) Report(ledger []ClosedLot) []GridLevel {
+	prices := gs.config.Prices(gs.rules)
+	report := make([]GridLevel, len(prices))
+	for i, price := range prices {
+		report[i].Price = price
+	}
+	if len(prices) == 0 {
+		return report
+	}
+	for _, c := range ledger {
+		if c.Symbol != gs.config.Symbol {
+			continue
+		}
+		level := &report[nearestLevel(prices, c.BuyPrice)]
+		level.RoundTrips++
+		level.Quantity += c.Quantity
+		level.Fees += c.Fees
+		level.PnL += c.PnL
+	}
+	return report
+}
+
+// nearestLevel returns the index of the level closest to the price
+func nearestLevel(levels []float64, price float64) int {
+	nearest := 0
+	for i, level := range levels {
+		if math.Abs(level-price) < math.Abs(levels[nearest]-price) {
+			nearest = i
+		}
+	}
+	return nearest
+}
@@ -0,0 +1,264 @@
+package trader
+
+// this file contains the realized profit and loss ledger of the trader. Every
+// lot that is sold, in full or in part, and every traded arbitrage loop is
+// recorded with its cost basis, proceeds, fees and holding period. The ledger
+// is saved to its own file in the state directory as it only grows, and can
+// be summarized to evaluate a strategy or written as csv for tax reporting.
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gitlab.com/NebulousLabs/Sia/persist"
+)
+
+// longTermHolding is the holding period after which a gain is long term
+const longTermHolding = 365 * 24 * time.Hour
+
+var (
+	// ledgerMetadata is the metadata for the persisted file that stores the
+	// ledger
+	ledgerMetadata = persist.Metadata{
+		Header:  "Trader Ledger",
+		Version: "v1.0.0",
+	}
+
+	// ledgerFile is the filename for the persisted ledger
+	ledgerFile = "ledger.json"
+)
+
+// ClosedLot is a lot, or the part of a lot, that was sold. Amounts are in the
+// quote asset of the symbol. A traded arbitrage loop has its route as the
+// symbol and its amounts in the start asset
+type ClosedLot struct {
+	LotID    uint64    `json:"lotid"`
+	Symbol   string    `json:"symbol"`
+	Quantity float64   `json:"quantity"`
+	Bought   time.Time `json:"bought"`
+	Sold     time.Time `json:"sold"`
+
+	// BuyPrice and SellPrice are the prices the lot was bought and sold at
+	BuyPrice  float64 `json:"buyprice"`
+	SellPrice float64 `json:"sellprice"`
+
+	// CostBasis is the cost of the buy including its fees, Proceeds are what
+	// the sell received after its fees and Fees are the fees of both
+	CostBasis float64 `json:"costbasis"`
+	Proceeds  float64 `json:"proceeds"`
+	Fees      float64 `json:"fees"`
+
+	// PnL is the realized profit, Proceeds less CostBasis
+	PnL float64 `json:"pnl"`
+
+	// Exit is the exit that sold the lot, empty if the strategy sold it
+	Exit ExitKind `json:"exit,omitempty"`
+}
+
+// HoldingPeriod returns how long the lot was held, 0 if the buy time is not
+// known
+func (c ClosedLot) HoldingPeriod() time.Duration {
+	if c.Bought.IsZero() {
+		return 0
+	}
+	return c.Sold.Sub(c.Bought)
+}
+
+// LedgerSummary is the summary of the closed lots of a ledger
+type LedgerSummary struct {
+	Lots   int
+	Wins   int
+	Losses int
+
+	CostBasis float64
+	Proceeds  float64
+	Fees      float64
+	PnL       float64
+
+	// GrossProfit and GrossLoss are the profit of the wins and the loss of
+	// the losses, the loss is positive
+	GrossProfit float64
+	GrossLoss   float64
+
+	// ShortTermPnL and LongTermPnL are the profit of the lots held for less
+	// and more than a year
+	ShortTermPnL float64
+	LongTermPnL  float64
+
+	AverageHold time.Duration
+}
+
+// Ledger returns the closed lots, oldest sell first
+func (t *Trader) Ledger() []ClosedLot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ClosedLot(nil), t.ledger...)
+}
+
+// SummarizeLedger summarizes the closed lots. Amounts of different quote
+// assets are added as is so the ledger should be filtered by quote asset
+// first if it has more than one
+func SummarizeLedger(ledger []ClosedLot) LedgerSummary {
+	var s LedgerSummary
+	var hold time.Duration
+	for _, c := range ledger {
+		s.Lots++
+		if c.PnL > 0 {
+			s.Wins++
+			s.GrossProfit += c.PnL
+		} else if c.PnL < 0 {
+			s.Losses++
+			s.GrossLoss -= c.PnL
+		}
+		s.CostBasis += c.CostBasis
+		s.Proceeds += c.Proceeds
+		s.Fees += c.Fees
+		s.PnL += c.PnL
+		if c.HoldingPeriod() > longTermHolding {
+			s.LongTermPnL += c.PnL
+		} else {
+			s.ShortTermPnL += c.PnL
+		}
+		hold += c.HoldingPeriod()
+	}
+	if s.Lots > 0 {
+		s.AverageHold = hold / time.Duration(s.Lots)
+	}
+	return s
+}
+
+// WinRate returns the fraction of the closed lots that made a profit
+func (s LedgerSummary) WinRate() float64 {
+	if s.Lots == 0 {
+		return 0
+	}
+	return float64(s.Wins) / float64(s.Lots)
+}
+
+// Payoff returns the average profit of the wins over the average loss of the
+// losses, 0 if there are no wins or no losses
+func (s LedgerSummary) Payoff() float64 {
+	if s.Wins == 0 || s.Losses == 0 {
+		return 0
+	}
+	return (s.GrossProfit / float64(s.Wins)) / (s.GrossLoss / float64(s.Losses))
+}
+
+// WriteLedgerCSV writes the closed lots as csv with a header row
+func WriteLedgerCSV(w io.Writer, ledger []ClosedLot) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"lot", "symbol", "quantity", "bought", "sold", "held", "buy price", "sell price", "cost basis", "proceeds", "fees", "pnl", "exit"})
+	if err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, c := range ledger {
+		bought := ""
+		if !c.Bought.IsZero() {
+			bought = c.Bought.UTC().Format(time.RFC3339)
+		}
+		err := cw.Write([]string{
+			strconv.FormatUint(c.LotID, 10),
+			c.Symbol,
+			f(c.Quantity),
+			bought,
+			c.Sold.UTC().Format(time.RFC3339),
+			c.HoldingPeriod().String(),
+			f(c.BuyPrice),
+			f(c.SellPrice),
+			f(c.CostBasis),
+			f(c.Proceeds),
+			f(c.Fees),
+			f(c.PnL),
+			string(c.Exit),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// closeLot records the sell of a lot in the ledger. The lot is the part of
+// the lot that was sold with its share of the buy fees and fee is the value of
+// the sell fees
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) closeLot(lot order, intent OrderIntent, now time.Time, price, fee float64) ClosedLot {
+	closed := ClosedLot{
+		LotID:     lot.id,
+		Symbol:    lot.symbol,
+		Quantity:  lot.quantity,
+		Bought:    lot.bought,
+		Sold:      now,
+		BuyPrice:  lot.price,
+		SellPrice: price,
+		CostBasis: lot.price*lot.quantity + lot.fees,
+		Proceeds:  price*lot.quantity - fee,
+		Fees:      lot.fees + fee,
+		Exit:      intent.Exit,
+	}
+	closed.PnL = closed.Proceeds - closed.CostBasis
+	t.ledger = append(t.ledger, closed)
+	t.log.WithFields(orderFields(intent)).WithField("pnl", closed.PnL).Infof("Lot %v closed after %v", lot.id, closed.HoldingPeriod())
+	if err := t.saveLedger(); err != nil {
+		t.log.Warn("WARN: unable to save ledger: ", err)
+	}
+	return closed
+}
+
+// closeLoop records a traded arbitrage loop in the ledger. The symbol of the
+// entry is the route of the loop and its amounts are in the start asset, the
+// last asset of the route
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) closeLoop(r ArbitrageResult, started, now time.Time) ClosedLot {
+	closed := ClosedLot{
+		Symbol:    r.Opportunity.Route(),
+		Quantity:  r.Spent,
+		Bought:    started,
+		Sold:      now,
+		CostBasis: r.Spent,
+		Proceeds:  r.Returned - (r.Fees - r.taken),
+		Fees:      r.Fees,
+	}
+	closed.PnL = closed.Proceeds - closed.CostBasis
+	t.ledger = append(t.ledger, closed)
+	t.log.WithField("pnl", closed.PnL).Infof("Arbitrage %v closed", closed.Symbol)
+	if err := t.saveLedger(); err != nil {
+		t.log.Warn("WARN: unable to save ledger: ", err)
+	}
+	return closed
+}
+
+// loadLedger loads the ledger from the state directory
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) loadLedger() error {
+	var ledger []ClosedLot
+	err := persist.LoadJSON(ledgerMetadata, &ledger, filepath.Join(t.persistDir, ledgerFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	t.ledger = ledger
+	return nil
+}
+
+// saveLedger saves the ledger to the state directory, it does nothing if the
+// state has not been loaded
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) saveLedger() error {
+	if t.persistDir == "" {
+		return nil
+	}
+	return persist.SaveJSON(ledgerMetadata, t.ledger, filepath.Join(t.persistDir, ledgerFile))
+}
@@ -0,0 +1,73 @@
+package trader
+
+// this file contains the lot selection policies of the trader. The lots on
+// the buy order heap are passed to the strategy in the order of the policy so
+// the strategy sells the lot the policy matches first, or with the specific
+// lot policy the strategy picks the lot to sell itself.
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LotPolicy is the order the lots of a symbol are matched against sells in
+type LotPolicy string
+
+const (
+	// LotFIFO sells the first lot bought first
+	LotFIFO LotPolicy = "fifo"
+
+	// LotLIFO sells the last lot bought first
+	LotLIFO LotPolicy = "lifo"
+
+	// LotLowest sells the lowest priced lot first
+	LotLowest LotPolicy = "lowest"
+
+	// LotHighest sells the highest priced lot first
+	LotHighest LotPolicy = "highest"
+
+	// LotSpecific lets the strategy pick the lot each sell closes, the lots
+	// are passed lowest price first
+	LotSpecific LotPolicy = "specific"
+)
+
+// DefaultLotPolicy is the default lot selection policy
+const DefaultLotPolicy = LotLowest
+
+// LotPolicies returns the names of the lot selection policies
+func LotPolicies() []string {
+	return []string{string(LotFIFO), string(LotLIFO), string(LotLowest), string(LotHighest), string(LotSpecific)}
+}
+
+// Validate returns an error if the policy is unknown
+func (p LotPolicy) Validate() error {
+	switch p {
+	case LotFIFO, LotLIFO, LotLowest, LotHighest, LotSpecific:
+		return nil
+	}
+	return fmt.Errorf("unknown lot policy %q, expected one of %v", p, LotPolicies())
+}
+
+// SetLotPolicy sets the order the lots are passed to the strategy in
+func (t *Trader) SetLotPolicy(policy LotPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lotPolicy = policy
+}
+
+// sortLots sorts the lots in the order of the policy. Lot IDs increase with
+// every buy so they order the lots by when they were bought
+func sortLots(lots []Lot, policy LotPolicy) {
+	var less func(a, b Lot) bool
+	switch policy {
+	case LotFIFO:
+		less = func(a, b Lot) bool { return a.ID < b.ID }
+	case LotLIFO:
+		less = func(a, b Lot) bool { return a.ID > b.ID }
+	case LotHighest:
+		less = func(a, b Lot) bool { return a.Price > b.Price || (a.Price == b.Price && a.ID < b.ID) }
+	default:
+		less = func(a, b Lot) bool { return a.Price < b.Price || (a.Price == b.Price && a.ID < b.ID) }
+	}
+	sort.SliceStable(lots, func(i, j int) bool { return less(lots[i], lots[j]) })
+}
@@ -0,0 +1,394 @@
+package trader
+
+// this file contains the order manager of the trader. Orders are tracked from
+// submission until they reach a final status by polling the exchange. Fills
+// are only recorded, and lots only added to the buy order heap, with the
+// quantity that was actually executed and the average price it was executed
+// at. Orders that rest on the book for too long are canceled and repriced at
+// the current price.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// errNoOrderID is returned when the exchange does not return an order id
+	// for a submitted order
+	errNoOrderID = errors.New("no order id returned for order")
+
+	// errNoStopOrders is returned when an exit is placed on an exchange that
+	// does not support stop limit orders
+	errNoStopOrders = errors.New("exchange does not support stop limit orders")
+
+	// errUnderMinimums is returned when an order is under the minimum
+	// quantity or notional of its symbol once it is rounded
+	errUnderMinimums = errors.New("order is under the exchange minimums")
+)
+
+// DefaultOrderConfig is the default configuration of the order manager
+var DefaultOrderConfig = OrderConfig{
+	Timeout:     2 * time.Minute,
+	MaxReprices: 3,
+}
+
+// OrderConfig is the configuration of the order manager
+type OrderConfig struct {
+	// Timeout is how long an order can be open before it is canceled
+	Timeout time.Duration
+
+	// MaxReprices is how many times the unfilled quantity of a canceled order
+	// is resubmitted at the current price before the order is given up on
+	MaxReprices int
+}
+
+// OpenOrder is an order the trader submitted that has not reached a final
+// status
+type OpenOrder struct {
+	OrderID   int             `json:"orderid"`
+	Intent    OrderIntent     `json:"intent"`
+	Price     float64         `json:"price"`
+	Quantity  float64         `json:"quantity"`
+	Status    api.OrderStatus `json:"status"`
+	Submitted time.Time       `json:"submitted"`
+
+	// ExecutedQty and ExecutedQuote are the quantity executed so far and
+	// its cost in the quote asset, including the executions of the orders
+	// this order repriced
+	ExecutedQty   float64 `json:"executedqty"`
+	ExecutedQuote float64 `json:"executedquote"`
+
+	// PriorQty and PriorQuote are the executions of the orders this order
+	// repriced
+	PriorQty   float64 `json:"priorqty"`
+	PriorQuote float64 `json:"priorquote"`
+
+	// PriorOrderIDs are the ids of the orders this order repriced, the
+	// commission paid on them is recorded with this order
+	PriorOrderIDs []int `json:"priororderids,omitempty"`
+
+	// Reprices is the number of times the order has been repriced
+	Reprices int `json:"reprices"`
+}
+
+// SetOrderConfig sets the configuration of the order manager
+func (t *Trader) SetOrderConfig(config OrderConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.orderConfig = config
+}
+
+// OpenOrders returns the orders that have not reached a final status
+func (t *Trader) OpenOrders() []OpenOrder {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	orders := make([]OpenOrder, 0, len(t.openOrders))
+	for _, o := range t.openOrders {
+		orders = append(orders, *o)
+	}
+	return orders
+}
+
+// submit places the order for an intent and tracks it until it reaches a
+// final status. The quantity and prices are rounded to the lot and tick sizes
+// of the symbol first
+func (t *Trader) submit(ex Exchange, intent OrderIntent, prior OpenOrder) error {
+	t.mu.Lock()
+	intent, valid := t.roundIntent(intent)
+	t.mu.Unlock()
+	var result api.Result
+	var err error
+	if valid {
+		result, err = t.post(ex, intent)
+	} else {
+		err = errUnderMinimums
+	}
+	if api.IsUnknownStatus(err) {
+		result, err = t.placedOrder(ex, intent, err)
+	}
+	if err == nil && result.OrderID == 0 {
+		err = errNoOrderID
+	}
+	if err != nil {
+		t.log.WithFields(orderFields(intent)).Warn("WARN: order rejected: ", err)
+		// Record what the repriced order executed before it was canceled
+		if prior.OrderID != 0 {
+			t.finish(ex, &prior, api.OrderStatusCanceled)
+		}
+		return err
+	}
+	t.mu.Lock()
+	t.recordOrder(time.Now())
+	t.mu.Unlock()
+	qty, price, err := result.Executed()
+	if err != nil {
+		t.log.WithFields(orderFields(intent)).WithField("orderID", result.OrderID).Warn("WARN: unable to read submitted order: ", err)
+		return err
+	}
+
+	o := &OpenOrder{
+		OrderID:    result.OrderID,
+		Intent:     intent,
+		Price:      intent.Price,
+		Quantity:   intent.Quantity,
+		Status:     result.Status,
+		Submitted:  time.Now(),
+		PriorQty:   prior.ExecutedQty,
+		PriorQuote: prior.ExecutedQuote,
+		Reprices:   prior.Reprices,
+	}
+	if prior.OrderID != 0 {
+		o.PriorOrderIDs = append(append([]int{}, prior.PriorOrderIDs...), prior.OrderID)
+	}
+	o.update(qty, qty*price)
+	t.log.WithFields(orderFields(intent)).WithField("orderID", o.OrderID).Infof("Order submitted with status %v", o.Status)
+	// The order repriced is tracked by this order from now on
+	if prior.OrderID != 0 {
+		t.mu.Lock()
+		delete(t.openOrders, prior.OrderID)
+		t.mu.Unlock()
+	}
+	if o.Status.Final() {
+		t.finish(ex, o, o.Status)
+		return nil
+	}
+
+	t.mu.Lock()
+	t.openOrders[o.OrderID] = o
+	t.mu.Unlock()
+	t.saveState()
+	return nil
+}
+
+// roundIntent rounds the quantity of an intent down to the lot size of its
+// symbol and the prices down to the tick size, false is returned if the order
+// is under the minimum quantity or notional
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) roundIntent(intent OrderIntent) (OrderIntent, bool) {
+	rules := t.rules[intent.Symbol]
+	intent.Quantity = rules.RoundQuantity(intent.Quantity)
+	intent.Price = rules.RoundPrice(intent.Price)
+	intent.StopPrice = rules.RoundPrice(intent.StopPrice)
+	return intent, rules.Valid(intent.Quantity, intent.Price)
+}
+
+// openOrderSource is implemented by exchanges that list the open orders
+type openOrderSource interface {
+	GetOpenOrders() ([]api.Order, error)
+}
+
+// placedOrder looks for the order of an intent the exchange couldn't say was
+// placed, an open order of the intent that isn't tracked yet. The error of the
+// post is returned if there is no such order so the intent is decided on again
+func (t *Trader) placedOrder(ex Exchange, intent OrderIntent, postErr error) (api.Result, error) {
+	source, ok := ex.(openOrderSource)
+	if !ok {
+		return api.Result{}, postErr
+	}
+	orders, err := source.GetOpenOrders()
+	if err != nil {
+		t.log.WithFields(orderFields(intent)).Warn("WARN: unable to look for an order with an unknown status: ", err)
+		return api.Result{}, postErr
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, o := range orders {
+		if _, tracked := t.openOrders[o.OrderID]; tracked || o.Symbol != intent.Symbol || o.Side != intent.Side {
+			continue
+		}
+		price, _ := strconv.ParseFloat(o.Price, 64)
+		qty, _ := strconv.ParseFloat(o.OrigQty, 64)
+		if math.Abs(price-intent.Price) > 1e-9*price || math.Abs(qty-intent.Quantity) > 1e-9*qty {
+			continue
+		}
+		return api.Result{
+			OrderRespHeader:     api.OrderRespHeader{Symbol: o.Symbol, OrderID: o.OrderID, ClientOrderID: o.ClientOrderID},
+			Price:               o.Price,
+			OrigQty:             o.OrigQty,
+			ExecutedQty:         o.ExecutedQty,
+			CummulativeQuoteQty: o.CummulativeQuoteQty,
+			Status:              o.Status,
+			TimeInForce:         o.TimeInForce,
+			Type:                o.Type,
+			Side:                o.Side,
+		}, nil
+	}
+	return api.Result{}, postErr
+}
+
+// post posts the order of an intent, exits with a stop price are posted as
+// stop limit orders
+func (t *Trader) post(ex Exchange, intent OrderIntent) (api.Result, error) {
+	if intent.StopPrice == 0 {
+		return ex.PostNewLimitOrder(intent.Symbol, intent.Side, intent.Quantity, intent.Price)
+	}
+	so, ok := ex.(stopOrderer)
+	if !ok {
+		return api.Result{}, errNoStopOrders
+	}
+	orderType := api.OrderTypeStopLossLimit
+	if intent.Exit == ExitTakeProfit {
+		orderType = api.OrderTypeTakeProfitLimit
+	}
+	return so.PostNewStopLimitOrder(intent.Symbol, intent.Side, orderType, intent.Quantity, intent.Price, intent.StopPrice)
+}
+
+// update sets the executions of the order from the exchange, adding the
+// executions of the orders it repriced
+func (o *OpenOrder) update(qty, quote float64) {
+	o.ExecutedQty = o.PriorQty + qty
+	o.ExecutedQuote = o.PriorQuote + quote
+}
+
+// PollOrders polls the exchange for the status of the open orders. Orders that
+// reached a final status are recorded and orders that have been open longer
+// than the timeout are canceled and repriced at the current prices. An order
+// that can't be polled or repriced is logged and the rest are still polled,
+// the error returned counts the orders that failed
+func (t *Trader) PollOrders(ex Exchange, prices map[string]float64, now time.Time) error {
+	orders := t.OpenOrders()
+	var failed int
+	var first error
+	for _, open := range orders {
+		if err := t.pollOrder(ex, open, prices, now); err != nil {
+			t.log.WithFields(orderFields(open.Intent)).WithField("orderID", open.OrderID).Warn("WARN: unable to poll order: ", err)
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v open orders not polled: %v", failed, len(orders), first)
+	}
+	return nil
+}
+
+// pollOrder polls the exchange for the status of an open order, recording it
+// if it reached a final status and repricing it if it is stale
+func (t *Trader) pollOrder(ex Exchange, open OpenOrder, prices map[string]float64, now time.Time) error {
+	order, err := ex.GetOrder(open.Intent.Symbol, open.OrderID)
+	if err != nil {
+		return err
+	}
+	qty, price, err := order.Executed()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	o, ok := t.openOrders[open.OrderID]
+	if !ok {
+		t.mu.Unlock()
+		return nil
+	}
+	o.update(qty, qty*price)
+	if o.Status != order.Status {
+		t.log.WithField("orderID", o.OrderID).Infof("Order status changed from %v to %v", o.Status, order.Status)
+		o.Status = order.Status
+	}
+	config := t.orderConfig
+	t.mu.Unlock()
+
+	if order.Status.Final() {
+		t.finish(ex, o, order.Status)
+		return nil
+	}
+	if o.Intent.Resting || config.Timeout == 0 || now.Sub(o.Submitted) < config.Timeout {
+		return nil
+	}
+
+	// Cancel the stale order and reprice what was not filled. An order that
+	// can't be canceled is read again as it may have filled in the meantime,
+	// it is only repriced once it is known to be canceled
+	canceled, err := ex.CancelOrder(o.Intent.Symbol, o.OrderID)
+	if err != nil {
+		order, getErr := ex.GetOrder(o.Intent.Symbol, o.OrderID)
+		if getErr != nil || !order.Status.Final() {
+			return err
+		}
+		canceled = order
+	}
+	qty, price, err = canceled.Executed()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	o.update(qty, qty*price)
+	o.Status = api.OrderStatusCanceled
+	if canceled.Status == api.OrderStatusFilled {
+		o.Status = api.OrderStatusFilled
+	}
+	t.mu.Unlock()
+	if o.Status == api.OrderStatusFilled {
+		t.finish(ex, o, o.Status)
+		return nil
+	}
+	t.log.WithField("orderID", o.OrderID).Infof("Order canceled after being open for %v", now.Sub(o.Submitted))
+
+	remaining := o.Quantity - (o.ExecutedQty - o.PriorQty)
+	current, ok := prices[o.Intent.Symbol]
+	if o.Reprices >= config.MaxReprices || remaining <= 0 || !ok {
+		t.finish(ex, o, api.OrderStatusCanceled)
+		return nil
+	}
+	// The canceled order is saved until the order repricing it is tracked,
+	// so what it executed is recorded after a crash or a failed submit
+	t.saveState()
+
+	intent := o.Intent
+	intent.Quantity = remaining
+	intent.Price = current
+	prior := *o
+	prior.Reprices++
+	t.log.WithFields(orderFields(intent)).Infof("Repricing order %v from %v", o.OrderID, o.Price)
+	return t.submit(ex, intent, prior)
+}
+
+// finish stops tracking an order that reached a final status and records what
+// was executed with the commission paid on it
+func (t *Trader) finish(ex Exchange, o *OpenOrder, status api.OrderStatus) {
+	t.mu.Lock()
+	delete(t.openOrders, o.OrderID)
+	t.mu.Unlock()
+
+	t.log.WithFields(orderFields(o.Intent)).WithFields(logrus.Fields{
+		"orderID":  o.OrderID,
+		"executed": o.ExecutedQty,
+	}).Infof("Order finished with status %v", status)
+	if o.ExecutedQty > 0 {
+		commissions := t.orderCommissions(ex, o)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		defer t.persistState()
+		t.recordFill(o.Intent, o.ExecutedQuote/o.ExecutedQty, o.ExecutedQty, commissions)
+		return
+	}
+	t.saveState()
+}
+
+// saveState saves the trader state, taking the locks it needs
+func (t *Trader) saveState() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.persistState()
+}
+
+// orderFields returns the log fields of an intent
+func orderFields(intent OrderIntent) logrus.Fields {
+	return logrus.Fields{
+		"symbol":   intent.Symbol,
+		"side":     intent.Side,
+		"price":    intent.Price,
+		"quantity": intent.Quantity,
+		"lot":      intent.LotID,
+		"exit":     intent.Exit,
+	}
+}
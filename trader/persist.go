@@ -0,0 +1,225 @@
+package trader
+
+// this file contains the code for persisting the trader state. The buy order
+// heaps, the open orders, the price levels, the counters and the min balances
+// are saved to disk whenever an order is submitted or filled so the trader can
+// be restarted, or recover from a crash, without losing track of the lots it
+// bought.
+
+import (
+	"container/heap"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/NebulousLabs/Sia/persist"
+)
+
+var (
+	// stateMetadata is the metadata for the persisted file that stores the
+	// trader state
+	stateMetadata = persist.Metadata{
+		Header:  "Trader",
+		Version: "v1.1.0",
+	}
+
+	// stateMetadataV1 is the metadata of the trader state from before the
+	// trader traded multiple pairs, it only had a BTC min balance
+	stateMetadataV1 = persist.Metadata{
+		Header:  "Trader",
+		Version: "v1.0.0",
+	}
+
+	// stateFile is the filename for the persisted trader state
+	stateFile = "trader.json"
+)
+
+type (
+	// persistedState is the trader state that is persisted to disk
+	persistedState struct {
+		Strategy      string                 `json:"strategy"`
+		Lots          []Lot                  `json:"lots"`
+		LastLotID     uint64                 `json:"lastlotid"`
+		BuyerLevels   map[string]PriceLevels `json:"buyerlevels"`
+		SellerLevels  map[string]PriceLevels `json:"sellerlevels"`
+		NumberOfBuys  map[string]int         `json:"numberofbuys"`
+		NumberOfSells map[string]int         `json:"numberofsells"`
+		MinBalances   map[string]float64     `json:"minbalances"`
+		OpenOrders    []OpenOrder            `json:"openorders"`
+		Thresholds    map[string]Thresholds  `json:"thresholds,omitempty"`
+		Risk          *persistedRisk         `json:"risk,omitempty"`
+		Commissions   map[string]float64     `json:"commissions,omitempty"`
+		StrategyState json.RawMessage        `json:"strategystate,omitempty"`
+		Saved         time.Time              `json:"saved"`
+
+		// MinBalance is the BTC min balance of a v1.0.0 state
+		MinBalance float64 `json:"minbalance,omitempty"`
+	}
+
+	// persistedRisk is the risk manager state that is persisted to disk so a
+	// restart does not clear a halt or the loss of the day
+	persistedRisk struct {
+		Halted     bool      `json:"halted"`
+		HaltReason string    `json:"haltreason"`
+		Day        time.Time `json:"day"`
+		DailyPnL   float64   `json:"dailypnl"`
+		Peak       float64   `json:"peak"`
+	}
+)
+
+// Load loads the trader state from the directory and enables saving the state
+// to it. A trader without a saved state starts fresh
+func (t *Trader) Load(dir string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	t.persistDir = dir
+	if err := t.loadLedger(); err != nil {
+		return err
+	}
+
+	var state persistedState
+	filename := filepath.Join(dir, stateFile)
+	err := persist.LoadJSON(stateMetadata, &state, filename)
+	if err == persist.ErrBadVersion {
+		err = persist.LoadJSON(stateMetadataV1, &state, filename)
+		if state.MinBalance > 0 {
+			state.MinBalances = map[string]float64{"BTC": state.MinBalance}
+		}
+	}
+	if os.IsNotExist(err) {
+		t.log.Info("No saved trader state in ", dir)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if state.Strategy != t.strategy.Name() {
+		t.log.Warnf("Saved trader state is from the %v strategy, trading with %v", state.Strategy, t.strategy.Name())
+	} else if ss, ok := t.strategy.(statefulStrategy); ok && len(state.StrategyState) > 0 {
+		if err := ss.UnmarshalState(state.StrategyState); err != nil {
+			return err
+		}
+	}
+
+	// Rebuild the buy order heap of each pair
+	for _, lot := range state.Lots {
+		heap.Push(&t.pair(lot.Symbol).Buyer.orders, &order{
+			id:           lot.ID,
+			symbol:       lot.Symbol,
+			price:        lot.Price,
+			quantity:     lot.Quantity,
+			stopLoss:     lot.StopLoss,
+			takeProfit:   lot.TakeProfit,
+			trailingStop: lot.TrailingStop,
+			high:         lot.High,
+			bought:       lot.Bought,
+			fees:         lot.Fees,
+		})
+		if lot.ID > state.LastLotID {
+			state.LastLotID = lot.ID
+		}
+	}
+	t.lastLotID = state.LastLotID
+
+	for symbol, l := range state.BuyerLevels {
+		t.pair(symbol).Buyer.levels = l
+	}
+	for symbol, l := range state.SellerLevels {
+		t.pair(symbol).Seller.levels = l
+	}
+	for symbol, n := range state.NumberOfBuys {
+		t.numberOfBuys[symbol] = n
+	}
+	for symbol, n := range state.NumberOfSells {
+		t.numberOfSells[symbol] = n
+	}
+	for asset, bal := range state.MinBalances {
+		t.minBalances[asset] = bal
+	}
+	if r := state.Risk; r != nil {
+		t.risk.halted = r.Halted
+		t.risk.haltReason = r.HaltReason
+		t.risk.day = r.Day
+		t.risk.dailyPnL = r.DailyPnL
+		t.risk.peak = r.Peak
+		if r.Halted {
+			t.log.Warn("Trading is halted: ", r.HaltReason)
+		}
+	}
+	for asset, qty := range state.Commissions {
+		t.commissions[asset] = qty
+	}
+	for symbol, th := range state.Thresholds {
+		t.pair(symbol).adaptive.thresholds = th
+	}
+	for i := range state.OpenOrders {
+		o := state.OpenOrders[i]
+		t.openOrders[o.OrderID] = &o
+	}
+
+	t.log.Infof("Loaded trader state saved at %v with %v lots and %v open orders", state.Saved, len(state.Lots), len(state.OpenOrders))
+	return nil
+}
+
+// Save saves the trader state to disk. It does nothing if the state has not
+// been loaded
+func (t *Trader) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.save()
+}
+
+// save saves the trader state to disk. The state is written to a temporary
+// file that replaces the saved state so a crash mid write does not corrupt it
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) save() error {
+	if t.persistDir == "" {
+		return nil
+	}
+	state := persistedState{
+		Strategy:      t.strategy.Name(),
+		Lots:          t.lots(),
+		LastLotID:     t.lastLotID,
+		BuyerLevels:   make(map[string]PriceLevels),
+		SellerLevels:  make(map[string]PriceLevels),
+		NumberOfBuys:  t.numberOfBuys,
+		NumberOfSells: t.numberOfSells,
+		MinBalances:   t.minBalances,
+		Commissions:   t.commissions,
+		Risk: &persistedRisk{
+			Halted:     t.risk.halted,
+			HaltReason: t.risk.haltReason,
+			Day:        t.risk.day,
+			DailyPnL:   t.risk.dailyPnL,
+			Peak:       t.risk.peak,
+		},
+		Saved: time.Now(),
+	}
+	if ss, ok := t.strategy.(statefulStrategy); ok {
+		data, err := ss.MarshalState()
+		if err != nil {
+			return err
+		}
+		state.StrategyState = data
+	}
+	for _, o := range t.openOrders {
+		state.OpenOrders = append(state.OpenOrders, *o)
+	}
+	for symbol, p := range t.pairs {
+		state.BuyerLevels[symbol] = p.Buyer.levels
+		state.SellerLevels[symbol] = p.Seller.levels
+		if p.adaptive.thresholds != (Thresholds{}) {
+			if state.Thresholds == nil {
+				state.Thresholds = make(map[string]Thresholds)
+			}
+			state.Thresholds[symbol] = p.adaptive.thresholds
+		}
+	}
+	return persist.SaveJSON(stateMetadata, state, filepath.Join(t.persistDir, stateFile))
+}
@@ -0,0 +1,473 @@
+package trader
+
+// this file contains the rebalancing strategy. The strategy keeps the value of
+// a set of assets at target weights of the portfolio. A rebalance starts when
+// an asset drifts further than the threshold from its target or on a
+// cron-like schedule, and carries on until every asset is within the minimum
+// trade of its target. Each trade from an asset over its target to one under
+// it takes the route through the strategy's symbols that gives the most of the
+// asset bought after fees, a direct market or a route through one other
+// asset of the targets. Only the first leg of a route is placed, the asset it buys is then
+// over its target and is traded on once it has filled. No new trades are
+// planned while a rebalance order is on the book. A position sizer can cap the
+// orders, the rest of a trade is then made once they fill. The buys don't open
+// lots and the min balances of the trader limit how much of an asset can be
+// sold.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+const (
+	// RebalanceStrategyName is the name of the rebalancing strategy
+	RebalanceStrategyName = "rebalance"
+
+	// rebalanceTag is the tag of the rebalance orders
+	rebalanceTag = "rebalance"
+)
+
+// DefaultRebalanceConfig is the default configuration of the rebalancing
+// strategy, the targets have to be set for it to trade
+var DefaultRebalanceConfig = RebalanceConfig{
+	Symbols:    []string{api.BNBBTC, api.BNBUSDT, api.BTCUSDT},
+	ValueAsset: "USDT",
+	Threshold:  0.05,
+	MinTrade:   10,
+}
+
+// RebalanceConfig is the configuration of the rebalancing strategy
+type RebalanceConfig struct {
+	// Targets are the target weights of the assets keyed by asset, they add
+	// up to 1
+	Targets map[string]float64
+
+	// Symbols are the markets the trades are routed through and ValueAsset
+	// is the asset the portfolio is valued in
+	Symbols    []string
+	ValueAsset string
+
+	// Threshold is how far the weight of an asset can drift from its target
+	// before a rebalance and Schedule is the cron-like schedule of the
+	// calendar rebalances. Either is not used when zero
+	Threshold float64
+	Schedule  string
+
+	// MinTrade is the smallest trade in the value asset
+	MinTrade float64
+
+	// Sizer caps each order in the quote asset of its symbol, the rest of
+	// the trade is made on the next orders. The orders are not capped when
+	// it is nil
+	Sizer PositionSizer
+}
+
+// RebalancePlan is a preview of the trades that bring the portfolio back to
+// its targets
+type RebalancePlan struct {
+	// Total is the value of the assets in the value asset
+	Total  float64
+	Assets []RebalanceAsset
+	Trades []RebalanceTrade
+}
+
+// RebalanceAsset is the value and weight of an asset of the portfolio
+type RebalanceAsset struct {
+	Asset   string
+	Balance float64
+	Value   float64
+	Weight  float64
+	Target  float64
+}
+
+// Drift returns how far the weight is from the target
+func (a RebalanceAsset) Drift() float64 {
+	return a.Weight - a.Target
+}
+
+// RebalanceTrade is a trade from an asset over its target to one under it
+type RebalanceTrade struct {
+	From string
+	To   string
+
+	// Amount is the quantity of From sold and Value is its value
+	Amount float64
+	Value  float64
+
+	// Route are the legs of the trade and Received is the quantity of To
+	// bought after the fees of each leg at the current prices
+	Route    []RebalanceLeg
+	Received float64
+}
+
+// RebalanceLeg is an order of a trade
+type RebalanceLeg struct {
+	Symbol string
+	Side   api.Side
+}
+
+// String returns the route as a list of its symbols
+func (t RebalanceTrade) String() string {
+	symbols := make([]string, len(t.Route))
+	for i, leg := range t.Route {
+		symbols[i] = leg.Symbol
+	}
+	return fmt.Sprintf("%v %v to %v via %v, %v %v after fees", t.Amount, t.From, t.To, strings.Join(symbols, ","), t.Received, t.To)
+}
+
+// Validate returns an error if the portfolio can't be rebalanced
+func (c RebalanceConfig) Validate() error {
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("rebalance targets can't be empty")
+	}
+	var sum float64
+	for asset, weight := range c.Targets {
+		if weight < 0 || weight > 1 {
+			return fmt.Errorf("rebalance target %q must be between 0 and 1, got %v", asset, weight)
+		}
+		sum += weight
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		return fmt.Errorf("rebalance targets must add up to 1, got %v", sum)
+	}
+	for _, symbol := range c.Symbols {
+		if _, _, ok := api.SplitSymbol(symbol); !ok {
+			return fmt.Errorf("unknown rebalance symbol %q", symbol)
+		}
+	}
+	for asset := range c.Targets {
+		if asset != c.ValueAsset && len(c.routes(asset, c.ValueAsset)) == 0 {
+			return fmt.Errorf("rebalance asset %q has no route to %v through %v", asset, c.ValueAsset, c.Symbols)
+		}
+	}
+	if c.Threshold < 0 || c.Threshold >= 1 {
+		return fmt.Errorf("rebalance threshold must be between 0 and 1, got %v", c.Threshold)
+	}
+	if c.Schedule != "" {
+		schedule, err := ParseSchedule(c.Schedule)
+		if err != nil {
+			return err
+		}
+		if schedule.Next(time.Now()).IsZero() {
+			return fmt.Errorf("rebalance schedule %q never runs", c.Schedule)
+		}
+	}
+	if c.Threshold == 0 && c.Schedule == "" {
+		return fmt.Errorf("rebalance needs a threshold or a schedule")
+	}
+	if c.MinTrade < 0 {
+		return fmt.Errorf("rebalance min trade can't be negative, got %v", c.MinTrade)
+	}
+	return nil
+}
+
+// routes returns the routes from an asset to another through the symbols, the
+// direct markets and the routes through one other asset. Only the assets of
+// the targets are routed through, an asset without a target would not be
+// traded on once the first leg filled
+func (c RebalanceConfig) routes(from, to string) [][]RebalanceLeg {
+	var routes [][]RebalanceLeg
+	for _, first := range c.legs(from) {
+		next := legAsset(first)
+		if next == to {
+			routes = append(routes, []RebalanceLeg{first})
+			continue
+		}
+		if _, ok := c.Targets[next]; !ok {
+			continue
+		}
+		for _, second := range c.legs(next) {
+			if legAsset(second) == to {
+				routes = append(routes, []RebalanceLeg{first, second})
+			}
+		}
+	}
+	return routes
+}
+
+// legs returns the orders that sell the asset in the symbols
+func (c RebalanceConfig) legs(asset string) []RebalanceLeg {
+	var legs []RebalanceLeg
+	for _, symbol := range c.Symbols {
+		base, quote, _ := api.SplitSymbol(symbol)
+		if base == asset {
+			legs = append(legs, RebalanceLeg{Symbol: symbol, Side: api.SideSell})
+		} else if quote == asset {
+			legs = append(legs, RebalanceLeg{Symbol: symbol, Side: api.SideBuy})
+		}
+	}
+	return legs
+}
+
+// legAsset returns the asset the leg buys
+func legAsset(leg RebalanceLeg) string {
+	base, quote, _ := api.SplitSymbol(leg.Symbol)
+	if leg.Side == api.SideBuy {
+		return base
+	}
+	return quote
+}
+
+// rebalanceState is the state of the rebalancing strategy, it is saved with
+// the trader state
+type rebalanceState struct {
+	// Schedule is the schedule Next was set from, Next is reset when the
+	// schedule changes
+	Schedule string    `json:"schedule"`
+	Next     time.Time `json:"next"`
+
+	// Active is set while a rebalance is in progress
+	Active bool `json:"active"`
+
+	schedule Schedule
+}
+
+// RebalanceStrategy is the portfolio rebalancing strategy
+type RebalanceStrategy struct {
+	config RebalanceConfig
+	state  rebalanceState
+
+	// plan is the plan of the time step being decided, every symbol of the
+	// step places its legs of the same plan
+	plan    RebalancePlan
+	planned time.Time
+}
+
+// NewRebalanceStrategy returns a new rebalancing strategy
+func NewRebalanceStrategy(config RebalanceConfig) *RebalanceStrategy {
+	return &RebalanceStrategy{config: config}
+}
+
+// SetConfig changes the configuration of the strategy. It must not be called
+// while the strategy is deciding
+func (rs *RebalanceStrategy) SetConfig(config RebalanceConfig) {
+	rs.config = config
+}
+
+// Name implements the Strategy interface
+func (rs *RebalanceStrategy) Name() string { return RebalanceStrategyName }
+
+// Symbols implements the Strategy interface
+func (rs *RebalanceStrategy) Symbols() []string { return rs.config.Symbols }
+
+// Decide implements the Strategy interface. The plan is made once per time
+// step and the first leg of each of its trades is placed when its symbol is
+// decided on
+func (rs *RebalanceStrategy) Decide(market MarketData, portfolio PortfolioState) []OrderIntent {
+	if rs.config.Validate() != nil {
+		return nil
+	}
+	if !market.Time.Equal(rs.planned) {
+		rs.planned = market.Time
+		rs.plan = rs.nextPlan(market, portfolio)
+	}
+
+	var intents []OrderIntent
+	for _, trade := range rs.plan.Trades {
+		leg := trade.Route[0]
+		if leg.Symbol != market.Symbol {
+			continue
+		}
+		qty := trade.Amount
+		if leg.Side == api.SideBuy {
+			qty /= market.Price
+		}
+		if rs.config.Sizer != nil {
+			amount, ok := rs.config.Sizer.Size(market, portfolio, market.Price)
+			if !ok {
+				continue
+			}
+			qty = math.Min(qty, amount/market.Price)
+		}
+		qty = market.Rules.RoundQuantity(qty)
+		if qty <= 0 || !market.Rules.Valid(qty, market.Price) {
+			continue
+		}
+		intents = append(intents, OrderIntent{
+			Symbol:   market.Symbol,
+			Side:     leg.Side,
+			Quantity: qty,
+			Price:    market.Price,
+			NoLot:    leg.Side == api.SideBuy,
+			Tag:      rebalanceTag,
+			Reason:   "rebalance " + trade.String(),
+		})
+	}
+	return intents
+}
+
+// nextPlan returns the plan of a time step, it has no trades unless a
+// rebalance is in progress or triggered
+func (rs *RebalanceStrategy) nextPlan(market MarketData, portfolio PortfolioState) RebalancePlan {
+	for _, intent := range portfolio.Pending {
+		if intent.Tag == rebalanceTag {
+			return RebalancePlan{}
+		}
+	}
+	due := rs.scheduled(market.Time)
+	plan := rs.Preview(portfolio.Balances, portfolio.MinBalances, market.Prices, portfolio.Fees)
+	drifted := false
+	for _, a := range plan.Assets {
+		if rs.config.Threshold > 0 && math.Abs(a.Drift()) >= rs.config.Threshold {
+			drifted = true
+		}
+	}
+	if !rs.state.Active && !due && !drifted {
+		return RebalancePlan{}
+	}
+	rs.state.Active = len(plan.Trades) > 0
+	return plan
+}
+
+// scheduled returns true if a calendar rebalance is due. Rebalances missed
+// while the trader was stopped are only made once
+func (rs *RebalanceStrategy) scheduled(now time.Time) bool {
+	s := &rs.state
+	if rs.config.Schedule == "" {
+		return false
+	}
+	if s.Schedule != rs.config.Schedule || s.schedule.String() != rs.config.Schedule {
+		schedule, err := ParseSchedule(rs.config.Schedule)
+		if err != nil {
+			return false
+		}
+		if s.Schedule != rs.config.Schedule {
+			s.Next = time.Time{}
+		}
+		s.Schedule, s.schedule = rs.config.Schedule, schedule
+	}
+	if s.Next.IsZero() {
+		s.Next = s.schedule.Next(now)
+		return false
+	}
+	if now.Before(s.Next) {
+		return false
+	}
+	s.Next = s.schedule.Next(now)
+	return true
+}
+
+// Preview returns the weights of the assets and the trades that bring them
+// back to their targets at the prices. The balances above the min balances
+// can be sold and trades smaller than the min trade are left out
+func (rs *RebalanceStrategy) Preview(balances, minBalances, prices map[string]float64, fees Fees) RebalancePlan {
+	c := rs.config
+	snapshot := api.TickerSnapshot{Prices: prices}
+	var plan RebalancePlan
+	rates := make(map[string]float64)
+	for asset, target := range c.Targets {
+		rate := 1.0
+		if asset != c.ValueAsset {
+			var ok bool
+			if rate, ok = snapshot.Rate(asset, c.ValueAsset); !ok {
+				return RebalancePlan{}
+			}
+		}
+		rates[asset] = rate
+		a := RebalanceAsset{Asset: asset, Balance: balances[asset], Value: balances[asset] * rate, Target: target}
+		plan.Total += a.Value
+		plan.Assets = append(plan.Assets, a)
+	}
+	if plan.Total <= 0 {
+		return RebalancePlan{}
+	}
+	sort.Slice(plan.Assets, func(i, j int) bool { return plan.Assets[i].Asset < plan.Assets[j].Asset })
+
+	// The value each asset is over or under its target
+	type gap struct {
+		asset string
+		value float64
+	}
+	var over, under []gap
+	for i := range plan.Assets {
+		a := &plan.Assets[i]
+		a.Weight = a.Value / plan.Total
+		diff := a.Value - a.Target*plan.Total
+		if sellable := (a.Balance - minBalances[a.Asset]) * rates[a.Asset]; diff > sellable {
+			diff = sellable
+		}
+		if diff >= c.MinTrade && diff > 0 {
+			over = append(over, gap{a.Asset, diff})
+		} else if -diff >= c.MinTrade && diff < 0 {
+			under = append(under, gap{a.Asset, -diff})
+		}
+	}
+	sort.SliceStable(over, func(i, j int) bool { return over[i].value > over[j].value })
+	sort.SliceStable(under, func(i, j int) bool { return under[i].value > under[j].value })
+
+	// Match the largest gaps first
+	for i, j := 0, 0; i < len(over) && j < len(under); {
+		value := math.Min(over[i].value, under[j].value)
+		over[i].value -= value
+		under[j].value -= value
+		if value >= c.MinTrade && value > 0 {
+			if trade, ok := rs.route(over[i].asset, under[j].asset, value/rates[over[i].asset], snapshot, fees); ok {
+				trade.Value = value
+				plan.Trades = append(plan.Trades, trade)
+			}
+		}
+		if over[i].value <= under[j].value {
+			i++
+		} else {
+			j++
+		}
+	}
+	return plan
+}
+
+// route returns the trade of the amount of an asset with the route that
+// receives the most of the other asset after the taker fee of each leg
+func (rs *RebalanceStrategy) route(from, to string, amount float64, snapshot api.TickerSnapshot, fees Fees) (RebalanceTrade, bool) {
+	best := RebalanceTrade{From: from, To: to, Amount: amount}
+	for _, route := range rs.config.routes(from, to) {
+		received := amount
+		for _, leg := range route {
+			price, ok := snapshot.Price(leg.Symbol)
+			if !ok {
+				received = 0
+				break
+			}
+			if leg.Side == api.SideBuy {
+				received /= price
+			} else {
+				received *= price
+			}
+			received *= 1 - fees.Taker
+		}
+		if received > best.Received {
+			best.Route, best.Received = route, received
+		}
+	}
+	return best, best.Received > 0
+}
+
+// MarshalState implements the statefulStrategy interface
+func (rs *RebalanceStrategy) MarshalState() ([]byte, error) {
+	return json.Marshal(rs.state)
+}
+
+// UnmarshalState implements the statefulStrategy interface
+func (rs *RebalanceStrategy) UnmarshalState(data []byte) error {
+	return json.Unmarshal(data, &rs.state)
+}
+
+// PreviewRebalance returns the plan of the rebalancing strategy with the
+// balances and fees of the trader at the prices
+func (t *Trader) PreviewRebalance(prices map[string]float64) (RebalancePlan, error) {
+	rs, ok := t.strategy.(*RebalanceStrategy)
+	if !ok {
+		return RebalancePlan{}, fmt.Errorf("strategy %v doesn't rebalance", t.strategy.Name())
+	}
+	if err := rs.config.Validate(); err != nil {
+		return RebalancePlan{}, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return rs.Preview(t.balances, t.minBalances, prices, t.fees()), nil
+}
@@ -0,0 +1,333 @@
+package trader
+
+// this file contains the risk manager of the trader. Every order intent has to
+// pass the position, open lot and order rate limits before it is submitted,
+// an intent over them is dropped. The realized loss of the day and the
+// drawdown of the equity from its peak are checked every step, breaching them
+// halts the buys until the halt is cleared and notifies the subscribers so an
+// alert can be sent. Sells, ie the exits of the lots, are still made while
+// halted.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+// riskQuote is the asset the equity and realized profit are valued in
+const riskQuote = "USDT"
+
+// RiskConfig is the configuration of the risk manager. Limits that are 0 are
+// not enforced
+type RiskConfig struct {
+	// MaxDailyLoss is the most that can be lost on the lots sold in a UTC
+	// day, valued in USDT
+	MaxDailyLoss float64
+
+	// MaxDrawdown is the largest fraction the equity can fall from its peak
+	MaxDrawdown float64
+
+	// MaxPositions is the most of each asset that can be held, including
+	// the open buys, keyed by asset
+	MaxPositions map[string]float64
+
+	// MaxOpenLots is the most lots that can be open across all pairs,
+	// including the open buys
+	MaxOpenLots int
+
+	// MaxOrdersPerHour is the most orders that can be submitted in an hour
+	MaxOrdersPerHour int
+}
+
+// RiskEvent is emitted when the daily loss or drawdown limit is breached and
+// trading is halted
+type RiskEvent struct {
+	Time   time.Time
+	Limit  string
+	Reason string
+}
+
+// riskState is the state of the risk manager
+type riskState struct {
+	halted     bool
+	haltReason string
+
+	// orders are the times of the orders submitted in the last hour
+	orders []time.Time
+
+	// dailyPnL is the profit realized in the UTC day starting at day and
+	// peak is the highest equity seen, both valued in USDT
+	day      time.Time
+	dailyPnL float64
+	peak     float64
+
+	subscribers []func(RiskEvent)
+}
+
+// riskBreach is a breached risk limit
+type riskBreach struct {
+	limit  string
+	reason string
+}
+
+// Error implements the error interface
+func (b riskBreach) Error() string {
+	return b.limit + " breached: " + b.reason
+}
+
+// SetRiskConfig sets the configuration of the risk manager
+func (t *Trader) SetRiskConfig(config RiskConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.riskConfig = config
+}
+
+// SubscribeRisk registers a function that is called when trading is halted.
+// The function is called in its own goroutine
+func (t *Trader) SubscribeRisk(f func(RiskEvent)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.risk.subscribers = append(t.risk.subscribers, f)
+}
+
+// Halted returns true and the reason if trading has been halted by the risk
+// manager
+func (t *Trader) Halted() (bool, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.risk.halted, t.risk.haltReason
+}
+
+// ClearHalt resumes trading after it was halted by the risk manager. The
+// equity peak is reset so a drawdown halt is not immediately hit again
+func (t *Trader) ClearHalt() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.risk.halted {
+		return
+	}
+	t.log.Info("Trading halt cleared: ", t.risk.haltReason)
+	t.risk.halted = false
+	t.risk.haltReason = ""
+	t.risk.peak = 0
+	t.persistState()
+}
+
+// halt halts trading and notifies the subscribers
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) halt(now time.Time, breach riskBreach) {
+	if t.risk.halted {
+		return
+	}
+	t.risk.halted = true
+	t.risk.haltReason = breach.Error()
+	t.log.Warn("Trading halted: ", t.risk.haltReason)
+	event := RiskEvent{Time: now, Limit: breach.limit, Reason: breach.reason}
+	for _, f := range t.risk.subscribers {
+		go f(event)
+	}
+	t.persistState()
+}
+
+// checkRisk checks the realized loss of the day and the drawdown of the
+// equity and halts trading if a limit is breached. It returns true if trading
+// is halted
+func (t *Trader) checkRisk(now time.Time, prices map[string]float64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.risk.halted {
+		return true
+	}
+	config := t.riskConfig
+	t.rollDay(now)
+	if config.MaxDailyLoss > 0 && -t.risk.dailyPnL > config.MaxDailyLoss {
+		t.halt(now, riskBreach{
+			limit:  "max daily loss",
+			reason: fmt.Sprintf("realized loss of %v %v today is over the limit of %v", -t.risk.dailyPnL, riskQuote, config.MaxDailyLoss),
+		})
+		return true
+	}
+
+	equity, ok := t.equity(prices)
+	if !ok {
+		return false
+	}
+	if equity > t.risk.peak {
+		t.risk.peak = equity
+	}
+	if config.MaxDrawdown > 0 && t.risk.peak > 0 {
+		drawdown := (t.risk.peak - equity) / t.risk.peak
+		if drawdown > config.MaxDrawdown {
+			t.halt(now, riskBreach{
+				limit:  "max drawdown",
+				reason: fmt.Sprintf("equity of %v %v is %v below the peak of %v", equity, riskQuote, formatPercent(drawdown), t.risk.peak),
+			})
+			return true
+		}
+	}
+	return false
+}
+
+// checkIntent checks that an order intent is within the position, open lot
+// and order rate limits and that it isn't a buy while trading is halted
+func (t *Trader) checkIntent(now time.Time, intent OrderIntent) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.risk.halted && intent.Side == api.SideBuy {
+		return riskBreach{limit: "halt", reason: t.risk.haltReason}
+	}
+	if breach, ok := t.breach(now, intent); ok {
+		return breach
+	}
+	return nil
+}
+
+// checkIntents checks that the intents placed one after the other are within
+// the limits, each counting the orders placed before it. Nothing is placed
+// while trading is halted
+func (t *Trader) checkIntents(now time.Time, intents []OrderIntent) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.risk.halted {
+		return riskBreach{limit: "halt", reason: t.risk.haltReason}
+	}
+	orders := t.risk.orders
+	defer func() { t.risk.orders = orders }()
+	for _, intent := range intents {
+		if breach, ok := t.breach(now, intent); ok {
+			return breach
+		}
+		n := len(t.risk.orders)
+		t.risk.orders = append(t.risk.orders[:n:n], now)
+	}
+	return nil
+}
+
+// breach returns the limit the intent would breach
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) breach(now time.Time, intent OrderIntent) (riskBreach, bool) {
+	config := t.riskConfig
+
+	// Orders per hour
+	i := 0
+	for i < len(t.risk.orders) && now.Sub(t.risk.orders[i]) >= time.Hour {
+		i++
+	}
+	t.risk.orders = t.risk.orders[i:]
+	if config.MaxOrdersPerHour > 0 && len(t.risk.orders) >= config.MaxOrdersPerHour {
+		return riskBreach{
+			limit:  "max orders per hour",
+			reason: fmt.Sprintf("%v orders submitted in the last hour, the limit is %v", len(t.risk.orders), config.MaxOrdersPerHour),
+		}, true
+	}
+	if intent.Side != api.SideBuy {
+		return riskBreach{}, false
+	}
+
+	// Position of the asset bought
+	p := t.pair(intent.Symbol)
+	if max, ok := config.MaxPositions[p.Base]; ok && max > 0 {
+		position := t.balances[p.Base] + intent.Quantity
+		for _, o := range t.openOrders {
+			if o.Intent.Side == api.SideBuy && t.pair(o.Intent.Symbol).Base == p.Base {
+				position += o.Quantity - o.ExecutedQty
+			}
+		}
+		if position > max {
+			return riskBreach{
+				limit:  "max position",
+				reason: fmt.Sprintf("%v position of %v would be over the limit of %v", p.Base, position, max),
+			}, true
+		}
+	}
+
+	// Open lots
+	if config.MaxOpenLots > 0 && !intent.NoLot {
+		lots := len(t.lots()) + 1
+		for _, o := range t.openOrders {
+			if o.Intent.Side == api.SideBuy && !o.Intent.NoLot {
+				lots++
+			}
+		}
+		if lots > config.MaxOpenLots {
+			return riskBreach{
+				limit:  "max open lots",
+				reason: fmt.Sprintf("%v open lots would be over the limit of %v", lots, config.MaxOpenLots),
+			}, true
+		}
+	}
+	return riskBreach{}, false
+}
+
+// recordOrder records the time an order was submitted for the order rate
+// limit
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) recordOrder(now time.Time) {
+	t.risk.orders = append(t.risk.orders, now)
+}
+
+// recordProfit records the profit realized in the asset, ie by selling a lot
+// in the quote asset of its pair
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) recordProfit(now time.Time, asset string, profit float64) {
+	snapshot := api.TickerSnapshot{Prices: t.prices}
+	rate, ok := snapshot.Rate(asset, riskQuote)
+	if !ok {
+		t.log.Warnf("WARN: no %v rate for %v, realized profit not recorded", riskQuote, asset)
+		return
+	}
+	t.rollDay(now)
+	t.risk.dailyPnL += profit * rate
+}
+
+// rollDay resets the realized profit at the start of a UTC day
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) rollDay(now time.Time) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	if !day.Equal(t.risk.day) {
+		t.risk.day = day
+		t.risk.dailyPnL = 0
+	}
+}
+
+// equity returns the value of the balances and the open orders in USDT. It
+// returns false if nothing could be valued
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) equity(prices map[string]float64) (float64, bool) {
+	holdings := make(map[string]float64, len(t.balances))
+	for asset, bal := range t.balances {
+		holdings[asset] += bal
+	}
+	// The balances of the open orders are locked and not in the free balances
+	for _, o := range t.openOrders {
+		p := t.pair(o.Intent.Symbol)
+		left := o.Quantity - o.ExecutedQty
+		if o.Intent.Side == api.SideBuy {
+			holdings[p.Quote] += left * o.Price
+		} else {
+			holdings[p.Base] += left
+		}
+	}
+	snapshot := api.TickerSnapshot{Prices: prices}
+	var equity float64
+	valued := false
+	for asset, qty := range holdings {
+		if qty == 0 {
+			continue
+		}
+		rate, ok := snapshot.Rate(asset, riskQuote)
+		if !ok {
+			continue
+		}
+		equity += qty * rate
+		valued = true
+	}
+	return equity, valued
+}
@@ -0,0 +1,156 @@
+package trader
+
+// this file contains the cron-like schedules used to time recurring orders.
+// A schedule has the five fields of a crontab line, minute, hour, day of the
+// month, month and day of the week, and is evaluated in UTC. Each field is
+// a "*", a number, a range like "1-5", a list like "1,15" or a step like
+// "*/15" or "0-30/10". The @hourly, @daily and @weekly aliases are also
+// accepted, and daily and weekly are the same as their aliases.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleAliases are the schedules that can be used by name
+var scheduleAliases = map[string]string{
+	"@hourly": "0 * * * *",
+	"@daily":  "0 0 * * *",
+	"daily":   "0 0 * * *",
+	"@weekly": "0 0 * * 0",
+	"weekly":  "0 0 * * 0",
+}
+
+// Schedule is a parsed cron-like schedule
+type Schedule struct {
+	spec string
+
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+
+	// anyDay and anyWeekday are set when the day fields are "*", when both
+	// are restricted a time matches either like cron
+	anyDay     bool
+	anyWeekday bool
+}
+
+// ParseSchedule parses a cron-like schedule
+func ParseSchedule(spec string) (Schedule, error) {
+	s := Schedule{spec: spec}
+	if alias, ok := scheduleAliases[strings.TrimSpace(spec)]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("schedule %q must have 5 fields, minute hour day month weekday", s.spec)
+	}
+	var err error
+	for _, f := range []struct {
+		name     string
+		field    string
+		min, max int
+		dest     *map[int]bool
+	}{
+		{"minute", fields[0], 0, 59, &s.minutes},
+		{"hour", fields[1], 0, 23, &s.hours},
+		{"day", fields[2], 1, 31, &s.days},
+		{"month", fields[3], 1, 12, &s.months},
+		{"weekday", fields[4], 0, 6, &s.weekdays},
+	} {
+		*f.dest, err = parseScheduleField(f.field, f.min, f.max)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("schedule %q %v: %v", s.spec, f.name, err)
+		}
+	}
+	s.anyDay = fields[2] == "*"
+	s.anyWeekday = fields[4] == "*"
+	return s, nil
+}
+
+// parseScheduleField parses a field of a schedule into the values it matches
+func parseScheduleField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%v-%v is outside of %v-%v", lo, hi, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// String returns the schedule as it was parsed
+func (s Schedule) String() string { return s.spec }
+
+// Next returns the first time after t that matches the schedule. It returns
+// the zero time if nothing matches within 5 years, ie for the 31st of February
+func (s Schedule) Next(t time.Time) time.Time {
+	if s.minutes == nil {
+		return time.Time{}
+	}
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !s.months[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !s.hours[t.Hour()]:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case !s.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay returns true if the day of t matches the day of the month and day
+// of the week fields
+func (s Schedule) matchDay(t time.Time) bool {
+	day := s.days[t.Day()]
+	weekday := s.weekdays[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
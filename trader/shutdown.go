@@ -0,0 +1,131 @@
+package trader
+
+// this file contains the shutdown routine of the trader. When the trader stops
+// the lots on the buy order heap and the orders on the book can be left as
+// they are, the open orders can be canceled, or resting take-profit sells can
+// be placed for every lot so the lots are still sold while the trader is not
+// running.
+
+import (
+	"fmt"
+
+	"github.com/MSevey/traderbot/api"
+)
+
+// ShutdownPolicy is what the trader does with its lots and open orders when it
+// shuts down
+type ShutdownPolicy string
+
+const (
+	// ShutdownLeave leaves the lots and open orders as they are
+	ShutdownLeave ShutdownPolicy = "leave"
+
+	// ShutdownCancel cancels all the open orders
+	ShutdownCancel ShutdownPolicy = "cancel"
+
+	// ShutdownTakeProfit places a resting take-profit sell for every lot on
+	// the buy order heap
+	ShutdownTakeProfit ShutdownPolicy = "take-profit"
+)
+
+// DefaultShutdownConfig is the default configuration of the shutdown routine
+var DefaultShutdownConfig = ShutdownConfig{
+	Policy:     ShutdownLeave,
+	TakeProfit: diffLimit,
+}
+
+// ShutdownConfig is the configuration of the shutdown routine
+type ShutdownConfig struct {
+	Policy ShutdownPolicy
+
+	// TakeProfit is the fraction above the lot price the take-profit sells
+	// are placed at
+	TakeProfit float64
+}
+
+// ShutdownPolicies returns the names of the shutdown policies
+func ShutdownPolicies() []string {
+	return []string{string(ShutdownLeave), string(ShutdownCancel), string(ShutdownTakeProfit)}
+}
+
+// Validate returns an error if the policy is unknown
+func (p ShutdownPolicy) Validate() error {
+	switch p {
+	case ShutdownLeave, ShutdownCancel, ShutdownTakeProfit:
+		return nil
+	}
+	return fmt.Errorf("unknown shutdown policy %q, expected one of %v", p, ShutdownPolicies())
+}
+
+// Shutdown applies the shutdown policy and saves the trader state. It should
+// only be called once the trading loop has stopped
+func (t *Trader) Shutdown(ex Exchange, config ShutdownConfig) error {
+	if err := config.Policy.Validate(); err != nil {
+		return err
+	}
+	t.log.Infof("Shutting down with the %v policy", config.Policy)
+
+	var err error
+	switch config.Policy {
+	case ShutdownCancel:
+		err = t.cancelOpenOrders(ex)
+	case ShutdownTakeProfit:
+		err = t.placeTakeProfits(ex, config.TakeProfit)
+	}
+	if saveErr := t.Save(); saveErr != nil {
+		t.log.Warn("WARN: unable to save trader state: ", saveErr)
+		if err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// cancelOpenOrders cancels all the open orders, recording what they executed
+// before they were canceled
+func (t *Trader) cancelOpenOrders(ex Exchange) error {
+	var firstErr error
+	for _, o := range t.OpenOrders() {
+		canceled, err := ex.CancelOrder(o.Intent.Symbol, o.OrderID)
+		if err != nil {
+			t.log.WithField("orderID", o.OrderID).Warn("WARN: unable to cancel order: ", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		qty, price, err := canceled.Executed()
+		if err != nil {
+			return err
+		}
+		o.update(qty, qty*price)
+		t.finish(ex, &o, api.OrderStatusCanceled)
+	}
+	return firstErr
+}
+
+// placeTakeProfits places a resting sell for every lot on the buy order heap
+// that is not already being sold or protected by an exit on the exchange. The
+// sells are not repriced so they rest on the book until the price reaches them
+func (t *Trader) placeTakeProfits(ex Exchange, takeProfit float64) error {
+	t.mu.Lock()
+	lots := t.availableLots(true)
+	t.mu.Unlock()
+
+	var firstErr error
+	for _, lot := range lots {
+		intent := OrderIntent{
+			Symbol:   lot.Symbol,
+			Side:     api.SideSell,
+			Quantity: lot.Quantity,
+			Price:    lot.Price * (1 + takeProfit),
+			LotID:    lot.ID,
+			Resting:  true,
+			Reason:   "take-profit placed on shutdown",
+		}
+		if err := t.submit(ex, intent, OpenOrder{}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
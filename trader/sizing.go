@@ -0,0 +1,266 @@
+package trader
+
+// this file contains the position sizers. A sizer decides how much of the
+// quote asset a strategy buys at a time, a fixed amount, a fraction of the
+// equity, an amount that targets a volatility of the equity from the ATR, a
+// fraction of the Kelly criterion from the win rate and payoff of the ledger
+// or backtest, or the amount that loses at most a set amount at the stop
+// distance. Amounts from the equity are valued in USDT and converted to the
+// quote asset of the symbol. Every buy is rounded to the lot size of the
+// symbol and dropped if it is under the exchange minimums.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/indicators"
+)
+
+// SizingMethod is how a position sizer sizes the buys
+type SizingMethod string
+
+const (
+	// SizeFixed buys a fixed amount of the quote asset
+	SizeFixed SizingMethod = "fixed"
+
+	// SizeEquity buys a fraction of the equity
+	SizeEquity SizingMethod = "equity"
+
+	// SizeVolatility buys the amount that moves the equity by the target
+	// fraction on a move of one ATR
+	SizeVolatility SizingMethod = "volatility"
+
+	// SizeKelly buys a fraction of the Kelly criterion of the equity
+	SizeKelly SizingMethod = "kelly"
+
+	// SizeMaxLoss buys the amount that loses the max loss if the price falls
+	// by the stop distance
+	SizeMaxLoss SizingMethod = "max-loss"
+)
+
+// DefaultSizingConfig is the default configuration of the position sizer, it
+// buys the fixed quote amount of the strategy
+var DefaultSizingConfig = SizingConfig{
+	Method:        SizeFixed,
+	ATRPeriods:    14,
+	ATRInterval:   time.Hour,
+	KellyFraction: 0.5,
+}
+
+// SizingConfig is the configuration of a position sizer. Only the parameters
+// of the method are used
+type SizingConfig struct {
+	Method SizingMethod
+
+	// EquityFraction is the fraction of the equity bought with SizeEquity
+	EquityFraction float64
+
+	// TargetVolatility is the fraction of the equity a move of one ATR of
+	// ATRPeriods bars of ATRInterval changes the position by
+	TargetVolatility float64
+	ATRPeriods       int
+	ATRInterval      time.Duration
+
+	// WinRate and Payoff are the fraction of the round trips that made a
+	// profit and the average win over the average loss, KellyFraction is the
+	// fraction of the Kelly criterion bought
+	WinRate       float64
+	Payoff        float64
+	KellyFraction float64
+
+	// MaxLoss is the most a buy loses in USDT if the price falls by
+	// StopDistance, a fraction of the price
+	MaxLoss      float64
+	StopDistance float64
+}
+
+// SizingMethods returns the names of the sizing methods
+func SizingMethods() []string {
+	return []string{string(SizeFixed), string(SizeEquity), string(SizeVolatility), string(SizeKelly), string(SizeMaxLoss)}
+}
+
+// Validate returns an error if the parameters of the method are invalid
+func (c SizingConfig) Validate() error {
+	switch c.Method {
+	case SizeFixed:
+	case SizeEquity:
+		if c.EquityFraction <= 0 || c.EquityFraction > 1 {
+			return fmt.Errorf("sizing equity fraction must be between 0 and 1, got %v", c.EquityFraction)
+		}
+	case SizeVolatility:
+		if c.TargetVolatility <= 0 || c.TargetVolatility >= 1 {
+			return fmt.Errorf("sizing target volatility must be between 0 and 1, got %v", c.TargetVolatility)
+		}
+		if c.ATRPeriods < 1 || c.ATRInterval < time.Minute {
+			return fmt.Errorf("sizing atr periods must be positive and the interval at least 1m, got %v and %v", c.ATRPeriods, c.ATRInterval)
+		}
+		if time.Duration(c.ATRPeriods)*c.ATRInterval > dailyWindow {
+			return fmt.Errorf("sizing atr needs %v of prices, only %v are kept", time.Duration(c.ATRPeriods)*c.ATRInterval, dailyWindow)
+		}
+	case SizeKelly:
+		if c.WinRate <= 0 || c.WinRate >= 1 || c.Payoff <= 0 {
+			return fmt.Errorf("sizing win rate must be between 0 and 1 and payoff positive, got %v and %v", c.WinRate, c.Payoff)
+		}
+		if c.KellyFraction <= 0 || c.KellyFraction > 1 {
+			return fmt.Errorf("sizing kelly fraction must be between 0 and 1, got %v", c.KellyFraction)
+		}
+	case SizeMaxLoss:
+		if c.MaxLoss <= 0 {
+			return fmt.Errorf("sizing max loss must be positive, got %v", c.MaxLoss)
+		}
+		if c.StopDistance <= 0 || c.StopDistance >= 1 {
+			return fmt.Errorf("sizing stop distance must be between 0 and 1, got %v", c.StopDistance)
+		}
+	default:
+		return fmt.Errorf("unknown sizing method %q, expected one of %v", c.Method, SizingMethods())
+	}
+	return nil
+}
+
+// Sizer returns the position sizer of the config, the fixed sizer buys amount
+func (c SizingConfig) Sizer(amount float64) PositionSizer {
+	switch c.Method {
+	case SizeEquity:
+		return EquitySizer{Fraction: c.EquityFraction}
+	case SizeVolatility:
+		return VolatilitySizer{Target: c.TargetVolatility, Periods: c.ATRPeriods, Interval: c.ATRInterval}
+	case SizeKelly:
+		return KellySizer{WinRate: c.WinRate, Payoff: c.Payoff, Fraction: c.KellyFraction}
+	case SizeMaxLoss:
+		return MaxLossSizer{MaxLoss: c.MaxLoss, StopDistance: c.StopDistance}
+	}
+	return FixedSizer{Amount: amount}
+}
+
+// PositionSizer sizes the buys of a strategy
+type PositionSizer interface {
+	// Size returns how much of the quote asset of the symbol to buy at the
+	// price, false if nothing should be bought
+	Size(market MarketData, portfolio PortfolioState, price float64) (float64, bool)
+}
+
+// FixedSizer buys a fixed amount of the quote asset
+type FixedSizer struct {
+	Amount float64
+}
+
+// Size implements the PositionSizer interface
+func (s FixedSizer) Size(market MarketData, portfolio PortfolioState, price float64) (float64, bool) {
+	return s.Amount, s.Amount > 0
+}
+
+// EquitySizer buys a fraction of the equity
+type EquitySizer struct {
+	Fraction float64
+}
+
+// Size implements the PositionSizer interface
+func (s EquitySizer) Size(market MarketData, portfolio PortfolioState, price float64) (float64, bool) {
+	return fromEquity(market, portfolio.Equity*s.Fraction)
+}
+
+// VolatilitySizer buys the amount that changes the equity by the Target
+// fraction on a move of one ATR of Periods bars of Interval. Nothing is
+// bought until there are enough prices for the ATR
+type VolatilitySizer struct {
+	Target   float64
+	Periods  int
+	Interval time.Duration
+}
+
+// Size implements the PositionSizer interface
+func (s VolatilitySizer) Size(market MarketData, portfolio PortfolioState, price float64) (float64, bool) {
+	bars := indicators.Resample(market.Candles, s.Interval)
+	atr := indicators.NewATR(s.Periods)
+	var value float64
+	ok := false
+	for _, b := range bars {
+		value, ok = atr.Update(b)
+	}
+	if !ok || value <= 0 {
+		return 0, false
+	}
+	return fromEquity(market, portfolio.Equity*s.Target/value*price)
+}
+
+// KellySizer buys the Fraction of the Kelly criterion of the equity, the
+// criterion is the win rate less the loss rate over the payoff. Nothing is
+// bought when the criterion is not positive
+type KellySizer struct {
+	WinRate  float64
+	Payoff   float64
+	Fraction float64
+}
+
+// NewKellySizer returns a Kelly sizer with the win rate and payoff of the
+// ledger summary, ie of a backtest
+func NewKellySizer(s LedgerSummary, fraction float64) KellySizer {
+	return KellySizer{WinRate: s.WinRate(), Payoff: s.Payoff(), Fraction: fraction}
+}
+
+// Criterion returns the fraction of the equity the Kelly criterion bets
+func (s KellySizer) Criterion() float64 {
+	if s.Payoff <= 0 {
+		return 0
+	}
+	return s.WinRate - (1-s.WinRate)/s.Payoff
+}
+
+// Size implements the PositionSizer interface
+func (s KellySizer) Size(market MarketData, portfolio PortfolioState, price float64) (float64, bool) {
+	f := s.Criterion()
+	if f <= 0 {
+		return 0, false
+	}
+	return fromEquity(market, portfolio.Equity*s.Fraction*f)
+}
+
+// MaxLossSizer buys the amount that loses MaxLoss USDT if the price falls by
+// StopDistance, a fraction of the price
+type MaxLossSizer struct {
+	MaxLoss      float64
+	StopDistance float64
+}
+
+// Size implements the PositionSizer interface
+func (s MaxLossSizer) Size(market MarketData, portfolio PortfolioState, price float64) (float64, bool) {
+	if s.StopDistance <= 0 {
+		return 0, false
+	}
+	return fromEquity(market, s.MaxLoss/s.StopDistance)
+}
+
+// fromEquity converts an amount in USDT to the quote asset of the symbol
+func fromEquity(market MarketData, amount float64) (float64, bool) {
+	_, quote, _ := api.SplitSymbol(market.Symbol)
+	snapshot := api.TickerSnapshot{Prices: market.Prices}
+	rate, ok := snapshot.Rate(riskQuote, quote)
+	if !ok || amount <= 0 {
+		return 0, false
+	}
+	return amount * rate, true
+}
+
+// sizeBuy returns the quantity to buy at the price from the sizer, or from the
+// fixed amount if the sizer is nil. The amount is capped by the quote balance
+// above its min balance, capped is true if it was, and the quantity is rounded
+// to the lot size. False is returned if it is under the exchange minimums
+func sizeBuy(sizer PositionSizer, amount float64, market MarketData, portfolio PortfolioState, price float64) (qty float64, capped, ok bool) {
+	if sizer == nil {
+		sizer = FixedSizer{Amount: amount}
+	}
+	amount, ok = sizer.Size(market, portfolio, price)
+	if !ok || price <= 0 {
+		return 0, false, false
+	}
+	_, quote, _ := api.SplitSymbol(market.Symbol)
+	if available := portfolio.Balances[quote] - portfolio.MinBalances[quote]; amount > available {
+		amount, capped = available, true
+	}
+	qty = market.Rules.RoundQuantity(amount / price)
+	if !market.Rules.Valid(qty, price) {
+		return 0, capped, false
+	}
+	return qty, capped, true
+}
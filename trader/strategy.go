@@ -0,0 +1,213 @@
+package trader
+
+// this file contains the Strategy interface. A strategy is the trading
+// algorithm, it looks at the market and the portfolio and decides which orders
+// to place. The trader takes care of gathering the market data, tracking the
+// portfolio and executing the orders so that a new idea only needs a new
+// Strategy implementation.
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/indicators"
+)
+
+// Strategy is a trading algorithm
+type Strategy interface {
+	// Name returns the name of the strategy
+	Name() string
+
+	// Symbols returns the symbols the strategy needs market data for
+	Symbols() []string
+
+	// Decide returns the orders the strategy wants to place based on the
+	// market data of one of its symbols and the state of the portfolio
+	Decide(market MarketData, portfolio PortfolioState) []OrderIntent
+}
+
+// MarketData is the market information for a symbol that is passed to a
+// Strategy
+type MarketData struct {
+	Symbol string
+	Price  float64
+	Time   time.Time
+
+	// Buyer and Seller are the reference prices tracked by the trader before
+	// Price was applied
+	Buyer  PriceLevels
+	Seller PriceLevels
+
+	// Prices are the latest prices of all the symbols of the strategy
+	Prices map[string]float64
+
+	// Daily are the rolling 24 hour statistics of the symbol including Price
+	Daily DailyStats
+
+	// Candles are the one minute bars of the prices seen over the last 24
+	// hours including Price, oldest first, for the strategy's indicators
+	Candles []indicators.Bar
+
+	// Thresholds are the buy and sell thresholds set by the adaptive
+	// threshold controller, they are zero when the strategy should use its
+	// own
+	Thresholds Thresholds
+
+	// Rules are the tick and lot sizes of the symbol, they are zero while
+	// the exchange info is not known
+	Rules api.SymbolRules
+}
+
+// PriceLevels are the reference prices the trader tracks for a symbol
+type PriceLevels struct {
+	// Base is the price at the point of the last order or the start of the
+	// program
+	Base float64
+
+	// Last is the price recorded from the last api call that moved in the
+	// tracked direction, the low for the Buyer and the high for the Seller
+	Last float64
+}
+
+// PortfolioState is the state of the portfolio that is passed to a Strategy
+type PortfolioState struct {
+	// Balances are the free balances keyed by asset
+	Balances map[string]float64
+
+	// MinBalances are the minimum balances to hold keyed by asset
+	MinBalances map[string]float64
+
+	// Equity is the value of the balances and open orders in USDT, 0 while
+	// it is not known
+	Equity float64
+
+	// CanBuy is true if the quote balance is enough for a buy of the pair
+	// being decided on and the pair is within its budget
+	CanBuy bool
+
+	// Lots are the open lots from the buy order heap that are not being
+	// sold, ordered by LotPolicy
+	Lots      []Lot
+	LotPolicy LotPolicy
+
+	// Pending are the orders on the book that have not reached a final
+	// status
+	Pending []OrderIntent
+
+	// Fees are the trading fees of the account
+	Fees Fees
+}
+
+// HasPending returns true if there is an order on the book for the symbol and
+// side
+func (ps PortfolioState) HasPending(symbol string, side api.Side) bool {
+	for _, intent := range ps.Pending {
+		if intent.Symbol == symbol && intent.Side == side {
+			return true
+		}
+	}
+	return false
+}
+
+// Lot is an open position from a buy order
+type Lot struct {
+	ID       uint64
+	Symbol   string
+	Price    float64
+	Quantity float64
+
+	// StopLoss and TakeProfit are the prices the lot is sold at, 0 if not
+	// set. TrailingStop is the fraction below High, the highest price since
+	// the lot was bought, the lot is sold at
+	StopLoss     float64
+	TakeProfit   float64
+	TrailingStop float64
+	High         float64
+
+	// Bought is when the lot was bought and Fees is the value of the buy
+	// fees of the lot in the quote asset
+	Bought time.Time
+	Fees   float64
+}
+
+// OrderIntent is an order a Strategy wants to place
+type OrderIntent struct {
+	Symbol   string
+	Side     api.Side
+	Quantity float64
+	Price    float64
+
+	// LotID is the ID of the lot a sell closes, 0 if the sell is not against
+	// a lot
+	LotID uint64
+
+	// NoLot is set for buys that should not be added to the buy order heap,
+	// ie buying BNB to pay fees with
+	NoLot bool
+
+	// Resting is set for orders that should rest on the book until they are
+	// filled instead of being repriced when they go stale, ie take-profits
+	Resting bool
+
+	// Exits are the exits of the lot a buy opens, the exits of the trader's
+	// exit config are used if none are set
+	Exits Exits
+
+	// Exit is the exit a sell of a lot is for and StopPrice is the stop
+	// price of exits placed on the exchange as stop limit orders
+	Exit      ExitKind
+	StopPrice float64
+
+	// Tag identifies the part of a strategy that placed the order, ie dca
+	Tag string
+
+	// Reason is a description of why the order was placed, for logging
+	Reason string
+}
+
+// fillObserver is implemented by strategies that track their own fills
+type fillObserver interface {
+	Filled(intent OrderIntent, price, quantity float64)
+}
+
+// statefulStrategy is implemented by strategies with state that is saved with
+// the trader state
+type statefulStrategy interface {
+	MarshalState() ([]byte, error)
+	UnmarshalState(data []byte) error
+}
+
+// strategies are the available strategies keyed by name
+var strategies = map[string]func() Strategy{
+	DipStrategyName:  func() Strategy { return NewDipStrategy(DefaultDipConfig) },
+	GridStrategyName: func() Strategy { return NewGridStrategy(DefaultGridConfig) },
+	RebalanceStrategyName: func() Strategy {
+		return NewRebalanceStrategy(DefaultRebalanceConfig)
+	},
+}
+
+// StrategyNames returns the names of the available strategies
+func StrategyNames() []string {
+	var names []string
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStrategy returns the strategy with the given name
+func NewStrategy(name string) (Strategy, error) {
+	newStrategy, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q, expected one of %v", name, StrategyNames())
+	}
+	return newStrategy(), nil
+}
+
+// formatPercent formats a fraction as a percentage for logging
+func formatPercent(f float64) string {
+	return fmt.Sprintf("%.4f%%", f*100)
+}
@@ -6,9 +6,8 @@ package trader
 
 import (
 	"container/heap"
-	"errors"
-	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -19,78 +18,140 @@ import (
 
 const (
 	// trading criteria
-	buyBalanceLimit = 5      // buy $5 at a time
-	diffLimit       = 0.0001 // .01% to start
-)
-
-var (
-	// Common errors
-	errBasePriceNotSet = errors.New("base price not set")
-	errUpdateLastPrice = errors.New("updating last price")
+	buyBalanceLimit  = 5      // buy $5 at a time
+	diffLimit        = 0.0001 // .01% to start
+	bnbBalanceTarget = 10     // set but binance trading levels
 )
 
 // Trader is the helper struct to control some of the functionality and in
 // memory information about the trader bot
 type Trader struct {
-	buyAmount     float64 // amount of BTC to buy at a time, set to 0.001 for now (~$6.60 as of 7/6/18)
-	dailyHigh     float64 // hight point of the past 24hrs
-	dailyLow      float64 // low point of the past 24hrs
-	buyLimit      bool    // Has the buy limit been reached
-	lastHighPoint float64 // The last price high point to compare against
-	numOrders     int     // Number of current active orders
+	buyAmount float64 // amount of BTC to buy at a time, set to 0.001 for now (~$6.60 as of 7/6/18)
+	buyLimit  bool    // Has the buy limit been reached
+	numOrders int     // Number of current active orders
 
 	// counters to help with refining buy and sell algos so they are making the
-	// most and not executing too quickly
+	// most and not executing too quickly, the adaptive threshold controller
+	// tracks the trades of each pair over its window
 	//
-	// TODO - will need to tie these into a method that adjust the difference
-	// target
-	numberOfBTCBuys  int
-	numberOfBNBBuys  int
-	numberOfBTCSells int
-
-	btcBalance  float64
-	bnbBalance  float64
-	usdtBalance float64
-	minBalance  float64 // in BTC, Set to 25% below starting limit
-	canBuyBTC   bool
+	// counters are keyed by symbol
+	numberOfBuys  map[string]int
+	numberOfSells map[string]int
+
+	// balances are the free balances keyed by asset and minBalances are the
+	// balances to hold keyed by asset, set to 25% below the highest balance of
+	// the base assets of the traded symbols
+	balances    map[string]float64
+	minBalances map[string]float64
+
+	// buyBalanceLimit is the quote balance needed to buy
+	buyBalanceLimit float64
+
+	// paused is set while trading is paused, ie while the exchange is
+	// unavailable
+	paused bool
 
 	// API Limits
 	Limits           api.BNBLimits
 	LimitsLastUpdate time.Time
 
-	// order structs
-	Buyer  *Buyer
-	Seller *Seller
+	// rules are the tick and lot sizes of the symbols keyed by symbol, they
+	// are updated with the limits
+	rules map[string]api.SymbolRules
+
+	// strategy is the trading algorithm that decides which orders to place
+	strategy Strategy
+
+	// pairs are the trading state of each symbol keyed by symbol
+	pairs map[string]*Pair
+
+	// lastLotID is the ID of the most recent lot pushed onto a heap, lot IDs
+	// are unique across pairs
+	lastLotID uint64
+
+	// openOrders are the submitted orders that have not reached a final
+	// status keyed by order id
+	openOrders  map[int]*OpenOrder
+	orderConfig OrderConfig
+
+	// adaptiveConfig is the configuration of the adaptive threshold
+	// controller
+	adaptiveConfig AdaptiveConfig
+
+	// exitConfig is the configuration of the lot exits
+	exitConfig ExitConfig
+
+	// feeConfig is the configuration of the fee model, makerCommission and
+	// takerCommission are the commissions of the account and commissions is
+	// the commission paid on all the fills keyed by asset
+	feeConfig       FeeConfig
+	makerCommission int
+	takerCommission int
+	commissions     map[string]float64
+
+	// lotPolicy is the order the lots are passed to the strategy in and
+	// ledger are the lots that have been sold
+	lotPolicy LotPolicy
+	ledger    []ClosedLot
+
+	// arbitrageConfig and arbitrage are the configuration and counts of the
+	// arbitrage detector
+	arbitrageConfig ArbitrageConfig
+	arbitrage       ArbitrageStats
+
+	// riskConfig and risk are the limits and state of the risk manager
+	riskConfig RiskConfig
+	risk       riskState
+
+	// prices are the latest prices the strategy decided on keyed by symbol
+	prices map[string]float64
+
+	// persistDir is the directory the trader state is saved to, the state is
+	// not saved if it is empty
+	persistDir string
 
 	// utilities
 	log *logrus.Logger
 	mu  sync.Mutex
 }
 
+// Pair is the trading state of a symbol. Each pair has its own buyer and
+// seller price levels, lot heap and budget
+type Pair struct {
+	Symbol string
+	Base   string
+	Quote  string
+
+	// Budget is the most of the quote asset that can be spent on the lots of
+	// the pair, 0 for no limit
+	Budget float64
+
+	Buyer  *Buyer
+	Seller *Seller
+
+	// adaptive is the state of the adaptive threshold controller
+	adaptive adaptive
+
+	// daily is the rolling 24 hour statistics of the pair
+	daily daily
+}
+
 // Buyer is a helper struct to help control the buying algorithm
 type Buyer struct {
-	// starting price, price at point of buy or start of program
-	bnbBasePrice float64
-	btcBasePrice float64
-	// price recorded from last api call
-	bnbLastPrice float64
-	btcLastPrice float64
+	// levels are the base and last prices. The base price is the price at
+	// point of buy or start of program and the last price is the lowest price
+	// recorded from the api calls since
+	levels PriceLevels
 
 	orders buyOrderHeap
-
-	mu sync.Mutex
 }
 
 // Seller is a helper struct to help control the selling algorithm
 type Seller struct {
-	// starting price, price at point of sale or start of program
-	bnbBasePrice float64
-	btcBasePrice float64
-	// price recorded from last api call
-	bnbLastPrice float64
-	btcLastPrice float64
-
-	mu sync.Mutex
+	// levels are the base and last prices. The base price is the price at
+	// point of sale or start of program and the last price is the highest
+	// price recorded from the api calls since
+	levels PriceLevels
 }
 
 // buyOrderHeap is a priority queue and implements heap.Interface and holds orders
@@ -99,14 +160,27 @@ type buyOrderHeap []*order
 // order contatins the necessary information from a buy order to prioritize sell
 // orders
 type order struct {
+	id       uint64
 	symbol   string
 	price    float64
 	quantity float64
 	index    int
+
+	// exits of the lot, high is the highest price since the lot was bought
+	// and exitRejected is set when the exchange rejected the exit
+	stopLoss     float64
+	takeProfit   float64
+	trailingStop float64
+	high         float64
+	exitRejected bool
+
+	// bought is when the lot was bought and fees is the value of the buy
+	// fees of the quantity left in the quote asset
+	bought time.Time
+	fees   float64
 }
 
 // Heap implementation
-//
 func (boh buyOrderHeap) Len() int           { return len(boh) }
 func (boh buyOrderHeap) Less(i, j int) bool { return boh[i].price < boh[j].price }
 func (boh buyOrderHeap) Swap(i, j int) {
@@ -135,14 +209,120 @@ func (boh *buyOrderHeap) update(o *order, symbol string, price, quantity float64
 	heap.Fix(boh, o.index)
 }
 
-// NewTrader returns initializes a new Trader
-func NewTrader() *Trader {
-	t := &Trader{}
-	t.Buyer = &Buyer{}
-	t.Seller = &Seller{}
-	buyOrderHeap := make(buyOrderHeap, 0)
-	heap.Init(&buyOrderHeap)
-	t.Buyer.orders = buyOrderHeap
+// remove removes the order with the given id from the heap, it returns nil if
+// the order is not in the heap
+func (boh *buyOrderHeap) remove(id uint64) *order {
+	for _, o := range *boh {
+		if o.id == id {
+			return heap.Remove(boh, o.index).(*order)
+		}
+	}
+	return nil
+}
+
+// lots returns the orders in the heap as lots, lowest price first
+func (boh buyOrderHeap) lots() []Lot {
+	lots := make([]Lot, 0, len(boh))
+	for _, o := range boh {
+		lots = append(lots, Lot{
+			ID:           o.id,
+			Symbol:       o.symbol,
+			Price:        o.price,
+			Quantity:     o.quantity,
+			StopLoss:     o.stopLoss,
+			TakeProfit:   o.takeProfit,
+			TrailingStop: o.trailingStop,
+			High:         o.high,
+			Bought:       o.bought,
+			Fees:         o.fees,
+		})
+	}
+	sort.Slice(lots, func(i, j int) bool { return lots[i].Price < lots[j].Price })
+	return lots
+}
+
+// newPair returns the trading state of a new pair
+func newPair(symbol string) *Pair {
+	base, quote, ok := api.SplitSymbol(symbol)
+	if !ok {
+		base = symbol
+	}
+	p := &Pair{
+		Symbol: symbol,
+		Base:   base,
+		Quote:  quote,
+		Buyer:  &Buyer{orders: make(buyOrderHeap, 0)},
+		Seller: &Seller{},
+	}
+	heap.Init(&p.Buyer.orders)
+	return p
+}
+
+// spent returns the quote asset spent on the open lots of the pair
+func (p *Pair) spent() float64 {
+	var spent float64
+	for _, o := range p.Buyer.orders {
+		spent += o.price * o.quantity
+	}
+	return spent
+}
+
+// apply records a new price. The base price is set if it hasn't been and the
+// last price tracks the lowest price
+func (b *Buyer) apply(price float64) {
+	l := &b.levels
+	// Check to make sure base price is set
+	if l.Base == 0 {
+		l.Base = price
+		return
+	}
+	// Compare to previous price
+	if price <= l.Last || l.Last == 0 {
+		l.Last = price
+	}
+}
+
+// apply records a new price. The base price is set if it hasn't been and the
+// last price tracks the highest price
+func (s *Seller) apply(price float64) {
+	l := &s.levels
+	// Check to make sure base price is set
+	if l.Base == 0 {
+		l.Base = price
+		return
+	}
+	// Compare to previous price
+	if price >= l.Last {
+		l.Last = price
+	}
+}
+
+// NewTrader returns initializes a new Trader that trades with the provided
+// strategy
+func NewTrader(strategy Strategy) *Trader {
+	t := &Trader{
+		strategy:      strategy,
+		numberOfBuys:  make(map[string]int),
+		numberOfSells: make(map[string]int),
+		balances:      make(map[string]float64),
+		minBalances:   make(map[string]float64),
+		pairs:         make(map[string]*Pair),
+		openOrders:    make(map[int]*OpenOrder),
+		orderConfig:   DefaultOrderConfig,
+		exitConfig:    DefaultExitConfig,
+		feeConfig:     DefaultFeeConfig,
+		commissions:   make(map[string]float64),
+		lotPolicy:     DefaultLotPolicy,
+
+		arbitrageConfig: DefaultArbitrageConfig,
+
+		adaptiveConfig: DefaultAdaptiveConfig,
+
+		buyBalanceLimit: buyBalanceLimit,
+	}
+	for _, symbol := range strategy.Symbols() {
+		t.pairs[symbol] = newPair(symbol)
+	}
 
 	// Init logger
 	t.log = logrus.New()
@@ -159,344 +339,521 @@ func NewTrader() *Trader {
 	return t
 }
 
-// TryBNBBuy tries to buy bnb
-func (t *Trader) TryBNBBuy(c *api.Client) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	t.Buyer.mu.Lock()
-	defer t.Buyer.mu.Unlock()
+// Exchange is the exchange the trader trades on. It is implemented by the
+// Binance api client and by the paper trading exchange
+type Exchange interface {
+	GetAccountInfo() (api.AccountInfo, error)
+	GetBinanceExchangeInfo() (api.ExchangeInfo, error)
+	GetCoinPrice(symbol string) (api.TickerPrice, error)
+	PostNewLimitOrder(symbol string, side api.Side, quantity, price float64) (api.Result, error)
+	GetOrder(symbol string, orderID int) (api.Order, error)
+	CancelOrder(symbol string, orderID int) (api.Order, error)
+}
+
+// Step runs one iteration of trading. It gets the prices of the strategy's
+// symbols, polls the open orders, places the exchange exits of the lots,
+// refreshes the balances, checks the risk limits, refreshes the 24hr stats,
+// looks for arbitrage, runs the strategy and places the orders it decides on
+// that pass the risk limits. Only the sells are placed while trading is halted
+// and no arbitrage is looked for. Orders that can't be polled or placed are
+// logged and don't stop the rest of the step
+func (t *Trader) Step(ex Exchange) error {
+	prices := make(map[string]float64)
+	for _, symbol := range t.strategy.Symbols() {
+		tp, err := ex.GetCoinPrice(symbol)
+		if err != nil {
+			return err
+		}
+		price, err := strconv.ParseFloat(tp.Price, 64)
+		if err != nil {
+			return err
+		}
+		prices[symbol] = price
+	}
 
-	// Check price of BNBBTC
-	bnbpriceStr, err := c.GetCoinPrice(api.BNBBTC)
+	// Track the orders already on the book before deciding on new ones, the
+	// orders that failed were logged and are polled again on the next step
+	t.PollOrders(ex, prices, time.Now())
+	t.placeExits(ex)
+
+	// The proceeds of the orders just filled are in the balances before the
+	// equity is checked
+	account, err := ex.GetAccountInfo()
 	if err != nil {
 		return err
 	}
-	bnbprice, err := strconv.ParseFloat(bnbpriceStr.Price, 64)
-	if err != nil {
+	if err := t.UpdateBalances(account); err != nil {
 		return err
 	}
+	halted := t.checkRisk(time.Now(), prices)
+	t.refreshDailyStats(ex, time.Now())
+	if !halted {
+		t.scanArbitrage(ex, time.Now())
+	}
 
-	// Check to make sure base price is set
-	if t.Buyer.bnbBasePrice == 0 {
-		t.Buyer.bnbBasePrice = bnbprice
-		return nil
+	for _, intent := range t.Decide(time.Now(), prices) {
+		if err := t.checkIntent(time.Now(), intent); err != nil {
+			t.log.WithFields(orderFields(intent)).Warn("WARN: order blocked by the risk limits: ", err)
+			continue
+		}
+		intent, ok := t.cancelExits(ex, intent)
+		if !ok {
+			continue
+		}
+		// A failed order is logged by submit and the rest are still placed
+		t.submit(ex, intent, OpenOrder{})
 	}
-	// Compare to previous price
-	if bnbprice <= t.Buyer.bnbLastPrice || t.Buyer.bnbLastPrice == 0 {
-		t.Buyer.bnbLastPrice = bnbprice
-		return nil
-	}
-
-	diff := (t.Buyer.bnbBasePrice - bnbprice) / t.Buyer.bnbBasePrice
-	if diff < diffLimit {
-		return nil
-	}
-
-	// Buy at last price
-	// TODO
-	//  - change to api order
-	//  - how to confirm order went through??
-	quantity := buyBalanceLimit / t.Buyer.btcLastPrice / bnbprice
-	t.log.WithFields(logrus.Fields{
-		"bnbBasePrice":    t.Buyer.bnbBasePrice,
-		"bnbLastPrice":    t.Buyer.bnbLastPrice,
-		"bnbprice":        bnbprice,
-		"diff":            diff,
-		"quantity":        quantity,
-		"buyBalanceLimit": buyBalanceLimit,
-		"btcLastPrice":    t.Buyer.btcLastPrice,
-	}).Debug("***BNB Buy conditions met***")
-
-	t.numberOfBNBBuys++
-	fmt.Println("Number of BNB Buys", t.numberOfBNBBuys)
-
-	// Update Base Price
-	t.Buyer.bnbBasePrice = t.Buyer.bnbLastPrice
 	return nil
 }
 
-// TryBTCBuy tries to buy btc
-func (t *Trader) TryBTCBuy(c *api.Client, btcPrice float64, btcSymbol string) {
+// Decide runs the strategy against the latest prices and returns the orders
+// it wants to place along with the sells of the lots that reached their exits.
+// Buys that would take a pair over its budget are dropped.
+// The Buyer and Seller price levels are updated with the prices after the
+// strategy has seen them and the prices are passed to the adaptive threshold
+// controller
+func (t *Trader) Decide(now time.Time, prices map[string]float64) []OrderIntent {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.Buyer.mu.Lock()
-	defer t.Buyer.mu.Unlock()
-
-	diff := (t.Buyer.btcBasePrice - btcPrice) / t.Buyer.btcBasePrice
-	if diff < diffLimit {
-		return
-	}
-
-	// Buy at last price
-	// TODO
-	//  - change to api order
-	//  - how to confirm order went through??
-	//  - should by at t.Buyer.btcLastPrice not btcPrice
-	quantity := buyBalanceLimit / btcPrice
-	t.log.WithFields(logrus.Fields{
-		"btcBasePrice": t.Buyer.btcBasePrice,
-		"btcLastPrice": t.Buyer.btcLastPrice,
-		"btcPrice":     btcPrice,
-		"diff":         diff,
-		"quantity":     quantity,
-	}).Debug("***BTC Buy conditions met***")
-
-	t.numberOfBTCBuys++
-	fmt.Println("Number of BTC Buys", t.numberOfBTCBuys)
 
-	// Add to Heap
-	order := &order{
-		symbol:   btcSymbol,
-		price:    t.Buyer.btcLastPrice,
-		quantity: quantity,
+	t.prices = prices
+	var intents []OrderIntent
+	// planned is the quote asset of the buys decided on so far keyed by
+	// symbol, so several buys in one step can't go over the budget
+	planned := make(map[string]float64)
+	for _, symbol := range t.strategy.Symbols() {
+		price, ok := prices[symbol]
+		if !ok {
+			continue
+		}
+		p := t.pair(symbol)
+		p.daily.apply(now, price)
+		exiting := make(map[uint64]bool)
+		for _, intent := range t.exitIntents(p, price) {
+			t.log.WithFields(orderFields(intent)).Infof("***%v %v*** %v", intent.Symbol, intent.Exit, intent.Reason)
+			exiting[intent.LotID] = true
+			intents = append(intents, intent)
+		}
+		market := MarketData{
+			Symbol: symbol,
+			Price:  price,
+			Time:   now,
+			Buyer:  p.Buyer.levels,
+			Seller: p.Seller.levels,
+			Prices: prices,
+			Daily:  p.daily.stats(now),
+
+			Candles:    p.daily.bars(),
+			Thresholds: t.thresholds(p),
+			Rules:      t.rules[symbol],
+		}
+		for _, intent := range t.strategy.Decide(market, t.portfolioState(p)) {
+			fields := logrus.Fields{
+				"strategy":   t.strategy.Name(),
+				"symbol":     intent.Symbol,
+				"side":       intent.Side,
+				"price":      intent.Price,
+				"quantity":   intent.Quantity,
+				"lot":        intent.LotID,
+				"buyerBase":  market.Buyer.Base,
+				"buyerLast":  market.Buyer.Last,
+				"sellerBase": market.Seller.Base,
+				"sellerLast": market.Seller.Last,
+				"dailyHigh":  market.Daily.High,
+				"dailyLow":   market.Daily.Low,
+			}
+			if intent.LotID != 0 && exiting[intent.LotID] {
+				continue
+			}
+			// The DCA buys are budgeted by the DCA schedule, not the pair
+			if ip := t.pair(intent.Symbol); intent.Side == api.SideBuy && !intent.NoLot && intent.Tag != dcaTag {
+				cost := intent.Quantity * intent.Price
+				if ip.Budget > 0 && t.committed(ip)+planned[intent.Symbol]+cost > ip.Budget {
+					t.log.WithFields(fields).Infof("%v buy dropped, over the budget of %v", intent.Symbol, ip.Budget)
+					continue
+				}
+				planned[intent.Symbol] += cost
+			}
+			t.log.WithFields(fields).Debugf("***%v %v conditions met*** %v", intent.Symbol, intent.Side, intent.Reason)
+			intents = append(intents, intent)
+		}
+		p.Buyer.apply(price)
+		p.Seller.apply(price)
+		t.observePrice(p, now, price)
 	}
-	heap.Push(&t.Buyer.orders, order)
-	t.Buyer.orders.update(order, order.symbol, order.price, order.quantity)
-
-	// update Base price
-	t.Buyer.btcBasePrice = t.Buyer.btcLastPrice
+	return intents
 }
 
-// TryBTCSell tries to buy btc
-func (t *Trader) TryBTCSell(c *api.Client, btcprice float64) {
+// RecordFill records that an order from the strategy was filled at the price
+// and quantity provided. Buys are added to the buy order heap of the pair and
+// sells against a lot remove the lot from the heap
+func (t *Trader) RecordFill(intent OrderIntent, price, quantity float64) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.Seller.mu.Lock()
-	defer t.Seller.mu.Unlock()
-	// BTC Selling
-	// Prioritize selling against previous buy orders, if not orders in heap, track against base and last price
-	//
+	defer t.persistState()
+	t.recordFill(intent, price, quantity, nil)
+}
+
+// recordFill records a fill and the commission paid on it keyed by asset. The
+// commission paid in the asset bought is not part of the lot and the value of
+// all the commission is a realized loss
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) recordFill(intent OrderIntent, price, quantity float64, commissions map[string]float64) {
+	p := t.pair(intent.Symbol)
+	now := time.Now()
+	if fo, ok := t.strategy.(fillObserver); ok {
+		fo.Filled(intent, price, quantity)
+	}
+	switch intent.Side {
+	case api.SideBuy:
+		t.numberOfBuys[intent.Symbol]++
+		t.log.Infof("Number of %v Buys %v", intent.Symbol, t.numberOfBuys[intent.Symbol])
+		paid, fee := t.recordCommissions(p, intent, commissions, p.Base)
+		if fee > 0 {
+			t.recordProfit(now, p.Quote, -fee)
+		}
 
-	// Pop lowest buy order off heap, set price to base price
-	t.Buyer.mu.Lock()
-	defer t.Buyer.mu.Unlock()
-	if len(t.Buyer.orders) != 0 {
-		t.log.Info("Order from Heap")
-		order := heap.Pop(&t.Buyer.orders).(*order)
-		t.log.WithFields(logrus.Fields{
-			"order price": order.price,
-			"btcprice":    btcprice,
-		}).Debug("BTC Sell info")
-
-		// Compare to previous order price
-		if btcprice < order.price {
+		// Add to Heap
+		if !intent.NoLot && quantity > paid {
+			t.lastLotID++
+			order := &order{
+				id:       t.lastLotID,
+				symbol:   intent.Symbol,
+				price:    price,
+				quantity: quantity - paid,
+				bought:   now,
+				fees:     fee,
+			}
+			exits := intent.Exits
+			if exits == (Exits{}) {
+				exits = t.exitConfig.Exits
+			}
+			order.setExits(exits)
+			heap.Push(&p.Buyer.orders, order)
+		}
+		// The arbitrage legs aren't trades of the strategy
+		if intent.Tag == arbitrageTag {
 			return
 		}
+		t.observeFill(p, 0, price)
 
-		diff := (btcprice-order.price)/order.price - 1
-		if diff < diffLimit {
-			// if no sell push order back onto heap
-			heap.Push(&t.Buyer.orders, order)
-			t.Buyer.orders.update(order, order.symbol, order.price, order.quantity)
+		// update Base price, the DCA buys are made on a schedule and don't
+		// move it
+		if intent.Tag != dcaTag {
+			p.Buyer.levels.Base = p.Buyer.levels.Last
+		}
+	case api.SideSell:
+		t.numberOfSells[intent.Symbol]++
+		t.log.Infof("Number of %v Sells %v", intent.Symbol, t.numberOfSells[intent.Symbol])
+		_, fee := t.recordCommissions(p, intent, commissions, p.Quote)
+
+		if intent.LotID != 0 {
+			var lotPrice float64
+			if lot, ok := t.sellLot(p, intent.LotID, quantity); ok {
+				lotPrice = lot.price
+				closed := t.closeLot(lot, intent, now, price, fee)
+				// The buy fees were recorded when the lot was bought
+				t.recordProfit(now, p.Quote, closed.PnL+lot.fees)
+			}
+			t.observeFill(p, lotPrice, price)
 			return
 		}
+		if fee > 0 {
+			t.recordProfit(now, p.Quote, -fee)
+		}
+		if intent.Tag == arbitrageTag {
+			return
+		}
+		t.observeFill(p, 0, price)
+
+		// Reset base price
+		p.Seller.levels.Base = p.Seller.levels.Last
+	}
+}
 
-		// Sell at last price
-		// TODO
-		//  - change to api order
-		//  - how to confirm order went through??
-		//  - should sell at t.Seller.btcLastPrice not btcprice
-		t.log.WithFields(logrus.Fields{
-			"btcBasePrice": t.Seller.btcBasePrice,
-			"btcLastPrice": t.Seller.btcLastPrice,
-			"btcprice":     btcprice,
-			"diff":         diff,
-			"quantity":     order.quantity,
-		}).Debug("***BTC Sell conditions met***")
-
-		t.numberOfBTCSells++
-		fmt.Println("Number of BTC Sells", t.numberOfBTCSells)
-
-		// if no sell push order back onto heap
-		if false {
-			heap.Push(&t.Buyer.orders, order)
-			t.Buyer.orders.update(order, order.symbol, order.price, order.quantity)
+// sellLot removes a sold lot from the heap of the pair and returns the part of
+// the lot that was sold with its share of the buy fees. A lot that was only
+// partially sold stays on the heap with the quantity that is left, unless what
+// is left is less than the lot size of the symbol and can't be sold
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) sellLot(p *Pair, id uint64, quantity float64) (order, bool) {
+	for _, o := range p.Buyer.orders {
+		if o.id != id {
+			continue
 		}
-		return
+		sold := *o
+		if quantity < o.quantity && t.rules[p.Symbol].RoundQuantity(o.quantity-quantity) > 0 {
+			sold.quantity = quantity
+			sold.fees = o.fees * quantity / o.quantity
+			o.fees -= sold.fees
+			p.Buyer.orders.update(o, o.symbol, o.price, o.quantity-quantity)
+			return sold, true
+		}
+		// The dust left can't be sold, its cost is written off with the lot
+		if quantity < o.quantity {
+			sold.quantity = quantity
+			sold.fees += o.price * (o.quantity - quantity)
+		}
+		p.Buyer.orders.remove(id)
+		return sold, true
 	}
+	t.log.Warn("Sold lot not found in heap: ", id)
+	return order{}, false
+}
 
-	diff := (btcprice-t.Seller.btcBasePrice)/t.Seller.btcBasePrice - 1
-	if diff < diffLimit {
-		return
+// pair returns the trading state of a symbol, creating it if needed
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) pair(symbol string) *Pair {
+	p, ok := t.pairs[symbol]
+	if !ok {
+		p = newPair(symbol)
+		t.pairs[symbol] = p
 	}
+	return p
+}
 
-	// Sell
-	// TODO
-	//  - change to api order
-	//  - how to confirm order went through??
-	t.log.Info("Sell based on Prices")
-	quantity := buyBalanceLimit / t.Seller.btcBasePrice
-	t.log.WithFields(logrus.Fields{
-		"btcBasePrice": t.Seller.btcBasePrice,
-		"btcLastPrice": t.Seller.btcLastPrice,
-		"btcprice":     btcprice,
-		"diff":         diff,
-		"quantity":     quantity,
-	}).Debug("***BTC Sell conditions met***")
-	t.numberOfBTCSells++
-	fmt.Println("Number of BTC Sells", t.numberOfBTCSells)
+// committed returns the quote asset spent on the lots of the pair and locked
+// in its open buys
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) committed(p *Pair) float64 {
+	committed := p.spent()
+	for _, o := range t.openOrders {
+		if o.Intent.Symbol == p.Symbol && o.Intent.Side == api.SideBuy && !o.Intent.NoLot {
+			committed += o.Quantity * o.Price
+		}
+	}
+	return committed
+}
 
-	// Reset base price
-	t.Seller.btcBasePrice = t.Seller.btcLastPrice
+// lots returns the lots of all the pairs, lowest price first
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) lots() []Lot {
+	var lots []Lot
+	for _, p := range t.pairs {
+		lots = append(lots, p.Buyer.orders.lots()...)
+	}
+	sort.Slice(lots, func(i, j int) bool { return lots[i].Price < lots[j].Price })
+	return lots
+}
+
+// availableLots returns the lots of all the pairs that don't have a sell on the
+// book, lowest price first. Exits on the exchange are canceled when a lot is
+// sold so they are only counted if exits is set
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) availableLots(exits bool) []Lot {
+	selling := t.selling(exits)
+	var lots []Lot
+	for _, lot := range t.lots() {
+		if !selling[lot.ID] {
+			lots = append(lots, lot)
+		}
+	}
+	return lots
+}
+
+// selling returns the lots that have a sell on the book, exits on the exchange
+// are only included if exits is set
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) selling(exits bool) map[uint64]bool {
+	selling := make(map[uint64]bool)
+	for _, o := range t.openOrders {
+		if o.Intent.Side == api.SideSell && o.Intent.LotID != 0 && (exits || o.Intent.StopPrice == 0) {
+			selling[o.Intent.LotID] = true
+		}
+	}
+	return selling
+}
+
+// portfolioState returns the state of the portfolio for the strategy deciding
+// on the pair
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) portfolioState(p *Pair) PortfolioState {
+	var pending []OrderIntent
+	for _, o := range t.openOrders {
+		pending = append(pending, o.Intent)
+	}
+	balances := make(map[string]float64, len(t.balances))
+	for asset, bal := range t.balances {
+		balances[asset] = bal
+	}
+	minBalances := make(map[string]float64, len(t.minBalances))
+	for asset, bal := range t.minBalances {
+		minBalances[asset] = bal
+	}
+	canBuy := t.balances[p.Quote] > t.buyBalanceLimit
+	if p.Budget > 0 && t.committed(p)+t.buyBalanceLimit > p.Budget {
+		canBuy = false
+	}
+	lots := t.availableLots(false)
+	sortLots(lots, t.lotPolicy)
+	equity, _ := t.equity(t.prices)
+	return PortfolioState{
+		Balances:    balances,
+		MinBalances: minBalances,
+		Equity:      equity,
+		CanBuy:      canBuy,
+		Lots:        lots,
+		LotPolicy:   t.lotPolicy,
+		Pending:     pending,
+		Fees:        t.fees(),
+	}
+}
 
+// persistState saves the trader state, logging any error
+//
+// NOTE: the caller must hold the trader lock
+func (t *Trader) persistState() {
+	if err := t.save(); err != nil {
+		t.log.Warn("WARN: unable to save trader state: ", err)
+	}
 }
 
-// UpdateBalances updates the asset and min balance of the Trader
+// UpdateBalances updates the asset and min balances of the Trader
 func (t *Trader) UpdateBalances(account api.AccountInfo) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	balances := make(map[string]float64, len(account.Balances))
 	for _, asset := range account.Balances {
-		if asset.Asset == "BTC" {
-			bal, err := strconv.ParseFloat(asset.Free, 64)
-			if err != nil {
-				return err
-			}
-			t.btcBalance = bal
-		}
-		if asset.Asset == "BNB" {
-			bal, err := strconv.ParseFloat(asset.Free, 64)
-			if err != nil {
-				return err
-			}
-			t.bnbBalance = bal
-		}
-		if asset.Asset == "USDT" {
-			bal, err := strconv.ParseFloat(asset.Free, 64)
-			if err != nil {
-				return err
-			}
-			t.usdtBalance = bal
-			if t.usdtBalance > buyBalanceLimit {
-				t.canBuyBTC = true
-			}
-		}
-	}
-
-	// Set min balance
-	var minBal float64
-	var err error
-	minBalStr := os.Getenv("binanceMinBalance")
-	if minBalStr != "" {
-		minBal, err = strconv.ParseFloat(minBalStr, 64)
+		bal, err := strconv.ParseFloat(asset.Free, 64)
 		if err != nil {
 			return err
 		}
+		balances[asset.Asset] = bal
+	}
+	t.balances = balances
+	t.makerCommission = account.MakerCommission
+	t.takerCommission = account.TakerCommission
+
+	// The min balances start from the persisted state. Only the base assets
+	// of the traded symbols are held at 75% of their highest balance, the
+	// quote assets are what the buys are made with
+	held := t.heldAssets()
+	minBalances := make(map[string]float64)
+	for asset, bal := range t.minBalances {
+		if held[asset] {
+			minBalances[asset] = bal
+		}
 	}
-	if t.minBalance < minBal {
-		t.minBalance = minBal
+	for asset, bal := range t.balances {
+		if held[asset] && minBalances[asset] < 0.75*bal {
+			minBalances[asset] = 0.75 * bal
+		}
 	}
-	if t.minBalance < 0.75*t.btcBalance {
-		t.minBalance = 0.75 * t.btcBalance
+	changed := len(minBalances) != len(t.minBalances)
+	for asset, bal := range minBalances {
+		if t.minBalances[asset] != bal {
+			changed = true
+		}
 	}
+	t.minBalances = minBalances
 
+	// Save the state if the min balances changed
+	if changed {
+		t.persistState()
+	}
 	return nil
 }
 
-// UpdateLimits updates the api limits of the Trader
+// heldAssets returns the base assets of the symbols of the strategy
+func (t *Trader) heldAssets() map[string]bool {
+	held := make(map[string]bool)
+	for _, symbol := range t.strategy.Symbols() {
+		if base, _, ok := api.SplitSymbol(symbol); ok {
+			held[base] = true
+		}
+	}
+	return held
+}
+
+// UpdateLimits updates the api limits and the symbol rules of the Trader
 func (t *Trader) UpdateLimits(info api.ExchangeInfo) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if time.Now().After(t.LimitsLastUpdate.Add(24 * time.Hour)) {
 		t.LimitsLastUpdate = time.Now()
 		t.Limits = info.BNBLimits
+		if len(info.Symbols) > 0 {
+			rules, err := info.Rules()
+			if err != nil {
+				t.log.Warn("WARN: unable to parse symbol rules: ", err)
+			} else {
+				t.rules = rules
+			}
+		}
 		t.log.Debug("Limits Updated")
 	}
 }
 
-// UpdateBTCBuyerPrices updates the base and last price of the Buyer
-func (t *Trader) UpdateBTCBuyerPrices(c *api.Client) (float64, string, error) {
+// SetBuyBalanceLimit sets the quote balance needed to buy
+func (t *Trader) SetBuyBalanceLimit(limit float64) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.Buyer.mu.Lock()
-	defer t.Buyer.mu.Unlock()
-
-	// Check price of BTCUSDT
-	btcpriceStr, err := c.GetCoinPrice(api.BTCUSDT)
-	if err != nil {
-		return 0, "", err
-	}
-	btcprice, err := strconv.ParseFloat(btcpriceStr.Price, 64)
-	if err != nil {
-		return 0, "", err
-	}
-
-	// Check to make sure base price is set
-	if t.Buyer.btcBasePrice == 0 {
-		t.Buyer.btcBasePrice = btcprice
-		return btcprice, btcpriceStr.Symbol, errBasePriceNotSet
-	}
-	// Compare to previous price
-	if btcprice <= t.Buyer.btcLastPrice || t.Buyer.btcLastPrice == 0 {
-		t.Buyer.btcLastPrice = btcprice
-		return btcprice, btcpriceStr.Symbol, errUpdateLastPrice
-	}
-
-	return btcprice, btcpriceStr.Symbol, nil
+	t.buyBalanceLimit = limit
+	t.log.Info("Buy balance limit set to ", limit)
 }
 
-// UpdateBTCSellerPrices updates the base and last price of the Seller
-func (t *Trader) UpdateBTCSellerPrices(c *api.Client) (float64, error) {
+// SetBudget sets the most of the quote asset that can be spent on the lots of
+// a pair, 0 for no limit
+func (t *Trader) SetBudget(symbol string, budget float64) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.Seller.mu.Lock()
-	defer t.Seller.mu.Unlock()
-
-	// Check price of BTCUSDT
-	btcpriceStr, err := c.GetCoinPrice(api.BTCUSDT)
-	if err != nil {
-		return 0, err
-	}
-	btcprice, err := strconv.ParseFloat(btcpriceStr.Price, 64)
-	if err != nil {
-		return 0, err
-	}
-
-	// Check to make sure base price is set
-	if t.Seller.btcBasePrice == 0 {
-		t.Seller.btcBasePrice = btcprice
-		return btcprice, errBasePriceNotSet
-	}
-	// Compare to previous price
-	if btcprice >= t.Seller.btcLastPrice {
-		t.Seller.btcLastPrice = btcprice
-		return btcprice, errUpdateLastPrice
-	}
+	t.pair(symbol).Budget = budget
+	t.log.Infof("%v budget set to %v", symbol, budget)
+}
 
-	return btcprice, nil
+// Pause pauses trading
+func (t *Trader) Pause(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = true
+	t.log.Warn("Trading paused: ", reason)
 }
 
-// BtcBalance returns the btcBalance of the trader
-func (t *Trader) BtcBalance() float64 {
+// Resume resumes trading
+func (t *Trader) Resume(reason string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	return t.btcBalance
+	t.paused = false
+	t.log.Info("Trading resumed: ", reason)
 }
 
-// BnbBalance returns the bnbBalance of the trader
-func (t *Trader) BnbBalance() float64 {
+// Paused returns true if trading is paused
+func (t *Trader) Paused() bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	return t.bnbBalance
+	return t.paused
 }
 
-// CanBuyBTC returns the canBuyBTC value of the trader
-func (t *Trader) CanBuyBTC() bool {
+// Balance returns the free balance of an asset
+func (t *Trader) Balance(asset string) float64 {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	return t.canBuyBTC
+	return t.balances[asset]
 }
 
-// MinBalance returns the minBalance of the trader
-func (t *Trader) MinBalance() float64 {
+// Balances returns the free balances keyed by asset
+func (t *Trader) Balances() map[string]float64 {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	return t.minBalance
+	balances := make(map[string]float64, len(t.balances))
+	for asset, bal := range t.balances {
+		balances[asset] = bal
+	}
+	return balances
 }
 
-// UsdtBalance returns the usdtBalance of the trader
-func (t *Trader) UsdtBalance() float64 {
+// MinBalance returns the min balance of an asset
+func (t *Trader) MinBalance(asset string) float64 {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	return t.usdtBalance
+	return t.minBalances[asset]
 }
@@ -0,0 +1,1647 @@
+package trader
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MSevey/traderbot/api"
+	"github.com/MSevey/traderbot/indicators"
+	"github.com/MSevey/traderbot/paper"
+)
+
+// TestBuyOrderHeap tests that the heap orders lots lowest price first and that
+// lots can be removed by id
+func TestBuyOrderHeap(t *testing.T) {
+	boh := make(buyOrderHeap, 0)
+	heap.Init(&boh)
+	for i, price := range []float64{3, 1, 4, 2} {
+		heap.Push(&boh, &order{id: uint64(i + 1), symbol: api.BTCUSDT, price: price, quantity: 1})
+	}
+
+	lots := boh.lots()
+	for i, lot := range lots {
+		if lot.Price != float64(i+1) {
+			t.Fatal("lots not ordered by price", lots)
+		}
+	}
+
+	// Remove the lot with price 1
+	if o := boh.remove(2); o == nil || o.price != 1 {
+		t.Fatal("unexpected removed order", o)
+	}
+	if boh.remove(2) != nil {
+		t.Fatal("order should only be removed once")
+	}
+	if o := heap.Pop(&boh).(*order); o.price != 2 {
+		t.Fatal("expected lowest price to be popped, got", o.price)
+	}
+}
+
+// TestDipStrategy tests that the trader and dip strategy buy on a rebound
+// from a dip and sell the lot once the price is above it
+func TestDipStrategy(t *testing.T) {
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	err := tr.UpdateBalances(api.AccountInfo{
+		Balances: []api.Asset{
+			{Asset: "BTC", Free: "1"},
+			{Asset: "BNB", Free: "100"},
+			{Asset: "USDT", Free: "100"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	step := func(price float64) []OrderIntent {
+		now = now.Add(time.Second)
+		intents := tr.Decide(now, map[string]float64{api.BTCUSDT: price, api.BNBBTC: 0.01})
+		for _, intent := range intents {
+			tr.RecordFill(intent, intent.Price, intent.Quantity)
+		}
+		return intents
+	}
+
+	// Set the base price and dip
+	for _, price := range []float64{100, 98} {
+		if intents := step(price); len(intents) != 0 {
+			t.Fatal("unexpected intents", intents)
+		}
+	}
+
+	// Rebound should buy
+	intents := step(98.5)
+	if len(intents) != 1 || intents[0].Side != api.SideBuy {
+		t.Fatal("expected buy, got", intents)
+	}
+	if len(tr.pairs[api.BTCUSDT].Buyer.orders) != 1 {
+		t.Fatal("expected lot on heap")
+	}
+
+	// Rise and then fall back should sell the lot
+	step(101)
+	intents = step(100.9)
+	if len(intents) != 1 || intents[0].Side != api.SideSell || intents[0].LotID != 1 {
+		t.Fatal("expected sell of lot, got", intents)
+	}
+	if len(tr.pairs[api.BTCUSDT].Buyer.orders) != 0 {
+		t.Fatal("expected lot to be removed from heap")
+	}
+}
+
+// TestDipBuyBalance tests that the dip strategy only buys on a rebound while
+// the quote balance covers a buy and that sized buys are capped by the balance
+func TestDipBuyBalance(t *testing.T) {
+	for _, test := range []struct {
+		usdt   string
+		sizer  PositionSizer
+		buys   int
+		capped bool
+	}{
+		{"4", nil, 0, false},
+		{"100", nil, 1, false},
+		{"100", EquitySizer{Fraction: 0.9}, 1, true},
+	} {
+		config := DefaultDipConfig
+		config.Sizer = test.sizer
+		tr := NewTrader(NewDipStrategy(config))
+		err := tr.UpdateBalances(api.AccountInfo{
+			Balances: []api.Asset{{Asset: "BTC", Free: "1"}, {Asset: "USDT", Free: test.usdt}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		var buys []OrderIntent
+		for _, price := range []float64{100, 98, 98.5} {
+			now = now.Add(time.Second)
+			for _, intent := range tr.Decide(now, map[string]float64{api.BTCUSDT: price}) {
+				if intent.Side == api.SideBuy {
+					buys = append(buys, intent)
+				}
+			}
+		}
+		if len(buys) != test.buys {
+			t.Fatalf("expected %v buys with %v USDT, got %v", test.buys, test.usdt, buys)
+		}
+		// 90% of the equity of 198.5 USDT is capped at the 100 USDT held
+		if test.capped && (math.Abs(buys[0].Quantity*buys[0].Price-100) > 1e-9 || !strings.Contains(buys[0].Reason, "capped")) {
+			t.Fatal("expected the buy to be capped by the balance, got", buys[0])
+		}
+	}
+}
+
+// TestMinBalances tests that only the base assets of the traded symbols are
+// held at 75% of their highest balance
+func TestMinBalances(t *testing.T) {
+	config := DefaultGridConfig
+	config.Lower, config.Upper = 90, 110
+	tr := NewTrader(NewGridStrategy(config))
+	// A quote min balance saved by an older version is dropped
+	tr.minBalances["USDT"] = 750
+	// The environment of older versions doesn't set the min balance
+	t.Setenv("binanceMinBalance", "5")
+	update := func(btc, usdt string) {
+		err := tr.UpdateBalances(api.AccountInfo{
+			Balances: []api.Asset{{Asset: "BTC", Free: btc}, {Asset: "USDT", Free: usdt}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	update("1", "1000")
+	if tr.MinBalance("BTC") != 0.75 || tr.MinBalance("USDT") != 0 {
+		t.Fatal("unexpected min balances", tr.minBalances)
+	}
+	// The min balance doesn't go down with the balance
+	update("0.5", "100")
+	if tr.MinBalance("BTC") != 0.75 || tr.MinBalance("USDT") != 0 {
+		t.Fatal("unexpected min balances", tr.minBalances)
+	}
+}
+
+// TestTraderPersist tests that the buy order heap, price levels and counters
+// are reloaded from the saved state
+func TestTraderPersist(t *testing.T) {
+	dir := t.TempDir()
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	if err := tr.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	tr.Decide(time.Now(), map[string]float64{api.BTCUSDT: 100})
+	for _, price := range []float64{99, 97, 98} {
+		tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Price: price, Quantity: 1}, price, 1)
+	}
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, LotID: 2}, 101, 1)
+
+	reloaded := NewTrader(NewDipStrategy(DefaultDipConfig))
+	if err := reloaded.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	lots := reloaded.pairs[api.BTCUSDT].Buyer.orders.lots()
+	if len(lots) != 2 || lots[0].ID != 3 || lots[1].ID != 1 {
+		t.Fatal("unexpected reloaded lots", lots)
+	}
+	if reloaded.lastLotID != 3 || reloaded.numberOfBuys[api.BTCUSDT] != 3 || reloaded.numberOfSells[api.BTCUSDT] != 1 {
+		t.Fatal("unexpected reloaded counters", reloaded.lastLotID, reloaded.numberOfBuys, reloaded.numberOfSells)
+	}
+	if reloaded.pairs[api.BTCUSDT].Buyer.levels != tr.pairs[api.BTCUSDT].Buyer.levels {
+		t.Fatal("buyer levels not reloaded")
+	}
+
+	// The reloaded heap still pops the lowest lot first
+	if o := heap.Pop(&reloaded.pairs[api.BTCUSDT].Buyer.orders).(*order); o.id != 3 {
+		t.Fatal("expected lowest lot to be popped, got", o.id)
+	}
+}
+
+// TestOrderTracking tests that lots are only added once an order is filled and
+// that stale orders are repriced
+func TestOrderTracking(t *testing.T) {
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"USDT": 1000}})
+	prices.Set(api.BTCUSDT, 100)
+	if _, err := ex.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	tr.SetOrderConfig(OrderConfig{Timeout: time.Minute, MaxReprices: 1})
+
+	// A buy below the price rests on the book without adding a lot
+	buy := OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Quantity: 1, Price: 99}
+	if err := tr.submit(ex, buy, OpenOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.OpenOrders()) != 1 || len(tr.pairs[api.BTCUSDT].Buyer.orders) != 0 {
+		t.Fatal("expected open order and no lot")
+	}
+	if state := tr.portfolioState(tr.pairs[api.BTCUSDT]); !state.HasPending(api.BTCUSDT, api.SideBuy) {
+		t.Fatal("expected pending buy")
+	}
+
+	// The buy fills once the price crosses it
+	prices.Set(api.BTCUSDT, 98)
+	if _, err := ex.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.PollOrders(ex, map[string]float64{api.BTCUSDT: 98}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	lots := tr.pairs[api.BTCUSDT].Buyer.orders.lots()
+	if len(tr.OpenOrders()) != 0 || len(lots) != 1 || lots[0].Price != 99 || lots[0].Quantity != 1 {
+		t.Fatal("expected lot at the limit price, got", lots)
+	}
+
+	// A sell above the price is repriced at the current price once stale
+	sell := OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, Quantity: 1, Price: 110, LotID: lots[0].ID}
+	if err := tr.submit(ex, sell, OpenOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	if state := tr.portfolioState(tr.pairs[api.BTCUSDT]); len(state.Lots) != 0 {
+		t.Fatal("lot being sold should not be available to the strategy")
+	}
+	if err := tr.PollOrders(ex, map[string]float64{api.BTCUSDT: 98}, time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.OpenOrders()) != 0 || len(tr.pairs[api.BTCUSDT].Buyer.orders) != 0 {
+		t.Fatal("expected repriced sell to fill and remove the lot")
+	}
+}
+
+// postHookExchange calls the hook before an order is posted and fails the
+// order if the hook returns an error
+type postHookExchange struct {
+	*paper.Exchange
+	hook func(symbol string, quantity, price float64) error
+}
+
+// PostNewLimitOrder posts the order unless the hook fails it
+func (e postHookExchange) PostNewLimitOrder(symbol string, side api.Side, quantity, price float64) (api.Result, error) {
+	if err := e.hook(symbol, quantity, price); err != nil {
+		return api.Result{}, err
+	}
+	return e.Exchange.PostNewLimitOrder(symbol, side, quantity, price)
+}
+
+// PostNewStopLimitOrder posts the stop order unless the hook fails it
+func (e postHookExchange) PostNewStopLimitOrder(symbol string, side api.Side, orderType api.OrderType, quantity, price, stopPrice float64) (api.Result, error) {
+	if err := e.hook(symbol, quantity, price); err != nil {
+		return api.Result{}, err
+	}
+	return e.Exchange.PostNewStopLimitOrder(symbol, side, orderType, quantity, price, stopPrice)
+}
+
+// TestRepriceFailure tests that a stale order is saved as canceled before it is
+// repriced and that a failed reprice doesn't stop the other orders from being
+// polled
+func TestRepriceFailure(t *testing.T) {
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"BTC": 1, "BNB": 10}})
+	prices.Set(api.BTCUSDT, 100)
+	prices.Set(api.BNBUSDT, 10)
+	for _, symbol := range []string{api.BTCUSDT, api.BNBUSDT} {
+		if _, err := ex.GetCoinPrice(symbol); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	if err := tr.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	tr.SetOrderConfig(OrderConfig{Timeout: time.Minute, MaxReprices: 1})
+	for _, intent := range []OrderIntent{
+		{Symbol: api.BTCUSDT, Side: api.SideSell, Quantity: 1, Price: 110},
+		{Symbol: api.BNBUSDT, Side: api.SideSell, Quantity: 1, Price: 11},
+	} {
+		if err := tr.submit(ex, intent, OpenOrder{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every repriced order is saved as canceled before its reprice is posted
+	// and the BTC reprice times out
+	hooked := postHookExchange{Exchange: ex, hook: func(symbol string, quantity, price float64) error {
+		reloaded := NewTrader(NewDipStrategy(DefaultDipConfig))
+		if err := reloaded.Load(dir); err != nil {
+			t.Fatal(err)
+		}
+		saved := false
+		for _, o := range reloaded.OpenOrders() {
+			saved = saved || (o.Intent.Symbol == symbol && o.Status == api.OrderStatusCanceled)
+		}
+		if !saved {
+			t.Fatal("expected the canceled order to be saved before the reprice of", symbol)
+		}
+		if symbol == api.BTCUSDT {
+			return errors.New("timeout")
+		}
+		return nil
+	}}
+	err := tr.PollOrders(hooked, map[string]float64{api.BTCUSDT: 100, api.BNBUSDT: 10}, time.Now().Add(2*time.Minute))
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Fatal("expected the BTC reprice to fail, got", err)
+	}
+	// The BNB sell was repriced and filled, the BTC sell is no longer tracked
+	if len(tr.OpenOrders()) != 0 {
+		t.Fatal("expected no open orders, got", tr.OpenOrders())
+	}
+	account, _ := ex.GetAccountInfo()
+	for _, a := range account.Balances {
+		if a.Asset == "BNB" && a.Free != "9" {
+			t.Fatal("expected the BNB sell to fill, got", a.Free)
+		}
+	}
+}
+
+// TestShutdown tests that the take-profit policy places a resting sell for
+// every lot and the cancel policy cancels them
+func TestShutdown(t *testing.T) {
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"BTC": 2}})
+	prices.Set(api.BTCUSDT, 100)
+	if _, err := ex.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	if err := tr.Load(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	for _, price := range []float64{90, 95} {
+		tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Price: price, Quantity: 1}, price, 1)
+	}
+
+	if err := tr.Shutdown(ex, ShutdownConfig{Policy: ShutdownTakeProfit, TakeProfit: 0.2}); err != nil {
+		t.Fatal(err)
+	}
+	orders, err := ex.GetOpenOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || len(tr.OpenOrders()) != 2 {
+		t.Fatal("expected a take-profit for every lot, got", orders)
+	}
+	for _, o := range tr.OpenOrders() {
+		if !o.Intent.Resting || o.Price < 108 {
+			t.Fatal("unexpected take-profit", o)
+		}
+	}
+
+	// Take-profits are not repriced when they go stale
+	if err := tr.PollOrders(ex, map[string]float64{api.BTCUSDT: 100}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.OpenOrders()) != 2 {
+		t.Fatal("take-profits should rest on the book")
+	}
+
+	if err := tr.Shutdown(ex, ShutdownConfig{Policy: ShutdownCancel}); err != nil {
+		t.Fatal(err)
+	}
+	if orders, _ := ex.GetOpenOrders(); len(orders) != 0 || len(tr.OpenOrders()) != 0 || len(tr.pairs[api.BTCUSDT].Buyer.orders) != 2 {
+		t.Fatal("expected orders canceled and lots kept")
+	}
+	if err := ShutdownPolicy("sell-everything").Validate(); err == nil {
+		t.Fatal("expected unknown policy to be invalid")
+	}
+}
+
+// TestMultiplePairs tests that each pair keeps its own lots and price levels
+// and that buys are held to the budget of the pair
+func TestMultiplePairs(t *testing.T) {
+	config := DefaultDipConfig
+	config.Symbols = []string{api.BTCUSDT, "ETHUSDT"}
+	config.DiffLimit = 0.01
+	config.BuyQuoteAmount = 10
+	tr := NewTrader(NewDipStrategy(config))
+	tr.SetBudget("ETHUSDT", 15)
+	err := tr.UpdateBalances(api.AccountInfo{
+		Balances: []api.Asset{
+			{Asset: "BNB", Free: "100"},
+			{Asset: "USDT", Free: "1000"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	step := func(btc, eth float64) []OrderIntent {
+		now = now.Add(time.Second)
+		intents := tr.Decide(now, map[string]float64{api.BTCUSDT: btc, "ETHUSDT": eth, api.BNBBTC: 0.01})
+		for _, intent := range intents {
+			tr.RecordFill(intent, intent.Price, intent.Quantity)
+		}
+		return intents
+	}
+
+	// Both pairs dip and rebound, each buys into its own heap
+	step(100, 10)
+	step(95, 9.5)
+	if intents := step(96, 9.6); len(intents) != 2 {
+		t.Fatal("expected a buy of each pair, got", intents)
+	}
+	if len(tr.pairs[api.BTCUSDT].Buyer.orders) != 1 || len(tr.pairs["ETHUSDT"].Buyer.orders) != 1 {
+		t.Fatal("expected a lot on each heap")
+	}
+	if tr.pairs["ETHUSDT"].Buyer.levels.Base != 9.5 || tr.pairs[api.BTCUSDT].Buyer.levels.Base != 95 {
+		t.Fatal("unexpected buyer levels")
+	}
+
+	// A second ETH buy would take the pair over its budget
+	step(90, 9)
+	intents := step(91, 9.1)
+	if len(intents) != 1 || intents[0].Symbol != api.BTCUSDT {
+		t.Fatal("expected only a BTC buy, got", intents)
+	}
+	if len(tr.pairs["ETHUSDT"].Buyer.orders) != 1 {
+		t.Fatal("ETH budget not enforced")
+	}
+}
+
+// TestAdaptiveThresholds tests that the thresholds are widened and narrowed
+// within their bounds
+func TestAdaptiveThresholds(t *testing.T) {
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	config := DefaultAdaptiveConfig
+	config.Enabled = true
+	config.Initial = 0.01
+	config.MinDiff = 0.005
+	config.MaxDiff = 0.02
+	config.VolatilityFactor = 0
+	tr.SetAdaptiveConfig(config)
+	p := tr.pairs[api.BTCUSDT]
+
+	now := time.Now()
+	observe := func(d time.Duration) {
+		now = now.Add(d)
+		tr.observePrice(p, now, 100)
+	}
+	observe(0)
+	if th := tr.Thresholds(api.BTCUSDT); th.Buy != 0.01 || th.Sell != 0.01 {
+		t.Fatal("thresholds should start at the initial diff", th)
+	}
+
+	// Trading too often widens both thresholds
+	for i := 0; i < 2*config.TargetRoundTrips+1; i++ {
+		tr.observeFill(p, 0, 100)
+	}
+	observe(config.Interval)
+	if th := tr.Thresholds(api.BTCUSDT); th.Buy != 0.012 || th.Sell != 0.012 {
+		t.Fatal("thresholds should be widened", th)
+	}
+
+	// Round trips below the min profit widen the sell threshold, the trades
+	// are less than half the target so the buy threshold is left
+	observe(config.Window)
+	before := tr.Thresholds(api.BTCUSDT)
+	tr.observeFill(p, 100, 100.1)
+	observe(config.Interval)
+	if th := tr.Thresholds(api.BTCUSDT); th.Buy != before.Buy || th.Sell <= before.Sell {
+		t.Fatal("only the sell threshold should be widened", th)
+	}
+
+	// Without trades the thresholds narrow down to the min
+	for i := 0; i < 10; i++ {
+		observe(config.Window)
+	}
+	if th := tr.Thresholds(api.BTCUSDT); th.Buy != config.MinDiff || th.Sell != config.MinDiff {
+		t.Fatal("thresholds should be narrowed to the min", th)
+	}
+
+	// Volatility keeps the thresholds up, capped by the max
+	tr.adaptiveConfig.VolatilityFactor = 1
+	for _, price := range []float64{50, 150, 50, 150} {
+		now = now.Add(time.Minute)
+		tr.observePrice(p, now, price)
+	}
+	observe(config.Interval)
+	if th := tr.Thresholds(api.BTCUSDT); th.Buy != config.MaxDiff || th.Sell != config.MaxDiff {
+		t.Fatal("thresholds should be raised to the max", th)
+	}
+
+	// The strategy sees the thresholds only while the controller is enabled
+	if intents := tr.Decide(now, map[string]float64{api.BTCUSDT: 100}); intents != nil {
+		t.Fatal("unexpected intents", intents)
+	}
+	config.Enabled = false
+	tr.SetAdaptiveConfig(config)
+	if th := tr.Thresholds(api.BTCUSDT); th != (Thresholds{}) {
+		t.Fatal("disabled controller should not set thresholds", th)
+	}
+}
+
+// TestDailyStats tests the rolling 24 hour statistics and the range rules of
+// the dip strategy
+func TestDailyStats(t *testing.T) {
+	config := DefaultDipConfig
+	config.MaxBuyRange = 0.5
+	tr := NewTrader(NewDipStrategy(config))
+	err := tr.UpdateBalances(api.AccountInfo{
+		Balances: []api.Asset{
+			{Asset: "BNB", Free: "100"},
+			{Asset: "USDT", Free: "100"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	step := func(price float64) []OrderIntent {
+		now = now.Add(time.Second)
+		return tr.Decide(now, map[string]float64{api.BTCUSDT: price, api.BNBBTC: 0.01})
+	}
+
+	// The exchange stats put the rebound in the top of the range so there is
+	// no buy
+	stats := api.Stats24hr{OpenPrice: "60", HighPrice: "100", LowPrice: "50", Volume: "10", QuoteVolume: "1000"}
+	if err := tr.UpdateDailyStats(api.BTCUSDT, stats, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, price := range []float64{100, 98, 98.5} {
+		if intents := step(price); len(intents) != 0 {
+			t.Fatal("unexpected intents", intents)
+		}
+	}
+	if d := tr.DailyStats(api.BTCUSDT, now); d != (DailyStats{Open: 60, High: 100, Low: 50, Volume: 10, QuoteVolume: 1000}) {
+		t.Fatal("unexpected daily stats", d)
+	}
+
+	// Once the exchange stats are stale the range of the prices seen is used
+	now = now.Add(3 * time.Hour)
+	step(97)
+	intents := step(97.5)
+	if len(intents) != 1 || intents[0].Side != api.SideBuy {
+		t.Fatal("expected buy in the lower part of the range, got", intents)
+	}
+	d := tr.DailyStats(api.BTCUSDT, now)
+	if d.Open != 100 || d.High != 100 || d.Low != 97 || d.Volume != 0 {
+		t.Fatal("unexpected daily stats", d)
+	}
+	if position, ok := d.Position(97.75); !ok || position != 0.25 {
+		t.Fatal("unexpected position", position, ok)
+	}
+
+	// Prices drop out of the window after 24 hours
+	now = now.Add(25 * time.Hour)
+	step(90)
+	if d := tr.DailyStats(api.BTCUSDT, now); d.High != 90 || d.Low != 90 {
+		t.Fatal("old prices should be dropped", d)
+	}
+	if _, ok := tr.DailyStats(api.BTCUSDT, now).Position(90); ok {
+		t.Fatal("position should be unknown without a range")
+	}
+}
+
+// TestRiskLimits tests that intents over the per order limits are dropped and
+// that breaching the daily loss or drawdown halts the buys, notifies the
+// subscribers and survives a restart until it is cleared
+func TestRiskLimits(t *testing.T) {
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	tr.SetRiskConfig(RiskConfig{
+		MaxDailyLoss:     5,
+		MaxDrawdown:      0.2,
+		MaxPositions:     map[string]float64{"BTC": 1},
+		MaxOpenLots:      2,
+		MaxOrdersPerHour: 3,
+	})
+	events := make(chan RiskEvent, 1)
+	tr.SubscribeRisk(func(e RiskEvent) { events <- e })
+	err := tr.UpdateBalances(api.AccountInfo{
+		Balances: []api.Asset{
+			{Asset: "BTC", Free: "0.5"},
+			{Asset: "USDT", Free: "100"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	prices := map[string]float64{api.BTCUSDT: 100}
+	buy := OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Quantity: 0.1, Price: 100}
+
+	// Intents within the limits pass, orders and lots are counted
+	if tr.checkRisk(now, prices) {
+		t.Fatal("unexpected halt")
+	}
+	if err := tr.checkIntent(now, buy); err != nil {
+		t.Fatal(err)
+	}
+	tr.mu.Lock()
+	tr.recordOrder(now)
+	tr.mu.Unlock()
+	tr.RecordFill(buy, 100, 0.1)
+	tr.RecordFill(buy, 100, 0.1)
+	if err := tr.checkIntent(now, OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, Quantity: 0.1, Price: 100}); err != nil {
+		t.Fatal("sells should not count against the lot limit", err)
+	}
+
+	// A third lot is over the open lot limit and only that intent is dropped
+	err = tr.checkIntent(now, buy)
+	if breach, ok := err.(riskBreach); !ok || breach.limit != "max open lots" {
+		t.Fatal("expected max open lots breach, got", err)
+	}
+	if halted, _ := tr.Halted(); halted || tr.checkRisk(now, prices) {
+		t.Fatal("unexpected halt")
+	}
+
+	// The position limit includes the balance
+	tr.SetRiskConfig(RiskConfig{MaxPositions: map[string]float64{"BTC": 1}, MaxOrdersPerHour: 1})
+	if err := tr.checkIntent(now, OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Quantity: 0.6, Price: 100}); err == nil {
+		t.Fatal("expected max position breach")
+	}
+
+	// The order rate only counts the last hour
+	if err := tr.checkIntent(now, buy); err == nil {
+		t.Fatal("expected max orders per hour breach")
+	}
+	if err := tr.checkIntent(now.Add(time.Hour), buy); err != nil {
+		t.Fatal(err)
+	}
+
+	// Losses realized on lots count against the daily loss
+	tr.SetRiskConfig(RiskConfig{MaxDailyLoss: 5})
+	lots := tr.pairs[api.BTCUSDT].Buyer.orders.lots()
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, LotID: lots[0].ID}, 60, 0.1)
+	if tr.checkRisk(now, prices) {
+		t.Fatal("loss of 4 is below the limit")
+	}
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, LotID: lots[1].ID}, 80, 0.1)
+	if !tr.checkRisk(now, prices) {
+		t.Fatal("expected max daily loss halt")
+	}
+	select {
+	case e := <-events:
+		if e.Limit != "max daily loss" {
+			t.Fatal("unexpected event", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected risk event")
+	}
+
+	// Only the buys are blocked while halted
+	if err := tr.checkIntent(now.Add(time.Hour), buy); err == nil {
+		t.Fatal("expected the buy to be blocked by the halt")
+	}
+	if err := tr.checkIntent(now.Add(time.Hour), OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, Quantity: 0.1, Price: 100}); err != nil {
+		t.Fatal("expected the sell to be made while halted, got", err)
+	}
+	tr.ClearHalt()
+	if tr.checkRisk(now.Add(24*time.Hour), prices) {
+		t.Fatal("daily loss should reset on a new day")
+	}
+
+	// The drawdown is measured from the equity peak and the halt is saved
+	dir := t.TempDir()
+	if err := tr.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	tr.SetRiskConfig(RiskConfig{MaxDrawdown: 0.2})
+	if tr.checkRisk(now, prices) {
+		t.Fatal("unexpected halt")
+	}
+	if !tr.checkRisk(now, map[string]float64{api.BTCUSDT: 10}) {
+		t.Fatal("expected max drawdown halt")
+	}
+	reloaded := NewTrader(NewDipStrategy(DefaultDipConfig))
+	if err := reloaded.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if halted, reason := reloaded.Halted(); !halted || !strings.Contains(reason, "max drawdown") {
+		t.Fatal("expected halt to be reloaded, got", reason)
+	}
+}
+
+// TestLotExits tests that the exits of the lots are enforced locally and on
+// the exchange and that the heap is kept in sync when they fill
+func TestLotExits(t *testing.T) {
+	// Local exits are sold by the trader
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	tr.SetExitConfig(ExitConfig{Mode: ExitLocal, Exits: Exits{StopLoss: 0.05}})
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy}, 100, 1)
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Exits: Exits{TakeProfit: 0.25, TrailingStop: 0.05}}, 100, 1)
+	lots := tr.pairs[api.BTCUSDT].Buyer.orders.lots()
+	if lots[0].StopLoss != 95 || lots[1].TakeProfit != 125 || lots[1].StopLoss != 0 {
+		t.Fatal("unexpected exits", lots)
+	}
+	now := time.Now()
+	decide := func(price float64) []OrderIntent {
+		now = now.Add(time.Second)
+		return tr.Decide(now, map[string]float64{api.BTCUSDT: price, api.BNBBTC: 0.01})
+	}
+	if intents := decide(108); len(intents) != 0 {
+		t.Fatal("unexpected intents", intents)
+	}
+	intents := decide(102)
+	if len(intents) != 1 || intents[0].Exit != ExitTrailingStop || intents[0].LotID != lots[1].ID {
+		t.Fatal("expected trailing stop, got", intents)
+	}
+	intents = decide(94)
+	if len(intents) != 2 || intents[0].Exit != ExitStopLoss || intents[1].Exit != ExitTrailingStop {
+		t.Fatal("expected stop-loss and trailing stop, got", intents)
+	}
+	tr.RecordFill(intents[0], 94, 1)
+	if len(tr.pairs[api.BTCUSDT].Buyer.orders) != 1 {
+		t.Fatal("expected the stop-loss to remove the lot")
+	}
+
+	// Exchange exits are placed as stop limit orders
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"BTC": 2}})
+	update := func(price float64) {
+		prices.Set(api.BTCUSDT, price)
+		prices.Set(api.BNBBTC, 0.01)
+		if err := tr.Step(ex); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tr = NewTrader(NewDipStrategy(DefaultDipConfig))
+	tr.SetExitConfig(ExitConfig{Mode: ExitExchange, Exits: Exits{StopLoss: 0.05, TrailingStop: 0.05}, LimitOffset: 0.001})
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy}, 100, 1)
+	update(100)
+	orders := tr.OpenOrders()
+	if len(orders) != 1 || orders[0].Intent.Exit != ExitStopLoss || orders[0].Intent.StopPrice != 95 {
+		t.Fatal("expected stop-loss on the exchange, got", orders)
+	}
+	if state := tr.portfolioState(tr.pairs[api.BTCUSDT]); len(state.Lots) != 1 {
+		t.Fatal("lots with an exchange exit should be available to the strategy")
+	}
+	update(95)
+	update(95)
+	if len(tr.OpenOrders()) != 0 || len(tr.pairs[api.BTCUSDT].Buyer.orders) != 0 {
+		t.Fatal("expected the stop-loss to fill and remove the lot")
+	}
+
+	// A local exit cancels the exchange exit before selling the lot
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy}, 100, 1)
+	update(100)
+	update(120)
+	if len(tr.OpenOrders()) != 1 {
+		t.Fatal("expected the exchange exit to stay on the book")
+	}
+	update(113)
+	if len(tr.OpenOrders()) != 0 || len(tr.pairs[api.BTCUSDT].Buyer.orders) != 0 {
+		t.Fatal("expected the trailing stop to sell the lot", tr.OpenOrders())
+	}
+	if open, _ := ex.GetOpenOrders(); len(open) != 0 {
+		t.Fatal("expected the exchange exit to be canceled", open)
+	}
+}
+
+// TestFees tests that sells must clear the net profit after fees and that the
+// commission paid on a fill is recorded
+func TestFees(t *testing.T) {
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	account := api.AccountInfo{
+		MakerCommission: 10,
+		TakerCommission: 10,
+		Balances:        []api.Asset{{Asset: "USDT", Free: "1000"}},
+	}
+	if err := tr.UpdateBalances(account); err != nil {
+		t.Fatal(err)
+	}
+	if fees := tr.fees(); math.Abs(fees.Taker-0.001) > 1e-12 {
+		t.Fatal("expected 0.1% taker fee, got", fees)
+	}
+	account.Balances = append(account.Balances, api.Asset{Asset: "BNB", Free: "1"})
+	if err := tr.UpdateBalances(account); err != nil {
+		t.Fatal(err)
+	}
+	fees := tr.fees()
+	if math.Abs(fees.Taker-0.00075) > 1e-12 {
+		t.Fatal("expected discounted taker fee, got", fees)
+	}
+
+	// A sell above the diff limit is not made until it clears the fees
+	ds := NewDipStrategy(DefaultDipConfig)
+	portfolio := PortfolioState{
+		Lots: []Lot{{ID: 1, Symbol: api.BTCUSDT, Price: 100, Quantity: 1}},
+		Fees: fees,
+	}
+	market := MarketData{Symbol: api.BTCUSDT, Price: 100.1, Seller: PriceLevels{Base: 100, Last: 100.2}}
+	if intents := ds.sell(market, portfolio); len(intents) != 0 {
+		t.Fatal("expected no sell below the fees, got", intents)
+	}
+	market.Price, market.Seller.Last = 100.2, 100.3
+	if intents := ds.sell(market, portfolio); len(intents) != 1 {
+		t.Fatal("expected sell above the fees, got", intents)
+	}
+
+	// The commission paid in the asset bought is not part of the lot
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"USDT": 1000}, TakerCommission: 10, BNBDiscount: 0.25})
+	prices.Set(api.BTCUSDT, 100)
+	if _, err := ex.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+	tr = NewTrader(NewDipStrategy(DefaultDipConfig))
+	tr.Decide(time.Now(), map[string]float64{api.BTCUSDT: 100})
+	if err := tr.submit(ex, OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Quantity: 1, Price: 100}, OpenOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	lots := tr.pairs[api.BTCUSDT].Buyer.orders.lots()
+	if len(lots) != 1 || math.Abs(lots[0].Quantity-0.999) > 1e-12 {
+		t.Fatal("expected lot without the commission, got", lots)
+	}
+	if paid := tr.Commissions()["BTC"]; math.Abs(paid-0.001) > 1e-12 {
+		t.Fatal("expected commission to be recorded, got", paid)
+	}
+	if math.Abs(tr.risk.dailyPnL+0.1) > 1e-9 {
+		t.Fatal("expected commission to be a realized loss, got", tr.risk.dailyPnL)
+	}
+}
+
+// TestLotPolicies tests that the lots are ordered by the lot policy and that
+// closed lots are recorded in the ledger
+func TestLotPolicies(t *testing.T) {
+	lots := []Lot{
+		{ID: 1, Symbol: api.BTCUSDT, Price: 100, Quantity: 1},
+		{ID: 2, Symbol: api.BTCUSDT, Price: 90, Quantity: 1},
+		{ID: 3, Symbol: api.BTCUSDT, Price: 110, Quantity: 1},
+	}
+	for policy, expected := range map[LotPolicy][]uint64{
+		LotFIFO:     {1, 2, 3},
+		LotLIFO:     {3, 2, 1},
+		LotLowest:   {2, 1, 3},
+		LotHighest:  {3, 1, 2},
+		LotSpecific: {2, 1, 3},
+	} {
+		sorted := append([]Lot(nil), lots...)
+		sortLots(sorted, policy)
+		for i, lot := range sorted {
+			if lot.ID != expected[i] {
+				t.Fatalf("%v: expected %v, got %v", policy, expected, sorted)
+			}
+		}
+	}
+
+	// Only the first lot of the policy is sold unless lots are specific
+	ds := NewDipStrategy(DefaultDipConfig)
+	market := MarketData{Symbol: api.BTCUSDT, Price: 105, Seller: PriceLevels{Base: 100, Last: 106}}
+	portfolio := PortfolioState{Lots: append([]Lot(nil), lots...)}
+	sortLots(portfolio.Lots, LotHighest)
+	if intents := ds.sell(market, portfolio); len(intents) != 0 {
+		t.Fatal("expected no sell of the highest lot, got", intents)
+	}
+	portfolio.LotPolicy = LotSpecific
+	sortLots(portfolio.Lots, LotSpecific)
+	portfolio.Lots = portfolio.Lots[1:]
+	if intents := ds.sell(market, portfolio); len(intents) != 1 || intents[0].LotID != 1 {
+		t.Fatal("expected sell of the profitable lot, got", intents)
+	}
+	portfolio.Lots = []Lot{lots[2]}
+	if intents := ds.sell(market, portfolio); len(intents) != 0 {
+		t.Fatal("expected no sell against the base price while there are lots, got", intents)
+	}
+
+	// Selling a lot in two parts records both in the ledger
+	dir := t.TempDir()
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	if err := tr.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	tr.Decide(time.Now(), map[string]float64{api.BTCUSDT: 100})
+	tr.mu.Lock()
+	tr.recordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy}, 100, 2, map[string]float64{"USDT": 0.2})
+	tr.mu.Unlock()
+	lot := tr.pairs[api.BTCUSDT].Buyer.orders.lots()[0]
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, LotID: lot.ID}, 110, 1)
+	tr.mu.Lock()
+	tr.recordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, LotID: lot.ID, Exit: ExitStopLoss}, 95, 1, map[string]float64{"USDT": 0.1})
+	tr.mu.Unlock()
+	ledger := tr.Ledger()
+	if len(ledger) != 2 || ledger[0].Quantity != 1 || ledger[1].Exit != ExitStopLoss {
+		t.Fatal("unexpected ledger", ledger)
+	}
+	if math.Abs(ledger[0].CostBasis-100.1) > 1e-9 || math.Abs(ledger[0].PnL-9.9) > 1e-9 {
+		t.Fatal("unexpected first close", ledger[0])
+	}
+	if math.Abs(ledger[1].Fees-0.2) > 1e-9 || math.Abs(ledger[1].PnL+5.2) > 1e-9 {
+		t.Fatal("unexpected second close", ledger[1])
+	}
+	s := SummarizeLedger(ledger)
+	if s.Lots != 2 || s.Wins != 1 || s.Losses != 1 || math.Abs(s.PnL-4.7) > 1e-9 || math.Abs(s.ShortTermPnL-s.PnL) > 1e-9 {
+		t.Fatal("unexpected summary", s)
+	}
+
+	// The ledger is reloaded and written as csv
+	tr = NewTrader(NewDipStrategy(DefaultDipConfig))
+	if err := tr.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.Ledger()) != 2 {
+		t.Fatal("expected ledger to be reloaded, got", tr.Ledger())
+	}
+	var sb strings.Builder
+	if err := WriteLedgerCSV(&sb, tr.Ledger()); err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Split(strings.TrimSpace(sb.String()), "\n"); len(rows) != 3 || !strings.HasPrefix(rows[0], "lot,symbol") {
+		t.Fatal("unexpected csv", sb.String())
+	}
+}
+
+// TestGridStrategy tests that the grid keeps buys below the price, sells each
+// lot one level up and reports the profit of each level
+func TestGridStrategy(t *testing.T) {
+	config := GridConfig{Symbol: api.BTCUSDT, Lower: 100, Upper: 400, Levels: 3, Spacing: GridGeometric, QuoteAmount: 10}
+	if prices := config.Prices(api.SymbolRules{}); len(prices) != 3 || math.Abs(prices[1]-200) > 1e-9 {
+		t.Fatal("unexpected geometric levels", prices)
+	}
+	config = GridConfig{Symbol: api.BTCUSDT, Lower: 90, Upper: 110, Levels: 5, Spacing: GridArithmetic, QuoteAmount: 10}
+	if prices := config.Prices(api.SymbolRules{TickSize: 10}); len(prices) != 3 || prices[1] != 100 {
+		t.Fatal("expected levels merged by the tick size, got", prices)
+	}
+	gs := NewGridStrategy(config)
+	gs.Decide(MarketData{Symbol: api.BTCUSDT, Price: 95, Rules: api.SymbolRules{TickSize: 10}}, PortfolioState{})
+	if report := gs.Report([]ClosedLot{{Symbol: api.BTCUSDT, BuyPrice: 100, PnL: 1}}); len(report) != 3 || report[1].Price != 100 || report[1].RoundTrips != 1 {
+		t.Fatal("expected the report on the levels of the decision, got", report)
+	}
+
+	// A lot at the top level and a lot whose sell doesn't clear the fees are
+	// held and keep their levels
+	rules := api.SymbolRules{TickSize: 0.01, StepSize: 0.001}
+	portfolio := PortfolioState{
+		Balances: map[string]float64{"USDT": 1000},
+		Lots:     []Lot{{ID: 1, Symbol: api.BTCUSDT, Price: 110, Quantity: 0.1}, {ID: 2, Symbol: api.BTCUSDT, Price: 100, Quantity: 0.1}},
+		Fees:     Fees{Taker: 0.001, MinNetProfit: 0.06},
+	}
+	intents := gs.Decide(MarketData{Symbol: api.BTCUSDT, Price: 104, Rules: rules}, portfolio)
+	if len(intents) != 2 || intents[0].Side != api.SideBuy || intents[0].Price != 90 || intents[1].Price != 95 {
+		t.Fatal("expected only the buys below the held lot, got", intents)
+	}
+	portfolio.Fees.MinNetProfit = 0.01
+	intents = gs.Decide(MarketData{Symbol: api.BTCUSDT, Price: 104, Rules: rules}, portfolio)
+	if len(intents) != 3 || intents[0].Side != api.SideSell || intents[0].LotID != 2 || intents[0].Price != 105 {
+		t.Fatal("expected the lot to be sold one level up, got", intents)
+	}
+
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"USDT": 1000}})
+	tr := NewTrader(NewGridStrategy(config))
+	if err := tr.UpdateBalances(api.AccountInfo{Balances: []api.Asset{{Asset: "USDT", Free: "1000"}}}); err != nil {
+		t.Fatal(err)
+	}
+	tr.UpdateLimits(api.ExchangeInfo{Symbols: []api.SymbolInfo{{
+		Symbol: api.BTCUSDT,
+		Filters: []api.SymbolFilter{
+			{FilterType: "PRICE_FILTER", TickSize: "0.01"},
+			{FilterType: "LOT_SIZE", StepSize: "0.001", MinQty: "0.001"},
+		},
+	}}})
+	step := func(price float64) []OpenOrder {
+		prices.Set(api.BTCUSDT, price)
+		if err := tr.Step(ex); err != nil {
+			t.Fatal(err)
+		}
+		orders := tr.OpenOrders()
+		sort.Slice(orders, func(i, j int) bool { return orders[i].Price < orders[j].Price })
+		return orders
+	}
+
+	orders := step(102)
+	if len(orders) != 3 || orders[0].Price != 90 || orders[2].Price != 100 || orders[0].Quantity != 0.111 {
+		t.Fatal("expected buys at the levels below the price, got", orders)
+	}
+	orders = step(99)
+	if len(orders) != 3 || orders[2].Intent.Side != api.SideSell || orders[2].Price != 105 {
+		t.Fatal("expected the lot to be sold one level up, got", orders)
+	}
+	orders = step(106)
+	if len(orders) != 4 || orders[2].Intent.Side != api.SideBuy || orders[2].Price != 100 || orders[3].Price != 105 {
+		t.Fatal("expected the level to be bought again, got", orders)
+	}
+
+	// Outside of the bounds no new orders are placed
+	if orders = step(120); len(orders) != 4 {
+		t.Fatal("expected the buys to be kept, got", orders)
+	}
+
+	report := tr.strategy.(*GridStrategy).Report(tr.Ledger())
+	if len(report) != 5 || report[2].RoundTrips != 1 || math.Abs(report[2].PnL-0.5) > 1e-9 || report[1].RoundTrips != 0 {
+		t.Fatal("unexpected report", report)
+	}
+}
+
+// TestSchedule tests that cron-like schedules are parsed and the next time
+// they match is found
+func TestSchedule(t *testing.T) {
+	start := time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC) // a Wednesday
+	for spec, expected := range map[string]time.Time{
+		"daily":          time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		"@hourly":        time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC),
+		"weekly":         time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC),
+		"*/20 * * * *":   time.Date(2024, 1, 31, 10, 40, 0, 0, time.UTC),
+		"0 9 * * 1-5":    time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC),
+		"15 8 29 2 *":    time.Date(2024, 2, 29, 8, 15, 0, 0, time.UTC),
+		"0 0 1,15 3 *":   time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"30 10 31 * 3":   time.Date(2024, 2, 7, 10, 30, 0, 0, time.UTC),
+		"0 12 30-31 * *": time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+	} {
+		s, err := ParseSchedule(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := s.Next(start); !next.Equal(expected) {
+			t.Errorf("%v: expected %v, got %v", spec, expected, next)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 7", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("expected %q to be invalid", spec)
+		}
+	}
+	if s, _ := ParseSchedule("0 0 31 2 *"); !s.Next(start).IsZero() {
+		t.Error("expected a schedule that never matches to return the zero time")
+	}
+}
+
+// TestDCA tests that the DCA buys are made on the schedule, boosted below the
+// moving average, kept within their budget and paused
+func TestDCA(t *testing.T) {
+	config := DefaultDipConfig
+	config.DCA = DCAConfig{
+		Enabled:           true,
+		Symbol:            api.BTCUSDT,
+		Schedule:          "@hourly",
+		QuoteAmount:       10,
+		MAInterval:        time.Hour,
+		MAPeriods:         2,
+		BelowMAMultiplier: 2,
+		Budget:            35,
+	}
+	ds := NewDipStrategy(config)
+	tr := NewTrader(ds)
+	if err := tr.UpdateBalances(api.AccountInfo{Balances: []api.Asset{{Asset: "USDT", Free: "1000"}}}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	dca := func(price float64) []OrderIntent {
+		var dca []OrderIntent
+		for _, intent := range tr.Decide(now, map[string]float64{api.BTCUSDT: price}) {
+			if intent.Tag == dcaTag {
+				tr.RecordFill(intent, intent.Price, intent.Quantity)
+				dca = append(dca, intent)
+			}
+		}
+		now = now.Add(time.Hour)
+		return dca
+	}
+
+	// The first buy is made on the first scheduled time after the start
+	if intents := dca(100); len(intents) != 0 {
+		t.Fatal("unexpected buy before the schedule", intents)
+	}
+	intents := dca(100)
+	if len(intents) != 1 || intents[0].Quantity != 0.1 {
+		t.Fatal("expected scheduled buy, got", intents)
+	}
+	if lots := tr.pairs[api.BTCUSDT].Buyer.orders.lots(); len(lots) != 1 {
+		t.Fatal("expected the buy to be added to the heap, got", lots)
+	}
+
+	// Below the moving average the buy is boosted, then capped by the budget
+	intents = dca(80)
+	if len(intents) != 1 || math.Abs(intents[0].Quantity*intents[0].Price-20) > 1e-9 {
+		t.Fatal("expected boosted buy, got", intents)
+	}
+	intents = dca(100)
+	if len(intents) != 1 || math.Abs(intents[0].Quantity*intents[0].Price-5) > 1e-9 {
+		t.Fatal("expected buy of what is left of the budget, got", intents)
+	}
+	if intents = dca(100); len(intents) != 0 {
+		t.Fatal("expected no buys once the budget is spent", intents)
+	}
+
+	// The state is saved with the trader and buys are skipped while paused
+	dir := t.TempDir()
+	if err := tr.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Save(); err != nil {
+		t.Fatal(err)
+	}
+	config.DCA.Budget = 0
+	config.DCA.Pauses = []Pause{{Start: now, End: now.Add(45 * time.Minute)}}
+	ds = NewDipStrategy(config)
+	tr = NewTrader(ds)
+	if err := tr.UpdateBalances(api.AccountInfo{Balances: []api.Asset{{Asset: "USDT", Free: "1000"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(ds.dca.Spent-35) > 1e-9 || len(ds.dca.Samples) != 2 {
+		t.Fatal("expected the dca state to be reloaded, got", ds.dca)
+	}
+	if intents = dca(100); len(intents) != 0 {
+		t.Fatal("expected no buys while paused", intents)
+	}
+	if intents = dca(100); len(intents) != 1 {
+		t.Fatal("expected buys after the pause, got", intents)
+	}
+
+	// A buy that is dropped is decided on again until it fills
+	decided := func(at time.Time) int {
+		var n int
+		for _, intent := range tr.Decide(at, map[string]float64{api.BTCUSDT: 100}) {
+			if intent.Tag == dcaTag {
+				n++
+			}
+		}
+		return n
+	}
+	if decided(now) != 1 || decided(now.Add(time.Minute)) != 1 {
+		t.Fatal("expected the dropped buy to be decided on again")
+	}
+	if intents = dca(100); len(intents) != 1 || decided(now.Add(-time.Hour)) != 0 {
+		t.Fatal("expected the schedule to move on once the buy fills, got", intents)
+	}
+
+	// A sizer sizes the buys in place of the quote amount
+	ds.config.DCA.Sizer = FixedSizer{Amount: 20}
+	if intents = dca(100); len(intents) != 1 || intents[0].Quantity != 0.2 {
+		t.Fatal("expected the buy to be sized by the sizer, got", intents)
+	}
+
+	// The buys aren't held to the budget of the pair and don't move its base
+	// price
+	tr.SetBudget(api.BTCUSDT, 1)
+	tr.pairs[api.BTCUSDT].Buyer.levels.Base = 120
+	if intents = dca(90); len(intents) != 1 || tr.pairs[api.BTCUSDT].Buyer.levels.Base != 120 {
+		t.Fatal("expected the buy over the pair budget to keep the base price, got", intents, tr.pairs[api.BTCUSDT].Buyer.levels)
+	}
+
+	// A schedule that never runs is rejected
+	config.DCA.Schedule = "0 0 31 2 *"
+	if err := config.DCA.Validate(); err == nil {
+		t.Fatal("expected the schedule to be rejected")
+	}
+}
+
+// TestRebalanceStrategy tests that drift from the targets is traded back
+// through the cheapest route and that rebalances wait for their orders
+func TestRebalanceStrategy(t *testing.T) {
+	config := DefaultRebalanceConfig
+	config.Targets = map[string]float64{"BTC": 0.4, "BNB": 0.2, "USDT": 0.4}
+	config.MinTrade = 1
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	never := config
+	never.Schedule = "0 0 31 2 *"
+	if err := never.Validate(); err == nil {
+		t.Fatal("expected the schedule to be rejected")
+	}
+	rs := NewRebalanceStrategy(config)
+
+	// BNB is dear in BTC so BTC is sold for it through USDT
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	prices := map[string]float64{api.BTCUSDT: 100, api.BNBUSDT: 10, api.BNBBTC: 0.102}
+	portfolio := PortfolioState{
+		Balances:    map[string]float64{"BTC": 1, "USDT": 100},
+		MinBalances: map[string]float64{},
+		Fees:        Fees{Taker: 0.001},
+	}
+	plan := rs.Preview(portfolio.Balances, portfolio.MinBalances, prices, portfolio.Fees)
+	if plan.Total != 200 || len(plan.Assets) != 3 || plan.Assets[0].Asset != "BNB" || plan.Assets[0].Drift() != -0.2 {
+		t.Fatal("unexpected weights", plan)
+	}
+	if len(plan.Trades) != 2 {
+		t.Fatal("expected 2 trades, got", plan.Trades)
+	}
+	btc, usdt := plan.Trades[0], plan.Trades[1]
+	if btc.From != "BTC" || btc.To != "BNB" || btc.Amount != 0.2 || len(btc.Route) != 2 || btc.Route[0].Symbol != api.BTCUSDT || btc.Route[1].Symbol != api.BNBUSDT {
+		t.Fatal("expected BTC to be routed through USDT, got", btc)
+	}
+	if math.Abs(btc.Received-0.2*100*0.999/10*0.999) > 1e-9 {
+		t.Fatal("unexpected amount received", btc.Received)
+	}
+	if usdt.From != "USDT" || usdt.Amount != 20 || len(usdt.Route) != 1 || usdt.Route[0] != (RebalanceLeg{Symbol: api.BNBUSDT, Side: api.SideBuy}) {
+		t.Fatal("expected USDT to buy BNB directly, got", usdt)
+	}
+
+	// Routes only go through the assets of the targets
+	if routes := (RebalanceConfig{Symbols: config.Symbols, Targets: map[string]float64{"BTC": 0.5, "BNB": 0.5}}).routes("BTC", "BNB"); len(routes) != 1 || len(routes[0]) != 1 {
+		t.Fatal("expected only the direct route, got", routes)
+	}
+
+	// The first leg of each trade is placed with its symbol
+	decide := func(symbol string) []OrderIntent {
+		return rs.Decide(MarketData{Symbol: symbol, Price: prices[symbol], Time: now, Prices: prices}, portfolio)
+	}
+	if intents := decide(api.BTCUSDT); len(intents) != 1 || intents[0].Side != api.SideSell || intents[0].Quantity != 0.2 || intents[0].Tag != rebalanceTag {
+		t.Fatal("expected BTC to be sold, got", intents)
+	}
+	if intents := decide(api.BNBUSDT); len(intents) != 1 || intents[0].Side != api.SideBuy || intents[0].Quantity != 2 || !intents[0].NoLot {
+		t.Fatal("expected BNB to be bought, got", intents)
+	}
+	if intents := decide(api.BNBBTC); len(intents) != 0 {
+		t.Fatal("unexpected intents", intents)
+	}
+
+	// A sizer caps the orders
+	rs.config.Sizer = FixedSizer{Amount: 10}
+	if intents := decide(api.BTCUSDT); len(intents) != 1 || intents[0].Quantity != 0.1 {
+		t.Fatal("expected the sell to be capped by the sizer, got", intents)
+	}
+	rs.config.Sizer = nil
+
+	// No trades are planned while a rebalance order is on the book
+	now = now.Add(time.Minute)
+	portfolio.Pending = []OrderIntent{{Symbol: api.BNBUSDT, Side: api.SideBuy, Tag: rebalanceTag}}
+	if intents := decide(api.BTCUSDT); len(intents) != 0 {
+		t.Fatal("expected to wait for the order, got", intents)
+	}
+
+	// The min balances limit how much can be sold
+	portfolio.Pending = nil
+	portfolio.MinBalances["BTC"] = 0.9
+	if plan := rs.Preview(portfolio.Balances, portfolio.MinBalances, prices, portfolio.Fees); plan.Trades[0].From != "USDT" || math.Abs(plan.Trades[1].Amount-0.1) > 1e-9 {
+		t.Fatal("expected the BTC trade to be limited by the min balance, got", plan.Trades)
+	}
+
+	// Once the targets are reached the rebalance stops and small drift
+	// doesn't start a new one
+	portfolio.MinBalances = map[string]float64{}
+	portfolio.Balances = map[string]float64{"BTC": 0.8, "BNB": 4, "USDT": 80}
+	now = now.Add(time.Minute)
+	if intents := decide(api.BTCUSDT); len(intents) != 0 || rs.state.Active {
+		t.Fatal("expected the rebalance to be done, got", intents)
+	}
+	portfolio.Balances["BTC"] = 0.83
+	now = now.Add(time.Minute)
+	if intents := decide(api.BTCUSDT); len(intents) != 0 {
+		t.Fatal("expected no rebalance within the threshold, got", intents)
+	}
+
+	// A calendar rebalance trades the drift within the threshold
+	config.Threshold = 0
+	config.Schedule = "daily"
+	rs.SetConfig(config)
+	now = now.Add(time.Minute)
+	decide(api.BTCUSDT)
+	now = now.Add(24 * time.Hour)
+	if intents := decide(api.BTCUSDT); len(intents) != 1 || intents[0].Side != api.SideSell {
+		t.Fatal("expected a scheduled rebalance, got", intents)
+	}
+}
+
+// rejectingExchange is a paper exchange that rejects the orders of a symbol
+type rejectingExchange struct {
+	*paper.Exchange
+	symbol string
+}
+
+// PostNewLimitOrder rejects the orders of the symbol
+func (e rejectingExchange) PostNewLimitOrder(symbol string, side api.Side, quantity, price float64) (api.Result, error) {
+	if symbol == e.symbol {
+		return api.Result{}, errors.New("rejected")
+	}
+	return e.Exchange.PostNewLimitOrder(symbol, side, quantity, price)
+}
+
+// TestArbitrage tests that the loops of a triangle are found after fees and
+// rounding, and that a failed leg is traded back to the start asset
+func TestArbitrage(t *testing.T) {
+	if err := (Triangle{Start: "USDT", Symbols: []string{api.BTCUSDT, api.BNBUSDT, "ETHUSDT"}}).Validate(); err == nil {
+		t.Fatal("expected symbols that don't form a loop to be rejected")
+	}
+
+	// BNB is cheap in BTC, so USDT buys BTC, BTC buys BNB and BNB is sold
+	prices := paper.NewStaticPrices()
+	prices.Set(api.BTCUSDT, 100)
+	prices.Set(api.BNBUSDT, 10)
+	prices.Set(api.BNBBTC, 0.095)
+	setup := func() (*Trader, *paper.Exchange) {
+		ex := paper.New(prices, paper.Config{Balances: map[string]float64{"USDT": 1000}, TakerCommission: 10})
+		for _, symbol := range DefaultTriangle.Symbols {
+			if _, err := ex.GetCoinPrice(symbol); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+		account, _ := ex.GetAccountInfo()
+		if err := tr.UpdateBalances(account); err != nil {
+			t.Fatal(err)
+		}
+		tr.UpdateLimits(api.ExchangeInfo{Symbols: []api.SymbolInfo{
+			{Symbol: api.BTCUSDT, Filters: []api.SymbolFilter{{FilterType: "LOT_SIZE", StepSize: "0.00001"}}},
+			{Symbol: api.BNBBTC, Filters: []api.SymbolFilter{{FilterType: "LOT_SIZE", StepSize: "0.01"}}},
+			{Symbol: api.BNBUSDT, Filters: []api.SymbolFilter{{FilterType: "LOT_SIZE", StepSize: "0.01"}}},
+		}})
+		tr.SetArbitrageConfig(ArbitrageConfig{Enabled: true, Amount: 20, MinEdge: 0.001, Execute: true, LegTimeout: time.Second})
+		tr.prices = map[string]float64{api.BTCUSDT: 100, api.BNBUSDT: 10, api.BNBBTC: 0.095}
+		return tr, ex
+	}
+	tr, ex := setup()
+	books, _ := ex.GetAllBookTickers()
+	snapshot, err := api.NewTickerSnapshot(time.Now(), nil, books)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0.2 BTC less fees buys 2.10 BNB, less fees 2.09 BNB are sold
+	opportunities := tr.FindArbitrage(snapshot)
+	if len(opportunities) != 1 {
+		t.Fatal("expected one opportunity, got", opportunities)
+	}
+	o := opportunities[0]
+	if o.Route() != "USDT>BTC>BNB>USDT" || o.Amount != 20 || math.Abs(o.Return-2.09*10*0.999) > 1e-9 || o.Legs[1].Quantity != 2.1 {
+		t.Fatal("unexpected opportunity", o)
+	}
+
+	r := tr.ExecuteArbitrage(ex, o, time.Second)
+	if r.Err != nil || r.Unwound || len(r.Filled) != 3 || math.Abs(r.Profit()-o.Profit()) > 1e-6 {
+		t.Fatal("unexpected result", r)
+	}
+	account, _ := ex.GetAccountInfo()
+	for _, a := range account.Balances {
+		if a.Asset == "USDT" && a.Free != "1000.8791" {
+			t.Fatal("unexpected USDT balance", a.Free)
+		}
+	}
+
+	// The profit is realized and recorded in the ledger with the fees, the
+	// balances are updated
+	if math.Abs(tr.risk.dailyPnL-r.Profit()) > 1e-6 || math.Abs(tr.balances["USDT"]-1000.8791) > 1e-9 {
+		t.Fatal("unexpected daily profit and balance", tr.risk.dailyPnL, tr.balances)
+	}
+	ledger := tr.Ledger()
+	if len(ledger) != 1 || ledger[0].Symbol != o.Route() || ledger[0].CostBasis != 20 || math.Abs(ledger[0].PnL-r.Profit()) > 1e-6 || r.Fees <= 0 || ledger[0].Fees != r.Fees {
+		t.Fatal("unexpected ledger", ledger, r.Fees)
+	}
+
+	// The BTC of a loop that fails on its second leg is sold back for USDT
+	tr, ex = setup()
+	r = tr.ExecuteArbitrage(rejectingExchange{Exchange: ex, symbol: api.BNBBTC}, o, time.Second)
+	if r.Err == nil || !r.Unwound || len(r.Filled) != 1 || math.Abs(r.Returned-0.1998*100*0.999) > 1e-6 {
+		t.Fatal("expected the BTC to be unwound, got", r)
+	}
+	if stats := tr.ArbitrageStats(); stats.Failed != 1 || math.Abs(stats.Profit["USDT"]-r.Profit()) > 1e-9 {
+		t.Fatal("unexpected stats", stats)
+	}
+	if r.Profit() >= 0 || math.Abs(tr.risk.dailyPnL-r.Profit()) > 1e-6 || len(tr.Ledger()) != 1 {
+		t.Fatal("expected the unwind loss to be realized, got", tr.risk.dailyPnL)
+	}
+
+	// A loop whose second leg is over the risk limits isn't started, trading
+	// carries on
+	tr, ex = setup()
+	tr.SetRiskConfig(RiskConfig{MaxPositions: map[string]float64{"BNB": 2}})
+	r = tr.ExecuteArbitrage(ex, o, time.Second)
+	if _, ok := r.Err.(riskBreach); !ok || len(r.Filled) != 0 {
+		t.Fatal("expected the loop to be stopped by the limits, got", r)
+	}
+	if halted, _ := tr.Halted(); halted {
+		t.Fatal("unexpected halt")
+	}
+	if account, _ := ex.GetAccountInfo(); len(account.Balances) != 1 || account.Balances[0].Free != "1000" {
+		t.Fatal("expected nothing to be traded, got", account.Balances)
+	}
+
+	// The BTC is unwound while trading is halted
+	tr, ex = setup()
+	hooked := postHookExchange{Exchange: ex, hook: func(symbol string, quantity, price float64) error {
+		if symbol != api.BNBBTC {
+			return nil
+		}
+		tr.mu.Lock()
+		tr.halt(time.Now(), riskBreach{limit: "max daily loss", reason: "halted between the legs"})
+		tr.mu.Unlock()
+		return errors.New("rejected")
+	}}
+	r = tr.ExecuteArbitrage(hooked, o, time.Second)
+	if halted, _ := tr.Halted(); !halted || !r.Unwound || math.Abs(r.Returned-0.1998*100*0.999) > 1e-6 {
+		t.Fatal("expected the BTC to be unwound while halted, got", r)
+	}
+}
+
+// TestPositionSizing tests the position sizers and that the buys are capped by
+// the available balance and dropped under the exchange minimums
+func TestPositionSizing(t *testing.T) {
+	market := MarketData{
+		Symbol: api.BTCUSDT,
+		Price:  100,
+		Prices: map[string]float64{api.BTCUSDT: 100},
+		Rules:  api.SymbolRules{StepSize: 0.001, MinNotional: 5},
+	}
+	portfolio := PortfolioState{
+		Balances:    map[string]float64{"USDT": 1000},
+		MinBalances: map[string]float64{"USDT": 750},
+		Equity:      2000,
+	}
+	// An ATR of 2 over 14 hourly bars
+	start := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 15; i++ {
+		market.Candles = append(market.Candles, indicators.Bar{Time: start.Add(time.Duration(i) * time.Hour), High: 101, Low: 99, Close: 100})
+	}
+
+	// Half Kelly of a 2/3 win rate and 1.5 payoff is 0.5*(2/3-1/3/1.5)
+	kelly := NewKellySizer(SummarizeLedger([]ClosedLot{{PnL: 3}, {PnL: 3}, {PnL: -2}}), 0.5)
+	if math.Abs(kelly.Criterion()-4.0/9) > 1e-9 {
+		t.Fatal("unexpected kelly criterion", kelly)
+	}
+
+	tests := []struct {
+		name  string
+		sizer PositionSizer
+		qty   float64
+		ok    bool
+	}{
+		{"fixed", FixedSizer{Amount: 20}, 0.2, true},
+		{"equity", EquitySizer{Fraction: 0.01}, 0.2, true},
+		{"volatility", VolatilitySizer{Target: 0.001, Periods: 14, Interval: time.Hour}, 1, true},
+		{"volatility warming up", VolatilitySizer{Target: 0.001, Periods: 20, Interval: time.Hour}, 0, false},
+		{"kelly capped by the balance", kelly, 2.5, true},
+		{"kelly without an edge", KellySizer{WinRate: 0.4, Payoff: 1, Fraction: 1}, 0, false},
+		{"max loss", MaxLossSizer{MaxLoss: 2, StopDistance: 0.05}, 0.4, true},
+		{"under the min notional", FixedSizer{Amount: 4}, 0, false},
+	}
+	for _, test := range tests {
+		qty, _, ok := sizeBuy(test.sizer, 0, market, portfolio, market.Price)
+		if ok != test.ok || math.Abs(qty-test.qty) > 1e-9 {
+			t.Fatalf("%v: expected %v %v, got %v %v", test.name, test.qty, test.ok, qty, ok)
+		}
+	}
+
+	// Amounts in USDT are converted to the quote asset and the fixed amount
+	// is used without a sizer
+	btc := MarketData{Symbol: api.BNBBTC, Prices: map[string]float64{api.BTCUSDT: 10000}}
+	portfolio.Balances["BTC"] = 1
+	if qty, _, ok := sizeBuy(MaxLossSizer{MaxLoss: 5, StopDistance: 0.05}, 0, btc, portfolio, 0.001); !ok || math.Abs(qty-10) > 1e-9 {
+		t.Fatal("unexpected BNB quantity", qty, ok)
+	}
+	if qty, _, ok := sizeBuy(nil, 0.002, btc, portfolio, 0.001); !ok || qty != 2 {
+		t.Fatal("unexpected BNB quantity", qty, ok)
+	}
+}
+
+// cancelHookExchange calls the hook before an order is canceled
+type cancelHookExchange struct {
+	*paper.Exchange
+	hook func()
+}
+
+// CancelOrder cancels the order after the hook
+func (e cancelHookExchange) CancelOrder(symbol string, orderID int) (api.Order, error) {
+	e.hook()
+	return e.Exchange.CancelOrder(symbol, orderID)
+}
+
+// lostResponseExchange is a paper exchange that places the stop orders but
+// answers that their status is unknown
+type lostResponseExchange struct {
+	*paper.Exchange
+}
+
+// PostNewStopLimitOrder places the order and loses the response
+func (e lostResponseExchange) PostNewStopLimitOrder(symbol string, side api.Side, orderType api.OrderType, quantity, price, stopPrice float64) (api.Result, error) {
+	if _, err := e.Exchange.PostNewStopLimitOrder(symbol, side, orderType, quantity, price, stopPrice); err != nil {
+		return api.Result{}, err
+	}
+	return api.Result{}, api.Error{Code: -1007, Msg: "Timeout waiting for response from backend server."}
+}
+
+// TestUnknownOrderStatus tests that an order that fills before it can be
+// canceled is recorded and not repriced, and that an order placed without a
+// response is found on the book instead of being placed again
+func TestUnknownOrderStatus(t *testing.T) {
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"USDT": 1000}})
+	prices.Set(api.BTCUSDT, 100)
+	if _, err := ex.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	tr.SetOrderConfig(OrderConfig{Timeout: time.Minute, MaxReprices: 1})
+	if err := tr.submit(ex, OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Quantity: 1, Price: 99}, OpenOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	filled := cancelHookExchange{Exchange: ex, hook: func() { ex.UpdatePrice(api.BTCUSDT, 98) }}
+	if err := tr.PollOrders(filled, map[string]float64{api.BTCUSDT: 100}, time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if open, _ := ex.GetOpenOrders(); len(open) != 0 || len(tr.OpenOrders()) != 0 {
+		t.Fatal("expected the filled order not to be repriced, got", open)
+	}
+	if lots := tr.pairs[api.BTCUSDT].Buyer.orders.lots(); len(lots) != 1 || lots[0].Quantity != 1 || lots[0].Price != 99 {
+		t.Fatal("expected the fill to be recorded, got", lots)
+	}
+
+	tr.SetExitConfig(ExitConfig{Mode: ExitExchange, Exits: Exits{StopLoss: 0.05}})
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy}, 100, 1)
+	lost := lostResponseExchange{Exchange: ex}
+	tr.placeExits(lost)
+	tr.placeExits(lost)
+	// Only the lot bought after the exits were set has one
+	if open, _ := ex.GetOpenOrders(); len(open) != 1 || len(tr.OpenOrders()) != 1 {
+		t.Fatal("expected the exit to be found on the book and placed once, got", open, tr.OpenOrders())
+	}
+}
+
+// TestOrderRounding tests that orders are rounded to the lot and tick sizes of
+// the symbol and that the dust left of a lot whose fee was paid in the base
+// asset is written off when the lot is sold
+func TestOrderRounding(t *testing.T) {
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"USDT": 1000}, TakerCommission: 10})
+	prices.Set(api.BTCUSDT, 100)
+	if _, err := ex.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	tr.UpdateLimits(api.ExchangeInfo{Symbols: []api.SymbolInfo{{
+		Symbol: api.BTCUSDT,
+		Filters: []api.SymbolFilter{
+			{FilterType: "PRICE_FILTER", TickSize: "0.01"},
+			{FilterType: "LOT_SIZE", StepSize: "0.001", MinQty: "0.001"},
+		},
+	}}})
+	tr.Decide(time.Now(), map[string]float64{api.BTCUSDT: 100})
+
+	var posted [][2]float64
+	hooked := postHookExchange{Exchange: ex, hook: func(symbol string, quantity, price float64) error {
+		posted = append(posted, [2]float64{quantity, price})
+		return nil
+	}}
+
+	// The commission of 0.0015 BTC leaves a lot of 1.4985 BTC
+	if err := tr.submit(hooked, OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Quantity: 1.5004, Price: 100.004}, OpenOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	lots := tr.pairs[api.BTCUSDT].Buyer.orders.lots()
+	if len(posted) != 1 || posted[0] != [2]float64{1.5, 100} || len(lots) != 1 || math.Abs(lots[0].Quantity-1.4985) > 1e-12 {
+		t.Fatal("unexpected buy", posted, lots)
+	}
+
+	// The lot is sold rounded down to 1.498 and the 0.0005 left is written off
+	sell := OrderIntent{Symbol: api.BTCUSDT, Side: api.SideSell, Quantity: lots[0].Quantity, Price: 100.009, LotID: lots[0].ID}
+	if err := tr.submit(hooked, sell, OpenOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 2 || posted[1] != [2]float64{1.498, 100} || len(tr.pairs[api.BTCUSDT].Buyer.orders) != 0 {
+		t.Fatal("unexpected sell", posted, tr.pairs[api.BTCUSDT].Buyer.orders.lots())
+	}
+	if ledger := tr.Ledger(); len(ledger) != 1 || ledger[0].Quantity != 1.498 || math.Abs(ledger[0].CostBasis-150) > 1e-9 {
+		t.Fatal("unexpected closed lot", ledger)
+	}
+
+	// Orders under the minimums once rounded are not posted
+	if err := tr.submit(hooked, OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy, Quantity: 0.0009, Price: 100}, OpenOrder{}); err != errUnderMinimums || len(posted) != 2 {
+		t.Fatal("expected the order to be under the minimums, got", err)
+	}
+}
+
+// TestExitPlacement tests that exchange exits are rounded to the symbol rules,
+// placed again after a transient error and only enforced locally once the
+// exchange rejects them
+func TestExitPlacement(t *testing.T) {
+	prices := paper.NewStaticPrices()
+	ex := paper.New(prices, paper.Config{Balances: map[string]float64{"BTC": 2}})
+	prices.Set(api.BTCUSDT, 100)
+	if _, err := ex.GetCoinPrice(api.BTCUSDT); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTrader(NewDipStrategy(DefaultDipConfig))
+	tr.SetExitConfig(ExitConfig{Mode: ExitExchange, Exits: Exits{StopLoss: 0.05}, LimitOffset: 0.001})
+	tr.UpdateLimits(api.ExchangeInfo{Symbols: []api.SymbolInfo{{
+		Symbol: api.BTCUSDT,
+		Filters: []api.SymbolFilter{
+			{FilterType: "PRICE_FILTER", TickSize: "0.01"},
+			{FilterType: "LOT_SIZE", StepSize: "0.001", MinQty: "0.001"},
+		},
+	}}})
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy}, 100.003, 0.9995)
+
+	var errs []error
+	var posted [][2]float64
+	hooked := postHookExchange{Exchange: ex, hook: func(symbol string, quantity, price float64) error {
+		posted = append(posted, [2]float64{quantity, price})
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}}
+	exitRejected := func(id uint64) bool {
+		for _, o := range tr.pairs[api.BTCUSDT].Buyer.orders {
+			if o.id == id {
+				return o.exitRejected
+			}
+		}
+		return false
+	}
+
+	// A timeout is placed again on the next step
+	errs = []error{api.Error{Code: -1007, Msg: "Timeout waiting for response from backend server."}, nil}
+	tr.placeExits(hooked)
+	if len(posted) != 1 || exitRejected(1) || len(tr.OpenOrders()) != 0 {
+		t.Fatal("expected the exit to be retried", posted)
+	}
+	tr.placeExits(hooked)
+	orders := tr.OpenOrders()
+	if len(posted) != 2 || len(orders) != 1 {
+		t.Fatal("expected the exit on the book, got", orders)
+	}
+	if i := orders[0].Intent; posted[1] != [2]float64{0.999, 94.9} || i.Quantity != 0.999 || i.Price != 94.9 || i.StopPrice != 95 {
+		t.Fatal("expected the exit rounded to the symbol rules, got", posted[1], i)
+	}
+
+	// A rejected exit is enforced locally and not placed again
+	tr.RecordFill(OrderIntent{Symbol: api.BTCUSDT, Side: api.SideBuy}, 100, 0.5)
+	errs = []error{api.Error{Code: -2010, Msg: "Order would immediately trigger."}}
+	tr.placeExits(hooked)
+	tr.placeExits(hooked)
+	if len(posted) != 3 || !exitRejected(2) || len(tr.OpenOrders()) != 1 {
+		t.Fatal("expected the exit to be rejected once", posted)
+	}
+}